@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gempir/go-twitch-irc/v4"
+)
+
+// onWhisper lets the owner of the channel, checked by user ID, control the bot
+// by whispering it:
+//
+//	reload                 - read CONFIG_FILE again
+//	enable|disable feature - switch one of the bot's features on or off
+//	toggle feature
+//	join|part channel      - join or leave a channel
+//	say channel message    - send a message as the bot
+func onWhisper(b *bot, owner string) func(twitch.WhisperMessage) {
+	return func(message twitch.WhisperMessage) {
+		log.Debugf("whisper from %s: %s", message.User.Name, message.Message)
+
+		// Look the ID up every time rather than trusting the name, which
+		// can change hands.
+		ownerID, err := b.api.userID(owner)
+		if err != nil {
+			log.Errorf("unable to check whisper from %s: %v", message.User.Name, err)
+			return
+		} else if message.User.ID != ownerID {
+			return
+		}
+
+		// Replying takes an API call, so don't hold up reading chat.
+		go func() {
+			reply := adminCommand(b, strings.Fields(strings.TrimPrefix(message.Message, "!")))
+			if err := b.api.whisper(message.User.ID, reply); err != nil {
+				log.Errorf("unable to reply to %s: %v", message.User.Name, err)
+			}
+		}()
+	}
+}
+
+// adminCommand runs the whispered command and returns the reply.
+func adminCommand(b *bot, args []string) string {
+	client := b.client
+
+	if len(args) == 0 {
+		return "Commands: reload, enable, disable, toggle, join, part, say"
+	}
+
+	switch cmd, args := strings.ToLower(args[0]), args[1:]; {
+	case cmd == "reload":
+		if err := b.reloadConfig(); err != nil {
+			log.Error(err)
+			return "Unable to reload config, see the log"
+		}
+		return "Config reloaded"
+	case (cmd == "enable" || cmd == "disable" || cmd == "toggle") && len(args) == 1:
+		name := strings.ToLower(args[0])
+		if !isFeature(name) {
+			return fmt.Sprintf("Unknown feature %q", name)
+		}
+
+		on := cmd == "enable"
+		if cmd == "toggle" {
+			on = b.features.toggle(name)
+		} else {
+			b.features.set(name, on)
+		}
+		return fmt.Sprintf("%s is %s", name, onOff(on))
+	case cmd == "join" && len(args) == 1:
+		client.Join(strings.ToLower(args[0]))
+		return "Joined " + args[0]
+	case cmd == "part" && len(args) == 1:
+		client.Depart(strings.ToLower(args[0]))
+		return "Left " + args[0]
+	case cmd == "say" && len(args) > 1:
+		client.Say(strings.ToLower(args[0]), strings.Join(args[1:], " "))
+		return "Sent"
+	}
+
+	return "Unknown command or wrong arguments"
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+const (
+	// defaultAIPrompt is the system prompt if the config doesn't set one.
+	defaultAIPrompt = "You are batybot, a friendly bat in a Twitch chat. Reply to the last message in one or two short sentences, without hashtags."
+	// defaultAICooldown is how long between AI replies if the config doesn't
+	// say.
+	defaultAICooldown = 30 * time.Second
+	// aiContext is how much recent chat is sent along with a mention.
+	aiContext = 10
+)
+
+// aiReplies answers mentions with a language model running locally, so chat
+// isn't sent to a cloud provider. Backend is either ollama or llamacpp, for
+// llama.cpp's server or anything else with an OpenAI compatible chat API.
+type aiReplies struct {
+	Backend  string `json:"backend"`
+	URL      string `json:"url"`      // default http://localhost:11434 for ollama, http://localhost:8080 for llamacpp
+	Model    string `json:"model"`    // required for ollama
+	Prompt   string `json:"prompt"`   // the system prompt
+	Cooldown string `json:"cooldown"` // default 30s
+}
+
+// aiMessage is a message in a chat completion, in both backends' APIs.
+type aiMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+func (a aiReplies) enabled() bool {
+	return a.Backend != ""
+}
+
+func (a aiReplies) cooldown() time.Duration {
+	if d, err := time.ParseDuration(a.Cooldown); err == nil && a.Cooldown != "" {
+		return d
+	}
+
+	return defaultAICooldown
+}
+
+// messages are what's sent to the model to reply to the message: the prompt,
+// then what was said in chat recently ending with the message.
+func (a aiReplies) messages(c *chatContext) []aiMessage {
+	prompt := a.Prompt
+	if prompt == "" {
+		prompt = defaultAIPrompt
+	}
+
+	recent := c.bot.history.since(c.message.Channel, c.sent().Add(-5*time.Minute), func(twitch.PrivateMessage) bool { return true })
+	if len(recent) > aiContext {
+		recent = recent[len(recent)-aiContext:]
+	}
+
+	var chat strings.Builder
+	for _, m := range recent {
+		if m.ID == c.message.ID {
+			break
+		}
+		fmt.Fprintf(&chat, "%s: %s\n", m.User.DisplayName, m.Message)
+	}
+	fmt.Fprintf(&chat, "%s: %s", c.message.User.DisplayName, c.message.Message)
+
+	return []aiMessage{
+		{Role: "system", Content: prompt},
+		{Role: "user", Content: chat.String()},
+	}
+}
+
+// reply asks the model for the reply to messages.
+func (a aiReplies) reply(messages []aiMessage) (string, error) {
+	var text string
+	switch a.Backend {
+	case "ollama":
+		var resp struct {
+			Message aiMessage `json:"message"`
+		}
+		err := postJSON(a.url("http://localhost:11434")+"/api/chat", "", map[string]any{
+			"model":    a.Model,
+			"messages": messages,
+			"stream":   false,
+		}, &resp)
+		if err != nil {
+			return "", fmt.Errorf("reply: %w", err)
+		}
+		text = resp.Message.Content
+	case "llamacpp":
+		var resp struct {
+			Choices []struct {
+				Message aiMessage `json:"message"`
+			} `json:"choices"`
+		}
+		err := postJSON(a.url("http://localhost:8080")+"/v1/chat/completions", "", map[string]any{
+			"model":    a.Model,
+			"messages": messages,
+		}, &resp)
+		if err != nil {
+			return "", fmt.Errorf("reply: %w", err)
+		} else if len(resp.Choices) == 0 {
+			return "", fmt.Errorf("reply: no choices in the response")
+		}
+		text = resp.Choices[0].Message.Content
+	default:
+		return "", fmt.Errorf("reply: unknown backend %q", a.Backend)
+	}
+
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) > chat.MaxMessageLength {
+		text = string([]rune(text)[:chat.MaxMessageLength-1]) + "…"
+	}
+
+	return text, nil
+}
+
+func (a aiReplies) url(fallback string) string {
+	if a.URL == "" {
+		return fallback
+	}
+
+	return strings.TrimSuffix(a.URL, "/")
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// analyticsDays is how many days of stats are kept.
+	analyticsDays = 90
+	// analyticsSaveEvery is how often the stats are saved while they're
+	// changing.
+	analyticsSaveEvery = time.Minute
+	// dateFormat is how days are keyed in the stats.
+	dateFormat = "2006-01-02"
+)
+
+// report is the config for the daily and weekly reports of chat and channel
+// stats. Weekly ones are sent on Mondays for the week before. They're posted
+// to Discord webhooks and emailed through the server in SMTP_ADDR.
+type report struct {
+	Daily    bool     `json:"daily"`
+	Weekly   bool     `json:"weekly"`
+	At       string   `json:"at"`       // time of day to send them, default 09:00
+	Webhooks []string `json:"webhooks"` // Discord
+	Email    []string `json:"email"`    // addresses to send them to
+}
+
+func (r report) enabled() bool {
+	return (r.Daily || r.Weekly) && (len(r.Webhooks) > 0 || len(r.Email) > 0)
+}
+
+// next returns when the next report is due after now.
+func (r report) next(now time.Time) time.Time {
+	at, err := time.Parse("15:04", r.At)
+	if err != nil || r.At == "" {
+		at = time.Date(0, 1, 1, 9, 0, 0, 0, time.UTC)
+	}
+
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	return next
+}
+
+// dayStats is what happened in a channel in a day.
+type dayStats struct {
+	Messages int             `json:"messages"`
+	Chatters map[string]bool `json:"chatters"` // by user ID
+	Follows  int             `json:"follows"`
+	Subs     int             `json:"subs"`
+	Gifted   int             `json:"gifted"` // subs
+	Raids    int             `json:"raids"`
+	Raiders  int             `json:"raiders"`
+	Bits     int             `json:"bits"`
+}
+
+// analyticsStore keeps each channel's stats by day, saved to analytics.json
+// in the state directory.
+type analyticsStore struct {
+	mu     sync.Mutex
+	loaded bool
+	file   string                          // empty to keep them in memory
+	days   map[string]map[string]*dayStats // by channel, then date
+	dirty  bool
+}
+
+// loadLocked reads the stats saved before, the first time they're needed.
+func (a *analyticsStore) loadLocked() {
+	if a.loaded {
+		return
+	}
+	a.loaded = true
+	a.days = map[string]map[string]*dayStats{}
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Warnf("analytics will only be kept in memory: %v", err)
+		return
+	}
+	a.file = filepath.Join(dir, "analytics.json")
+
+	data, err := os.ReadFile(a.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Errorf("unable to read analytics: %v", err)
+		return
+	}
+
+	if err := json.Unmarshal(data, &a.days); err != nil {
+		log.Errorf("invalid analytics in %q: %v", a.file, err)
+		a.days = map[string]map[string]*dayStats{}
+	}
+}
+
+// add counts the event in its channel's stats for the day.
+func (a *analyticsStore) add(e event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.loadLocked()
+
+	channel := a.days[e.Channel]
+	if channel == nil {
+		channel = map[string]*dayStats{}
+		a.days[e.Channel] = channel
+	}
+
+	date := e.Time.Local().Format(dateFormat)
+	day := channel[date]
+	if day == nil {
+		day = &dayStats{Chatters: map[string]bool{}}
+		channel[date] = day
+	}
+
+	switch e.Type {
+	case eventTypeMessage:
+		day.Messages++
+		if e.UserID != "" {
+			day.Chatters[e.UserID] = true
+		}
+	case eventTypeFollow:
+		day.Follows++
+	case eventTypeSub:
+		day.Subs++
+	case eventTypeGift:
+		day.Gifted += e.Amount
+	case eventTypeRaid:
+		day.Raids++
+		day.Raiders += e.Amount
+	case eventTypeCheer:
+		day.Bits += e.Amount
+	default:
+		return
+	}
+
+	a.dirty = true
+}
+
+// purge removes the user from the days they chatted on, returning how many
+// days that was. Their messages still count toward the totals.
+func (a *analyticsStore) purge(userID string) (int, error) {
+	a.mu.Lock()
+	a.loadLocked()
+
+	purged := 0
+	for _, days := range a.days {
+		for _, day := range days {
+			if day.Chatters[userID] {
+				delete(day.Chatters, userID)
+				purged++
+			}
+		}
+	}
+	if purged > 0 {
+		a.dirty = true
+	}
+	a.mu.Unlock()
+
+	if err := a.save(time.Now()); err != nil {
+		return purged, fmt.Errorf("purge: %w", err)
+	}
+
+	return purged, nil
+}
+
+// periodStats is what happened in a channel over some days.
+type periodStats struct {
+	Messages int
+	Chatters int
+	Follows  int
+	Subs     int
+	Gifted   int
+	Raids    int
+	Raiders  int
+	Bits     int
+}
+
+// period adds up the channel's stats for the days from start.
+func (a *analyticsStore) period(channel string, start time.Time, days int) periodStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.loadLocked()
+
+	var p periodStats
+	chatters := map[string]bool{}
+	for i := 0; i < days; i++ {
+		day := a.days[channel][start.AddDate(0, 0, i).Format(dateFormat)]
+		if day == nil {
+			continue
+		}
+
+		p.Messages += day.Messages
+		p.Follows += day.Follows
+		p.Subs += day.Subs
+		p.Gifted += day.Gifted
+		p.Raids += day.Raids
+		p.Raiders += day.Raiders
+		p.Bits += day.Bits
+		for id := range day.Chatters {
+			chatters[id] = true
+		}
+	}
+	p.Chatters = len(chatters)
+
+	return p
+}
+
+// channels returns every channel with stats, sorted.
+func (a *analyticsStore) channels() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.loadLocked()
+
+	return sortedKeys(a.days)
+}
+
+// save writes the stats if they've changed since the last save, dropping days
+// older than analyticsDays.
+func (a *analyticsStore) save(now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.loaded || !a.dirty {
+		return nil
+	}
+	a.dirty = false
+
+	oldest := now.AddDate(0, 0, -analyticsDays).Format(dateFormat)
+	for channel, days := range a.days {
+		for date := range days {
+			if date < oldest {
+				delete(days, date)
+			}
+		}
+		if len(days) == 0 {
+			delete(a.days, channel)
+		}
+	}
+
+	if a.file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(a.days)
+	if err != nil {
+		return fmt.Errorf("save: unable to encode analytics: %w", err)
+	}
+
+	if err := writeStateFile(a.file, data); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+
+	return nil
+}
+
+// analyticsReport is a channel's stats for a day or week, next to the one
+// before it to show how the channel's growing.
+type analyticsReport struct {
+	title    string
+	current  periodStats
+	previous periodStats
+}
+
+// reports returns every channel's reports as of now: the day before's if
+// daily, and the week before's if weekly.
+func (a *analyticsStore) reports(now time.Time, daily, weekly bool) []analyticsReport {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	yesterday := today.AddDate(0, 0, -1)
+
+	var found []analyticsReport
+	for _, channel := range a.channels() {
+		if daily {
+			found = append(found, analyticsReport{
+				title:    fmt.Sprintf("Daily report for %s, %s", channel, yesterday.Format("Mon Jan 2")),
+				current:  a.period(channel, yesterday, 1),
+				previous: a.period(channel, yesterday.AddDate(0, 0, -1), 1),
+			})
+		}
+
+		if weekly {
+			start := today.AddDate(0, 0, -7)
+			found = append(found, analyticsReport{
+				title:    fmt.Sprintf("Weekly report for %s, %s to %s", channel, start.Format("Jan 2"), yesterday.Format("Jan 2")),
+				current:  a.period(channel, start, 7),
+				previous: a.period(channel, start.AddDate(0, 0, -7), 7),
+			})
+		}
+	}
+
+	return found
+}
+
+// withChange describes now and how much it differs from before, as a
+// percentage.
+func withChange(now, before int) string {
+	if before == 0 {
+		return fmt.Sprint(now)
+	}
+
+	return fmt.Sprintf("%d (%+d%%)", now, (now-before)*100/before)
+}
+
+// lines returns the report's stats, one per line, each with its change since
+// the period before.
+func (r analyticsReport) lines() [][2]string {
+	c, p := r.current, r.previous
+
+	return [][2]string{
+		{"Messages", withChange(c.Messages, p.Messages)},
+		{"Chatters", withChange(c.Chatters, p.Chatters)},
+		{"Follows", withChange(c.Follows, p.Follows)},
+		{"Subs", withChange(c.Subs, p.Subs)},
+		{"Gifted subs", withChange(c.Gifted, p.Gifted)},
+		{"Raids", fmt.Sprintf("%d, with %d raiders", c.Raids, c.Raiders)},
+		{"Bits", withChange(c.Bits, p.Bits)},
+	}
+}
+
+func (r analyticsReport) String() string {
+	var b strings.Builder
+	b.WriteString(r.title + "\n\n")
+	for _, l := range r.lines() {
+		fmt.Fprintf(&b, "%s: %s\n", l[0], l[1])
+	}
+	b.WriteString("\nChanges are from the period before.\n")
+
+	return b.String()
+}
+
+func (r analyticsReport) discord() discordMessage {
+	embed := discordEmbed{Title: r.title, Color: twitchPurple, Description: "Changes are from the period before."}
+	for _, l := range r.lines() {
+		embed.Fields = append(embed.Fields, discordEmbedField{Name: l[0], Value: l[1], Inline: true})
+	}
+
+	return discordMessage{Embeds: []discordEmbed{embed}}
+}
+
+// send delivers the report to the config's webhooks and email addresses.
+func (r analyticsReport) send(conf report) {
+	for _, webhook := range conf.Webhooks {
+		if err := postDiscord(webhook, r.discord()); err != nil {
+			log.Errorf("unable to post report: %v", err)
+		}
+	}
+
+	if len(conf.Email) > 0 {
+		if err := sendEmail(conf.Email, r.title, r.String()); err != nil {
+			log.Errorf("unable to email report: %v", err)
+		}
+	}
+}
+
+// sendEmail sends a plain text email through the server in SMTP_ADDR, as
+// SMTP_FROM, signing in with SMTP_USERNAME and SMTP_PASSWORD if they're set.
+func sendEmail(to []string, subject, body string) error {
+	addr, from := os.Getenv("SMTP_ADDR"), os.Getenv("SMTP_FROM")
+	if addr == "" || from == "" {
+		return fmt.Errorf("sendEmail: SMTP_ADDR and SMTP_FROM need to be set")
+	}
+
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USERNAME"); user != "" {
+		host, _, _ := strings.Cut(addr, ":")
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		from, strings.Join(to, ", "), subject, time.Now().Format(time.RFC1123Z), strings.ReplaceAll(body, "\n", "\r\n"))
+	if err := smtp.SendMail(addr, auth, from, to, []byte(message)); err != nil {
+		return fmt.Errorf("sendEmail: %w", err)
+	}
+
+	return nil
+}
+
+// analyticsRecorder counts events into the stats and sends the reports when
+// they're due.
+type analyticsRecorder struct {
+	config *configManager
+	store  *analyticsStore
+	stop   func()
+	events <-chan event
+}
+
+func newAnalyticsRecorder(conf *configManager, bus *eventBus, store *analyticsStore) *analyticsRecorder {
+	events, unsubscribe := bus.subscribe()
+	return &analyticsRecorder{config: conf, store: store, events: events, stop: unsubscribe}
+}
+
+// ignored reports whether the event's from someone in the config's ignore
+// list, like another bot.
+func (r *analyticsRecorder) ignored(e event) bool {
+	for _, user := range r.config.get().Ignore {
+		if strings.EqualFold(user, e.User) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *analyticsRecorder) Start() error {
+	save := time.NewTicker(analyticsSaveEvery)
+	defer save.Stop()
+
+	due := r.config.get().Report.next(time.Now())
+	timer := time.NewTimer(time.Until(due))
+	defer timer.Stop()
+
+	for {
+		select {
+		case e, ok := <-r.events:
+			if !ok {
+				return nil
+			}
+			if !r.ignored(e) {
+				r.store.add(e)
+			}
+		case now := <-save.C:
+			if err := r.store.save(now); err != nil {
+				log.Errorf("unable to save analytics: %v", err)
+			}
+		case now := <-timer.C:
+			conf := r.config.get().Report
+			if conf.enabled() {
+				if err := r.store.save(now); err != nil {
+					log.Errorf("unable to save analytics: %v", err)
+				}
+
+				for _, report := range r.store.reports(now, conf.Daily, conf.Weekly && now.Weekday() == time.Monday) {
+					report.send(conf)
+				}
+			}
+
+			timer.Reset(time.Until(conf.next(now)))
+		}
+	}
+}
+
+// Shutdown stops counting events, ending Start.
+func (r *analyticsRecorder) Shutdown(ctx context.Context) error {
+	r.stop()
+	return nil
+}
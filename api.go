@@ -0,0 +1,393 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+	"github.com/nicklaw5/helix/v2"
+)
+
+// twitchAPI is used for the chat actions Twitch no longer allows over IRC,
+// such as deleting messages and timing users out. It acts as the bot user.
+type twitchAPI struct {
+	*helix.Client
+	status *botStatus
+	modlog *modLog // where moderation done through the API is recorded
+
+	mu  sync.RWMutex
+	bot twitch.User
+	ids map[string]string
+}
+
+func newTwitchAPI(token string, refreshes refreshRequests, status *botStatus, modlog *modLog) (*twitchAPI, error) {
+	client, err := helix.NewClient(&helix.Options{
+		ClientID:        os.Getenv("TWITCH_CLIENT_ID"),
+		UserAccessToken: strings.TrimPrefix(token, "oauth:"),
+		HTTPClient:      &refreshingClient{refreshes: refreshes},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("newTwitchAPI: unable to set up client: %w", err)
+	}
+
+	return &twitchAPI{Client: client, status: status, modlog: modlog, ids: map[string]string{}}, nil
+}
+
+// newAppAPI sets up the API with an app access token rather than the bot's,
+// which is only enough to look things up, like users and streams. The token
+// is renewed before it expires.
+func newAppAPI(status *botStatus, modlog *modLog) (*twitchAPI, error) {
+	client, err := helix.NewClient(&helix.Options{
+		ClientID:     os.Getenv("TWITCH_CLIENT_ID"),
+		ClientSecret: os.Getenv("TWITCH_CLIENT_SECRET"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("newAppAPI: unable to set up client: %w", err)
+	}
+
+	a := &twitchAPI{Client: client, status: status, modlog: modlog, ids: map[string]string{}}
+	expires, err := a.renewAppToken()
+	if err != nil {
+		return nil, fmt.Errorf("newAppAPI: %w", err)
+	}
+
+	go func() {
+		for {
+			time.Sleep(expires * 9 / 10)
+
+			for expires, err = a.renewAppToken(); err != nil; expires, err = a.renewAppToken() {
+				log.Errorf("unable to renew app access token, trying again in a minute: %v", err)
+				time.Sleep(time.Minute)
+			}
+		}
+	}()
+
+	return a, nil
+}
+
+// renewAppToken gets a new app access token and returns how long it lasts.
+func (a *twitchAPI) renewAppToken() (time.Duration, error) {
+	r, err := a.RequestAppAccessToken(nil)
+	if err != nil {
+		return 0, fmt.Errorf("renewAppToken: unable to get app access token: %w", err)
+	} else if r.ErrorStatus != 0 {
+		return 0, fmt.Errorf("renewAppToken: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	}
+
+	a.SetAppAccessToken(r.Data.AccessToken)
+	a.status.setTokenExpires(time.Now().Add(time.Duration(r.Data.ExpiresIn) * time.Second))
+
+	return time.Duration(r.Data.ExpiresIn) * time.Second, nil
+}
+
+func (a *twitchAPI) setToken(token string) {
+	a.SetUserAccessToken(strings.TrimPrefix(token, "oauth:"))
+}
+
+// onGlobalUserState learns who the bot is logged in as, which is needed to
+// moderate as the bot.
+func (a *twitchAPI) onGlobalUserState(message twitch.GlobalUserStateMessage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.bot = message.User
+}
+
+func (a *twitchAPI) botUser() (twitch.User, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.bot.ID == "" {
+		return a.bot, errors.New("bot user is unknown until connected")
+	}
+
+	return a.bot, nil
+}
+
+// userLookup finds Twitch users' IDs, so what needs them can be given
+// something other than the Helix API in tests.
+type userLookup interface {
+	userID(login string) (string, error)
+}
+
+// userID looks up the ID of the user with the login name, which is also the
+// ID of their channel.
+func (a *twitchAPI) userID(login string) (string, error) {
+	a.mu.RLock()
+	id, ok := a.ids[login]
+	a.mu.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	r, err := a.GetUsers(&helix.UsersParams{Logins: []string{login}})
+	if err != nil {
+		return "", fmt.Errorf("userID: unable to get user: %w", err)
+	} else if r.ErrorStatus != 0 {
+		return "", fmt.Errorf("userID: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	} else if len(r.Data.Users) == 0 {
+		return "", fmt.Errorf("userID: unknown user %q", login)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.ids[login] = r.Data.Users[0].ID
+	return r.Data.Users[0].ID, nil
+}
+
+// user looks up the user with the login name.
+func (a *twitchAPI) user(login string) (helix.User, error) {
+	r, err := a.GetUsers(&helix.UsersParams{Logins: []string{login}})
+	if err != nil {
+		return helix.User{}, fmt.Errorf("user: unable to get user: %w", err)
+	} else if r.ErrorStatus != 0 {
+		return helix.User{}, fmt.Errorf("user: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	} else if len(r.Data.Users) == 0 {
+		return helix.User{}, fmt.Errorf("user: unknown user %q", login)
+	}
+
+	return r.Data.Users[0], nil
+}
+
+// deleteMessage removes a message from chat and records it in the moderation
+// log.
+func (a *twitchAPI) deleteMessage(message twitch.PrivateMessage, reason string) error {
+	bot, err := a.botUser()
+	if err != nil {
+		return fmt.Errorf("deleteMessage: %w", err)
+	}
+
+	r, err := a.DeleteChatMessage(&helix.DeleteChatMessageParams{
+		BroadcasterID: message.RoomID,
+		ModeratorID:   bot.ID,
+		MessageID:     message.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("deleteMessage: unable to delete message: %w", err)
+	} else if r.ErrorStatus != 0 {
+		return fmt.Errorf("deleteMessage: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	}
+
+	a.modlog.record(modAction{
+		Channel:   message.Channel,
+		Action:    "delete",
+		Target:    message.User.Name,
+		TargetID:  message.User.ID,
+		Moderator: bot.Name,
+		Message:   message.Message,
+		MessageID: message.ID,
+		Reason:    reason,
+	})
+
+	return nil
+}
+
+// timeout times the user out of the channel and records it in the moderation
+// log.
+func (a *twitchAPI) timeout(channel, broadcasterID string, user twitch.User, d time.Duration, reason string) error {
+	if d < time.Second {
+		return fmt.Errorf("timeout: %s is too short", d)
+	}
+
+	if err := a.banUser(channel, broadcasterID, user, d, reason); err != nil {
+		return fmt.Errorf("timeout: %w", err)
+	}
+
+	return nil
+}
+
+// ban bans the user from the channel and records it in the moderation log.
+func (a *twitchAPI) ban(channel, broadcasterID string, user twitch.User, reason string) error {
+	if err := a.banUser(channel, broadcasterID, user, 0, reason); err != nil {
+		return fmt.Errorf("ban: %w", err)
+	}
+
+	return nil
+}
+
+// banUser bans the user, for d if it isn't 0.
+func (a *twitchAPI) banUser(channel, broadcasterID string, user twitch.User, d time.Duration, reason string) error {
+	bot, err := a.botUser()
+	if err != nil {
+		return fmt.Errorf("banUser: %w", err)
+	}
+
+	r, err := a.BanUser(&helix.BanUserParams{
+		BroadcasterID: broadcasterID,
+		ModeratorId:   bot.ID,
+		Body: helix.BanUserRequestBody{
+			Duration: int(d.Seconds()),
+			Reason:   reason,
+			UserId:   user.ID,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("banUser: unable to ban user: %w", err)
+	} else if r.ErrorStatus != 0 {
+		return fmt.Errorf("banUser: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	}
+
+	action := "timeout"
+	if d == 0 {
+		action = "ban"
+	}
+
+	a.modlog.record(modAction{
+		Channel:   channel,
+		Action:    action,
+		Target:    user.Name,
+		TargetID:  user.ID,
+		Moderator: bot.Name,
+		Duration:  int(d.Seconds()),
+		Reason:    reason,
+	})
+
+	return nil
+}
+
+func (a *twitchAPI) chatSettings(broadcasterID string) (helix.ChatSettings, error) {
+	bot, err := a.botUser()
+	if err != nil {
+		return helix.ChatSettings{}, fmt.Errorf("chatSettings: %w", err)
+	}
+
+	r, err := a.GetChatSettings(&helix.GetChatSettingsParams{
+		BroadcasterID: broadcasterID,
+		ModeratorID:   bot.ID,
+	})
+	if err != nil {
+		return helix.ChatSettings{}, fmt.Errorf("chatSettings: unable to get chat settings: %w", err)
+	} else if r.ErrorStatus != 0 {
+		return helix.ChatSettings{}, fmt.Errorf("chatSettings: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	} else if len(r.Data.Settings) == 0 {
+		return helix.ChatSettings{}, errors.New("chatSettings: no settings returned")
+	}
+
+	return r.Data.Settings[0], nil
+}
+
+func (a *twitchAPI) updateChatSettings(broadcasterID string, params helix.UpdateChatSettingsParams) error {
+	bot, err := a.botUser()
+	if err != nil {
+		return fmt.Errorf("updateChatSettings: %w", err)
+	}
+
+	params.BroadcasterID = broadcasterID
+	params.ModeratorID = bot.ID
+
+	r, err := a.UpdateChatSettings(&params)
+	if err != nil {
+		return fmt.Errorf("updateChatSettings: unable to update chat settings: %w", err)
+	} else if r.ErrorStatus != 0 {
+		return fmt.Errorf("updateChatSettings: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	}
+
+	return nil
+}
+
+// SendMessage sends the message to the channel, as a reply if parentID is
+// set.
+func (a *twitchAPI) SendMessage(channel, parentID, text string) error {
+	bot, err := a.botUser()
+	if err != nil {
+		return fmt.Errorf("SendMessage: %w", err)
+	}
+
+	broadcasterID, err := a.userID(channel)
+	if err != nil {
+		return fmt.Errorf("SendMessage: %w", err)
+	}
+
+	r, err := a.SendChatMessage(&helix.SendChatMessageParams{
+		BroadcasterID:        broadcasterID,
+		SenderID:             bot.ID,
+		Message:              text,
+		ReplyParentMessageID: parentID,
+	})
+	if err != nil {
+		return fmt.Errorf("SendMessage: unable to send message: %w", err)
+	} else if r.ErrorStatus != 0 {
+		return fmt.Errorf("SendMessage: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	}
+
+	for _, m := range r.Data.Messages {
+		if !m.IsSent {
+			return fmt.Errorf("SendMessage: message dropped: %s", m.DropReasons.Data.Message)
+		}
+	}
+
+	return nil
+}
+
+func (a *twitchAPI) Announce(channel, text, color string) error {
+	switch color {
+	case "blue", "green", "orange", "purple", "primary", "":
+	default:
+		return fmt.Errorf("Announce: invalid color %q", color)
+	}
+
+	bot, err := a.botUser()
+	if err != nil {
+		return fmt.Errorf("Announce: %w", err)
+	}
+
+	broadcasterID, err := a.userID(channel)
+	if err != nil {
+		return fmt.Errorf("Announce: %w", err)
+	}
+
+	r, err := a.SendChatAnnouncement(&helix.SendChatAnnouncementParams{
+		BroadcasterID: broadcasterID,
+		ModeratorID:   bot.ID,
+		Message:       text,
+		Color:         color,
+	})
+	if err != nil {
+		return fmt.Errorf("Announce: unable to send announcement: %w", err)
+	} else if r.ErrorStatus != 0 {
+		return fmt.Errorf("Announce: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	}
+
+	return nil
+}
+
+// whisper sends a whisper from the bot to the user.
+func (a *twitchAPI) whisper(userID, text string) error {
+	bot, err := a.botUser()
+	if err != nil {
+		return fmt.Errorf("whisper: %w", err)
+	}
+
+	r, err := a.SendUserWhisper(&helix.SendUserWhisperParams{
+		FromUserID: bot.ID,
+		ToUserID:   userID,
+		Message:    text,
+	})
+	if err != nil {
+		return fmt.Errorf("whisper: unable to send whisper: %w", err)
+	} else if r.ErrorStatus != 0 {
+		return fmt.Errorf("whisper: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	}
+
+	return nil
+}
+
+// stream returns the broadcaster's live stream, or an error if they aren't
+// live.
+func (a *twitchAPI) stream(broadcasterID string) (helix.Stream, error) {
+	r, err := a.GetStreams(&helix.StreamsParams{UserIDs: []string{broadcasterID}})
+	if err != nil {
+		return helix.Stream{}, fmt.Errorf("stream: unable to get stream: %w", err)
+	} else if r.ErrorStatus != 0 {
+		return helix.Stream{}, fmt.Errorf("stream: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	} else if len(r.Data.Streams) == 0 {
+		return helix.Stream{}, errors.New("stream: not live")
+	}
+
+	return r.Data.Streams[0], nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import "os"
+
+// runAppOnly runs the bot with only an app access token, for when there's
+// nobody to authorize it as a user. There's no chat, so only what's driven
+// by EventSub and the API works: go live posts, MQTT, the event stream, and
+// health checks.
+func runAppOnly(b *bot) {
+	channel := os.Getenv("TWITCH_CHANNEL")
+	if channel == "" {
+		log.Fatal("expected TWITCH_CHANNEL to be set")
+	}
+
+	var err error
+	b.api, err = newAppAPI(b.status, b.modlog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b.status.setAppOnly()
+
+	log.Warnf("running without chat, only with an app access token")
+
+	startPluginEvents(b.bus)
+	publisher := b.startMQTT(channel)
+
+	if os.Getenv("EVENTSUB_TRANSPORT") == "websocket" {
+		log.Fatal("EVENTSUB_TRANSPORT=websocket needs the bot's token, so it doesn't work in app only mode")
+	}
+
+	if secret := os.Getenv("EVENTSUB_SECRET"); secret != "" {
+		b.startEventSub(secret, channel, os.Getenv("TWITCH_MODERATOR"), publisher)
+	} else {
+		log.Warn("without EVENTSUB_SECRET set, going live isn't noticed")
+	}
+
+	b.startServers()
+
+	if err := sdNotify("READY=1\nSTATUS=running without chat"); err != nil {
+		log.Error(err)
+	}
+	go watchdog(b.status)
+
+	handleShutdown(b.services)
+	if err := b.services.wait(); err != nil {
+		panic(err)
+	}
+}
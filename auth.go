@@ -1,14 +1,19 @@
 package main
 
 import (
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nicklaw5/helix/v2"
+
+	"github.com/losinggeneration/batybot/auth"
 )
 
 type Token struct{ helix.AccessCredentials }
@@ -17,23 +22,84 @@ type server struct {
 	http.Server
 
 	listen string
+	state  string
 	code   string
+
+	done   chan struct{}
+	finish sync.Once
+}
+
+// authCallback passes the callback on to the authorization waiting for it
+// while the EventSub server has its address, if there is one.
+func (e *eventSub) authCallback(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	s := e.authorizing
+	e.mu.Unlock()
+
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.ServeHTTP(w, r)
 }
 
 var (
 	listen   = ":8080"
 	redirect = fmt.Sprintf("http://localhost%s", listen)
+
+	// callbackPath is where on redirect Twitch sends users back to, the root
+	// unless it's set.
+	callbackPath string
 )
 
 func init() {
+	setRedirect()
+}
+
+// setRedirect sets where the authorization server listens from AUTH_LISTEN,
+// and where Twitch sends users back to from VIRTUAL_HOST and
+// AUTH_CALLBACK_PATH.
+func setRedirect() {
+	if l := os.Getenv("AUTH_LISTEN"); l != "" {
+		listen = l
+	}
+
 	if vhost := os.Getenv("VIRTUAL_HOST"); vhost != "" {
 		redirect = fmt.Sprintf("https://%s", vhost)
+	} else if _, port, err := net.SplitHostPort(listen); err == nil {
+		redirect = fmt.Sprintf("http://localhost:%s", port)
+	}
+
+	callbackPath = os.Getenv("AUTH_CALLBACK_PATH")
+	if callbackPath != "" && !strings.HasPrefix(callbackPath, "/") {
+		callbackPath = "/" + callbackPath
 	}
 }
 
+// redirectURI is the URL Twitch sends users back to after authorizing.
+func redirectURI() string {
+	return redirect + callbackPath
+}
+
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if path := r.URL.Path; path != callbackPath && (callbackPath != "" || path != "/") {
+		http.NotFound(w, r)
+		return
+	}
+
 	q := r.URL.Query()
-	s.code = q.Get("code") // scope is also available, but I don't think it's needed
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(s.state)) != 1 {
+		// Not from the authorization the bot started, so keep waiting.
+		log.Warnf("auth: ignoring callback with the wrong state from %s", r.RemoteAddr)
+		http.Error(w, "invalid state", http.StatusBadRequest)
+		return
+	}
+
+	s.finish.Do(func() {
+		s.code = q.Get("code") // scope is also available, but I don't think it's needed
+		close(s.done)
+	})
 	s.Shutdown(r.Context())
 }
 
@@ -57,59 +123,100 @@ func (t Token) get() (token, refresh, expires string) {
 	return token, refresh, expires
 }
 
-func authCode() (string, error) {
-	client, err := helix.NewClient(&helix.Options{
-		ClientID:    os.Getenv("TWITCH_CLIENT_ID"),
-		RedirectURI: redirect,
-	})
-	if err != nil {
-		return "", fmt.Errorf("authCode: unable to set up client: %w", err)
+// botScopes are what the bot's own token is authorized for.
+var botScopes = []string{
+	"chat:edit", "chat:read", "whispers:read", "whispers:edit",
+	"moderator:manage:chat_messages", "moderator:manage:banned_users",
+	"moderator:manage:chat_settings", "moderator:manage:announcements",
+	"user:write:chat", "user:manage:whispers", "moderator:read:followers",
+}
+
+// twitchApp is the bot's application on Twitch.
+func twitchApp() auth.Client {
+	return auth.Client{
+		ID:     os.Getenv("TWITCH_CLIENT_ID"),
+		Secret: os.Getenv("TWITCH_CLIENT_SECRET"),
 	}
+}
 
-	url := client.GetAuthorizationURL(&helix.AuthorizationURLParams{
-		ResponseType: "code",
-		Scopes:       []string{"chat:edit", "chat:read", "whispers:read", "whispers:edit"},
-	})
+// authCode sends the user to authorize the bot and waits for the code Twitch
+// sends back. The state guards the callback against forged requests, and the
+// returned PKCE verifier has to be sent with the code to exchange it. If the
+// running EventSub server, e, has the authorization server's address, it
+// passes the callback on instead.
+func authCode(scopes []string, e *eventSub) (code, verifier string, err error) {
+	a, err := twitchApp().Authorize(redirectURI(), scopes)
+	if err != nil {
+		return "", "", fmt.Errorf("authCode: %w", err)
+	}
 
-	log.Info(url)
+	log.Info(a.URL)
 
-	s := server{
+	s := &server{
 		listen: listen,
+		state:  a.State,
+		done:   make(chan struct{}),
 	}
+
+	if e != nil && e.listen == listen {
+		// Authorizing again while the bot's running.
+		e.mu.Lock()
+		e.authorizing = s
+		e.mu.Unlock()
+
+		<-s.done
+
+		e.mu.Lock()
+		e.authorizing = nil
+		e.mu.Unlock()
+
+		return s.code, a.Verifier, nil
+	}
+
 	if err := s.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		return "", fmt.Errorf("authCode: unable to start server: %w", err)
+		return "", "", fmt.Errorf("authCode: unable to start server: %w", err)
 	}
 
-	return s.code, nil
+	return s.code, a.Verifier, nil
 }
 
-func getUserToken(code string) (*Token, error) {
-	client, err := helix.NewClient(&helix.Options{
-		ClientID:     os.Getenv("TWITCH_CLIENT_ID"),
-		ClientSecret: os.Getenv("TWITCH_CLIENT_SECRET"),
-		RedirectURI:  redirect,
-	})
+func getUserToken(code, verifier string) (*Token, error) {
+	creds, err := twitchApp().Exchange(code, verifier, redirectURI())
 	if err != nil {
-		return nil, fmt.Errorf("getUserToken: unable to set up client: %w", err)
+		return nil, fmt.Errorf("getUserToken: %w", err)
 	}
 
-	r, err := client.RequestUserAccessToken(code)
+	return &Token{*creds}, nil
+}
+
+// deviceToken gets a token with the device code grant, for authorizing the
+// bot on a host without a browser or a callback Twitch can reach. The user
+// enters a code at twitch.tv/activate from any device.
+func deviceToken(scopes []string) (*Token, error) {
+	creds, err := twitchApp().Device(scopes, func(code auth.DeviceCode) {
+		log.Infof("go to %s and enter %s to authorize the bot", code.VerificationURI, code.UserCode)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("getUserToken: unable to get user token: %w", err)
-	} else if r.ErrorStatus != 0 {
-		return nil, fmt.Errorf("getUserToken: invalid response: %v", r.ErrorStatus)
+		return nil, fmt.Errorf("deviceToken: %w", err)
 	}
 
-	return &Token{r.Data}, nil
+	return &Token{*creds}, nil
 }
 
-func getToken() (*Token, error) {
-	code, err := authCode()
+// getToken has the user authorize the bot, with the device code grant if
+// TWITCH_AUTH_FLOW is device, or else by sending them back to the bot. e is
+// the running EventSub server, if there is one.
+func getToken(scopes []string, e *eventSub) (*Token, error) {
+	if os.Getenv("TWITCH_AUTH_FLOW") == "device" {
+		return deviceToken(scopes)
+	}
+
+	code, verifier, err := authCode(scopes, e)
 	if err != nil {
 		return nil, fmt.Errorf("getToken: unable to get auth code: %w", err)
 	}
 
-	token, err := getUserToken(code)
+	token, err := getUserToken(code, verifier)
 	if err != nil {
 		return nil, fmt.Errorf("getToken: unable to get user token: %w", err)
 	}
@@ -117,21 +224,44 @@ func getToken() (*Token, error) {
 	return token, nil
 }
 
-func refreshToken(refresh string) (*Token, error) {
-	client, err := helix.NewClient(&helix.Options{
-		ClientID:     os.Getenv("TWITCH_CLIENT_ID"),
-		ClientSecret: os.Getenv("TWITCH_CLIENT_SECRET"),
-	})
+// tokenUserID returns the ID of the user the token belongs to.
+func tokenUserID(token string) (string, error) {
+	r, err := validateToken(token)
 	if err != nil {
-		return nil, fmt.Errorf("refreshToken: unable to set up client: %w", err)
+		return "", fmt.Errorf("tokenUserID: %w", err)
 	}
 
-	r, err := client.RefreshUserAccessToken(refresh)
+	return r.Data.UserID, nil
+}
+
+// missingScopes returns which of the scopes the token wasn't authorized for,
+// like ones the bot started asking for after it was authorized.
+func missingScopes(token string, scopes []string) []string {
+	r, err := validateToken(token)
 	if err != nil {
-		return nil, fmt.Errorf("refreshToken: unable to refresh token: %w", err)
-	} else if r.ErrorStatus != 0 {
-		return nil, fmt.Errorf("refreshToken: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+		log.Warnf("unable to check the token's scopes: %v", err)
+		return nil
 	}
 
-	return &Token{r.Data}, nil
+	return auth.MissingScopes(r.Data.Scopes, scopes)
+}
+
+// validateToken returns who the token belongs to, its scopes, and how long
+// it has left.
+func validateToken(token string) (*helix.ValidateTokenResponse, error) {
+	r, err := twitchApp().Validate(token)
+	if err != nil {
+		return nil, fmt.Errorf("validateToken: %w", err)
+	}
+
+	return r, nil
+}
+
+// errRefreshRevoked is returned when Twitch no longer accepts the refresh
+// token. The bot has to be authorized again.
+var errRefreshRevoked = auth.ErrRefreshRevoked
+
+// tokenRefresher gets a new token with a refresh token.
+type tokenRefresher interface {
+	Refresh(refresh string) (*helix.AccessCredentials, error)
 }
@@ -0,0 +1,263 @@
+// Package auth gets and looks after Twitch user tokens for an application,
+// with the authorization code grant, or the device code grant for hosts
+// without a browser.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+const (
+	authorizeURL = "https://id.twitch.tv/oauth2/authorize"
+	deviceURL    = "https://id.twitch.tv/oauth2/device"
+	tokenURL     = "https://id.twitch.tv/oauth2/token"
+)
+
+// ErrRefreshRevoked is returned when Twitch no longer accepts the refresh
+// token, like after the application's authorization is removed or the
+// account's password is changed. The user has to authorize it again.
+var ErrRefreshRevoked = errors.New("refresh token is no longer valid")
+
+// Client is a Twitch application users authorize.
+type Client struct {
+	ID     string
+	Secret string
+
+	// HTTPClient makes the requests, one with a 30 second timeout if it's
+	// nil.
+	HTTPClient *http.Client
+}
+
+func (c Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+// Authorization is an authorization code grant the user has been sent to
+// approve.
+type Authorization struct {
+	URL      string // where to send the user
+	State    string // has to match the state Twitch sends back
+	Verifier string // PKCE verifier to exchange the code with
+}
+
+// Authorize starts an authorization code grant for the scopes. Twitch sends
+// the user back to redirectURI with the code and state.
+func (c Client) Authorize(redirectURI string, scopes []string) (*Authorization, error) {
+	state, err := RandomString()
+	if err != nil {
+		return nil, fmt.Errorf("Authorize: %w", err)
+	}
+	verifier, err := RandomString()
+	if err != nil {
+		return nil, fmt.Errorf("Authorize: %w", err)
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	u := authorizeURL + "?" + url.Values{
+		"client_id":             {c.ID},
+		"redirect_uri":          {redirectURI},
+		"response_type":         {"code"},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}.Encode()
+
+	return &Authorization{URL: u, State: state, Verifier: verifier}, nil
+}
+
+// Exchange swaps the code Twitch sent back for a token.
+func (c Client) Exchange(code, verifier, redirectURI string) (*helix.AccessCredentials, error) {
+	var creds helix.AccessCredentials
+	status, err := c.postForm(tokenURL, url.Values{
+		"client_id":     {c.ID},
+		"client_secret": {c.Secret},
+		"code":          {code},
+		"code_verifier": {verifier},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {redirectURI},
+	}, &creds)
+	if err != nil {
+		return nil, fmt.Errorf("Exchange: unable to get user token: %w", err)
+	} else if status != "" {
+		return nil, fmt.Errorf("Exchange: invalid response: %s", status)
+	}
+
+	return &creds, nil
+}
+
+// DeviceCode is what the user enters, at VerificationURI, to authorize a
+// device code grant.
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// Device gets a token with the device code grant. prompt is called with the
+// code to show the user, and Device then polls until they've entered it or it
+// expires.
+func (c Client) Device(scopes []string, prompt func(DeviceCode)) (*helix.AccessCredentials, error) {
+	var code DeviceCode
+	status, err := c.postForm(deviceURL, url.Values{
+		"client_id": {c.ID},
+		"scopes":    {strings.Join(scopes, " ")},
+	}, &code)
+	if err != nil {
+		return nil, fmt.Errorf("Device: unable to get device code: %w", err)
+	} else if status != "" {
+		return nil, fmt.Errorf("Device: unable to get device code: %s", status)
+	}
+
+	prompt(code)
+
+	interval := time.Duration(code.Interval) * time.Second
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+
+		var creds helix.AccessCredentials
+		status, err := c.postForm(tokenURL, url.Values{
+			"client_id":     {c.ID},
+			"client_secret": {c.Secret},
+			"scopes":        {strings.Join(scopes, " ")},
+			"device_code":   {code.DeviceCode},
+			"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &creds)
+		if err != nil {
+			return nil, fmt.Errorf("Device: unable to get token: %w", err)
+		}
+
+		switch status {
+		case "":
+			return &creds, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("Device: authorization failed: %s", status)
+		}
+	}
+
+	return nil, fmt.Errorf("Device: code %s expired before it was entered", code.UserCode)
+}
+
+// Refresh gets a new token with the refresh token, wrapping ErrRefreshRevoked
+// if Twitch won't accept it.
+func (c Client) Refresh(refresh string) (*helix.AccessCredentials, error) {
+	client, err := helix.NewClient(&helix.Options{
+		ClientID:     c.ID,
+		ClientSecret: c.Secret,
+		HTTPClient:   c.httpClient(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Refresh: unable to set up client: %w", err)
+	}
+
+	r, err := client.RefreshUserAccessToken(refresh)
+	if err != nil {
+		return nil, fmt.Errorf("Refresh: unable to refresh token: %w", err)
+	} else if r.ErrorStatus == http.StatusBadRequest || r.ErrorStatus == http.StatusUnauthorized {
+		return nil, fmt.Errorf("Refresh: %w: %s", ErrRefreshRevoked, r.ErrorMessage)
+	} else if r.ErrorStatus != 0 {
+		return nil, fmt.Errorf("Refresh: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	}
+
+	return &r.Data, nil
+}
+
+// Validate returns who the token belongs to, its scopes, and how long it has
+// left.
+func (c Client) Validate(token string) (*helix.ValidateTokenResponse, error) {
+	client, err := helix.NewClient(&helix.Options{
+		ClientID:   c.ID,
+		HTTPClient: c.httpClient(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Validate: unable to set up client: %w", err)
+	}
+
+	valid, r, err := client.ValidateToken(strings.TrimPrefix(token, "oauth:"))
+	if err != nil {
+		return nil, fmt.Errorf("Validate: unable to validate token: %w", err)
+	} else if !valid {
+		return nil, fmt.Errorf("Validate: invalid token: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	}
+
+	return r, nil
+}
+
+// MissingScopes returns which of the wanted scopes weren't granted.
+func MissingScopes(granted, wanted []string) []string {
+	has := map[string]bool{}
+	for _, scope := range granted {
+		has[scope] = true
+	}
+
+	var missing []string
+	for _, scope := range wanted {
+		if !has[scope] {
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
+
+// RandomString returns 32 random bytes encoded for use in a URL.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("RandomString: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// postForm posts the form and decodes a successful response into v. Twitch
+// reports the state of a pending authorization as a 400 with a message,
+// which is returned as the status instead of an error.
+func (c Client) postForm(u string, form url.Values, v interface{}) (string, error) {
+	resp, err := c.httpClient().PostForm(u, form)
+	if err != nil {
+		return "", fmt.Errorf("postForm: request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusBadRequest {
+		var e struct {
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Message == "" {
+			return "", fmt.Errorf("postForm: unexpected status %s", resp.Status)
+		}
+		return e.Message, nil
+	} else if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("postForm: unexpected status %s: %s", resp.Status, b)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return "", fmt.Errorf("postForm: invalid response: %w", err)
+	}
+
+	return "", nil
+}
@@ -0,0 +1,224 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             v5.27.3
+// source: batybot.proto
+
+package batybotpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Batybot_SendMessage_FullMethodName     = "/batybot.v1.Batybot/SendMessage"
+	Batybot_GetStatus_FullMethodName       = "/batybot.v1.Batybot/GetStatus"
+	Batybot_SubscribeEvents_FullMethodName = "/batybot.v1.Batybot/SubscribeEvents"
+)
+
+// BatybotClient is the client API for Batybot service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Batybot is the gRPC version of the control API and event stream.
+type BatybotClient interface {
+	// SendMessage queues a message to be sent to a channel.
+	SendMessage(ctx context.Context, in *SendMessageRequest, opts ...grpc.CallOption) (*SendMessageResponse, error)
+	// GetStatus reports uptime, connection, token expiry, and queue depth.
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error)
+	// SubscribeEvents streams events as they happen.
+	SubscribeEvents(ctx context.Context, in *SubscribeEventsRequest, opts ...grpc.CallOption) (Batybot_SubscribeEventsClient, error)
+}
+
+type batybotClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBatybotClient(cc grpc.ClientConnInterface) BatybotClient {
+	return &batybotClient{cc}
+}
+
+func (c *batybotClient) SendMessage(ctx context.Context, in *SendMessageRequest, opts ...grpc.CallOption) (*SendMessageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SendMessageResponse)
+	err := c.cc.Invoke(ctx, Batybot_SendMessage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *batybotClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Status)
+	err := c.cc.Invoke(ctx, Batybot_GetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *batybotClient) SubscribeEvents(ctx context.Context, in *SubscribeEventsRequest, opts ...grpc.CallOption) (Batybot_SubscribeEventsClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Batybot_ServiceDesc.Streams[0], Batybot_SubscribeEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &batybotSubscribeEventsClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Batybot_SubscribeEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type batybotSubscribeEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *batybotSubscribeEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// BatybotServer is the server API for Batybot service.
+// All implementations must embed UnimplementedBatybotServer
+// for forward compatibility
+//
+// Batybot is the gRPC version of the control API and event stream.
+type BatybotServer interface {
+	// SendMessage queues a message to be sent to a channel.
+	SendMessage(context.Context, *SendMessageRequest) (*SendMessageResponse, error)
+	// GetStatus reports uptime, connection, token expiry, and queue depth.
+	GetStatus(context.Context, *GetStatusRequest) (*Status, error)
+	// SubscribeEvents streams events as they happen.
+	SubscribeEvents(*SubscribeEventsRequest, Batybot_SubscribeEventsServer) error
+	mustEmbedUnimplementedBatybotServer()
+}
+
+// UnimplementedBatybotServer must be embedded to have forward compatible implementations.
+type UnimplementedBatybotServer struct {
+}
+
+func (UnimplementedBatybotServer) SendMessage(context.Context, *SendMessageRequest) (*SendMessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendMessage not implemented")
+}
+func (UnimplementedBatybotServer) GetStatus(context.Context, *GetStatusRequest) (*Status, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedBatybotServer) SubscribeEvents(*SubscribeEventsRequest, Batybot_SubscribeEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeEvents not implemented")
+}
+func (UnimplementedBatybotServer) mustEmbedUnimplementedBatybotServer() {}
+
+// UnsafeBatybotServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BatybotServer will
+// result in compilation errors.
+type UnsafeBatybotServer interface {
+	mustEmbedUnimplementedBatybotServer()
+}
+
+func RegisterBatybotServer(s grpc.ServiceRegistrar, srv BatybotServer) {
+	s.RegisterService(&Batybot_ServiceDesc, srv)
+}
+
+func _Batybot_SendMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BatybotServer).SendMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Batybot_SendMessage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BatybotServer).SendMessage(ctx, req.(*SendMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Batybot_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BatybotServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Batybot_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BatybotServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Batybot_SubscribeEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BatybotServer).SubscribeEvents(m, &batybotSubscribeEventsServer{ServerStream: stream})
+}
+
+type Batybot_SubscribeEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type batybotSubscribeEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *batybotSubscribeEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Batybot_ServiceDesc is the grpc.ServiceDesc for Batybot service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Batybot_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "batybot.v1.Batybot",
+	HandlerType: (*BatybotServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SendMessage",
+			Handler:    _Batybot_SendMessage_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _Batybot_GetStatus_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeEvents",
+			Handler:       _Batybot_SubscribeEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "batybot.proto",
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	mathrand "math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+//go:embed web/bingo.html
+var bingoPageSource string
+
+var bingoPage = template.Must(template.New("bingo").Parse(bingoPageSource))
+
+// defaultBingoSize is how many squares across a bingo card is if the config
+// doesn't say.
+const defaultBingoSize = 5
+
+// bingo is the config for !bingo. Squares are things that might happen on
+// stream, which mods mark off as they do, and each card has size by size of
+// them, picked at random. With free_center the middle one's marked from the
+// start.
+type bingo struct {
+	Squares    []string `json:"squares"`
+	Size       int      `json:"size"` // default 5
+	FreeCenter bool     `json:"free_center"`
+}
+
+func (b bingo) size() int {
+	if b.Size <= 0 {
+		return defaultBingoSize
+	}
+
+	return b.Size
+}
+
+// needed is how many squares a card needs.
+func (b bingo) needed() int {
+	n := b.size() * b.size()
+	if b.FreeCenter && b.size()%2 == 1 {
+		return n - 1
+	}
+
+	return n
+}
+
+// freeSquare is the square on a card that's always marked.
+const freeSquare = -1
+
+// bingoCard is a chatter's card, the squares of the game going row by row.
+type bingoCard struct {
+	game    *bingoGame
+	userID  string
+	user    string
+	token   string // for its web page
+	squares []int  // freeSquare for the free one
+}
+
+// bingoGame is the bingo game going in a channel.
+type bingoGame struct {
+	channel    string
+	squares    []string
+	size       int
+	freeCenter bool
+	marked     map[int]bool
+	cards      map[string]*bingoCard // by user ID
+	won        map[string]bool       // by user ID
+}
+
+// newCard makes the user a card of squares in a random order.
+func (g *bingoGame) newCard(user twitch.User) *bingoCard {
+	cells := g.size * g.size
+	free := g.freeCenter && g.size%2 == 1
+
+	picked := mathrand.Perm(len(g.squares))
+	card := &bingoCard{game: g, userID: user.ID, user: user.DisplayName, token: bingoToken()}
+	for i := 0; i < cells; i++ {
+		if free && i == cells/2 {
+			card.squares = append(card.squares, freeSquare)
+			continue
+		}
+		card.squares = append(card.squares, picked[0])
+		picked = picked[1:]
+	}
+
+	return card
+}
+
+func (g *bingoGame) isMarked(square int) bool {
+	return square == freeSquare || g.marked[square]
+}
+
+// hasBingo reports whether a row, column, or diagonal of the card is marked.
+func (g *bingoGame) hasBingo(card *bingoCard) bool {
+	n := g.size
+	marked := func(row, col int) bool { return g.isMarked(card.squares[row*n+col]) }
+
+	diagonal, antiDiagonal := true, true
+	for i := 0; i < n; i++ {
+		row, col := true, true
+		for j := 0; j < n; j++ {
+			row = row && marked(i, j)
+			col = col && marked(j, i)
+		}
+		if row || col {
+			return true
+		}
+
+		diagonal = diagonal && marked(i, i)
+		antiDiagonal = antiDiagonal && marked(i, n-1-i)
+	}
+
+	return diagonal || antiDiagonal
+}
+
+// text writes the card out for a whisper, a row at a time, with marked
+// squares in brackets.
+func (g *bingoGame) text(card *bingoCard) string {
+	rows := make([]string, 0, g.size)
+	for row := 0; row < g.size; row++ {
+		var cells []string
+		for _, square := range card.squares[row*g.size : (row+1)*g.size] {
+			text := "FREE"
+			if square != freeSquare {
+				text = g.squares[square]
+			}
+			if g.isMarked(square) {
+				text = "[" + text + "]"
+			}
+			cells = append(cells, text)
+		}
+		rows = append(rows, strings.Join(cells, " | "))
+	}
+
+	return strings.Join(rows, " / ")
+}
+
+// bingoToken returns a random token for a card's web page, which is all it
+// takes to see it.
+func bingoToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// bingoTracker holds the bingo game in each channel.
+type bingoTracker struct {
+	mu     sync.Mutex
+	games  map[string]*bingoGame // by channel
+	tokens map[string]*bingoCard
+}
+
+// start begins a new game in the channel with the squares, ending the last
+// one.
+func (t *bingoTracker) start(channel string, squares []string, conf bingo) error {
+	g := &bingoGame{
+		channel:    channel,
+		squares:    squares,
+		size:       conf.size(),
+		freeCenter: conf.FreeCenter,
+		marked:     map[int]bool{},
+		cards:      map[string]*bingoCard{},
+		won:        map[string]bool{},
+	}
+	if len(squares) < conf.needed() {
+		return fmt.Errorf("start: a %dx%d card needs %d squares, there are %d", g.size, g.size, conf.needed(), len(squares))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.endLocked(channel)
+	t.games[channel] = g
+
+	return nil
+}
+
+// end stops the channel's game, reporting whether there was one.
+func (t *bingoTracker) end(channel string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.endLocked(channel)
+}
+
+func (t *bingoTracker) endLocked(channel string) bool {
+	g := t.games[channel]
+	if g == nil {
+		return false
+	}
+
+	for _, card := range g.cards {
+		delete(t.tokens, card.token)
+	}
+	delete(t.games, channel)
+
+	return true
+}
+
+// card returns the user's card in the channel's game as text and its token,
+// making them one if they don't have one yet.
+func (t *bingoTracker) card(channel string, user twitch.User) (string, string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.games[channel]
+	if g == nil {
+		return "", "", false
+	}
+
+	card := g.cards[user.ID]
+	if card == nil {
+		card = g.newCard(user)
+		g.cards[user.ID] = card
+		t.tokens[card.token] = card
+	}
+
+	return g.text(card), card.token, true
+}
+
+// squares returns the squares of the channel's game.
+func (t *bingoTracker) squares(channel string) ([]string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.games[channel]
+	if g == nil {
+		return nil, false
+	}
+
+	return g.squares, true
+}
+
+// mark marks the square, by its number or the only one with the text in it,
+// returning the square and whoever got bingo from it.
+func (t *bingoTracker) mark(channel, query string) (string, []string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.games[channel]
+	if g == nil {
+		return "", nil, fmt.Errorf("mark: there's no bingo game")
+	}
+
+	square := -1
+	if n, err := strconv.Atoi(query); err == nil && n >= 1 && n <= len(g.squares) {
+		square = n - 1
+	} else {
+		for i, s := range g.squares {
+			if !strings.Contains(strings.ToLower(s), strings.ToLower(query)) {
+				continue
+			} else if square >= 0 {
+				return "", nil, fmt.Errorf("mark: more than one square has %q", query)
+			}
+			square = i
+		}
+	}
+	if square < 0 {
+		return "", nil, fmt.Errorf("mark: no square has %q", query)
+	}
+
+	g.marked[square] = true
+
+	var winners []string
+	for _, id := range sortedKeys(g.cards) {
+		card := g.cards[id]
+		if !g.won[id] && g.hasBingo(card) {
+			g.won[id] = true
+			winners = append(winners, card.user)
+		}
+	}
+
+	return g.squares[square], winners, nil
+}
+
+// bingoCell is a square on a card's web page.
+type bingoCell struct {
+	Text   string
+	Marked bool
+}
+
+// bingoView is what a card's web page shows.
+type bingoView struct {
+	Channel string
+	User    string
+	Rows    [][]bingoCell
+	Won     bool
+}
+
+// view returns the card with the token, as its page shows it.
+func (t *bingoTracker) view(token string) (bingoView, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	card := t.tokens[token]
+	if card == nil {
+		return bingoView{}, false
+	}
+	g := card.game
+
+	v := bingoView{Channel: g.channel, User: card.user, Won: g.won[card.userID]}
+	for row := 0; row < g.size; row++ {
+		var cells []bingoCell
+		for _, square := range card.squares[row*g.size : (row+1)*g.size] {
+			text := "FREE"
+			if square != freeSquare {
+				text = g.squares[square]
+			}
+			cells = append(cells, bingoCell{Text: text, Marked: g.isMarked(square)})
+		}
+		v.Rows = append(v.Rows, cells)
+	}
+
+	return v, true
+}
+
+// bingoURL returns where cards' web pages are, BINGO_URL or BINGO_LISTEN's
+// address, or nothing if they aren't served.
+func bingoURL() string {
+	if u := os.Getenv("BINGO_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	} else if addr := os.Getenv("BINGO_LISTEN"); addr != "" {
+		return "http://" + addr
+	}
+
+	return ""
+}
+
+// bingoServer serves a page for each bingo card at /bingo/token, which keeps
+// up as squares are marked.
+type bingoServer struct {
+	http.Server
+	games *bingoTracker
+}
+
+func newBingoServer(addr string, games *bingoTracker) *bingoServer {
+	s := &bingoServer{games: games}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bingo/", s.page)
+
+	s.Addr = addr
+	s.Handler = mux
+
+	return s
+}
+
+func (s *bingoServer) Start() error {
+	return fmt.Errorf("unable to start bingo server: %w", s.ListenAndServe())
+}
+
+func (s *bingoServer) page(w http.ResponseWriter, r *http.Request) {
+	v, ok := s.games.view(strings.TrimPrefix(r.URL.Path, "/bingo/"))
+	if !ok {
+		http.Error(w, "This card isn't in a game that's going", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := bingoPage.Execute(w, v); err != nil {
+		log.Errorf("unable to write bingo card: %v", err)
+	}
+}
+
+// runBingo runs !bingo, which gives chatters a card, and !mark. Cards are
+// whispered, as a link to their page if cards are served, unless dryRun,
+// when they're replied with instead. Mods run the game with !bingo start,
+// with the config's squares or ones separated by semicolons, !mark with a
+// square's number or some of its text, and !bingo end. Anyone can list the
+// squares with !bingo squares.
+func runBingo(c *chatContext, dryRun bool) bool {
+	name, args, ok := parseCommand(c.message.Message)
+	if !ok || (name != "bingo" && name != "mark") {
+		return false
+	}
+
+	channel := c.message.Channel
+	reply := func(text string) { c.client.Reply(channel, c.message.ID, text) }
+
+	sub := "card"
+	if name == "mark" {
+		sub = "mark"
+	} else if len(args) > 0 {
+		sub, args = strings.ToLower(args[0]), args[1:]
+	}
+
+	switch sub {
+	case "card":
+		text, token, ok := c.bot.bingo.card(channel, c.message.User)
+		if !ok {
+			reply("There's no bingo game going")
+			return true
+		}
+		if u := bingoURL(); u != "" {
+			text = u + "/bingo/" + token
+		}
+		text = "Your bingo card for " + channel + ": " + text
+
+		if dryRun {
+			reply(text)
+			return true
+		}
+
+		user := c.message.User
+		go func() {
+			if err := c.bot.api.whisper(user.ID, text); err != nil {
+				log.Errorf("unable to whisper %s their bingo card: %v", user.Name, err)
+				reply("I couldn't whisper you your card")
+			}
+		}()
+		return true
+	case "squares":
+		squares, ok := c.bot.bingo.squares(channel)
+		if !ok {
+			reply("There's no bingo game going")
+			return true
+		}
+
+		text := "Squares:"
+		for i, s := range squares {
+			square := fmt.Sprintf(" %d. %s", i+1, s)
+			if len(text)+len(square) > chat.MaxMessageLength {
+				break
+			}
+			text += square
+		}
+		reply(text)
+		return true
+	}
+
+	if !c.privileged {
+		log.Debugf("%s tried to run mod command %s %s", c.message.User.Name, name, sub)
+		return true
+	}
+
+	conf := c.config.Bingo
+	switch sub {
+	case "start":
+		squares := conf.Squares
+		if len(args) > 0 {
+			squares = nil
+			for _, s := range strings.Split(strings.Join(args, " "), ";") {
+				if s = strings.TrimSpace(s); s != "" {
+					squares = append(squares, s)
+				}
+			}
+		}
+
+		if err := c.bot.bingo.start(channel, squares, conf); err != nil {
+			log.Debug(err)
+			reply(strings.TrimPrefix(err.Error(), "start: "))
+			return true
+		}
+		c.client.Say(channel, "Bingo's started! Type !bingo to get a card, squares are marked as they happen")
+	case "mark":
+		query := strings.Join(args, " ")
+		if query == "" {
+			reply("Usage: !mark number or text")
+			return true
+		}
+
+		square, winners, err := c.bot.bingo.mark(channel, query)
+		if err != nil {
+			reply(strings.TrimPrefix(err.Error(), "mark: "))
+			return true
+		}
+
+		text := "Marked " + square
+		if len(winners) > 0 {
+			text += ". BINGO for @" + strings.Join(winners, ", @") + "!"
+		}
+		c.client.Say(channel, text)
+	case "end":
+		if !c.bot.bingo.end(channel) {
+			reply("There's no bingo game going")
+			return true
+		}
+		c.client.Say(channel, "Bingo's over, thanks for playing!")
+	default:
+		reply("Usage: !bingo [card|squares|start [square; square; ...]|end]")
+	}
+
+	return true
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// bot is what a running bot is made of. It's passed to what needs the config,
+// chat, EventSub, or the bot's state rather than them being reached through
+// globals.
+type bot struct {
+	config  *configManager
+	client  *chat.Client // nil in app only mode
+	events  *eventSub    // nil without EVENTSUB_SECRET or EVENTSUB_TRANSPORT
+	overlay *overlay     // nil without OVERLAY_LISTEN
+	api     *twitchAPI   // nil until runBot or runAppOnly sets it up
+
+	// account is the account the bot chats as. Its user ID is filled in once
+	// the token is loaded or checked.
+	account   account
+	refreshes refreshRequests
+	status    *botStatus
+	modlog    *modLog
+	live      *liveState
+	panics    *panicMode
+	history   *chatHistory
+	custom    *customCommands
+	scripts   *scriptEngine
+	wasm      *wasmHost
+	commands  map[string]command // built in and the plugins'
+	steps     *pipelineSteps     // the plugins'
+
+	// What the bot keeps about chat and its channels.
+	bus       *eventBus
+	chatlog   *chatLog
+	stats     *chatStats
+	mood      *moodTracker
+	emotes    *emoteTracker
+	analytics *analyticsStore
+	brain     *chatterBrain
+	points    *pointsStore
+
+	// Games and features running in each channel.
+	features  *featureSet
+	pyramids  *pyramidTracker
+	combos    *comboTracker
+	raffles   *raffleTracker
+	winners   *raffleWinners
+	giveaways *giveawayTracker
+	bingo     *bingoTracker
+	wordGames *wordGameTracker
+	execs     *execTracker
+	goLive    *goLivePosts
+
+	// Background work and caches for chat.
+	toxicity   *toxicityFilter
+	translator *translator
+	fetcher    *urlFetcher
+	rates      *ratesCache
+	weather    *weatherCache
+	words      *wordMatchers
+
+	tokens        *tokenKeeper
+	notifications *notifier
+	services      *supervisor
+}
+
+func newBot(conf *configManager) *bot {
+	modlog := &modLog{}
+
+	return &bot{
+		config:    conf,
+		account:   account{Role: roleBot},
+		refreshes: make(refreshRequests),
+		status:    &botStatus{started: time.Now(), channels: map[string]bool{}},
+		modlog:    modlog,
+		live:      &liveState{},
+		panics:    &panicMode{modlog: modlog, previous: map[string]helix.ChatSettings{}},
+		history:   newChatHistory(1000),
+		custom:    &customCommands{commands: map[string]string{}},
+		scripts:   &scriptEngine{},
+		wasm:      &wasmHost{},
+		commands:  builtinCommands(),
+		steps:     &pipelineSteps{steps: map[string]middleware{}},
+
+		bus:       newEventBus(),
+		chatlog:   &chatLog{index: map[string][]int{}},
+		stats:     &chatStats{messages: map[string][]chatStat{}},
+		mood:      &moodTracker{scores: map[string][]moodScore{}},
+		emotes:    &emoteTracker{},
+		analytics: &analyticsStore{},
+		brain:     &chatterBrain{},
+		points:    &pointsStore{},
+
+		features:  &featureSet{disabled: map[string]bool{}},
+		pyramids:  &pyramidTracker{building: map[string]*pyramidProgress{}},
+		combos:    &comboTracker{combos: map[string]*combo{}},
+		raffles:   &raffleTracker{draws: map[string]*raffleDraw{}},
+		winners:   &raffleWinners{},
+		giveaways: &giveawayTracker{draws: map[string]*giveawayDraw{}},
+		bingo:     &bingoTracker{games: map[string]*bingoGame{}, tokens: map[string]*bingoCard{}},
+		wordGames: &wordGameTracker{games: map[string]*wordGame{}, ended: map[string]time.Time{}},
+		execs:     &execTracker{},
+		goLive:    &goLivePosts{},
+
+		toxicity:   &toxicityFilter{messages: make(chan toxicityCheck, toxicityQueue)},
+		translator: &translator{messages: make(chan *chatContext, translateQueue)},
+		fetcher:    newURLFetcher(),
+		rates:      &ratesCache{rates: map[string]cachedRates{}},
+		weather:    &weatherCache{reports: map[string]cachedWeather{}},
+		words:      &wordMatchers{},
+
+		tokens:        &tokenKeeper{},
+		notifications: newNotifier(),
+		services:      newSupervisor(),
+	}
+}
+
+// reloadConfig reads CONFIG_FILE again, warning about any changes that need
+// a restart.
+func (b *bot) reloadConfig() error {
+	old, err := b.config.reload()
+	if err != nil {
+		return fmt.Errorf("reloadConfig: %w", err)
+	}
+
+	for _, change := range restartNeeded(old, b.config.get(), b.events != nil) {
+		log.Warnf("%s, restart the bot for it to take effect", change)
+	}
+
+	return nil
+}
+
+// reload reads the config, custom commands, scripts, and wasm plugins again,
+// keeping the current ones if any can't be read.
+func (b *bot) reload() {
+	if os.Getenv("CONFIG_FILE") != "" {
+		if err := b.reloadConfig(); err != nil {
+			log.Errorf("unable to reload config: %v", err)
+		} else {
+			log.Info("config reloaded")
+		}
+	}
+
+	if file := os.Getenv("COMMANDS_FILE"); file != "" {
+		if err := b.custom.load(file); err != nil {
+			log.Errorf("unable to reload commands: %v", err)
+		} else {
+			log.Info("commands reloaded")
+		}
+	}
+
+	if os.Getenv("SCRIPTS_DIR") != "" {
+		if err := b.scripts.reload(); err != nil {
+			log.Errorf("unable to reload scripts: %v", err)
+		}
+	}
+
+	if os.Getenv("WASM_DIR") != "" {
+		if err := b.wasm.reload(); err != nil {
+			log.Errorf("unable to reload wasm plugins: %v", err)
+		}
+	}
+}
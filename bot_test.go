@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gempir/go-twitch-irc/v4"
+)
+
+// TestBotsDontShareState checks what one bot keeps about chat isn't seen by
+// another in the same process.
+func TestBotsDontShareState(t *testing.T) {
+	one, other := newBot(nil), newBot(nil)
+
+	one.features.set(featureSounds, false)
+	if !other.features.enabled(featureSounds) {
+		t.Error("muting one bot's sounds muted the other's")
+	}
+
+	events, unsubscribe := other.bus.subscribe()
+	defer unsubscribe()
+
+	message := twitch.PrivateMessage{Channel: "channel", User: twitch.User{ID: "1", Name: "viewer"}, Message: "hi"}
+	one.bus.onPrivateMessage(message)
+	select {
+	case e := <-events:
+		t.Errorf("the other bot's bus got %+v", e)
+	default:
+	}
+
+	one.stats.add(message, message.Time)
+	if n := other.stats.purge("1"); n != 0 {
+		t.Errorf("the other bot had %d of the chatter's messages", n)
+	}
+	if n := one.stats.purge("1"); n != 1 {
+		t.Errorf("purged %d of the chatter's messages, want 1", n)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// discordBridge mirrors a Twitch channel's chat into a Discord channel and
+// relays messages from it back.
+type discordBridge struct {
+	Channel string   `json:"channel"`          // Discord channel ID
+	Twitch  string   `json:"twitch,omitempty"` // TWITCH_CHANNEL if unset
+	Ignore  []string `json:"ignore,omitempty"` // Twitch or Discord users whose messages aren't relayed, such as other bots
+	Filter  []string `json:"filter,omitempty"` // messages containing any of these aren't relayed
+}
+
+// relays reports whether a message from the user should be passed across.
+func (b discordBridge) relays(user, message string) bool {
+	for _, ignored := range b.Ignore {
+		if strings.EqualFold(user, ignored) {
+			return false
+		}
+	}
+
+	message = strings.ToLower(message)
+	for _, word := range b.Filter {
+		if strings.Contains(message, strings.ToLower(word)) {
+			return false
+		}
+	}
+
+	return message != ""
+}
+
+// markdownEscaper keeps Twitch chat from being formatted by Discord.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`", "|", `\|`, ">", `\>`,
+)
+
+// chatBridge is the Discord bot that runs the config's discord.bridge.
+type chatBridge struct {
+	session *discordgo.Session
+	config  *configManager
+	client  chat.Sender
+	panics  *panicMode
+	channel string // default Twitch channel
+
+	// Subscribed once, rather than in Start, so restarts don't relay
+	// messages twice.
+	events <-chan event
+	stop   func()
+}
+
+func newChatBridge(token string, b *bot, channel string) (*chatBridge, error) {
+	session, err := discordgo.New("Bot " + token)
+	if err != nil {
+		return nil, fmt.Errorf("newChatBridge: unable to set up discord: %w", err)
+	}
+
+	session.Identify.Intents = discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent
+
+	events, unsubscribe := b.bus.subscribe()
+	bridge := &chatBridge{session: session, config: b.config, client: b.client, panics: b.panics, channel: channel, events: events, stop: unsubscribe}
+	session.AddHandler(bridge.onDiscordMessage)
+
+	return bridge, nil
+}
+
+// Start connects to Discord and mirrors Twitch chat until Shutdown.
+func (b *chatBridge) Start() error {
+	if err := b.session.Open(); err != nil {
+		return fmt.Errorf("unable to connect to discord: %w", err)
+	}
+
+	for e := range b.events {
+		if e.Type != eventTypeMessage {
+			continue
+		}
+
+		c := b.config.get().Discord.Bridge
+		if c.Channel == "" || !strings.EqualFold(e.Channel, b.twitchChannel(c)) || b.panics.active(e.Channel) || !c.relays(e.User, e.Message) {
+			continue
+		}
+
+		_, err := b.session.ChannelMessageSendComplex(c.Channel, &discordgo.MessageSend{
+			Content: fmt.Sprintf("**%s**: %s", markdownEscaper.Replace(e.User), markdownEscaper.Replace(e.Message)),
+			// Chat shouldn't be able to ping anyone on the server.
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		})
+		if err != nil {
+			log.Errorf("unable to relay message to discord: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Shutdown disconnects from Discord, ending Start.
+func (b *chatBridge) Shutdown(ctx context.Context) error {
+	b.stop()
+
+	if err := b.session.Close(); err != nil {
+		return fmt.Errorf("unable to disconnect from discord: %w", err)
+	}
+
+	return nil
+}
+
+func (b *chatBridge) twitchChannel(c discordBridge) string {
+	if c.Twitch != "" {
+		return c.Twitch
+	}
+
+	return b.channel
+}
+
+func (b *chatBridge) onDiscordMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
+	c := b.config.get().Discord.Bridge
+	if m.ChannelID != c.Channel || m.Author == nil || m.Author.Bot {
+		return
+	}
+
+	name := m.Author.DisplayName()
+	if m.Member != nil && m.Member.Nick != "" {
+		name = m.Member.Nick
+	}
+
+	text := strings.Join(strings.Fields(m.ContentWithMentionsReplaced()), " ")
+	channel := b.twitchChannel(c)
+	if b.panics.active(channel) || !c.relays(m.Author.Username, text) || !c.relays(name, text) {
+		return
+	}
+
+	b.client.Say(channel, fmt.Sprintf("[Discord] %s: %s", name, text))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+	"github.com/nicklaw5/helix/v2"
+)
+
+// Types of event.
+const (
+	eventTypeMessage = "message"
+	eventTypeSub     = "sub"
+	eventTypeGift    = "gift"
+	eventTypeRaid    = "raid"
+	eventTypeCheer   = "cheer"
+	eventTypeFollow  = "follow"
+)
+
+// event is something that happened in a channel, normalized from whichever of
+// IRC or EventSub it came from.
+type event struct {
+	Type    string    `json:"type"`
+	Channel string    `json:"channel"`
+	User    string    `json:"user,omitempty"`
+	UserID  string    `json:"user_id,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Amount  int       `json:"amount,omitempty"` // bits, raiders, months subbed, or subs gifted
+	Tier    string    `json:"tier,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// eventBus fans events out to everything that wants them.
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan event]bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{subscribers: map[chan event]bool{}}
+}
+
+// subscribe returns a channel of every event published from now on, and a
+// function to stop receiving them.
+func (b *eventBus) subscribe() (<-chan event, func()) {
+	ch := make(chan event, 100)
+
+	b.mu.Lock()
+	b.subscribers[ch] = true
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if b.subscribers[ch] {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// publish sends the event to every subscriber, dropping it for any that have
+// fallen too far behind rather than holding up the bot.
+func (b *eventBus) publish(e event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			log.Warnf("dropped %s event for a slow subscriber", e.Type)
+		}
+	}
+}
+
+func (b *eventBus) onPrivateMessage(message twitch.PrivateMessage) {
+	e := event{
+		Type:    eventTypeMessage,
+		Channel: message.Channel,
+		User:    message.User.DisplayName,
+		UserID:  message.User.ID,
+		Message: message.Message,
+		Time:    message.Time,
+	}
+	b.publish(e)
+
+	if message.Bits > 0 {
+		e.Type = eventTypeCheer
+		e.Amount = message.Bits
+		b.publish(e)
+	}
+}
+
+// onUserNotice publishes subs, gifts, and raids. These come from IRC rather
+// than EventSub so they work without EventSub set up.
+func (b *eventBus) onUserNotice(message twitch.UserNoticeMessage) {
+	e := event{
+		Channel: message.Channel,
+		User:    message.User.DisplayName,
+		UserID:  message.User.ID,
+		Message: message.Message,
+		Tier:    message.MsgParams["msg-param-sub-plan"],
+		Time:    message.Time,
+	}
+
+	switch message.MsgID {
+	case "sub", "resub":
+		e.Type = eventTypeSub
+		e.Amount, _ = strconv.Atoi(message.MsgParams["msg-param-cumulative-months"])
+	case "submysterygift":
+		e.Type = eventTypeGift
+		e.Amount, _ = strconv.Atoi(message.MsgParams["msg-param-mass-gift-count"])
+	case "subgift":
+		// Gifts in a submysterygift each get their own notice as well.
+		if message.MsgParams["msg-param-community-gift-id"] != "" {
+			return
+		}
+		e.Type = eventTypeGift
+		e.Amount = 1
+	case "raid":
+		e.Type = eventTypeRaid
+		e.Amount, _ = strconv.Atoi(message.MsgParams["msg-param-viewerCount"])
+	default:
+		return
+	}
+
+	b.publish(e)
+}
+
+// onFollow publishes follows, which are only available from EventSub.
+func (b *eventBus) onFollow(raw json.RawMessage) {
+	var follow helix.EventSubChannelFollowEvent
+	if err := json.Unmarshal(raw, &follow); err != nil {
+		log.WithField("event_type", helix.EventSubTypeChannelFollow).Errorf("invalid follow event: %v", err)
+		return
+	}
+
+	b.publish(event{
+		Type:    eventTypeFollow,
+		Channel: follow.BroadcasterUserLogin,
+		User:    follow.UserName,
+		UserID:  follow.UserID,
+	})
+}
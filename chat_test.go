@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/fakeirc"
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// TestChatReply runs the bot's chat handling against fakeirc, the way runBot
+// sets it up, and checks it replies to a custom command.
+func TestChatReply(t *testing.T) {
+	t.Setenv("TWITCH_CLIENT_ID", "test")
+	t.Setenv("CHAT_API", "")
+
+	server, err := fakeirc.Start("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	conf, err := newConfigManager("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := newBot(conf)
+	if err := b.custom.set("hello", "Hello, {user}!"); err != nil {
+		t.Fatal(err)
+	}
+
+	b.api, err = newTwitchAPI("token", b.refreshes, b.status, b.modlog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	irc := twitch.NewClient("batybot", "oauth:token")
+	irc.IrcAddress = server.Addr()
+	irc.TLS = false
+
+	b.client = chat.NewClient(irc, b.api, b.refreshes.now, log)
+	b.client.OnPrivateMessage(newChatHandler(b, b.client, true).onMessage)
+
+	joined := make(chan struct{})
+	b.client.OnSelfJoinMessage(func(twitch.UserJoinMessage) { close(joined) })
+	b.client.Join("channel")
+
+	done := make(chan error, 1)
+	go func() { done <- b.client.Run() }()
+	defer func() {
+		b.client.Disconnect()
+		<-done
+	}()
+
+	select {
+	case <-joined:
+	case err := <-done:
+		t.Fatalf("unable to connect: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out joining the channel")
+	}
+
+	server.Privmsg("channel", "viewer", "!hello", nil)
+
+	select {
+	case said := <-server.Received():
+		if want := "PRIVMSG #channel :Hello, viewer!"; said != want {
+			t.Errorf("said %q, want %q", said, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a reply")
+	}
+}
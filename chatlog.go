@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+const (
+	// defaultChatLogDays is how many days of chat are kept if CHATLOG_DAYS
+	// isn't set.
+	defaultChatLogDays = 30
+	// maxSearchResults is the most messages a search returns.
+	maxSearchResults = 100
+)
+
+// chatLogEntry is a message in the chat log.
+type chatLogEntry struct {
+	Time    time.Time `json:"time"`
+	Channel string    `json:"channel"`
+	ID      string    `json:"id"`
+	UserID  string    `json:"user_id"`
+	User    string    `json:"user"`
+	Message string    `json:"message"`
+}
+
+// chatLog keeps chat in CHATLOG_DIR, a file of JSON lines a day, and indexes
+// every word of it in memory so it can be searched.
+type chatLog struct {
+	mu      sync.Mutex
+	dir     string // empty when it's off
+	days    int
+	entries []chatLogEntry // oldest first
+	index   map[string][]int
+	day     string // of the latest entry, to know when to drop old ones
+}
+
+// searchTerms splits text into the lowercase words it's indexed by.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// load reads the last days of chat from dir, and keeps chat there from now on.
+func (l *chatLog) load(dir string, days int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("load: unable to create %q: %w", dir, err)
+	}
+	l.dir, l.days = dir, days
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+	sort.Strings(files)
+
+	now := time.Now()
+	oldest := l.oldestLocked(now)
+	for _, file := range files {
+		if strings.TrimSuffix(filepath.Base(file), ".jsonl") < oldest {
+			continue
+		}
+
+		if err := l.readLocked(file); err != nil {
+			return fmt.Errorf("load: %w", err)
+		}
+	}
+	l.day = now.Format(dateFormat)
+	l.pruneLocked(now)
+
+	return nil
+}
+
+func (l *chatLog) readLocked(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("unable to open %q: %w", file, err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e chatLogEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return fmt.Errorf("invalid entry in %q: %w", file, err)
+		}
+		l.indexLocked(e)
+	}
+
+	return s.Err()
+}
+
+// oldestLocked returns the date of the oldest day of chat that's kept at now.
+func (l *chatLog) oldestLocked(now time.Time) string {
+	return now.AddDate(0, 0, 1-l.days).Format(dateFormat)
+}
+
+func (l *chatLog) indexLocked(e chatLogEntry) {
+	i := len(l.entries)
+	l.entries = append(l.entries, e)
+
+	seen := map[string]bool{}
+	for _, term := range append(searchTerms(e.Message), searchTerms(e.User)...) {
+		if !seen[term] {
+			seen[term] = true
+			l.index[term] = append(l.index[term], i)
+		}
+	}
+}
+
+// rebuildLocked indexes the entries again, after some have been dropped.
+func (l *chatLog) rebuildLocked(entries []chatLogEntry) {
+	l.entries = nil
+	l.index = map[string][]int{}
+	for _, e := range entries {
+		l.indexLocked(e)
+	}
+}
+
+func (l *chatLog) enabled() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.dir != ""
+}
+
+func (l *chatLog) add(message twitch.PrivateMessage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.dir == "" {
+		return
+	}
+
+	e := chatLogEntry{
+		Time:    message.Time,
+		Channel: message.Channel,
+		ID:      message.ID,
+		UserID:  message.User.ID,
+		User:    message.User.DisplayName,
+		Message: message.Message,
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if day := e.Time.Local().Format(dateFormat); day != l.day {
+		l.day = day
+		l.pruneLocked(e.Time)
+	}
+	l.indexLocked(e)
+
+	if err := l.appendLocked(e); err != nil {
+		log.Errorf("unable to write chat log: %v", err)
+	}
+}
+
+func (l *chatLog) appendLocked(e chatLogEntry) error {
+	file := filepath.Join(l.dir, e.Time.Local().Format(dateFormat)+".jsonl")
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("append: unable to open %q: %w", file, err)
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(e); err != nil {
+		return fmt.Errorf("append: unable to write %q: %w", file, err)
+	}
+
+	return nil
+}
+
+// pruneLocked drops the days of chat that are too old to keep at now, from
+// memory and CHATLOG_DIR.
+func (l *chatLog) pruneLocked(now time.Time) {
+	oldest := l.oldestLocked(now)
+
+	files, _ := filepath.Glob(filepath.Join(l.dir, "*.jsonl"))
+	for _, file := range files {
+		if strings.TrimSuffix(filepath.Base(file), ".jsonl") < oldest {
+			if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Errorf("unable to remove old chat log: %v", err)
+			}
+		}
+	}
+
+	i := 0
+	for i < len(l.entries) && l.entries[i].Time.Local().Format(dateFormat) < oldest {
+		i++
+	}
+	if i > 0 {
+		l.rebuildLocked(l.entries[i:])
+	}
+}
+
+// purge removes everything the user sent from the chat log, in memory and in
+// CHATLOG_DIR, returning how many messages there were.
+func (l *chatLog) purge(userID string) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	kept := make([]chatLogEntry, 0, len(l.entries))
+	for _, e := range l.entries {
+		if e.UserID != userID {
+			kept = append(kept, e)
+		}
+	}
+	purged := len(l.entries) - len(kept)
+	if purged > 0 {
+		l.rebuildLocked(kept)
+	}
+
+	if l.dir == "" {
+		return purged, nil
+	}
+
+	// Days that aren't loaded yet are on disk too.
+	files, err := filepath.Glob(filepath.Join(l.dir, "*.jsonl"))
+	if err != nil {
+		return purged, fmt.Errorf("purge: %w", err)
+	}
+	for _, file := range files {
+		if err := purgeJSONLines(file, func(e chatLogEntry) bool { return e.UserID == userID }); err != nil {
+			return purged, fmt.Errorf("purge: %w", err)
+		}
+	}
+
+	return purged, nil
+}
+
+// search returns the channel's messages that have every word in query, or
+// are from someone named by it, newest first, up to limit of them.
+func (l *chatLog) search(channel, query string, limit int) []chatLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	// Go through the rarest term's messages, checking the others have them
+	// too.
+	sort.Slice(terms, func(i, j int) bool { return len(l.index[terms[i]]) < len(l.index[terms[j]]) })
+	rest := make([]map[int]bool, len(terms)-1)
+	for i, term := range terms[1:] {
+		rest[i] = map[int]bool{}
+		for _, n := range l.index[term] {
+			rest[i][n] = true
+		}
+	}
+
+	var found []chatLogEntry
+	matches := l.index[terms[0]]
+	for i := len(matches) - 1; i >= 0 && len(found) < limit; i-- {
+		n := matches[i]
+		if l.entries[n].Channel != channel {
+			continue
+		}
+
+		all := true
+		for _, r := range rest {
+			if !r[n] {
+				all = false
+				break
+			}
+		}
+		if all {
+			found = append(found, l.entries[n])
+		}
+	}
+
+	return found
+}
+
+// searchCommand replies with the most recent messages that match, as many as
+// fit in a message.
+func searchCommand(b *bot, client chat.Sender, message twitch.PrivateMessage, args []string) {
+	if !b.chatlog.enabled() {
+		client.Reply(message.Channel, message.ID, "Chat isn't being logged, set CHATLOG_DIR to search it")
+		return
+	} else if len(args) == 0 {
+		client.Reply(message.Channel, message.ID, "Usage: !search words or a name")
+		return
+	}
+
+	found := b.chatlog.search(message.Channel, strings.Join(args, " "), 10)
+	// The search itself is logged too.
+	if len(found) > 0 && found[0].ID == message.ID {
+		found = found[1:]
+	}
+	if len(found) == 0 {
+		client.Reply(message.Channel, message.ID, "Nothing found")
+		return
+	}
+
+	reply := "Newest first:"
+	for _, e := range found {
+		line := fmt.Sprintf(" [%s] %s: %s", e.Time.Local().Format("Jan 2 15:04"), e.User, e.Message)
+		if len(reply)+len(line) > chat.MaxMessageLength {
+			break
+		}
+		reply += line
+	}
+
+	client.Reply(message.Channel, message.ID, reply)
+}
+
+// chatLogDays returns CHATLOG_DAYS, or defaultChatLogDays if it isn't set.
+func chatLogDays() (int, error) {
+	v := os.Getenv("CHATLOG_DAYS")
+	if v == "" {
+		return defaultChatLogDays, nil
+	}
+
+	days, err := strconv.Atoi(v)
+	if err != nil || days < 1 {
+		return 0, fmt.Errorf("chatLogDays: CHATLOG_DAYS should be a number of days, not %q", v)
+	}
+
+	return days, nil
+}
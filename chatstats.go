@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+const (
+	// chatStatsWindow is how far back !chatstats goes.
+	chatStatsWindow = time.Hour
+	// chatSpeedWindow is how far back chat's speed is measured.
+	chatSpeedWindow = time.Minute
+)
+
+// chatStats keeps who sent each message in the last chatStatsWindow, per
+// channel, which is all !chatstats needs. It's only kept in memory.
+type chatStats struct {
+	mu       sync.Mutex
+	messages map[string][]chatStat // by channel, oldest first
+}
+
+type chatStat struct {
+	at     time.Time
+	userID string
+	user   string
+}
+
+func (s *chatStats) add(message twitch.PrivateMessage, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stat := chatStat{at: at, userID: message.User.ID, user: message.User.DisplayName}
+	s.messages[message.Channel] = append(s.pruneLocked(message.Channel, at), stat)
+}
+
+// pruneLocked drops the channel's messages that are too old to count at now.
+func (s *chatStats) pruneLocked(channel string, now time.Time) []chatStat {
+	messages := s.messages[channel]
+	i := 0
+	for i < len(messages) && now.Sub(messages[i].at) > chatStatsWindow {
+		i++
+	}
+	messages = messages[i:]
+
+	if len(messages) == 0 {
+		delete(s.messages, channel)
+	} else {
+		s.messages[channel] = messages
+	}
+
+	return messages
+}
+
+// purge removes the user's messages, returning how many there were.
+func (s *chatStats) purge(userID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := 0
+	for channel, messages := range s.messages {
+		kept := messages[:0]
+		for _, m := range messages {
+			if m.userID != userID {
+				kept = append(kept, m)
+			}
+		}
+		purged += len(messages) - len(kept)
+
+		if len(kept) == 0 {
+			delete(s.messages, channel)
+		} else {
+			s.messages[channel] = kept
+		}
+	}
+
+	return purged
+}
+
+// chatSummary is a channel's chat over the last chatStatsWindow.
+type chatSummary struct {
+	messages  int
+	chatters  int
+	top       string // who sent the most messages
+	topCount  int
+	perMinute int // messages in the last chatSpeedWindow
+}
+
+func (s *chatStats) summary(channel string, now time.Time) chatSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var summary chatSummary
+	messages := s.pruneLocked(channel, now)
+	counts := map[string]int{}
+	for _, m := range messages {
+		counts[m.userID]++
+		if n := counts[m.userID]; n > summary.topCount {
+			summary.top, summary.topCount = m.user, n
+		}
+		if now.Sub(m.at) <= chatSpeedWindow {
+			summary.perMinute++
+		}
+	}
+	summary.messages = len(messages)
+	summary.chatters = len(counts)
+
+	return summary
+}
+
+func (c chatSummary) String() string {
+	if c.messages == 0 {
+		return fmt.Sprintf("Nobody's said anything in the last %s", shortDuration(chatStatsWindow))
+	}
+
+	return fmt.Sprintf("In the last %s: %d messages from %d chatters, the most from %s (%d). Chat's going at %d messages a minute",
+		shortDuration(chatStatsWindow), c.messages, c.chatters, c.top, c.topCount, c.perMinute)
+}
+
+func chatStatsCommand(b *bot, client chat.Sender, message twitch.PrivateMessage, args []string) {
+	// Going by when it was sent keeps replays right.
+	now := message.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	client.Reply(message.Channel, message.ID, b.stats.summary(message.Channel, now).String())
+}
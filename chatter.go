@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxChatterLines is how many messages are remembered per channel, the
+	// oldest being forgotten first.
+	maxChatterLines = 5000
+	// minChatterLines is how many messages have to be learned before
+	// !chatter says anything.
+	minChatterLines = 50
+	// maxChatterWords is the longest a generated message can be.
+	maxChatterWords = 30
+	// chatterSaveEvery is how many messages are learned between saves.
+	chatterSaveEvery = 25
+	// defaultChatterCooldown is how long between !chatters in a channel if
+	// the config doesn't say.
+	defaultChatterCooldown = 30 * time.Second
+)
+
+// chatter is the config for !chatter, which makes up messages from what's
+// been said in chat. It's off unless enabled.
+type chatter struct {
+	Enabled  bool     `json:"enabled"`
+	Exclude  []string `json:"exclude"`  // chatters whose messages aren't learned
+	Cooldown string   `json:"cooldown"` // default 30s
+}
+
+func (c chatter) excluded(user string) bool {
+	for _, u := range c.Exclude {
+		if strings.EqualFold(u, user) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// chatterLine is a message chatter learned from, kept so what one chatter
+// said can be forgotten.
+type chatterLine struct {
+	UserID string `json:"user_id"`
+	User   string `json:"user"`
+	Text   string `json:"text"`
+}
+
+// markovChain maps two words to the words that have followed them, and how
+// often. An empty word marks the start or end of a message.
+type markovChain map[[2]string]map[string]int
+
+func (m markovChain) learn(text string) {
+	prev := [2]string{}
+	for _, word := range append(strings.Fields(text), "") {
+		next, ok := m[prev]
+		if !ok {
+			next = map[string]int{}
+			m[prev] = next
+		}
+		next[word]++
+		prev = [2]string{prev[1], word}
+	}
+}
+
+func (m markovChain) generate(r *rand.Rand) string {
+	var words []string
+	prev := [2]string{}
+	for len(words) < maxChatterWords {
+		next := m[prev]
+		total := 0
+		for _, n := range next {
+			total += n
+		}
+		if total == 0 {
+			break
+		}
+
+		// Map order isn't stable, but any order works for a weighted pick.
+		pick := r.Intn(total)
+		var word string
+		for w, n := range next {
+			if pick < n {
+				word = w
+				break
+			}
+			pick -= n
+		}
+		if word == "" {
+			break
+		}
+
+		words = append(words, word)
+		prev = [2]string{prev[1], word}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// chatterBrain is what's been learned in each channel, saved to chatter.json
+// in the state directory.
+type chatterBrain struct {
+	mu       sync.Mutex
+	loaded   bool
+	file     string // empty to keep it in memory
+	lines    map[string][]chatterLine
+	chains   map[string]markovChain
+	unsaved  int
+	lastSaid map[string]time.Time
+	rand     *rand.Rand
+}
+
+// loadLocked reads what was learned before, the first time it's needed.
+func (b *chatterBrain) loadLocked() {
+	if b.loaded {
+		return
+	}
+	b.loaded = true
+	b.lines = map[string][]chatterLine{}
+	b.chains = map[string]markovChain{}
+	b.lastSaid = map[string]time.Time{}
+	b.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Warnf("chatter will only be kept in memory: %v", err)
+		return
+	}
+	b.file = filepath.Join(dir, "chatter.json")
+
+	data, err := os.ReadFile(b.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Errorf("unable to read chatter: %v", err)
+		return
+	}
+
+	if err := json.Unmarshal(data, &b.lines); err != nil {
+		log.Errorf("invalid chatter in %q: %v", b.file, err)
+		b.lines = map[string][]chatterLine{}
+	}
+	for channel := range b.lines {
+		b.rebuildLocked(channel)
+	}
+}
+
+func (b *chatterBrain) rebuildLocked(channel string) {
+	chain := markovChain{}
+	for _, l := range b.lines[channel] {
+		chain.learn(l.Text)
+	}
+	b.chains[channel] = chain
+}
+
+// learn remembers the message, forgetting the channel's oldest once there
+// are too many.
+func (b *chatterBrain) learn(channel string, line chatterLine) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.loadLocked()
+
+	lines := append(b.lines[channel], line)
+	if len(lines) > maxChatterLines {
+		// Forgetting a line means building the chain again, so forget a
+		// batch at a time.
+		lines = append([]chatterLine{}, lines[len(lines)-maxChatterLines*9/10:]...)
+		b.lines[channel] = lines
+		b.rebuildLocked(channel)
+	} else {
+		b.lines[channel] = lines
+		if b.chains[channel] == nil {
+			b.chains[channel] = markovChain{}
+		}
+		b.chains[channel].learn(line.Text)
+	}
+
+	b.unsaved++
+	if b.unsaved >= chatterSaveEvery {
+		if err := b.saveLocked(); err != nil {
+			log.Errorf("unable to save chatter: %v", err)
+		}
+	}
+}
+
+// say makes up a message for the channel, or returns false if it hasn't
+// learned enough yet or said one less than cooldown ago.
+func (b *chatterBrain) say(channel string, now time.Time, cooldown time.Duration) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.loadLocked()
+
+	if len(b.lines[channel]) < minChatterLines || now.Sub(b.lastSaid[channel]) < cooldown {
+		return "", false
+	}
+	b.lastSaid[channel] = now
+
+	text := b.chains[channel].generate(b.rand)
+	return text, text != ""
+}
+
+// forget drops what was learned in the channel from user, or everyone if user
+// is empty, returning how many messages were forgotten.
+func (b *chatterBrain) forget(channel, user string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.loadLocked()
+
+	lines := b.lines[channel]
+	kept := lines[:0]
+	for _, l := range lines {
+		if user != "" && !strings.EqualFold(l.User, user) && l.UserID != user {
+			kept = append(kept, l)
+		}
+	}
+	forgotten := len(lines) - len(kept)
+
+	if len(kept) == 0 {
+		delete(b.lines, channel)
+	} else {
+		b.lines[channel] = kept
+	}
+	b.rebuildLocked(channel)
+
+	if err := b.saveLocked(); err != nil {
+		return forgotten, fmt.Errorf("forget: %w", err)
+	}
+
+	return forgotten, nil
+}
+
+// save writes what's been learned if anything has been since the last save.
+func (b *chatterBrain) save() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.loaded || b.unsaved == 0 {
+		return nil
+	}
+
+	return b.saveLocked()
+}
+
+// purge forgets what the user said in every channel, returning how many
+// messages it was.
+func (b *chatterBrain) purge(userID string) (int, error) {
+	b.mu.Lock()
+	b.loadLocked()
+	channels := sortedKeys(b.lines)
+	b.mu.Unlock()
+
+	purged := 0
+	for _, channel := range channels {
+		n, err := b.forget(channel, userID)
+		purged += n
+		if err != nil {
+			return purged, fmt.Errorf("purge: %w", err)
+		}
+	}
+
+	return purged, nil
+}
+
+func (b *chatterBrain) saveLocked() error {
+	b.unsaved = 0
+	if b.file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(b.lines)
+	if err != nil {
+		return fmt.Errorf("save: unable to encode chatter: %w", err)
+	}
+
+	if err := writeStateFile(b.file, data); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+
+	return nil
+}
+
+// runChatter learns from messages that aren't commands and answers !chatter.
+// Mods can make it forget the channel with !chatter forget, or one chatter
+// with !chatter forget name.
+func runChatter(c *chatContext) bool {
+	conf := c.config.Chatter
+	if !conf.Enabled {
+		return false
+	}
+
+	name, args, ok := parseCommand(c.message.Message)
+	if !ok {
+		text := strings.TrimSpace(c.message.Message)
+		// Links aren't learned, nor anything Twitch would take as a command
+		// if the bot said it.
+		if text != "" && !strings.HasPrefix(text, "/") && !strings.HasPrefix(text, ".") &&
+			!strings.Contains(text, "://") && !conf.excluded(c.message.User.Name) {
+			c.bot.brain.learn(c.message.Channel, chatterLine{UserID: c.message.User.ID, User: c.message.User.Name, Text: text})
+		}
+		return false
+	} else if name != "chatter" {
+		return false
+	}
+
+	if len(args) > 0 && strings.EqualFold(args[0], "forget") {
+		if !c.privileged {
+			log.Debugf("%s tried to run mod command chatter forget", c.message.User.Name)
+			return true
+		}
+
+		var user string
+		if len(args) > 1 {
+			user = strings.ToLower(strings.TrimPrefix(args[1], "@"))
+		}
+
+		forgotten, err := c.bot.brain.forget(c.message.Channel, user)
+		if err != nil {
+			log.Error(err)
+		}
+		c.client.Reply(c.message.Channel, c.message.ID, fmt.Sprintf("Forgot %d messages", forgotten))
+		return true
+	}
+
+	cooldown := defaultChatterCooldown
+	if d, err := time.ParseDuration(conf.Cooldown); err == nil && conf.Cooldown != "" {
+		cooldown = d
+	}
+
+	if text, ok := c.bot.brain.say(c.message.Channel, c.sent(), cooldown); ok {
+		c.client.Say(c.message.Channel, text)
+	}
+
+	return true
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+)
+
+// chooseViewerWindow is how recently someone has to have chatted to be picked
+// by !choose viewer.
+const chooseViewerWindow = 10 * time.Minute
+
+// choice is something !choose can pick, and its weight.
+type choice struct {
+	name   string
+	weight float64
+}
+
+// choiceSeparator splits what's given to !choose, by commas, pipes, or "or".
+var choiceSeparator = regexp.MustCompile(`\s*(?:,|\||\bor\b)\s*`)
+
+// parseChoices splits the list given to !choose, where each can have a weight
+// after a colon, like pizza:3, tacos.
+func parseChoices(list string) []choice {
+	var choices []choice
+	for _, s := range choiceSeparator.Split(list, -1) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		c := choice{name: s, weight: 1}
+		if i := strings.LastIndex(s, ":"); i > 0 {
+			if w, err := strconv.ParseFloat(s[i+1:], 64); err == nil && w >= 0 {
+				c = choice{name: strings.TrimSpace(s[:i]), weight: w}
+			}
+		}
+		choices = append(choices, c)
+	}
+
+	return choices
+}
+
+// pickChoice returns one of the choices, more likely the more weight it has.
+func pickChoice(choices []choice) (choice, bool) {
+	total := 0.0
+	for _, c := range choices {
+		total += c.weight
+	}
+	if total <= 0 {
+		return choice{}, false
+	}
+
+	at := rand.Float64() * total
+	for _, c := range choices {
+		at -= c.weight
+		if at < 0 {
+			return c, true
+		}
+	}
+
+	return choices[len(choices)-1], true
+}
+
+// viewerChoices returns everyone who's chatted in the channel recently,
+// weighted by how, one each for everyone, messages for how many they sent,
+// or subs for how many entries they'd get in a giveaway.
+func viewerChoices(history *chatHistory, channel string, since time.Time, by string, weights giveawayWeights) []choice {
+	var order []string
+	viewers := map[string]*choice{}
+	history.since(channel, since, func(m twitch.PrivateMessage) bool {
+		v := viewers[m.User.ID]
+		if v == nil {
+			v = &choice{name: m.User.DisplayName}
+			viewers[m.User.ID] = v
+			order = append(order, m.User.ID)
+		}
+
+		switch by {
+		case "messages":
+			v.weight++
+		case "subs":
+			v.weight = weights.weight(m.User)
+		default:
+			v.weight = 1
+		}
+
+		return false
+	})
+
+	choices := make([]choice, 0, len(order))
+	for _, id := range order {
+		choices = append(choices, *viewers[id])
+	}
+
+	return choices
+}
+
+// chooseCommand picks one of a list, like !choose pizza, tacos:2, sushi, or
+// with !choose viewer, someone who's chatted recently. Mods can weight viewers
+// with !choose viewer by messages or !choose viewer by subs.
+func chooseCommand(c *chatContext, args []string) {
+	reply := func(text string) { c.client.Reply(c.message.Channel, c.message.ID, text) }
+
+	if len(args) > 0 && strings.EqualFold(args[0], "viewer") {
+		if !c.privileged {
+			log.Debugf("%s tried to run mod command choose viewer", c.message.User.Name)
+			return
+		}
+
+		by := ""
+		if len(args) == 3 && strings.EqualFold(args[1], "by") {
+			by = strings.ToLower(args[2])
+		} else if len(args) != 1 {
+			by = "?"
+		}
+		if by != "" && by != "messages" && by != "subs" {
+			reply("Usage: !choose viewer [by messages|subs]")
+			return
+		}
+
+		viewers := viewerChoices(c.bot.history, c.message.Channel, c.sent().Add(-chooseViewerWindow), by, c.config.Giveaway.Weights)
+		picked, ok := pickChoice(viewers)
+		if !ok {
+			reply("No one's chatted lately")
+			return
+		}
+		c.client.Say(c.message.Channel, fmt.Sprintf("I pick @%s, out of %d chatters!", picked.name, len(viewers)))
+		return
+	}
+
+	choices := parseChoices(strings.Join(args, " "))
+	if len(choices) < 2 {
+		reply("Usage: !choose this, that:2, or something else")
+		return
+	}
+
+	picked, ok := pickChoice(choices)
+	if !ok {
+		reply("Give at least one of them some weight")
+		return
+	}
+	reply("I choose " + picked.name)
+}
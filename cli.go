@@ -0,0 +1,315 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+)
+
+const usage = `Usage: batybot [command] [flags]
+
+Commands:
+  run              run the bot, the default
+  auth             authorize the bot and store its token
+  doctor           check the bot is set up to run
+  validate-config  check CONFIG_FILE and COMMANDS_FILE can be loaded
+  config-schema    print the JSON Schema of the config file
+  export           write the bot's data to stdout, for backups or moving bots
+  export-emotes    write emote usage to stdout
+  export-modlog    write the moderation log to stdout
+  import           add Nightbot or StreamElements commands and timers
+  purge-user       delete what's kept about a user, for deletion requests
+  report           print yesterday's stats, or last week's with -weekly
+  replay           run a chat log through the bot and print what it would say
+  version          print the version
+
+Every command takes -set and -config, see batybot command -h for the rest.
+`
+
+// version is set when building a release with
+//
+//	go build -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// settings adds the flags every command takes to fs, parses args, and applies
+// them on top of the environment.
+func settings(fs *flag.FlagSet, args []string) {
+	overrides := envFlags{}
+	fs.Var(overrides, "set", "set a setting, e.g. -set TWITCH_CHANNEL=name, can be given more than once")
+	configFile := fs.String("config", "", "JSON config file, the same as -set CONFIG_FILE=file")
+	fs.Parse(args)
+
+	if *configFile != "" {
+		overrides["CONFIG_FILE"] = *configFile
+	}
+
+	if len(overrides) > 0 {
+		if err := overrides.apply(); err != nil {
+			log.Fatal(err)
+		}
+
+		// These were read before the flags were parsed.
+		setLogLevel()
+		setRedirect()
+	}
+
+	if err := loadSecretFiles(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadFiles loads the config file, plugins, moderation log, chat log, custom
+// commands, and scripts into a new bot.
+func loadFiles() (*bot, error) {
+	if os.Getenv("CONFIG_FILE") == "" {
+		if file := defaultConfigFile(); file != "" {
+			os.Setenv("CONFIG_FILE", file)
+		}
+	}
+
+	conf, err := newConfigManager(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	b := newBot(conf)
+
+	if err := loadPlugins(b); err != nil {
+		return nil, err
+	}
+
+	if file := os.Getenv("MODLOG_FILE"); file != "" {
+		if err := b.modlog.load(file); err != nil {
+			return nil, fmt.Errorf("unable to load moderation log: %w", err)
+		}
+	}
+
+	if dir := os.Getenv("CHATLOG_DIR"); dir != "" {
+		days, err := chatLogDays()
+		if err != nil {
+			return nil, err
+		}
+		if err := b.chatlog.load(dir, days); err != nil {
+			return nil, fmt.Errorf("unable to load chat log: %w", err)
+		}
+	}
+
+	if file := os.Getenv("COMMANDS_FILE"); file != "" {
+		if err := b.custom.load(file); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir := os.Getenv("SCRIPTS_DIR"); dir != "" {
+		if err := b.scripts.load(dir); err != nil {
+			return nil, fmt.Errorf("unable to load scripts: %w", err)
+		}
+	}
+
+	if dir := os.Getenv("WASM_DIR"); dir != "" {
+		if err := b.wasm.load(dir); err != nil {
+			return nil, fmt.Errorf("unable to load wasm plugins: %w", err)
+		}
+	}
+
+	return b, nil
+}
+
+// authorize has the bot, or TWITCH_MODERATOR with -moderator, authorized in
+// the browser or with a device code, and stores the token. Without a token
+// store it's printed to put in the environment instead.
+func authorize(args []string) {
+	fs := flag.NewFlagSet("auth", flag.ExitOnError)
+	moderator := fs.Bool("moderator", false, "authorize TWITCH_MODERATOR's account instead of the bot's")
+	settings(fs, args)
+
+	if *moderator {
+		if err := authorizeModerator(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	tokens := &tokenKeeper{}
+	if err := tokens.setup(newNotifier()); err != nil {
+		log.Fatal(err)
+	}
+
+	creds, err := getToken(botScopes, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	token, refresh, expires := creds.get()
+	if tokens.store == nil {
+		fmt.Printf("TWITCH_TOKEN=%s\nTWITCH_REFRESH=%s\nTWITCH_EXPIRES=%s\n", token, refresh, expires)
+		return
+	}
+
+	a := tokens.saveToken(account{Role: roleBot}, storedToken{Token: token, Refresh: refresh, Expires: expires})
+	log.Infof("stored the bot's token for user %s", a.UserID)
+}
+
+func checkSetup(args []string) {
+	settings(flag.NewFlagSet("doctor", flag.ExitOnError), args)
+
+	b, err := loadFiles()
+	if err != nil {
+		fmt.Printf("FAIL  %v\n", err)
+		os.Exit(1)
+	}
+
+	if !doctor(b) {
+		os.Exit(1)
+	}
+}
+
+func validateConfig(args []string) {
+	settings(flag.NewFlagSet("validate-config", flag.ExitOnError), args)
+
+	if _, err := loadFiles(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	fmt.Println("ok")
+}
+
+func exportModlog(args []string) {
+	fs := flag.NewFlagSet("export-modlog", flag.ExitOnError)
+	format := fs.String("format", "json", "json or csv")
+	settings(fs, args)
+
+	b, err := loadFiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := b.modlog.export(os.Stdout, *format); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func exportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "json", "json or csv, which needs -only")
+	only := fs.String("only", "", "export just commands, emotes, modlog, or scripts")
+	settings(fs, args)
+
+	b, err := loadFiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := exportAll(b, os.Stdout, *format, *only); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func exportEmotes(args []string) {
+	fs := flag.NewFlagSet("export-emotes", flag.ExitOnError)
+	format := fs.String("format", "json", "json or csv")
+	settings(fs, args)
+
+	if err := (&emoteTracker{}).export(os.Stdout, *format); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func importCommand(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	from := fs.String("from", "", "the bot the exports are from, nightbot or streamelements")
+	commandsFile := fs.String("commands", "", "file of the bot's commands")
+	timersFile := fs.String("timers", "", "file of the bot's timers, printed as offline timers for the config")
+	overwrite := fs.Bool("overwrite", false, "replace commands that already exist")
+	settings(fs, args)
+
+	importer, ok := importers[*from]
+	if !ok {
+		log.Fatalf("-from should be nightbot or streamelements, not %q", *from)
+	} else if *commandsFile == "" && *timersFile == "" {
+		log.Fatal("expected -commands, -timers, or both")
+	}
+
+	b, err := loadFiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *commandsFile != "" {
+		if os.Getenv("COMMANDS_FILE") == "" {
+			log.Fatal("expected COMMANDS_FILE to be set to import commands into")
+		}
+
+		data, err := os.ReadFile(*commandsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		commands, err := importer.commands(data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("imported %d of %d commands", importCommands(b.custom, commands, *overwrite), len(commands))
+	}
+
+	if *timersFile != "" {
+		data, err := os.ReadFile(*timersFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		timers, err := importer.timers(data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := importTimers(timers); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func purgeUserCommand(args []string) {
+	fs := flag.NewFlagSet("purge-user", flag.ExitOnError)
+	id := fs.String("id", "", "Twitch user ID of the user to delete")
+	settings(fs, args)
+
+	b, err := loadFiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	result, err := purgeUser(b, *id)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(result)
+}
+
+func printReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	weekly := fs.Bool("weekly", false, "the week before's report instead of yesterday's")
+	settings(fs, args)
+
+	for _, r := range (&analyticsStore{}).reports(time.Now(), !*weekly, *weekly) {
+		fmt.Println(r)
+	}
+}
+
+func printVersion(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	fs.Parse(args)
+
+	v := version
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && len(s.Value) >= 12 {
+				v += " (" + s.Value[:12] + ")"
+			}
+		}
+	}
+
+	fmt.Println("batybot", v)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+)
+
+// defaultComboCooldown is how long between combo announcements in a channel
+// if the config doesn't say.
+const defaultComboCooldown = 30 * time.Second
+
+// combos is the config for announcing emote combos, messages in a row that
+// all have the same emote. The combo is announced when it reaches each of
+// thresholds and, with announce_broken, when someone breaks it after it
+// reached the first. Messages can use {emote}, {count}, and {user}, who's the
+// one that broke it.
+type combos struct {
+	Enabled        bool   `json:"enabled"`
+	Thresholds     []int  `json:"thresholds"` // default [5]
+	Message        string `json:"message"`
+	AnnounceBroken bool   `json:"announce_broken"`
+	BrokenMessage  string `json:"broken_message"`
+	Cooldown       string `json:"cooldown"` // between announcements, default 30s
+}
+
+func (c combos) thresholds() []int {
+	if len(c.Thresholds) == 0 {
+		return []int{5}
+	}
+
+	return c.Thresholds
+}
+
+// threshold reports whether count is one of the thresholds.
+func (c combos) threshold(count int) bool {
+	for _, t := range c.thresholds() {
+		if t == count {
+			return true
+		}
+	}
+
+	return false
+}
+
+// counted reports whether count is at least the lowest threshold, so that
+// breaking it is worth announcing.
+func (c combos) counted(count int) bool {
+	for _, t := range c.thresholds() {
+		if count >= t {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c combos) cooldown() time.Duration {
+	if d, err := time.ParseDuration(c.Cooldown); err == nil && c.Cooldown != "" {
+		return d
+	}
+
+	return defaultComboCooldown
+}
+
+func (c combos) message(broken bool, emote string, count int, user string) string {
+	message := c.Message
+	if message == "" {
+		message = "{emote} x{count} combo!"
+	}
+	if broken {
+		message = c.BrokenMessage
+		if message == "" {
+			message = "{user} broke the {emote} x{count} combo BibleThump"
+		}
+	}
+
+	return replaceVars(message, map[string]string{
+		"{emote}": emote,
+		"{count}": strconv.Itoa(count),
+		"{user}":  user,
+	})
+}
+
+// combo is the combo going in a channel.
+type combo struct {
+	emote     string // by ID, which doesn't change when it's renamed
+	name      string
+	count     int
+	announced time.Time
+}
+
+// comboTracker follows the combo in each channel.
+type comboTracker struct {
+	mu     sync.Mutex
+	combos map[string]*combo // by channel
+}
+
+// add counts the message toward the channel's combo if it has the emote,
+// otherwise it starts a new one with the first emote it has. It returns what
+// should be announced, if anything.
+func (t *comboTracker) add(conf combos, message twitch.PrivateMessage, now time.Time) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := t.combos[message.Channel]
+	if c == nil {
+		c = &combo{}
+		t.combos[message.Channel] = c
+	}
+
+	for _, e := range message.Emotes {
+		if c.count > 0 && e.ID == c.emote {
+			c.count++
+			c.name = e.Name
+
+			if !conf.threshold(c.count) || now.Sub(c.announced) < conf.cooldown() {
+				return "", false
+			}
+			c.announced = now
+			return conf.message(false, c.name, c.count, message.User.DisplayName), true
+		}
+	}
+
+	broken, name, count := c.count > 0, c.name, c.count
+	c.emote, c.name, c.count = "", "", 0
+	if len(message.Emotes) > 0 {
+		c.emote, c.name, c.count = message.Emotes[0].ID, message.Emotes[0].Name, 1
+	}
+
+	if !broken || !conf.AnnounceBroken || !conf.counted(count) || now.Sub(c.announced) < conf.cooldown() {
+		return "", false
+	}
+	c.announced = now
+
+	return conf.message(true, name, count, message.User.DisplayName), true
+}
+
+// watchCombos announces the channel's emote combos as the config says.
+func watchCombos(c *chatContext) {
+	conf := c.config.Combos
+	if !conf.Enabled {
+		return
+	}
+
+	if text, ok := c.bot.combos.add(conf, c.message, c.sent()); ok {
+		c.client.Say(c.message.Channel, text)
+	}
+}
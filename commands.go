@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// command is a !command that can be run from chat.
+type command struct {
+	modOnly bool
+	run     func(b *bot, client chat.Sender, message twitch.PrivateMessage, args []string)
+}
+
+// commands are the built in commands. Each bot starts with a copy, see
+// builtinCommands, that plugins add theirs to.
+var commands = map[string]command{
+	"appeal":     {run: appealCommand},
+	"chatstats":  {run: chatStatsCommand},
+	"modlog":     {modOnly: true, run: modlogCommand},
+	"mood":       {run: moodCommand},
+	"mutealerts": {modOnly: true, run: muteAlertsCommand},
+	"nuke":       {modOnly: true, run: nukeCommand},
+	"panic":      {modOnly: true, run: panicCommand},
+	"points":     {run: pointsCommand},
+	"search":     {modOnly: true, run: searchCommand},
+	"topemotes":  {run: topEmotesCommand},
+	"unpanic":    {modOnly: true, run: unpanicCommand},
+}
+
+func builtinCommands() map[string]command {
+	all := make(map[string]command, len(commands))
+	for name, c := range commands {
+		all[name] = c
+	}
+
+	return all
+}
+
+// runCommand runs the command in the message if there is one and reports
+// whether the message was a command. privileged decides if mod only commands
+// can be run, usually by whether the sender's a mod.
+func runCommand(b *bot, client chat.Sender, message twitch.PrivateMessage, privileged bool) bool {
+	name, args, ok := parseCommand(message.Message)
+	if !ok {
+		return false
+	}
+
+	cmd, ok := b.commands[name]
+	if !ok {
+		return b.custom.run(b.fetcher, client, message, name, args)
+	}
+
+	if cmd.modOnly && !privileged {
+		log.Debugf("%s tried to run mod command %s", message.User.Name, name)
+		return true
+	}
+
+	cmd.run(b, client, message, args)
+
+	return true
+}
+
+// parseCommand splits a !command into its lowercased name and arguments, if
+// the text is one.
+func parseCommand(text string) (string, []string, bool) {
+	if !strings.HasPrefix(text, "!") {
+		return "", nil, false
+	}
+
+	fields := strings.Fields(text[1:])
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+
+	return strings.ToLower(fields[0]), fields[1:], true
+}
+
+func isMod(user twitch.User) bool {
+	return user.Badges["broadcaster"] > 0 || user.Badges["moderator"] > 0
+}
+
+// shortDuration formats d in the largest whole unit for chat.
+func shortDuration(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	}
+
+	return fmt.Sprintf("%dd", int(d.Hours()/24))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// config holds the settings that don't fit in an environment variable. It's
+// read from the JSON file in CONFIG_FILE.
+type config struct {
+	LogLevel    string           `json:"log_level,omitempty"` // overrides LOG_LEVEL
+	Redemptions []redemption     `json:"redemptions"`
+	Alerts      map[string]alert `json:"alerts"` // by event type
+	TTS         tts              `json:"tts"`
+	Sounds      map[string]sound `json:"sounds"` // by event type or name
+	Discord     discord          `json:"discord"`
+	GoLive      goLive           `json:"golive"`
+	LiveOnly    []string         `json:"live_only"` // features only on while the channel's live
+	Offline     offline          `json:"offline"`
+
+	AI        aiReplies          `json:"ai"` // answering mentions with a local language model
+	Chatter   chatter            `json:"chatter"`
+	Pyramids  map[string]pyramid `json:"pyramids"` // by channel, or * for any other
+	Combos    combos             `json:"combos"`
+	Report    report             `json:"report"` // daily and weekly stats
+	Giveaway  giveaway           `json:"giveaway"`
+	Raffle    raffle             `json:"raffle"`
+	Bingo     bingo              `json:"bingo"`
+	WordGames wordGames          `json:"word_games"` // word scramble and hangman
+	Fun       fun                `json:"fun"`        // !8ball and the like
+	Weather   weather            `json:"weather"`
+	Currency  currency           `json:"currency"` // !convert
+
+	Toxicity   toxicity    `json:"toxicity"` // deleting and timing out toxic messages
+	Translate  translation `json:"translate"`
+	WordFilter wordFilter  `json:"word_filter"`
+
+	ExecCommands map[string]execCommand `json:"exec_commands"` // by name, without the !
+	ExecLimit    int                    `json:"exec_limit"`    // programs that can run at once, default 4
+
+	Ignore          []string `json:"ignore"`           // chatters whose messages are ignored, like other bots
+	CommandCooldown string   `json:"command_cooldown"` // how long chatters wait between commands
+	Pipeline        []string `json:"pipeline"`         // steps chat messages go through, see defaultPipeline
+}
+
+// configManager holds the config, which can be reloaded while the bot is
+// running.
+type configManager struct {
+	file string
+
+	mu sync.RWMutex
+	c  config
+}
+
+// newConfigManager reads the config from file, or starts with an empty one if
+// file is empty.
+func newConfigManager(file string) (*configManager, error) {
+	m := &configManager{file: file}
+	if file == "" {
+		return m, nil
+	}
+
+	if _, err := m.reload(); err != nil {
+		return nil, fmt.Errorf("newConfigManager: %w", err)
+	}
+
+	return m, nil
+}
+
+// get returns the current config.
+func (m *configManager) get() config {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.c
+}
+
+// reload reads the file again and returns the config it replaced.
+func (m *configManager) reload() (config, error) {
+	if m.file == "" {
+		return config{}, fmt.Errorf("reload: CONFIG_FILE isn't set")
+	}
+
+	c, err := loadConfig(m.file)
+	if err != nil {
+		return config{}, fmt.Errorf("reload: %w", err)
+	}
+
+	if c.LogLevel != "" {
+		level, err := logrus.ParseLevel(c.LogLevel)
+		if err != nil {
+			return config{}, fmt.Errorf("reload: %w", err)
+		}
+		log.SetLevel(level)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old := m.c
+	m.c = c
+
+	return old, nil
+}
+
+// restartNeeded returns the changes between configs that can't be applied
+// while the bot is running.
+func restartNeeded(old, c config, subscribed bool) []string {
+	var changes []string
+
+	// Redemptions are only subscribed to if there were some when the bot
+	// started.
+	if subscribed && len(old.Redemptions) == 0 && len(c.Redemptions) > 0 {
+		changes = append(changes, "redemptions were added")
+	}
+
+	return changes
+}
+
+func loadConfig(file string) (config, error) {
+	var c config
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return c, fmt.Errorf("loadConfig: unable to read %q: %w", file, err)
+	}
+
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("loadConfig: invalid config in %q: %w", file, err)
+	}
+
+	if err := c.validate(); err != nil {
+		return c, fmt.Errorf("loadConfig: invalid config in %q:\n%w", file, err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// controlServer is an HTTP API for managing the running bot from dashboards
+// and scripts. Every request needs the API_TOKEN as a bearer token.
+//
+//	GET    /api/status          - uptime, connection, token expiry, and queue depth
+//	GET    /api/channels        - channels the bot is in
+//	GET    /api/commands        - custom commands
+//	GET    /api/commands/{name}
+//	PUT    /api/commands/{name} - {"response": "..."}
+//	DELETE /api/commands/{name}
+//	POST   /api/say             - {"channel": "...", "message": "..."}
+//	GET    /api/loglevel
+//	PUT    /api/loglevel        - {"level": "debug"}
+//	POST   /api/eventsub/replay - recorded EventSub notifications, one per line
+type controlServer struct {
+	http.Server
+
+	token string
+	bot   *bot
+}
+
+func newControlServer(addr, token string, b *bot) *controlServer {
+	s := &controlServer{token: token, bot: b}
+
+	s.Addr = addr
+	s.Handler = s.authorize(s.routes())
+
+	return s
+}
+
+// routes returns the API without any authorization, which is left to
+// whatever serves it.
+func (s *controlServer) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", s.status)
+	mux.HandleFunc("/api/channels", s.channels)
+	mux.HandleFunc("/api/commands", s.commands)
+	mux.HandleFunc("/api/commands/", s.command)
+	mux.HandleFunc("/api/emotes", s.emotes)
+	mux.HandleFunc("/api/search", s.search)
+	mux.HandleFunc("/api/users/", s.user)
+	mux.HandleFunc("/api/say", s.say)
+	mux.HandleFunc("/api/loglevel", s.logLevel)
+	mux.HandleFunc("/api/eventsub/replay", s.replay)
+
+	return mux
+}
+
+func (s *controlServer) Start() error {
+	return fmt.Errorf("unable to start control server: %w", s.ListenAndServe())
+}
+
+func (s *controlServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *controlServer) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.bot.status.report(s.bot.client, s.bot.features))
+}
+
+func (s *controlServer) channels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.bot.status.joined())
+}
+
+func (s *controlServer) commands(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.bot.custom.all())
+}
+
+func (s *controlServer) emotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		s.bot.emotes.export(w, "csv")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.bot.emotes.all())
+}
+
+func (s *controlServer) search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	} else if !s.bot.chatlog.enabled() {
+		writeError(w, http.StatusNotFound, "chat isn't being logged")
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("q") == "" {
+		writeError(w, http.StatusBadRequest, "expected q")
+		return
+	}
+
+	channel := strings.ToLower(query.Get("channel"))
+	if channel == "" {
+		channel = strings.ToLower(os.Getenv("TWITCH_CHANNEL"))
+	}
+
+	limit := 20
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 {
+		limit = v
+	}
+	if limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+
+	found := s.bot.chatlog.search(channel, query.Get("q"), limit)
+	if found == nil {
+		found = []chatLogEntry{}
+	}
+
+	writeJSON(w, http.StatusOK, found)
+}
+
+// user deletes what's kept about a user, by their Twitch user ID, for
+// deletion requests.
+func (s *controlServer) user(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/users/")
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusBadRequest, "expected a user ID")
+		return
+	}
+
+	result, err := purgeUser(s.bot, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *controlServer) command(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/api/commands/"))
+
+	switch r.Method {
+	case http.MethodGet:
+		response, ok := s.bot.custom.get(name)
+		if !ok {
+			writeError(w, http.StatusNotFound, "no such command")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"name": name, "response": response})
+	case http.MethodPut:
+		var body struct {
+			Response string `json:"response"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Response == "" {
+			writeError(w, http.StatusBadRequest, "expected a response")
+			return
+		}
+
+		if err := s.bot.custom.set(name, body.Response); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"name": name, "response": body.Response})
+	case http.MethodDelete:
+		if err := s.bot.custom.remove(name); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *controlServer) say(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var body struct {
+		Channel string `json:"channel"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Channel == "" || body.Message == "" {
+		writeError(w, http.StatusBadRequest, "expected a channel and message")
+		return
+	}
+
+	s.bot.client.Say(strings.ToLower(body.Channel), body.Message)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *controlServer) logLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "expected a level")
+			return
+		}
+
+		level, err := logrus.ParseLevel(body.Level)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.SetLevel(level)
+		log.Warnf("log level changed to %s by the control api", level)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"level": log.GetLevel().String()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("unable to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+// replay runs the EventSub handlers for recorded notifications, like ones
+// from EVENTSUB_RECORD, as if Twitch had just sent them.
+func (s *controlServer) replay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	} else if s.bot.events == nil {
+		writeError(w, http.StatusNotFound, "eventsub isn't enabled")
+		return
+	}
+
+	handled, err := s.bot.events.replay(io.LimitReader(r.Body, 10<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"handled": handled})
+}
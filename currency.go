@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRatesURL is where exchange rates come from if the config doesn't
+	// say, with {base} replaced by the currency they're from.
+	defaultRatesURL = "https://open.er-api.com/v6/latest/{base}"
+	// defaultRatesCache is how long exchange rates are reused for if the config
+	// doesn't say.
+	defaultRatesCache = time.Hour
+)
+
+// currency is the config for !convert. URL is an exchange rate API returning
+// JSON with the rates by currency code, like open.er-api.com or
+// frankfurter.app, with {base} replaced by the currency to convert from.
+type currency struct {
+	Enabled bool   `json:"enabled"`
+	URL     string `json:"url"`   // default open.er-api.com
+	Cache   string `json:"cache"` // how long to reuse rates, default 1h
+}
+
+func (c currency) url(base string) string {
+	u := c.URL
+	if u == "" {
+		u = defaultRatesURL
+	}
+
+	return strings.ReplaceAll(u, "{base}", url.PathEscape(base))
+}
+
+func (c currency) cache() time.Duration {
+	if d, err := time.ParseDuration(c.Cache); err == nil {
+		return d
+	}
+
+	return defaultRatesCache
+}
+
+// currencySymbols are the codes for symbols people are likely to use instead.
+var currencySymbols = map[string]string{"$": "USD", "€": "EUR", "£": "GBP", "¥": "JPY", "₹": "INR", "₩": "KRW"}
+
+var (
+	currencyCodePattern = regexp.MustCompile(`^[A-Za-z]{3}$`)
+	// currencyAmountPattern matches an amount with the currency before or
+	// after it, like 20, 20usd, $20, or 4.99€.
+	currencyAmountPattern = regexp.MustCompile(`^(\D*?)(\d+(?:[.,]\d+)?)(\D*)$`)
+)
+
+// currencyCode returns the code for a currency code or symbol, uppercase.
+func currencyCode(s string) (string, bool) {
+	if code, ok := currencySymbols[s]; ok {
+		return code, true
+	}
+	if !currencyCodePattern.MatchString(s) {
+		return "", false
+	}
+
+	return strings.ToUpper(s), true
+}
+
+// parseConversion reads what's given to !convert, an amount, the currency
+// it's in, and the one to convert it to, like 20 USD EUR, 20usd to eur, or
+// $20 in EUR.
+func parseConversion(args []string) (float64, string, string, error) {
+	usage := fmt.Errorf("parseConversion: Usage: !convert 20 USD EUR")
+	if len(args) == 0 {
+		return 0, "", "", usage
+	}
+
+	m := currencyAmountPattern.FindStringSubmatch(args[0])
+	if m == nil || (m[1] != "" && m[3] != "") {
+		return 0, "", "", usage
+	}
+	// A comma's a thousands separator before three digits, like 1,000, and
+	// otherwise a decimal point, like 4,99.
+	number := m[2]
+	if i := strings.Index(number, ","); i >= 0 && len(number)-i == 4 {
+		number = strings.Replace(number, ",", "", 1)
+	}
+	amount, err := strconv.ParseFloat(strings.Replace(number, ",", ".", 1), 64)
+	if err != nil {
+		return 0, "", "", usage
+	}
+
+	rest := args[1:]
+	from := m[1] + m[3]
+	if from == "" && len(rest) > 0 {
+		from, rest = rest[0], rest[1:]
+	}
+	if len(rest) == 2 && (strings.EqualFold(rest[0], "to") || strings.EqualFold(rest[0], "in")) {
+		rest = rest[1:]
+	}
+	if len(rest) != 1 {
+		return 0, "", "", usage
+	}
+
+	fromCode, ok := currencyCode(from)
+	if !ok {
+		return 0, "", "", fmt.Errorf("parseConversion: %q isn't a currency code like USD", from)
+	}
+	toCode, ok := currencyCode(rest[0])
+	if !ok {
+		return 0, "", "", fmt.Errorf("parseConversion: %q isn't a currency code like EUR", rest[0])
+	}
+
+	return amount, fromCode, toCode, nil
+}
+
+// cachedRates are the exchange rates from a currency and when they were
+// fetched.
+type cachedRates struct {
+	rates   map[string]float64 // by currency code
+	fetched time.Time
+}
+
+// ratesCache keeps the exchange rates fetched from each currency for a while,
+// since they don't change often and the free APIs have limits.
+type ratesCache struct {
+	mu    sync.Mutex
+	rates map[string]cachedRates // by currency code
+}
+
+// get returns the rates from the base currency, fetching them if they aren't
+// cached.
+func (c *ratesCache) get(conf currency, base string, now time.Time) (map[string]float64, error) {
+	c.mu.Lock()
+	cached, ok := c.rates[base]
+	c.mu.Unlock()
+	if ok && now.Sub(cached.fetched) < conf.cache() {
+		return cached.rates, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, conf.url(base), nil)
+	if err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+
+	var resp struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := doJSON(req, &resp); err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	} else if len(resp.Rates) == 0 {
+		return nil, fmt.Errorf("get: no rates for %s", base)
+	}
+	resp.Rates[base] = 1
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for code, r := range c.rates {
+		if now.Sub(r.fetched) >= conf.cache() {
+			delete(c.rates, code)
+		}
+	}
+	c.rates[base] = cachedRates{rates: resp.Rates, fetched: now}
+
+	return resp.Rates, nil
+}
+
+// formatAmount writes out an amount of money with two decimal places, or more
+// for tiny amounts so they don't show as 0.00.
+func formatAmount(amount float64) string {
+	if amount != 0 && amount < 0.01 {
+		return strconv.FormatFloat(amount, 'f', 1-int(math.Floor(math.Log10(amount))), 64)
+	}
+
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// runCurrency answers !convert 20 USD EUR.
+func runCurrency(c *chatContext) bool {
+	conf := c.config.Currency
+	if !conf.Enabled {
+		return false
+	}
+
+	name, args, ok := parseCommand(c.message.Message)
+	if !ok || name != "convert" {
+		return false
+	}
+
+	reply := func(text string) { c.client.Reply(c.message.Channel, c.message.ID, text) }
+
+	amount, from, to, err := parseConversion(args)
+	if err != nil {
+		reply(strings.TrimPrefix(err.Error(), "parseConversion: "))
+		return true
+	}
+
+	go func() {
+		rates, err := c.bot.rates.get(conf, from, time.Now())
+		if err != nil {
+			log.Errorf("unable to get exchange rates: %v", err)
+			reply("Unable to get the exchange rate for " + from)
+			return
+		}
+
+		rate, ok := rates[to]
+		if !ok {
+			reply(fmt.Sprintf("There's no exchange rate from %s to %s", from, to))
+			return
+		}
+
+		reply(fmt.Sprintf("%s %s = %s %s", formatAmount(amount), from, formatAmount(amount*rate), to))
+	}()
+
+	return true
+}
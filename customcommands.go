@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// customCommands are !commands that reply with a fixed response, managed
+// through the control API and saved to COMMANDS_FILE. In a response {user} is
+// replaced with who ran the command, {query} with what they wrote after it, and
+// $(urlfetch url) with what the URL returns.
+type customCommands struct {
+	mu       sync.RWMutex
+	file     string
+	commands map[string]string
+}
+
+// load reads the commands from file and sets it as where changes are saved.
+func (c *customCommands) load(file string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.file = file
+
+	b, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("load: unable to read %q: %w", file, err)
+	}
+
+	// Decode into a new map so commands removed from the file are dropped
+	// when it's reloaded.
+	commands := map[string]string{}
+	if err := json.Unmarshal(b, &commands); err != nil {
+		return fmt.Errorf("load: invalid commands in %q: %w", file, err)
+	}
+	c.commands = commands
+
+	return nil
+}
+
+func (c *customCommands) get(name string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	response, ok := c.commands[name]
+	return response, ok
+}
+
+func (c *customCommands) all() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	all := make(map[string]string, len(c.commands))
+	for name, response := range c.commands {
+		all[name] = response
+	}
+
+	return all
+}
+
+func (c *customCommands) names() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := make([]string, 0, len(c.commands))
+	for name := range c.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// set adds or replaces the command. Built in commands can't be replaced.
+func (c *customCommands) set(name, response string) error {
+	name = strings.ToLower(strings.TrimPrefix(name, "!"))
+	if isCommand(name) {
+		return fmt.Errorf("set: %q is a built in command", name)
+	} else if name == "" || strings.ContainsAny(name, " \t\n") {
+		return fmt.Errorf("set: invalid command name %q", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.commands[name] = response
+	return c.saveLocked()
+}
+
+func (c *customCommands) remove(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.commands, strings.ToLower(strings.TrimPrefix(name, "!")))
+	return c.saveLocked()
+}
+
+func (c *customCommands) saveLocked() error {
+	if c.file == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(c.commands, "", "  ")
+	if err != nil {
+		return fmt.Errorf("save: unable to encode commands: %w", err)
+	}
+
+	if err := writeStateFile(c.file, b); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+
+	return nil
+}
+
+// run replies with the custom command's response and reports whether there is
+// one by that name. Responses with a $(urlfetch) are sent once it's fetched,
+// rather than holding up chat while it is.
+func (c *customCommands) run(fetcher *urlFetcher, client chat.Sender, message twitch.PrivateMessage, name string, args []string) bool {
+	response, ok := c.get(name)
+	if !ok {
+		return false
+	}
+
+	vars := map[string]string{
+		"{user}":  message.User.DisplayName,
+		"{query}": strings.Join(args, " "),
+	}
+
+	if !hasURLFetch(response) {
+		client.Reply(message.Channel, message.ID, replaceVars(response, vars))
+		return true
+	}
+
+	fetcher.wg.Add(1)
+	go func() {
+		defer fetcher.wg.Done()
+
+		response := fetcher.expand(response, vars)
+		client.Reply(message.Channel, message.ID, replaceVars(response, vars))
+	}()
+
+	return true
+}
+
+func replaceVars(s string, vars map[string]string) string {
+	pairs := make([]string, 0, 2*len(vars))
+	for k, v := range vars {
+		pairs = append(pairs, k, v)
+	}
+
+	return strings.NewReplacer(pairs...).Replace(s)
+}
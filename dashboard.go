@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/losinggeneration/batybot/auth"
+)
+
+//go:embed web/dashboard.html
+var dashboardPage []byte
+
+// dashboard is a web page for managing the running bot: its status and token
+// expiry, a live feed of events, custom commands, and sending messages as the
+// bot. It signs in with DASHBOARD_PASSWORD and then uses the control API and
+// event stream with a session cookie.
+type dashboard struct {
+	http.Server
+
+	password string
+
+	mu       sync.Mutex
+	sessions map[string]time.Time // when each session expires
+}
+
+const (
+	sessionCookie = "batybot_session"
+	sessionLength = 12 * time.Hour
+)
+
+func newDashboard(addr, password string, b *bot) *dashboard {
+	d := &dashboard{password: password, sessions: map[string]time.Time{}}
+	api := &controlServer{bot: b}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.page)
+	mux.HandleFunc("/login", d.login)
+	mux.HandleFunc("/logout", d.logout)
+	mux.Handle("/api/", d.authorize(api.routes()))
+	// Unlike the event stream's own server, only the dashboard's origin is
+	// allowed since the session cookie comes along with it.
+	mux.Handle("/api/events", d.authorize(&eventStream{}))
+
+	d.Addr = addr
+	d.Handler = mux
+
+	return d
+}
+
+func (d *dashboard) Start() error {
+	return fmt.Errorf("unable to start dashboard: %w", d.ListenAndServe())
+}
+
+func (d *dashboard) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardPage)
+}
+
+func (d *dashboard) login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(r.PostFormValue("password")), []byte(d.password)) != 1 {
+		log.Warnf("dashboard: failed sign in from %s", r.RemoteAddr)
+		// Slow down guessing.
+		time.Sleep(time.Second)
+		writeError(w, http.StatusUnauthorized, "wrong password")
+		return
+	}
+
+	session, err := auth.RandomString()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	d.mu.Lock()
+	d.sessions[session] = time.Now().Add(sessionLength)
+	d.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    session,
+		Path:     "/",
+		MaxAge:   int(sessionLength.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *dashboard) logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		d.mu.Lock()
+		delete(d.sessions, c.Value)
+		d.mu.Unlock()
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *dashboard) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(sessionCookie)
+		if err != nil || !d.valid(c.Value) {
+			writeError(w, http.StatusUnauthorized, "sign in first")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// valid reports whether the session is signed in, and forgets any that have
+// expired.
+func (d *dashboard) valid(session string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for s, expires := range d.sessions {
+		if time.Now().After(expires) {
+			delete(d.sessions, s)
+		}
+	}
+
+	_, ok := d.sessions[session]
+	return ok
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// Limits on !roll, so one roll can't flood chat or take long.
+const (
+	maxDice      = 100
+	maxDieSides  = 1000
+	maxDiceTerms = 10
+	maxDiceBonus = 10000
+)
+
+// diceTerm is a term of a roll like 2d20+5, either dice or a number added or
+// taken away.
+type diceTerm struct {
+	sign  int // 1 or -1
+	count int // dice, or 0 for a number
+	sides int // or the number
+}
+
+var (
+	diceTermPattern = regexp.MustCompile(`^([+-]?)(?:(\d*)d(\d+)|(\d+))`)
+	diceSignPattern = regexp.MustCompile(`\s*([+-])\s*`)
+)
+
+// parseDice reads dice notation, terms like 2d20, d6, or 5 joined by + or -,
+// within the limits.
+func parseDice(notation string) ([]diceTerm, error) {
+	s := strings.ToLower(diceSignPattern.ReplaceAllString(strings.TrimSpace(notation), "$1"))
+	if s == "" {
+		return nil, fmt.Errorf("parseDice: no dice")
+	}
+
+	var terms []diceTerm
+	dice := 0
+	for s != "" {
+		m := diceTermPattern.FindStringSubmatch(s)
+		if m == nil || (len(terms) > 0 && m[1] == "") {
+			return nil, fmt.Errorf("parseDice: %q isn't dice notation like 2d20+5", notation)
+		}
+		s = s[len(m[0]):]
+
+		t := diceTerm{sign: 1}
+		if m[1] == "-" {
+			t.sign = -1
+		}
+
+		if m[4] != "" {
+			t.sides, _ = strconv.Atoi(m[4])
+			if t.sides > maxDiceBonus {
+				return nil, fmt.Errorf("parseDice: numbers can be up to %d", maxDiceBonus)
+			}
+		} else {
+			t.count = 1
+			if m[2] != "" {
+				t.count, _ = strconv.Atoi(m[2])
+			}
+			t.sides, _ = strconv.Atoi(m[3])
+
+			dice += t.count
+			if t.count < 1 || dice > maxDice {
+				return nil, fmt.Errorf("parseDice: between 1 and %d dice can be rolled", maxDice)
+			} else if t.sides < 2 || t.sides > maxDieSides {
+				return nil, fmt.Errorf("parseDice: dice can have between 2 and %d sides", maxDieSides)
+			}
+		}
+
+		terms = append(terms, t)
+		if len(terms) > maxDiceTerms {
+			return nil, fmt.Errorf("parseDice: there can be up to %d terms", maxDiceTerms)
+		}
+	}
+
+	return terms, nil
+}
+
+// rollDice rolls the terms, returning the total and how it was arrived at.
+func rollDice(terms []diceTerm) (int, string) {
+	total := 0
+	var parts []string
+	for i, t := range terms {
+		sign := ""
+		switch {
+		case t.sign < 0 && i == 0:
+			sign = "-"
+		case t.sign < 0:
+			sign = "- "
+		case i > 0:
+			sign = "+ "
+		}
+
+		if t.count == 0 {
+			total += t.sign * t.sides
+			parts = append(parts, sign+strconv.Itoa(t.sides))
+			continue
+		}
+
+		rolls := make([]string, t.count)
+		for j := range rolls {
+			roll := rand.Intn(t.sides) + 1
+			total += t.sign * roll
+			rolls[j] = strconv.Itoa(roll)
+		}
+		parts = append(parts, sign+"["+strings.Join(rolls, ", ")+"]")
+	}
+
+	return total, strings.Join(parts, " ")
+}
+
+// rollCommand rolls dice in the notation given, 1d20 if there's none.
+func rollCommand(c *chatContext, args []string) {
+	notation := strings.Join(args, " ")
+	if notation == "" {
+		notation = "1d20"
+	}
+
+	terms, err := parseDice(notation)
+	if err != nil {
+		c.client.Reply(c.message.Channel, c.message.ID, strings.TrimPrefix(err.Error(), "parseDice: "))
+		return
+	}
+
+	notation = strings.ToLower(diceSignPattern.ReplaceAllString(notation, "$1"))
+	total, rolls := rollDice(terms)
+	text := fmt.Sprintf("%s rolled %s: %s = %d", c.message.User.DisplayName, notation, rolls, total)
+	if len(text) > chat.MaxMessageLength {
+		text = fmt.Sprintf("%s rolled %s: %d", c.message.User.DisplayName, notation, total)
+	}
+
+	c.client.Reply(c.message.Channel, c.message.ID, text)
+}
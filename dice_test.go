@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDice(t *testing.T) {
+	tests := []struct {
+		notation string
+		want     []diceTerm
+		err      string
+	}{
+		{notation: "d20", want: []diceTerm{{sign: 1, count: 1, sides: 20}}},
+		{notation: "2d6", want: []diceTerm{{sign: 1, count: 2, sides: 6}}},
+		{notation: "2D6", want: []diceTerm{{sign: 1, count: 2, sides: 6}}},
+		{notation: "1d20+5", want: []diceTerm{{sign: 1, count: 1, sides: 20}, {sign: 1, sides: 5}}},
+		{notation: " 1d20 - 2 + d4 ", want: []diceTerm{{sign: 1, count: 1, sides: 20}, {sign: -1, sides: 2}, {sign: 1, count: 1, sides: 4}}},
+		{notation: "-3", want: []diceTerm{{sign: -1, sides: 3}}},
+		{notation: "100d1000", want: []diceTerm{{sign: 1, count: 100, sides: 1000}}},
+		{notation: "10000", want: []diceTerm{{sign: 1, sides: 10000}}},
+
+		{notation: "", err: "no dice"},
+		{notation: "   ", err: "no dice"},
+		{notation: "banana", err: "isn't dice notation"},
+		{notation: "1d20 5", err: "isn't dice notation"},
+		{notation: "1d20+", err: "isn't dice notation"},
+		{notation: "0d6", err: "dice can be rolled"},
+		{notation: "101d6", err: "dice can be rolled"},
+		{notation: "60d6+41d6", err: "dice can be rolled"},
+		{notation: "1d1", err: "sides"},
+		{notation: "1d1001", err: "sides"},
+		{notation: "10001", err: "numbers can be up to"},
+		{notation: strings.Repeat("1+", 10) + "1", err: "terms"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDice(tt.notation)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseDice(%q) = %v, %v, want an error containing %q", tt.notation, got, err, tt.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseDice(%q): %v", tt.notation, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseDice(%q) = %+v, want %+v", tt.notation, got, tt.want)
+		}
+	}
+}
+
+func TestRollDice(t *testing.T) {
+	tests := []struct {
+		notation string
+		min, max int
+	}{
+		{notation: "5", min: 5, max: 5},
+		{notation: "-5 + 2", min: -3, max: -3},
+		{notation: "d6", min: 1, max: 6},
+		{notation: "3d6+2", min: 5, max: 20},
+		{notation: "1d4-1d4", min: -3, max: 3},
+	}
+
+	for _, tt := range tests {
+		terms, err := parseDice(tt.notation)
+		if err != nil {
+			t.Fatalf("parseDice(%q): %v", tt.notation, err)
+		}
+
+		for i := 0; i < 100; i++ {
+			total, working := rollDice(terms)
+			if total < tt.min || total > tt.max {
+				t.Fatalf("rollDice(%q) = %d (%s), want between %d and %d", tt.notation, total, working, tt.min, tt.max)
+			}
+		}
+	}
+
+	terms, _ := parseDice("-1 + 2 - 3")
+	if total, working := rollDice(terms); total != -2 || working != "-1 + 2 - 3" {
+		t.Errorf("rollDice(-1 + 2 - 3) = %d, %q, want -2, %q", total, working, "-1 + 2 - 3")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// discord holds the settings for posting to Discord.
+type discord struct {
+	GoLive discordGoLive `json:"golive"`
+	Bridge discordBridge `json:"bridge"`
+}
+
+// discordGoLive posts an embed of the stream to Discord webhooks when it goes
+// live. In Message, Title, and Description {channel}, {title}, {category},
+// and {url} are replaced from the stream.
+type discordGoLive struct {
+	Webhooks    []string `json:"webhooks"`
+	Message     string   `json:"message,omitempty"`     // above the embed, "{channel} is live! {url}" if unset
+	Title       string   `json:"title,omitempty"`       // "{title}" if unset
+	Description string   `json:"description,omitempty"` // empty if unset
+	Color       int      `json:"color,omitempty"`       // of the embed, Twitch purple if unset
+}
+
+// discordMessage is a webhook message with the parts of an embed that are
+// used.
+type discordMessage struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []discordEmbed `json:"embeds,omitempty"`
+}
+
+type discordEmbed struct {
+	Title       string              `json:"title,omitempty"`
+	URL         string              `json:"url,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color,omitempty"`
+	Timestamp   string              `json:"timestamp,omitempty"`
+	Image       *discordEmbedImage  `json:"image,omitempty"`
+	Fields      []discordEmbedField `json:"fields,omitempty"`
+}
+
+type discordEmbedImage struct {
+	URL string `json:"url"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+const twitchPurple = 0x9146ff
+
+func (c discordGoLive) message(stream helix.Stream) discordMessage {
+	r := streamReplacer(stream)
+
+	content, title, color := c.Message, c.Title, c.Color
+	if content == "" {
+		content = "{channel} is live! {url}"
+	}
+	if title == "" {
+		title = "{title}"
+	}
+	if color == 0 {
+		color = twitchPurple
+	}
+
+	embed := discordEmbed{
+		Title:       r.Replace(title),
+		URL:         streamURL(stream),
+		Description: r.Replace(c.Description),
+		Color:       color,
+	}
+
+	if !stream.StartedAt.IsZero() {
+		embed.Timestamp = stream.StartedAt.Format(time.RFC3339)
+	}
+
+	if stream.ThumbnailURL != "" {
+		// Discord caches images by URL, so make sure it isn't the last stream's.
+		thumbnail := strings.NewReplacer("{width}", "1280", "{height}", "720").Replace(stream.ThumbnailURL)
+		embed.Image = &discordEmbedImage{URL: fmt.Sprintf("%s?t=%d", thumbnail, time.Now().Unix())}
+	}
+
+	if stream.GameName != "" {
+		embed.Fields = append(embed.Fields, discordEmbedField{Name: "Category", Value: stream.GameName, Inline: true})
+	}
+
+	return discordMessage{Content: r.Replace(content), Embeds: []discordEmbed{embed}}
+}
+
+// postDiscord sends the message to a Discord webhook.
+func postDiscord(webhook string, message discordMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("postDiscord: unable to encode message: %w", err)
+	}
+
+	resp, err := http.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The webhook URL is a secret, so keep it out of the logs.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("postDiscord: request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("postDiscord: unexpected status %s: %s", resp.Status, b)
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// checkup prints the result of each of doctor's checks.
+type checkup struct {
+	bot    *bot
+	failed bool
+}
+
+func (c *checkup) ok(format string, args ...interface{}) {
+	fmt.Printf("ok    "+format+"\n", args...)
+}
+
+func (c *checkup) fail(format string, args ...interface{}) {
+	c.failed = true
+	fmt.Printf("FAIL  "+format+"\n", args...)
+}
+
+// requiredScopes returns the scopes the bot's token needs for the features
+// that are set up, and what each is for.
+func requiredScopes() map[string]string {
+	scopes := map[string]string{
+		"chat:read":                      "reading chat",
+		"chat:edit":                      "sending messages",
+		"moderator:manage:chat_messages": "!nuke",
+		"moderator:manage:banned_users":  "!nuke timeouts",
+		"moderator:manage:chat_settings": "!panic",
+		"moderator:manage:announcements": "announcing redemptions",
+	}
+
+	if useAPI, _ := strconv.ParseBool(os.Getenv("CHAT_API")); useAPI {
+		scopes["user:write:chat"] = "sending messages with CHAT_API"
+	}
+
+	if os.Getenv("EVENTSUB_TRANSPORT") == "websocket" || (os.Getenv("EVENTSUB_SECRET") != "" && os.Getenv("TWITCH_MODERATOR") == "") {
+		scopes["moderator:read:followers"] = "follow events"
+	}
+
+	return scopes
+}
+
+// doctor checks the bot is set up to run without starting it, printing what
+// it finds and how to fix any problems. It reports whether everything's OK.
+func doctor(b *bot) bool {
+	c := checkup{bot: b}
+
+	for _, name := range []string{"TWITCH_CLIENT_ID", "TWITCH_CLIENT_SECRET", "TWITCH_USER", "TWITCH_CHANNEL"} {
+		if os.Getenv(name) == "" {
+			c.fail("%s isn't set", name)
+		} else {
+			c.ok("%s is set", name)
+		}
+	}
+
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		// It would have failed to start if it wasn't valid.
+		c.ok("config file %s is valid", file)
+	}
+
+	if os.Getenv("DASHBOARD_LISTEN") != "" && os.Getenv("DASHBOARD_PASSWORD") == "" {
+		c.fail("DASHBOARD_LISTEN is set without DASHBOARD_PASSWORD")
+	}
+
+	if os.Getenv("API_TOKEN") == "" && os.Getenv("GRPC_LISTEN") != "" {
+		c.fail("GRPC_LISTEN is set without API_TOKEN, so gRPC won't be served")
+	}
+
+	if os.Getenv("API_TOKEN") == "" && os.Getenv("EVENTS_LISTEN") != "" {
+		c.fail("EVENTS_LISTEN is set without API_TOKEN, which the event stream needs")
+	}
+
+	token, scopes := c.token()
+	if token != "" {
+		c.scopes(scopes)
+
+		if api, err := newTwitchAPI(token, b.refreshes, b.status, b.modlog); err != nil {
+			c.fail("unable to set up the Twitch API: %v", err)
+		} else if channel := os.Getenv("TWITCH_CHANNEL"); channel != "" {
+			if id, err := api.userID(channel); err != nil {
+				c.fail("unable to look up channel %s: %v", channel, err)
+			} else {
+				c.ok("channel %s has ID %s", channel, id)
+			}
+		}
+	}
+
+	switch transport := os.Getenv("EVENTSUB_TRANSPORT"); transport {
+	case "", "webhook":
+		if secret := os.Getenv("EVENTSUB_SECRET"); secret != "" {
+			c.eventSub(secret)
+		}
+	case "websocket":
+		c.ok("EventSub over a WebSocket, subscribed to with the bot's token")
+	default:
+		c.fail("EVENTSUB_TRANSPORT is %q, expected webhook or websocket", transport)
+	}
+
+	return !c.failed
+}
+
+// token returns the bot's token from the environment or token store, and its
+// scopes, and checks it's valid and for the right user.
+func (c *checkup) token() (string, []string) {
+	token, refresh := os.Getenv("TWITCH_TOKEN"), os.Getenv("TWITCH_REFRESH")
+
+	if token == "" {
+		if err := c.bot.tokens.setup(c.bot.notifications); err != nil {
+			c.fail("unable to set up the token store: %v", err)
+			return "", nil
+		}
+
+		a, stored, err := c.bot.tokens.loadToken(c.bot.account)
+		if err != nil {
+			c.fail("unable to load the stored token: %v", err)
+			return "", nil
+		} else if stored == nil {
+			c.fail("no token, set TWITCH_TOKEN or TOKEN_STORE, or run the bot to authorize it")
+			return "", nil
+		}
+
+		c.bot.account = a
+		token, refresh = stored.Token, stored.Refresh
+	}
+
+	r, err := validateToken(token)
+	if err != nil && refresh != "" {
+		// It may only have expired.
+		if creds, rerr := c.bot.tokens.refreshToken(refresh); rerr == nil {
+			var expires string
+			token, refresh, expires = creds.get()
+			c.bot.account = c.bot.tokens.saveToken(c.bot.account, storedToken{Token: token, Refresh: refresh, Expires: expires})
+			r, err = validateToken(token)
+		}
+	}
+	if err != nil {
+		c.fail("the token isn't valid, authorize the bot again: %v", err)
+		return "", nil
+	}
+
+	c.ok("token is valid for %v more", (time.Duration(r.Data.ExpiresIn) * time.Second).Round(time.Minute))
+
+	if user := os.Getenv("TWITCH_USER"); user != "" && !strings.EqualFold(r.Data.Login, user) {
+		c.fail("the token is for %s rather than TWITCH_USER %s", r.Data.Login, user)
+	} else {
+		c.ok("token is for %s", r.Data.Login)
+	}
+
+	return token, r.Data.Scopes
+}
+
+// scopes checks the token has the scopes every feature that's set up needs.
+func (c *checkup) scopes(scopes []string) {
+	has := map[string]bool{}
+	for _, scope := range scopes {
+		has[scope] = true
+	}
+
+	missing := false
+	for scope, use := range requiredScopes() {
+		if !has[scope] {
+			missing = true
+			c.fail("the token is missing %s, needed for %s", scope, use)
+		}
+	}
+
+	if missing {
+		fmt.Println("      authorize the bot again to add the missing scopes")
+	} else {
+		c.ok("token has every scope needed")
+	}
+}
+
+// eventSub checks EventSub can be subscribed to.
+func (c *checkup) eventSub(secret string) {
+	if len(secret) < 10 || len(secret) > 100 {
+		c.fail("EVENTSUB_SECRET has to be 10 to 100 characters")
+	}
+
+	if os.Getenv("VIRTUAL_HOST") == "" {
+		c.fail("EVENTSUB_SECRET is set without VIRTUAL_HOST, so Twitch can't reach the callback")
+	}
+
+	client, err := helix.NewClient(&helix.Options{
+		ClientID:     os.Getenv("TWITCH_CLIENT_ID"),
+		ClientSecret: os.Getenv("TWITCH_CLIENT_SECRET"),
+	})
+	if err != nil {
+		c.fail("unable to set up the EventSub client: %v", err)
+		return
+	}
+
+	r, err := client.RequestAppAccessToken(nil)
+	if err != nil {
+		c.fail("unable to get an app access token for EventSub: %v", err)
+	} else if r.ErrorStatus != 0 {
+		c.fail("unable to get an app access token for EventSub, check TWITCH_CLIENT_SECRET: %v - %s", r.ErrorStatus, r.ErrorMessage)
+	} else {
+		c.ok("app access token for EventSub")
+	}
+}
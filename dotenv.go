@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// dotEnvErr is set while the package's variables are initialized so .env is
+// loaded before any init reads the environment, like LOG_LEVEL and
+// VIRTUAL_HOST. Logging isn't set up yet, so it's reported from main.
+var dotEnvErr = loadDotEnv(".env")
+
+// loadDotEnv sets the variables in the file, if it exists, without replacing
+// ones already in the environment.
+func loadDotEnv(file string) error {
+	err := godotenv.Load(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("loadDotEnv: unable to load %q: %w", file, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+const (
+	// emoteSaveEvery is how many messages with emotes are counted between
+	// saves.
+	emoteSaveEvery = 50
+	// defaultTopEmotes is how many emotes !topemotes lists unless asked for
+	// more, up to maxTopEmotes.
+	defaultTopEmotes = 5
+	maxTopEmotes     = 10
+)
+
+// emoteCount is how often an emote's been used in a channel. Emotes are
+// counted by ID, so renamed ones keep their count under the latest name.
+type emoteCount struct {
+	Channel  string    `json:"channel"`
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Count    int       `json:"count"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// emoteTracker counts the Twitch emotes used in each channel, saved to
+// emotes.json in the state directory.
+type emoteTracker struct {
+	mu      sync.Mutex
+	loaded  bool
+	file    string                            // empty to keep them in memory
+	counts  map[string]map[string]*emoteCount // by channel, then emote ID
+	unsaved int
+}
+
+// loadLocked reads the counts saved before, the first time they're needed.
+func (e *emoteTracker) loadLocked() {
+	if e.loaded {
+		return
+	}
+	e.loaded = true
+	e.counts = map[string]map[string]*emoteCount{}
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Warnf("emote usage will only be kept in memory: %v", err)
+		return
+	}
+	e.file = filepath.Join(dir, "emotes.json")
+
+	data, err := os.ReadFile(e.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Errorf("unable to read emote usage: %v", err)
+		return
+	}
+
+	var counts []emoteCount
+	if err := json.Unmarshal(data, &counts); err != nil {
+		log.Errorf("invalid emote usage in %q: %v", e.file, err)
+		return
+	}
+	for i := range counts {
+		c := &counts[i]
+		if e.counts[c.Channel] == nil {
+			e.counts[c.Channel] = map[string]*emoteCount{}
+		}
+		e.counts[c.Channel][c.ID] = c
+	}
+}
+
+// add counts the emotes in the message, each as many times as it was used.
+func (e *emoteTracker) add(message twitch.PrivateMessage, at time.Time) {
+	if len(message.Emotes) == 0 {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.loadLocked()
+
+	channel := e.counts[message.Channel]
+	if channel == nil {
+		channel = map[string]*emoteCount{}
+		e.counts[message.Channel] = channel
+	}
+
+	for _, emote := range message.Emotes {
+		c, ok := channel[emote.ID]
+		if !ok {
+			c = &emoteCount{Channel: message.Channel, ID: emote.ID}
+			channel[emote.ID] = c
+		}
+		c.Name = emote.Name
+		c.Count += emote.Count
+		c.LastUsed = at
+	}
+
+	e.unsaved++
+	if e.unsaved >= emoteSaveEvery {
+		if err := e.saveLocked(); err != nil {
+			log.Errorf("unable to save emote usage: %v", err)
+		}
+	}
+}
+
+// top returns the channel's n most used emotes, most used first.
+func (e *emoteTracker) top(channel string, n int) []emoteCount {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.loadLocked()
+
+	counts := make([]emoteCount, 0, len(e.counts[channel]))
+	for _, c := range e.counts[channel] {
+		counts = append(counts, *c)
+	}
+	sortEmoteCounts(counts)
+
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+
+	return counts
+}
+
+// all returns every channel's counts, by channel then most used first.
+func (e *emoteTracker) all() []emoteCount {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.loadLocked()
+
+	return e.allLocked()
+}
+
+func (e *emoteTracker) allLocked() []emoteCount {
+	var counts []emoteCount
+	for _, channel := range e.counts {
+		for _, c := range channel {
+			counts = append(counts, *c)
+		}
+	}
+	sortEmoteCounts(counts)
+
+	return counts
+}
+
+func sortEmoteCounts(counts []emoteCount) {
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		} else if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Name < b.Name
+	})
+}
+
+// save writes the counts if any have changed since the last save.
+func (e *emoteTracker) save() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.loaded || e.unsaved == 0 {
+		return nil
+	}
+
+	return e.saveLocked()
+}
+
+func (e *emoteTracker) saveLocked() error {
+	e.unsaved = 0
+	if e.file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(e.allLocked())
+	if err != nil {
+		return fmt.Errorf("save: unable to encode emote usage: %w", err)
+	}
+
+	if err := writeStateFile(e.file, data); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+
+	return nil
+}
+
+// export writes every channel's counts to w either as "json" or "csv".
+func (e *emoteTracker) export(w io.Writer, format string) error {
+	counts := e.all()
+
+	switch format {
+	case "json":
+		if counts == nil {
+			counts = []emoteCount{}
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(counts)
+	case "csv":
+		c := csv.NewWriter(w)
+		c.Write([]string{"channel", "id", "name", "count", "last_used"})
+		for _, count := range counts {
+			c.Write([]string{count.Channel, count.ID, count.Name, strconv.Itoa(count.Count), count.LastUsed.Format(time.RFC3339)})
+		}
+		c.Flush()
+		return c.Error()
+	}
+
+	return fmt.Errorf("export: unknown format %q", format)
+}
+
+// topEmotesCommand lists the channel's most used emotes, 5 unless a number is
+// given.
+func topEmotesCommand(b *bot, client chat.Sender, message twitch.PrivateMessage, args []string) {
+	n := defaultTopEmotes
+	if len(args) > 0 {
+		if v, err := strconv.Atoi(args[0]); err == nil && v > 0 {
+			n = v
+		}
+	}
+	if n > maxTopEmotes {
+		n = maxTopEmotes
+	}
+
+	counts := b.emotes.top(message.Channel, n)
+	if len(counts) == 0 {
+		client.Reply(message.Channel, message.ID, "No emotes have been used yet")
+		return
+	}
+
+	top := make([]string, len(counts))
+	for i, c := range counts {
+		top[i] = fmt.Sprintf("%d. %s (%d)", i+1, c.Name, c.Count)
+	}
+
+	client.Reply(message.Channel, message.ID, "Top emotes: "+strings.Join(top, ", "))
+}
@@ -0,0 +1,681 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// eventSub receives EventSub notifications over webhooks, or a WebSocket with
+// EVENTSUB_TRANSPORT=websocket. Twitch has to be able to reach the webhook
+// callback over HTTPS on port 443, so webhooks are only usable when the bot is
+// behind a public hostname, see VIRTUAL_HOST.
+type eventSub struct {
+	client        *helix.Client
+	users         userLookup
+	status        *botStatus
+	refreshes     refreshRequests
+	notifications *notifier
+	secret        string
+	callback      string
+	listen        string
+	server        *http.Server
+	socket        bool          // notifications come over a WebSocket, see listenSocket
+	closed        chan struct{} // closed to disconnect the WebSocket
+
+	mu            sync.Mutex
+	handlers      map[string][]func(event json.RawMessage)
+	seen          map[string]time.Time
+	record        *os.File          // where notifications are recorded, if anywhere
+	broadcasterID string            // set by subscribe
+	moderatorID   string            // set by subscribe, if there's a moderator
+	subscriptions map[string]string // each subscription's status, by type
+	sessionID     string            // the WebSocket's, once Twitch has welcomed it
+	checked       time.Time         // when the subscriptions were last checked
+	notified      time.Time         // when the last notification came in
+	done          chan struct{}     // closed to stop the monitor
+	authorizing   *server           // waiting for Twitch to send the browser back, see authCode
+}
+
+// unrecoverableRevocations are why a subscription can be revoked that
+// subscribing again won't fix, since the user has to authorize the bot first,
+// or the bot has to be updated to a version of the subscription Twitch still
+// has.
+var unrecoverableRevocations = map[string]bool{
+	"authorization_revoked": true,
+	"user_removed":          true,
+	"moderator_removed":     true,
+	"version_removed":       true,
+}
+
+const (
+	// resubscribeAttempts is how many times a revoked subscription is made
+	// again before giving up.
+	resubscribeAttempts = 5
+	// resubscribeBackoff is how long to wait after the first attempt fails,
+	// doubling after each.
+	resubscribeBackoff = 5 * time.Second
+	// subscriptionCheckInterval is how often the monitor checks the
+	// subscriptions with Twitch.
+	subscriptionCheckInterval = 5 * time.Minute
+)
+
+// eventSubReport is how EventSub's doing, for /readyz.
+type eventSubReport struct {
+	Subscriptions    map[string]string `json:"subscriptions"`
+	Checked          *time.Time        `json:"checked,omitempty"`
+	LastNotification *time.Time        `json:"last_notification,omitempty"`
+}
+
+// eventSubMessage is the body of every request Twitch sends to the callback.
+type eventSubMessage struct {
+	Challenge    string                     `json:"challenge"`
+	Subscription helix.EventSubSubscription `json:"subscription"`
+	Event        json.RawMessage            `json:"event"`
+}
+
+func newEventSub(secret string, users userLookup, status *botStatus, refreshes refreshRequests, notifications *notifier) (*eventSub, error) {
+	client, err := helix.NewClient(&helix.Options{
+		ClientID:     os.Getenv("TWITCH_CLIENT_ID"),
+		ClientSecret: os.Getenv("TWITCH_CLIENT_SECRET"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("newEventSub: unable to set up client: %w", err)
+	}
+
+	e := &eventSub{
+		client:        client,
+		users:         users,
+		status:        status,
+		refreshes:     refreshes,
+		notifications: notifications,
+		secret:        secret,
+		callback:      redirect + "/eventsub",
+		listen:        listen,
+		handlers:      map[string][]func(json.RawMessage){},
+		seen:          map[string]time.Time{},
+		subscriptions: map[string]string{},
+		done:          make(chan struct{}),
+		closed:        make(chan struct{}),
+	}
+
+	switch transport := os.Getenv("EVENTSUB_TRANSPORT"); transport {
+	case "", "webhook":
+	case "websocket":
+		e.socket = true
+	default:
+		return nil, fmt.Errorf("newEventSub: unknown EVENTSUB_TRANSPORT %q, expected webhook or websocket", transport)
+	}
+
+	if l := os.Getenv("EVENTSUB_LISTEN"); l != "" {
+		e.listen = l
+	}
+
+	if file := os.Getenv("EVENTSUB_RECORD"); file != "" {
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("newEventSub: unable to open %q: %w", file, err)
+		}
+		e.record = f
+	}
+
+	mux := http.NewServeMux()
+	if !e.socket {
+		mux.Handle("/eventsub", e)
+	}
+	// Twitch sends the browser back here if the bot has to be authorized
+	// again while it's running, see authCode.
+	mux.HandleFunc("/", e.authCallback)
+	e.server = &http.Server{Addr: e.listen, Handler: mux}
+
+	return e, nil
+}
+
+// on calls handler with the event of every notification of the subscription
+// type. Only types with a handler are subscribed to.
+func (e *eventSub) on(typ string, handler func(event json.RawMessage)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.handlers[typ] = append(e.handlers[typ], handler)
+}
+
+func (e *eventSub) Start() error {
+	return fmt.Errorf("unable to start eventsub server: %w", e.server.ListenAndServe())
+}
+
+func (e *eventSub) Shutdown(ctx context.Context) error {
+	return e.server.Shutdown(ctx)
+}
+
+// subscribe replaces any subscriptions left from a previous run with ones for
+// every type that has a handler. The moderator is the user subscriptions that
+// need a moderator are made for, the bot unless TWITCH_MODERATOR is set.
+func (e *eventSub) subscribe(channel, moderator string) error {
+	if err := e.refreshAppToken(); err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+
+	broadcasterID, err := e.users.userID(strings.ToLower(channel))
+	if err != nil {
+		return fmt.Errorf("subscribe: unable to look up channel: %w", err)
+	}
+
+	var moderatorID string
+	if moderator != "" {
+		if moderatorID, err = e.users.userID(strings.ToLower(moderator)); err != nil {
+			return fmt.Errorf("subscribe: unable to look up moderator: %w", err)
+		}
+	}
+
+	e.mu.Lock()
+	e.broadcasterID, e.moderatorID = broadcasterID, moderatorID
+	waiting := e.socket && e.sessionID == ""
+	e.mu.Unlock()
+	if waiting {
+		// Subscriptions need the session, so they're made once it's welcomed.
+		return nil
+	}
+
+	if err := e.subscribeAll(); err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+
+	return nil
+}
+
+// subscribeAll replaces the bot's subscriptions with one for each type.
+func (e *eventSub) subscribeAll() error {
+	existing, err := e.subscriptionsFor()
+	if err != nil {
+		return fmt.Errorf("subscribeAll: %w", err)
+	}
+
+	for _, sub := range existing {
+		if _, err := e.client.RemoveEventSubSubscription(sub.ID); err != nil {
+			log.WithField("event_type", sub.Type).Errorf("unable to remove %s subscription: %v", sub.Type, err)
+		}
+	}
+
+	var errs []error
+	for _, typ := range e.types() {
+		if err := e.create(typ); err != nil {
+			errs = append(errs, fmt.Errorf("subscribeAll: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// refreshAppToken gets the app access token webhook subscriptions are made
+// with.
+func (e *eventSub) refreshAppToken() error {
+	if e.socket {
+		// WebSocket subscriptions are made with the bot's token instead.
+		return nil
+	}
+
+	token, err := e.client.RequestAppAccessToken(nil)
+	if err != nil {
+		return fmt.Errorf("refreshAppToken: unable to get app access token: %w", err)
+	} else if token.ErrorStatus != 0 {
+		return fmt.Errorf("refreshAppToken: invalid response: %v - %s", token.ErrorStatus, token.ErrorMessage)
+	}
+	e.client.SetAppAccessToken(token.Data.AccessToken)
+
+	return nil
+}
+
+// refreshToken gets a new token after Twitch rejects the one subscriptions are
+// made with, an app access token for webhooks or the bot's for the WebSocket.
+func (e *eventSub) refreshToken() error {
+	if !e.socket {
+		return e.refreshAppToken()
+	}
+
+	token, err := e.refreshes.now()
+	if err != nil {
+		return fmt.Errorf("refreshToken: %w", err)
+	}
+	e.client.SetUserAccessToken(strings.TrimPrefix(token, "oauth:"))
+
+	return nil
+}
+
+// types returns the subscription types that have a handler.
+func (e *eventSub) types() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return sortedKeys(e.handlers)
+}
+
+// create subscribes to the type for the channel subscribe was given.
+func (e *eventSub) create(typ string) error {
+	e.mu.Lock()
+	broadcasterID, moderatorID, sessionID := e.broadcasterID, e.moderatorID, e.sessionID
+	e.mu.Unlock()
+
+	sub := &helix.EventSubSubscription{
+		Type:    typ,
+		Version: "1",
+		Condition: helix.EventSubCondition{
+			BroadcasterUserID: broadcasterID,
+		},
+		Transport: helix.EventSubTransport{
+			Method:   "webhook",
+			Callback: e.callback,
+			Secret:   e.secret,
+		},
+	}
+	if e.socket {
+		sub.Transport = helix.EventSubTransport{Method: "websocket", SessionID: sessionID}
+	}
+
+	if typ == helix.EventSubTypeChannelFollow {
+		sub.Version = "2"
+		sub.Condition.ModeratorUserID = moderatorID
+	}
+
+	r, err := e.client.CreateEventSubSubscription(sub)
+	if err != nil {
+		e.setStatus(typ, "failed")
+		return fmt.Errorf("create: unable to subscribe to %s: %w", typ, err)
+	} else if r.ErrorStatus != 0 {
+		e.setStatus(typ, "failed")
+		return fmt.Errorf("create: invalid response for %s: %v - %s", typ, r.ErrorStatus, r.ErrorMessage)
+	}
+
+	status := "enabled"
+	if !e.socket {
+		status = "webhook_callback_verification_pending"
+	}
+	if len(r.Data.EventSubSubscriptions) > 0 {
+		status = r.Data.EventSubSubscriptions[0].Status
+	}
+	// Twitch can verify the callback before the response gets back.
+	e.mu.Lock()
+	verified := e.subscriptions[typ] == "enabled"
+	e.mu.Unlock()
+	if !verified {
+		e.setStatus(typ, status)
+	}
+
+	return nil
+}
+
+// setStatus records the subscription's status, and whether EventSub as a
+// whole is working for the health checks.
+func (e *eventSub) setStatus(typ, s string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.subscriptions[typ] = s
+
+	subscribed := true
+	for _, s := range e.subscriptions {
+		if s != "enabled" && s != "webhook_callback_verification_pending" {
+			subscribed = false
+		}
+	}
+	e.status.setEventSubscribed(subscribed)
+}
+
+// onRevocation subscribes to the type again, unless it was revoked for a
+// reason that'll just make it fail again.
+func (e *eventSub) onRevocation(sub helix.EventSubSubscription) {
+	log.WithField("event_type", sub.Type).Warnf("eventsub: %s subscription revoked: %s", sub.Type, sub.Status)
+	e.setStatus(sub.Type, sub.Status)
+
+	if sub.Status == "version_removed" {
+		log.Errorf("eventsub: version %s of %s was removed, the bot has to be updated to a newer one", sub.Version, sub.Type)
+		go e.notifications.send("EventSub subscription version removed",
+			fmt.Sprintf("%s version %s was removed, update the bot to subscribe to a newer version", sub.Type, sub.Version))
+		return
+	}
+
+	go e.notifications.send("EventSub subscription revoked", fmt.Sprintf("%s: %s", sub.Type, sub.Status))
+
+	if unrecoverableRevocations[sub.Status] {
+		log.Errorf("eventsub: not subscribing to %s again until the bot's authorized again", sub.Type)
+		return
+	}
+
+	go e.resubscribe(sub.Type)
+}
+
+// resubscribe subscribes to the type again with a fresh app access token,
+// trying resubscribeAttempts times and waiting longer after each failure.
+func (e *eventSub) resubscribe(typ string) {
+	backoff := resubscribeBackoff
+	for attempt := 1; ; attempt++ {
+		err := e.refreshAppToken()
+		if err == nil {
+			err = e.create(typ)
+		}
+		if err == nil {
+			log.Infof("eventsub: subscribed to %s again", typ)
+			return
+		}
+
+		if attempt == resubscribeAttempts {
+			log.Errorf("eventsub: giving up subscribing to %s again: %v", typ, err)
+			e.notifications.send("EventSub resubscribe failed", err.Error())
+			return
+		}
+
+		log.Warnf("eventsub: unable to subscribe to %s again, trying again in %v: %v", typ, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// monitor checks the subscriptions with Twitch every
+// subscriptionCheckInterval until stopMonitor's called, making any that are
+// missing or have failed again.
+func (e *eventSub) monitor() error {
+	tick := time.NewTicker(subscriptionCheckInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-e.done:
+			return nil
+		case <-tick.C:
+		}
+
+		if err := e.check(); err != nil {
+			log.Errorf("unable to check eventsub subscriptions: %v", err)
+		}
+	}
+}
+
+func (e *eventSub) stopMonitor(context.Context) error {
+	close(e.done)
+	return nil
+}
+
+// check compares the subscriptions Twitch has for the callback with the types
+// there are handlers for. Missing and failed ones are made again, except ones
+// revoked in a way that'd just happen again.
+func (e *eventSub) check() error {
+	e.mu.Lock()
+	subscribed := e.broadcasterID != "" && (!e.socket || e.sessionID != "")
+	e.mu.Unlock()
+	if !subscribed {
+		// Until subscribe's worked and the WebSocket's been welcomed, there's
+		// nothing to compare against.
+		return nil
+	}
+
+	current, err := e.subscriptionsFor()
+	if err != nil {
+		return fmt.Errorf("check: %w", err)
+	}
+
+	statuses := map[string]string{}
+	for _, sub := range current {
+		switch sub.Status {
+		case "enabled", "webhook_callback_verification_pending":
+			if statuses[sub.Type] != "enabled" {
+				statuses[sub.Type] = sub.Status
+			}
+			continue
+		}
+
+		// Failed subscriptions still count against the limit until removed.
+		if _, err := e.client.RemoveEventSubSubscription(sub.ID); err != nil {
+			log.WithField("event_type", sub.Type).Errorf("unable to remove %s subscription: %v", sub.Type, err)
+		}
+		if statuses[sub.Type] == "" {
+			statuses[sub.Type] = sub.Status
+		}
+	}
+
+	var errs []error
+	for _, typ := range e.types() {
+		s := statuses[typ]
+		switch {
+		case s == "enabled" || s == "webhook_callback_verification_pending":
+			e.setStatus(typ, s)
+			continue
+		case unrecoverableRevocations[e.statusOf(typ)]:
+			continue
+		case s == "":
+			s = "missing"
+		}
+
+		log.WithField("event_type", typ).Warnf("eventsub: %s subscription is %s, subscribing again", typ, s)
+		e.setStatus(typ, s)
+		if err := e.create(typ); err != nil {
+			errs = append(errs, fmt.Errorf("check: %w", err))
+		}
+	}
+
+	e.mu.Lock()
+	e.checked = time.Now()
+	e.mu.Unlock()
+
+	return errors.Join(errs...)
+}
+
+// subscriptionsFor returns every subscription Twitch has with the callback, or
+// the WebSocket's session, getting a new token if the one it had expired.
+func (e *eventSub) subscriptionsFor() ([]helix.EventSubSubscription, error) {
+	e.mu.Lock()
+	sessionID := e.sessionID
+	e.mu.Unlock()
+
+	var subs []helix.EventSubSubscription
+	params := &helix.EventSubSubscriptionsParams{}
+	refreshed := false
+	for {
+		r, err := e.client.GetEventSubSubscriptions(params)
+		if err != nil {
+			return nil, fmt.Errorf("subscriptionsFor: unable to get subscriptions: %w", err)
+		} else if r.StatusCode == http.StatusUnauthorized && !refreshed {
+			if err := e.refreshToken(); err != nil {
+				return nil, fmt.Errorf("subscriptionsFor: %w", err)
+			}
+			refreshed = true
+			continue
+		} else if r.ErrorStatus != 0 {
+			return nil, fmt.Errorf("subscriptionsFor: invalid response: %v - %s", r.ErrorStatus, r.ErrorMessage)
+		}
+
+		for _, sub := range r.Data.EventSubSubscriptions {
+			if (e.socket && sub.Transport.SessionID == sessionID) || (!e.socket && sub.Transport.Callback == e.callback) {
+				subs = append(subs, sub)
+			}
+		}
+
+		if r.Data.Pagination.Cursor == "" {
+			return subs, nil
+		}
+		params.After = r.Data.Pagination.Cursor
+	}
+}
+
+// statusOf returns the subscription's status as far as the bot knows.
+func (e *eventSub) statusOf(typ string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.subscriptions[typ]
+}
+
+// report returns the subscriptions' statuses and when they were last checked
+// and the last notification came in.
+func (e *eventSub) report() eventSubReport {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	r := eventSubReport{Subscriptions: make(map[string]string, len(e.subscriptions))}
+	for typ, s := range e.subscriptions {
+		r.Subscriptions[typ] = s
+	}
+	if !e.checked.IsZero() {
+		checked := e.checked
+		r.Checked = &checked
+	}
+	if !e.notified.IsZero() {
+		notified := e.notified
+		r.LastNotification = &notified
+	}
+
+	return r
+}
+
+func (e *eventSub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !helix.VerifyEventSubNotification(e.secret, r.Header, string(body)) {
+		log.Warnf("eventsub: invalid signature from %s", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	// Twitch's recommendation to guard against replays.
+	sent, err := time.Parse(time.RFC3339Nano, r.Header.Get("Twitch-Eventsub-Message-Timestamp"))
+	if err != nil || time.Since(sent) > 10*time.Minute {
+		http.Error(w, "stale message", http.StatusForbidden)
+		return
+	}
+
+	var message eventSubMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Header.Get("Twitch-Eventsub-Message-Type") {
+	case "webhook_callback_verification":
+		log.Infof("eventsub: verified %s subscription", message.Subscription.Type)
+		e.setStatus(message.Subscription.Type, "enabled")
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, message.Challenge)
+	case "revocation":
+		w.WriteHeader(http.StatusNoContent)
+		e.onRevocation(message.Subscription)
+	case "notification":
+		w.WriteHeader(http.StatusNoContent)
+		e.notify(r.Header.Get("Twitch-Eventsub-Message-Id"), body, message)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// notify handles a notification unless it's already been handled, since
+// Twitch can send one more than once.
+func (e *eventSub) notify(id string, body []byte, message eventSubMessage) {
+	e.mu.Lock()
+	e.notified = time.Now()
+	e.mu.Unlock()
+
+	if e.duplicate(id) {
+		return
+	}
+
+	e.save(body)
+	e.dispatch(message.Subscription.Type, message.Event)
+}
+
+// duplicate reports whether the message was already handled, since Twitch
+// may deliver the same notification more than once.
+func (e *eventSub) duplicate(id string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for seen, at := range e.seen {
+		if time.Since(at) > 10*time.Minute {
+			delete(e.seen, seen)
+		}
+	}
+
+	if _, ok := e.seen[id]; ok {
+		return true
+	}
+	e.seen[id] = time.Now()
+
+	return false
+}
+
+func (e *eventSub) dispatch(typ string, event json.RawMessage) {
+	e.mu.Lock()
+	handlers := e.handlers[typ]
+	e.mu.Unlock()
+
+	log.Debugf("eventsub: %s %s", typ, event)
+
+	for _, handler := range handlers {
+		go handler(event)
+	}
+}
+
+// save appends the notification to EVENTSUB_RECORD, one per line, to be
+// replayed later.
+func (e *eventSub) save(body []byte) {
+	if e.record == nil {
+		return
+	}
+
+	var line bytes.Buffer
+	if err := json.Compact(&line, body); err != nil {
+		log.Errorf("unable to record eventsub notification: %v", err)
+		return
+	}
+	line.WriteByte('\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := e.record.Write(line.Bytes()); err != nil {
+		log.Errorf("unable to record eventsub notification: %v", err)
+	}
+}
+
+// replay runs the handlers for each notification in r, one JSON body per
+// line as they're recorded, in order and waiting for each. Notifications for
+// types without a handler are skipped. It returns how many were handled.
+func (e *eventSub) replay(r io.Reader) (int, error) {
+	handled := 0
+
+	d := json.NewDecoder(r)
+	for {
+		var message eventSubMessage
+		if err := d.Decode(&message); errors.Is(err, io.EOF) {
+			return handled, nil
+		} else if err != nil {
+			return handled, fmt.Errorf("replay: invalid notification: %w", err)
+		}
+
+		e.mu.Lock()
+		handlers := e.handlers[message.Subscription.Type]
+		e.mu.Unlock()
+
+		if len(handlers) == 0 {
+			log.Debugf("eventsub: not replaying %s, nothing handles it", message.Subscription.Type)
+			continue
+		}
+
+		log.Debugf("eventsub: replaying %s %s", message.Subscription.Type, message.Event)
+		for _, handler := range handlers {
+			handler(message.Event)
+		}
+		handled++
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+const testEventSubSecret = "0123456789abcdef"
+
+// newTestEventSub sets up EventSub for webhooks with a server for its
+// callback, without subscribing to anything.
+func newTestEventSub(t *testing.T) (*eventSub, *httptest.Server) {
+	t.Helper()
+
+	t.Setenv("TWITCH_CLIENT_ID", "test")
+	t.Setenv("EVENTSUB_TRANSPORT", "")
+	t.Setenv("EVENTSUB_RECORD", "")
+
+	events, err := newEventSub(testEventSubSecret, nil, &botStatus{channels: map[string]bool{}}, nil, newNotifier())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(events.server.Handler)
+	t.Cleanup(server.Close)
+
+	return events, server
+}
+
+// postEventSub posts the body to the callback as a notification, signed with
+// secret like Twitch signs them.
+func postEventSub(t *testing.T, url, secret, id string, body []byte) *http.Response {
+	t.Helper()
+
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id + timestamp))
+	mac.Write(body)
+
+	req, err := http.NewRequest(http.MethodPost, url+"/eventsub", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Twitch-Eventsub-Message-Id", id)
+	req.Header.Set("Twitch-Eventsub-Message-Timestamp", timestamp)
+	req.Header.Set("Twitch-Eventsub-Message-Type", "notification")
+	req.Header.Set("Twitch-Eventsub-Message-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	return resp
+}
+
+// TestEventSubFixtures posts every notification in testdata/eventsub to the
+// callback and checks each is handled, and that follows reach the bus.
+func TestEventSubFixtures(t *testing.T) {
+	events, server := newTestEventSub(t)
+
+	handled := make(chan eventSubMessage, 10)
+	for _, typ := range []string{
+		helix.EventSubTypeChannelFollow,
+		helix.EventSubTypeChannelPointsCustomRewardRedemptionAdd,
+		helix.EventSubTypeStreamOnline,
+		helix.EventSubTypeStreamOffline,
+	} {
+		typ := typ
+		events.on(typ, func(raw json.RawMessage) {
+			handled <- eventSubMessage{Subscription: helix.EventSubSubscription{Type: typ}, Event: raw}
+		})
+	}
+	bus := newEventBus()
+	events.on(helix.EventSubTypeChannelFollow, bus.onFollow)
+
+	published, unsubscribe := bus.subscribe()
+	defer unsubscribe()
+
+	files, err := filepath.Glob("testdata/eventsub/*.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(files) == 0 {
+		t.Fatal("no fixtures in testdata/eventsub")
+	}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := bufio.NewScanner(f)
+		lines.Buffer(nil, 1<<20)
+		for n := 1; lines.Scan(); n++ {
+			name := fmt.Sprintf("%s:%d", filepath.Base(file), n)
+			body := lines.Bytes()
+
+			var want eventSubMessage
+			if err := json.Unmarshal(body, &want); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			resp := postEventSub(t, server.URL, testEventSubSecret, name, body)
+			if resp.StatusCode != http.StatusNoContent {
+				t.Errorf("%s: got status %d, want %d", name, resp.StatusCode, http.StatusNoContent)
+				continue
+			}
+
+			select {
+			case got := <-handled:
+				if got.Subscription.Type != want.Subscription.Type {
+					t.Errorf("%s: handled %s, want %s", name, got.Subscription.Type, want.Subscription.Type)
+				}
+				if !jsonEqual(t, got.Event, want.Event) {
+					t.Errorf("%s: handled %s, want %s", name, got.Event, want.Event)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: %s wasn't handled", name, want.Subscription.Type)
+			}
+
+			if want.Subscription.Type != helix.EventSubTypeChannelFollow {
+				continue
+			}
+
+			var follow helix.EventSubChannelFollowEvent
+			if err := json.Unmarshal(want.Event, &follow); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			select {
+			case e := <-published:
+				if e.Type != eventTypeFollow || e.UserID != follow.UserID || e.User != follow.UserName ||
+					e.Channel != follow.BroadcasterUserLogin {
+					t.Errorf("%s: published %+v, want a follow from %s in %s", name, e, follow.UserName, follow.BroadcasterUserLogin)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: the follow wasn't published", name)
+			}
+		}
+		f.Close()
+
+		if err := lines.Err(); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+	}
+}
+
+// TestEventSubBadSignature checks a notification signed with the wrong
+// secret is rejected and not handled.
+func TestEventSubBadSignature(t *testing.T) {
+	events, server := newTestEventSub(t)
+
+	handled := make(chan json.RawMessage, 1)
+	events.on(helix.EventSubTypeChannelFollow, func(raw json.RawMessage) { handled <- raw })
+
+	body, err := os.ReadFile("testdata/eventsub/follow.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := postEventSub(t, server.URL, "not the secret", "bad-signature", bytes.TrimSpace(body))
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	select {
+	case raw := <-handled:
+		t.Errorf("handled %s", raw)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// jsonEqual reports whether a and b are the same JSON, ignoring formatting.
+func jsonEqual(t *testing.T, a, b json.RawMessage) bool {
+	t.Helper()
+
+	var x, y interface{}
+	if err := json.Unmarshal(a, &x); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &y); err != nil {
+		t.Fatal(err)
+	}
+
+	return reflect.DeepEqual(x, y)
+}
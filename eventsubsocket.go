@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// eventSubSocketURL is where the WebSocket transport connects, asking
+	// for a keepalive every 30s when there's nothing else to send.
+	eventSubSocketURL = "wss://eventsub.wss.twitch.tv/ws?keepalive_timeout_seconds=30"
+	// eventSubSocketTimeout is how long the WebSocket can go without a
+	// message, keepalives included, before it's given up on.
+	eventSubSocketTimeout = 40 * time.Second
+)
+
+// eventSubSocketMessage is every message Twitch sends over the WebSocket.
+// The payload is a session for session messages, and otherwise the same as
+// the body of a webhook.
+type eventSubSocketMessage struct {
+	Metadata struct {
+		MessageID   string `json:"message_id"`
+		MessageType string `json:"message_type"`
+	} `json:"metadata"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type eventSubSession struct {
+	Session struct {
+		ID           string `json:"id"`
+		ReconnectURL string `json:"reconnect_url"`
+	} `json:"session"`
+}
+
+// socketRead is a message, or why there won't be any more, read from one of
+// the WebSocket's connections.
+type socketRead struct {
+	conn    *websocket.Conn
+	message eventSubSocketMessage
+	err     error
+}
+
+// listenSocket connects to Twitch's EventSub WebSocket and handles what it
+// sends until the connection's lost or closeSocket's called. Subscriptions
+// are made once Twitch welcomes the session. When Twitch asks the bot to
+// reconnect, it connects to the URL it's given and only closes the old
+// connection once the new one's welcomed, which keeps the session and its
+// subscriptions without missing anything in between.
+func (e *eventSub) listenSocket() error {
+	reads := make(chan socketRead)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	conn, err := dialSocket(eventSubSocketURL, reads, stop)
+	if err != nil {
+		return fmt.Errorf("listenSocket: %w", err)
+	}
+	defer func() { conn.Close() }()
+	defer e.disconnected()
+
+	for {
+		var r socketRead
+		select {
+		case <-e.closed:
+			return nil
+		case r = <-reads:
+		}
+
+		if r.err != nil {
+			if r.conn != conn {
+				// An old connection, closed after moving to a new one.
+				continue
+			}
+			return fmt.Errorf("listenSocket: connection lost: %w", r.err)
+		}
+
+		switch r.message.Metadata.MessageType {
+		case "session_welcome":
+			if r.conn != conn {
+				log.Info("eventsub: reconnected to the websocket")
+				conn.Close()
+				conn = r.conn
+				continue
+			}
+
+			var s eventSubSession
+			if err := json.Unmarshal(r.message.Payload, &s); err != nil {
+				return fmt.Errorf("listenSocket: invalid welcome: %w", err)
+			}
+			e.welcome(s.Session.ID)
+		case "session_keepalive":
+		case "session_reconnect":
+			var s eventSubSession
+			if err := json.Unmarshal(r.message.Payload, &s); err != nil {
+				return fmt.Errorf("listenSocket: invalid reconnect: %w", err)
+			}
+
+			log.Infof("eventsub: twitch asked to reconnect to the websocket")
+			if _, err := dialSocket(s.Session.ReconnectURL, reads, stop); err != nil {
+				// The session's lost with the old connection, so the restart
+				// subscribes again.
+				return fmt.Errorf("listenSocket: unable to reconnect: %w", err)
+			}
+		case "notification":
+			var message eventSubMessage
+			if err := json.Unmarshal(r.message.Payload, &message); err != nil {
+				log.Errorf("eventsub: invalid notification: %v", err)
+				continue
+			}
+			e.notify(r.message.Metadata.MessageID, r.message.Payload, message)
+		case "revocation":
+			var message eventSubMessage
+			if err := json.Unmarshal(r.message.Payload, &message); err != nil {
+				log.Errorf("eventsub: invalid revocation: %v", err)
+				continue
+			}
+			e.onRevocation(message.Subscription)
+		default:
+			log.Debugf("eventsub: unknown websocket message %s", r.message.Metadata.MessageType)
+		}
+	}
+}
+
+// disconnected forgets the session, since its subscriptions went with it.
+func (e *eventSub) disconnected() {
+	e.mu.Lock()
+	e.sessionID = ""
+	e.mu.Unlock()
+
+	for _, typ := range e.types() {
+		e.setStatus(typ, "websocket_disconnected")
+	}
+}
+
+func (e *eventSub) closeSocket(context.Context) error {
+	close(e.closed)
+	return nil
+}
+
+// setToken sets the bot's token, which WebSocket subscriptions are made with,
+// when it's refreshed. Subscriptions that failed, or for the WebSocket were
+// revoked with the bot's old authorization, are made again.
+func (e *eventSub) setToken(token string) {
+	if e.socket {
+		e.client.SetUserAccessToken(strings.TrimPrefix(token, "oauth:"))
+	}
+
+	e.mu.Lock()
+	var retry []string
+	for typ, s := range e.subscriptions {
+		if s == "failed" || (e.socket && s == "authorization_revoked") {
+			retry = append(retry, typ)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, typ := range retry {
+		go e.resubscribe(typ)
+	}
+}
+
+// welcome makes the subscriptions for a new session, if subscribe has found
+// who they're for. Otherwise subscribe makes them.
+func (e *eventSub) welcome(sessionID string) {
+	e.mu.Lock()
+	e.sessionID = sessionID
+	ready := e.broadcasterID != ""
+	e.mu.Unlock()
+
+	log.Infof("eventsub: connected to websocket session %s", sessionID)
+	if !ready {
+		return
+	}
+
+	go func() {
+		if err := e.subscribeAll(); err != nil {
+			log.Errorf("unable to subscribe to events: %v", err)
+			e.notifications.send("EventSub subscription failed", err.Error())
+		}
+	}()
+}
+
+// dialSocket connects to the URL and sends everything read from it to reads,
+// until it's closed or stop is.
+func dialSocket(url string, reads chan<- socketRead, stop <-chan struct{}) (*websocket.Conn, error) {
+	if !strings.HasPrefix(url, "wss://") {
+		return nil, fmt.Errorf("dialSocket: refusing to connect to %q", url)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dialSocket: %w", err)
+	}
+
+	go func() {
+		for {
+			r := socketRead{conn: conn}
+			conn.SetReadDeadline(time.Now().Add(eventSubSocketTimeout))
+			if r.err = conn.ReadJSON(&r.message); r.err != nil {
+				conn.Close()
+			}
+
+			select {
+			case reads <- r:
+			case <-stop:
+				conn.Close()
+				return
+			}
+			if r.err != nil {
+				return
+			}
+		}
+	}()
+
+	return conn, nil
+}
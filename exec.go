@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+const (
+	// defaultExecTimeout is how long a program gets to finish if its
+	// command doesn't say.
+	defaultExecTimeout = 10 * time.Second
+	// defaultExecLimit is how many programs can run at once if the config
+	// doesn't say.
+	defaultExecLimit = 4
+	// maxExecOutput is how much of what a program prints is read.
+	maxExecOutput = 64 * 1024
+)
+
+// execCommand is a !command that runs a program and replies with what it
+// prints. The program gets the message as JSON on stdin, with the command's
+// arguments in args, and in BATYBOT_ environment variables. It doesn't get the
+// bot's environment, which has its secrets, only PATH and HOME.
+type execCommand struct {
+	Program string   `json:"program"`
+	Args    []string `json:"args"`
+	Timeout string   `json:"timeout"` // default 10s
+	ModOnly bool     `json:"mod_only"`
+}
+
+// execInput is what a program is given on stdin.
+type execInput struct {
+	pluginMessage
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+// execTracker is how many programs are running, to hold it to the config's
+// exec_limit.
+type execTracker struct {
+	sync.Mutex
+	n  int
+	wg sync.WaitGroup
+}
+
+// runExec runs the config's exec command in the message, if there is one,
+// reporting whether there was. The program runs in the background, and its
+// reply is sent when it finishes.
+func runExec(c *chatContext) bool {
+	name, args, ok := parseCommand(c.message.Message)
+	if !ok {
+		return false
+	}
+
+	cmd, ok := c.config.ExecCommands[name]
+	if !ok {
+		return false
+	} else if cmd.ModOnly && !c.privileged {
+		log.Debugf("%s tried to run mod command %s", c.message.User.Name, name)
+		return true
+	}
+
+	limit := c.config.ExecLimit
+	if limit <= 0 {
+		limit = defaultExecLimit
+	}
+
+	c.bot.execs.Lock()
+	full := c.bot.execs.n >= limit
+	if !full {
+		c.bot.execs.n++
+		c.bot.execs.wg.Add(1)
+	}
+	c.bot.execs.Unlock()
+
+	if full {
+		log.Warnf("not running !%s, too many programs are already running", name)
+		return true
+	}
+
+	input := execInput{pluginMessage: newPluginMessage(c), Command: name, Args: args}
+	go func() {
+		defer func() {
+			c.bot.execs.Lock()
+			c.bot.execs.n--
+			c.bot.execs.Unlock()
+			c.bot.execs.wg.Done()
+		}()
+
+		out, err := cmd.run(input)
+		if err != nil {
+			log.Errorf("!%s failed: %v", name, err)
+			return
+		} else if out == "" {
+			return
+		}
+
+		c.client.Reply(c.message.Channel, c.message.ID, out)
+	}()
+
+	return true
+}
+
+// wait waits for the programs that are running to finish.
+func (t *execTracker) wait() {
+	t.wg.Wait()
+}
+
+// run runs the program and returns its output on one line, cut to fit in a
+// chat message.
+func (e execCommand) run(input execInput) (string, error) {
+	timeout := defaultExecTimeout
+	if e.Timeout != "" {
+		if d, err := time.ParseDuration(e.Timeout); err == nil {
+			timeout = d
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("run: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Program, e.Args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &limitedWriter{w: &stdout, n: maxExecOutput}
+	cmd.Stderr = &limitedWriter{w: &stderr, n: maxExecOutput}
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + os.Getenv("HOME"),
+		"BATYBOT_COMMAND=" + input.Command,
+		"BATYBOT_ARGS=" + strings.Join(input.Args, " "),
+		"BATYBOT_CHANNEL=" + input.Channel,
+		"BATYBOT_MESSAGE_ID=" + input.ID,
+		"BATYBOT_USER=" + input.User,
+		"BATYBOT_USER_ID=" + input.UserID,
+		"BATYBOT_DISPLAY_NAME=" + input.DisplayName,
+		"BATYBOT_MESSAGE=" + input.Text,
+	}
+	// Don't wait on a child the program left holding its output.
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "", fmt.Errorf("run: %s timed out after %s", e.Program, timeout)
+	} else if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("run: %s: %w: %s", e.Program, err, msg)
+		}
+		return "", fmt.Errorf("run: %s: %w", e.Program, err)
+	}
+
+	out := strings.Join(strings.Fields(stdout.String()), " ")
+	if utf8.RuneCountInString(out) > chat.MaxMessageLength {
+		out = string([]rune(out)[:chat.MaxMessageLength-1]) + "…"
+	}
+
+	return out, nil
+}
+
+// limitedWriter writes up to n bytes to w and quietly drops the rest.
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n <= 0 {
+		return len(p), nil
+	}
+
+	b := p
+	if len(b) > l.n {
+		b = b[:l.n]
+	}
+	l.n -= len(b)
+	if _, err := l.w.Write(b); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// exportable is what `batybot export` can write, by the name -only takes.
+var exportable = []string{"commands", "emotes", "modlog", "points", "scripts"}
+
+// exportData is everything the bot keeps that's worth backing up or taking to
+// another bot.
+type exportData struct {
+	Commands map[string]string                 `json:"commands"` // custom commands, by name
+	Emotes   []emoteCount                      `json:"emotes"`
+	ModLog   []modAction                       `json:"modlog"`
+	Points   map[string]map[string]pointsEntry `json:"points"`  // by channel, then user ID
+	Scripts  map[string]interface{}            `json:"scripts"` // what scripts keep with bot.set, like counters
+}
+
+func collectExport(b *bot) exportData {
+	data := exportData{
+		Commands: b.custom.all(),
+		Emotes:   b.emotes.all(),
+		ModLog:   b.modlog.all(),
+		Points:   b.points.all(),
+		Scripts:  newScriptStore().all(),
+	}
+	if data.Emotes == nil {
+		data.Emotes = []emoteCount{}
+	}
+
+	return data
+}
+
+// exportAll writes everything to w as one JSON object, or with only, just that
+// part of it, either as JSON or CSV.
+func exportAll(b *bot, w io.Writer, format, only string) error {
+	if only == "" {
+		if format != "json" {
+			return fmt.Errorf("exportAll: %s needs -only to pick one of %v", format, exportable)
+		}
+
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(collectExport(b))
+	}
+
+	switch only {
+	case "commands":
+		return exportTable(w, format, b.custom.all(), []string{"name", "response"}, func(k string, v string) []string {
+			return []string{k, v}
+		})
+	case "emotes":
+		return b.emotes.export(w, format)
+	case "modlog":
+		return b.modlog.export(w, format)
+	case "points":
+		return b.points.export(w, format)
+	case "scripts":
+		return exportTable(w, format, newScriptStore().all(), []string{"key", "value"}, func(k string, v interface{}) []string {
+			value, _ := json.Marshal(v)
+			return []string{k, string(value)}
+		})
+	}
+
+	return fmt.Errorf("exportAll: unknown data %q, should be one of %v", only, exportable)
+}
+
+// exportTable writes m as a JSON object, or as CSV with a row for each key,
+// sorted.
+func exportTable[T any](w io.Writer, format string, m map[string]T, header []string, row func(string, T) []string) error {
+	switch format {
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(m)
+	case "csv":
+		c := csv.NewWriter(w)
+		c.Write(header)
+		for _, k := range sortedKeys(m) {
+			c.Write(row(k, m[k]))
+		}
+		c.Flush()
+		return c.Error()
+	}
+
+	return fmt.Errorf("exportTable: unknown format %q", format)
+}
@@ -0,0 +1,317 @@
+// Package fakeirc is a Twitch chat server that runs locally. It speaks enough
+// of Twitch's IRC, logging in, joining, PRIVMSG, USERNOTICE, and ROOMSTATE, to
+// run the bot's handlers end to end in integration tests or while developing
+// without touching Twitch.
+//
+// Point the bot at it with TWITCH_IRC_ADDRESS, then send it chat with Privmsg
+// and the like, and read what it says from Received.
+package fakeirc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is a fake Twitch chat server.
+type Server struct {
+	// Token is the PASS a client has to log in with, without the oauth:
+	// prefix. Any is accepted if it's empty.
+	Token string
+
+	listener net.Listener
+	received chan string
+
+	mu    sync.Mutex
+	conns map[*conn]bool
+	ids   map[string]string // user IDs by login
+}
+
+// conn is a client connected to the server.
+type conn struct {
+	net.Conn
+	w  *bufio.Writer
+	mu sync.Mutex
+
+	nick     string
+	channels map[string]bool
+}
+
+// Start listens on addr, like 127.0.0.1:0 for any free port.
+func Start(addr string) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("Start: unable to listen: %w", err)
+	}
+
+	s := &Server{
+		listener: l,
+		received: make(chan string, 100),
+		conns:    map[*conn]bool{},
+		ids:      map[string]string{},
+	}
+	go s.accept()
+
+	return s, nil
+}
+
+// Addr is the address the server's listening on.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and disconnects every client.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		c.Close()
+	}
+
+	return err
+}
+
+// Received returns the PRIVMSG lines clients send, like
+// "PRIVMSG #channel :hello", without tags, for checking what the bot said,
+// including replies.
+func (s *Server) Received() <-chan string {
+	return s.received
+}
+
+// Privmsg sends a chat message from user to everyone in the channel. tags
+// are added to, or replace, the ones Twitch would send, like badges.
+func (s *Server) Privmsg(channel, user, text string, tags map[string]string) {
+	t := s.userTags(channel, user)
+	t["id"] = randomID()
+	t["tmi-sent-ts"] = strconv.FormatInt(time.Now().UnixMilli(), 10)
+	merge(t, tags)
+
+	s.Send(channel, fmt.Sprintf("%s:%s!%s@%s.tmi.twitch.tv PRIVMSG #%s :%s", formatTags(t), user, user, user, channel, text))
+}
+
+// UserNotice sends a USERNOTICE, like a sub, raid, or announcement, where
+// msgID is its msg-id, such as sub, resub, or raid, and text is the message
+// the user added, if any.
+func (s *Server) UserNotice(channel, msgID, user, text string, tags map[string]string) {
+	t := s.userTags(channel, user)
+	t["id"] = randomID()
+	t["login"] = user
+	t["msg-id"] = msgID
+	t["tmi-sent-ts"] = strconv.FormatInt(time.Now().UnixMilli(), 10)
+	merge(t, tags)
+
+	line := fmt.Sprintf("%s:tmi.twitch.tv USERNOTICE #%s", formatTags(t), channel)
+	if text != "" {
+		line += " :" + text
+	}
+	s.Send(channel, line)
+}
+
+// RoomState sends the channel's chat settings, like slow or followers-only.
+func (s *Server) RoomState(channel string, tags map[string]string) {
+	t := map[string]string{"room-id": s.userID(channel)}
+	merge(t, tags)
+
+	s.Send(channel, fmt.Sprintf("%s:tmi.twitch.tv ROOMSTATE #%s", formatTags(t), channel))
+}
+
+// Send sends a raw line to every client in the channel, or every client if
+// channel is empty.
+func (s *Server) Send(channel, line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		if channel == "" || c.inChannel(channel) {
+			c.send(line)
+		}
+	}
+}
+
+func (s *Server) accept() {
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &conn{Conn: nc, w: bufio.NewWriter(nc), channels: map[string]bool{}}
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+
+		go s.serve(c)
+	}
+}
+
+func (s *Server) serve(c *conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	var pass string
+	r := bufio.NewScanner(c)
+	for r.Scan() {
+		// Tags, like the parent of a reply, aren't needed here.
+		line := r.Text()
+		if strings.HasPrefix(line, "@") {
+			_, line, _ = strings.Cut(line, " ")
+		}
+		command, params, _ := strings.Cut(line, " ")
+
+		switch command {
+		case "CAP":
+			c.send(":tmi.twitch.tv CAP * ACK :" + strings.TrimPrefix(params, "REQ :"))
+		case "PASS":
+			pass = strings.TrimPrefix(params, "oauth:")
+		case "NICK":
+			if s.Token != "" && pass != s.Token {
+				c.send(":tmi.twitch.tv NOTICE * :Login authentication failed")
+				return
+			}
+			c.login(params)
+		case "JOIN":
+			for _, channel := range strings.Split(params, ",") {
+				s.join(c, strings.TrimPrefix(channel, "#"))
+			}
+		case "PART":
+			channel := strings.TrimPrefix(params, "#")
+			c.mu.Lock()
+			delete(c.channels, channel)
+			c.mu.Unlock()
+			c.send(fmt.Sprintf(":%s!%s@%s.tmi.twitch.tv PART #%s", c.nick, c.nick, c.nick, channel))
+		case "PING":
+			c.send("PONG " + params)
+		case "PRIVMSG":
+			select {
+			case s.received <- line:
+			default:
+				// Nobody's reading, so drop it rather than stall the bot.
+			}
+		}
+	}
+}
+
+func (c *conn) login(nick string) {
+	c.nick = nick
+	for _, line := range []string{
+		":tmi.twitch.tv 001 %[1]s :Welcome, GLHF!",
+		":tmi.twitch.tv 002 %[1]s :Your host is tmi.twitch.tv",
+		":tmi.twitch.tv 003 %[1]s :This server is rather new",
+		":tmi.twitch.tv 004 %[1]s :-",
+		":tmi.twitch.tv 375 %[1]s :-",
+		":tmi.twitch.tv 372 %[1]s :You are in a maze of twisty passages, all alike.",
+		":tmi.twitch.tv 376 %[1]s :>",
+	} {
+		c.send(fmt.Sprintf(line, nick))
+	}
+	c.send(fmt.Sprintf("@badge-info=;badges=;color=;display-name=%[1]s;emote-sets=0;user-id=1 :tmi.twitch.tv GLOBALUSERSTATE", nick))
+}
+
+func (s *Server) join(c *conn, channel string) {
+	c.mu.Lock()
+	c.channels[channel] = true
+	c.mu.Unlock()
+
+	c.send(fmt.Sprintf(":%[1]s!%[1]s@%[1]s.tmi.twitch.tv JOIN #%[2]s", c.nick, channel))
+	c.send(fmt.Sprintf(":%[1]s.tmi.twitch.tv 353 %[1]s = #%[2]s :%[1]s", c.nick, channel))
+	c.send(fmt.Sprintf(":%[1]s.tmi.twitch.tv 366 %[1]s #%[2]s :End of /NAMES list", c.nick, channel))
+	c.send(fmt.Sprintf("@badge-info=;badges=moderator/1;color=;display-name=%[1]s;emote-sets=0;mod=1;subscriber=0;user-type=mod :tmi.twitch.tv USERSTATE #%[2]s", c.nick, channel))
+	c.send(fmt.Sprintf("@emote-only=0;followers-only=-1;r9k=0;room-id=%s;slow=0;subs-only=0 :tmi.twitch.tv ROOMSTATE #%s", s.userID(channel), channel))
+}
+
+func (c *conn) inChannel(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.channels[strings.TrimPrefix(channel, "#")]
+}
+
+func (c *conn) send(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.w.WriteString(line + "\r\n")
+	c.w.Flush()
+}
+
+// userID makes up an ID for the user that stays the same for as long as the
+// server's running.
+func (s *Server) userID(login string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.ids[login]
+	if !ok {
+		id = strconv.Itoa(1000 + len(s.ids))
+		s.ids[login] = id
+	}
+
+	return id
+}
+
+// userTags are the tags on a message from the user that don't change from
+// one message to the next.
+func (s *Server) userTags(channel, user string) map[string]string {
+	return map[string]string{
+		"badge-info":   "",
+		"badges":       "",
+		"color":        "",
+		"display-name": user,
+		"emotes":       "",
+		"mod":          "0",
+		"room-id":      s.userID(channel),
+		"subscriber":   "0",
+		"turbo":        "0",
+		"user-id":      s.userID(user),
+		"user-type":    "",
+	}
+}
+
+func merge(tags, extra map[string]string) {
+	for k, v := range extra {
+		tags[k] = v
+	}
+}
+
+var tagEscaper = strings.NewReplacer(`\`, `\\`, ";", `\:`, " ", `\s`, "\r", `\r`, "\n", `\n`)
+
+// formatTags formats the tags, sorted so lines are the same every time, with
+// the leading @ and trailing space.
+func formatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + tagEscaper.Replace(tags[k])
+	}
+
+	return "@" + strings.Join(parts, ";") + " "
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(errors.New("randomID: unable to read random bytes"))
+	}
+
+	return hex.EncodeToString(b)
+}
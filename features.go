@@ -0,0 +1,50 @@
+package main
+
+import "sync"
+
+// featureSet tracks which parts of the bot are switched on so they can be
+// turned off while it's running. Everything is on unless it's been disabled.
+type featureSet struct {
+	mu       sync.Mutex
+	disabled map[string]bool
+}
+
+// The features that can be toggled.
+const (
+	featureTriggers = "triggers" // emote responses such as BatJAM
+	featureMention  = "mention"  // responding to being mentioned
+	featureSounds   = "sounds"   // sounds and speech on the alert overlay
+	featureFun      = "fun"      // fun commands like !8ball and !roll
+)
+
+func isFeature(name string) bool {
+	switch name {
+	case featureTriggers, featureMention, featureSounds, featureFun:
+		return true
+	}
+
+	return false
+}
+
+func (f *featureSet) enabled(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return !f.disabled[name]
+}
+
+func (f *featureSet) set(name string, on bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.disabled[name] = !on
+}
+
+// toggle flips the feature and returns whether it's now on.
+func (f *featureSet) toggle(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.disabled[name] = !f.disabled[name]
+	return !f.disabled[name]
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// envFlags is a flag that can be given more than once to set environment
+// variables, which is how the bot is configured, so any setting can be
+// overridden when it's started.
+type envFlags map[string]string
+
+func (e envFlags) String() string {
+	var s []string
+	for key, value := range e {
+		s = append(s, key+"="+value)
+	}
+	sort.Strings(s)
+
+	return strings.Join(s, " ")
+}
+
+func (e envFlags) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return errors.New("expected KEY=value")
+	}
+
+	e[key] = value
+	return nil
+}
+
+// apply sets the variables, taking precedence over the environment and
+// .env.
+func (e envFlags) apply() error {
+	for key, value := range e {
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("apply: unable to set %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+)
+
+// eightBallAnswers are the classic magic 8 ball's answers, used unless the
+// config has its own.
+var eightBallAnswers = []string{
+	"It is certain.",
+	"It is decidedly so.",
+	"Without a doubt.",
+	"Yes, definitely.",
+	"You may rely on it.",
+	"As I see it, yes.",
+	"Most likely.",
+	"Outlook good.",
+	"Yes.",
+	"Signs point to yes.",
+	"Reply hazy, try again.",
+	"Ask again later.",
+	"Better not tell you now.",
+	"Cannot predict now.",
+	"Concentrate and ask again.",
+	"Don't count on it.",
+	"My reply is no.",
+	"My sources say no.",
+	"Outlook not so good.",
+	"Very doubtful.",
+}
+
+// fun is the config for the fun commands, like !8ball and !roll. They can also
+// be switched off while the bot's running as the fun feature.
+type fun struct {
+	Enabled   bool      `json:"enabled"`
+	EightBall eightBall `json:"8ball"`
+}
+
+// eightBall is the config for !8ball. Answers and the message can use {user}
+// and {question}, and the message {answer} too.
+type eightBall struct {
+	Answers []string `json:"answers"` // default the classic 20
+	Message string   `json:"message"` // default {answer}
+}
+
+func (e eightBall) answer(user, question string) string {
+	answers := e.Answers
+	if len(answers) == 0 {
+		answers = eightBallAnswers
+	}
+
+	message := e.Message
+	if message == "" {
+		message = "{answer}"
+	}
+
+	vars := map[string]string{"{user}": user, "{question}": question}
+	vars["{answer}"] = replaceVars(answers[rand.Intn(len(answers))], vars)
+
+	return replaceVars(message, vars)
+}
+
+// runFun runs the fun commands, reporting whether the message was one.
+func runFun(c *chatContext) bool {
+	if !c.config.Fun.Enabled || !c.config.active(featureFun, c.bot.features, c.bot.live) {
+		return false
+	}
+
+	name, args, ok := parseCommand(c.message.Message)
+	if !ok {
+		return false
+	}
+
+	switch name {
+	case "8ball":
+		question := strings.Join(args, " ")
+		if question == "" {
+			c.client.Reply(c.message.Channel, c.message.ID, "Ask the magic 8 ball a question, like !8ball will it rain?")
+			return true
+		}
+		c.client.Reply(c.message.Channel, c.message.ID, c.config.Fun.EightBall.answer(c.message.User.DisplayName, question))
+	case "roll":
+		rollCommand(c, args)
+	case "choose", "pick":
+		chooseCommand(c, args)
+	default:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gempir/go-twitch-irc/v4"
+)
+
+// giveawayWeights is how many entries chatters get in a giveaway. Subs get
+// their tier's, which is the tier below's unless it's set, and gifters get
+// per_gift more for each sub their badge shows they've gifted, up to
+// max_gift_bonus if it's set.
+type giveawayWeights struct {
+	Everyone     float64 `json:"everyone"` // default 1
+	Tier1        float64 `json:"tier1"`
+	Tier2        float64 `json:"tier2"`
+	Tier3        float64 `json:"tier3"`
+	PerGift      float64 `json:"per_gift"`
+	MaxGiftBonus float64 `json:"max_gift_bonus"`
+}
+
+// giveaway is the config for !giveaway.
+type giveaway struct {
+	Weights giveawayWeights `json:"weights"`
+}
+
+func (w giveawayWeights) everyone() float64 {
+	if w.Everyone <= 0 {
+		return 1
+	}
+
+	return w.Everyone
+}
+
+func (w giveawayWeights) tier(tier int) float64 {
+	weight := 0.0
+	switch tier {
+	case 0:
+		return w.everyone()
+	case 1:
+		weight = w.Tier1
+	case 2:
+		weight = w.Tier2
+	case 3:
+		weight = w.Tier3
+	}
+	if weight <= 0 {
+		return w.tier(tier - 1)
+	}
+
+	return weight
+}
+
+// weight returns how many entries the user gets.
+func (w giveawayWeights) weight(user twitch.User) float64 {
+	bonus := w.PerGift * float64(user.Badges["sub-gifter"])
+	if w.MaxGiftBonus > 0 && bonus > w.MaxGiftBonus {
+		bonus = w.MaxGiftBonus
+	}
+
+	return w.tier(subTier(user)) + bonus
+}
+
+// String describes the weights for chat, so everyone knows their odds.
+func (w giveawayWeights) String() string {
+	s := fmt.Sprintf("everyone %s, tier 1 subs %s, tier 2 %s, tier 3 %s",
+		formatWeight(w.everyone()), formatWeight(w.tier(1)), formatWeight(w.tier(2)), formatWeight(w.tier(3)))
+	if w.PerGift > 0 {
+		s += fmt.Sprintf(", plus %s a gifted sub", formatWeight(w.PerGift))
+		if w.MaxGiftBonus > 0 {
+			s += fmt.Sprintf(" up to %s", formatWeight(w.MaxGiftBonus))
+		}
+	}
+
+	return s
+}
+
+func formatWeight(weight float64) string {
+	return strconv.FormatFloat(weight, 'f', -1, 64)
+}
+
+// subTier returns the tier of the user's sub, or 0 if they aren't subbed. The
+// subscriber badge's version is 2000 and up for tier 2, and 3000 and up for
+// tier 3.
+func subTier(user twitch.User) int {
+	v, ok := user.Badges["subscriber"]
+	switch {
+	case ok && v >= 3000:
+		return 3
+	case ok && v >= 2000:
+		return 2
+	case ok || user.Badges["founder"] > 0:
+		return 1
+	}
+
+	return 0
+}
+
+// giveawayEntry is someone who entered a giveaway.
+type giveawayEntry struct {
+	user   string
+	weight float64
+}
+
+// giveawayDraw is a channel's giveaway.
+type giveawayDraw struct {
+	prize   string
+	open    bool
+	entries map[string]giveawayEntry // by user ID
+	order   []string                 // user IDs in the order they entered
+}
+
+func (d *giveawayDraw) total() float64 {
+	total := 0.0
+	for _, e := range d.entries {
+		total += e.weight
+	}
+
+	return total
+}
+
+// pick returns a random entrant, more likely the more entries they have, and
+// takes them out so the next draw picks someone else.
+func (d *giveawayDraw) pick(r float64) (giveawayEntry, float64, bool) {
+	total := d.total()
+	if total <= 0 {
+		return giveawayEntry{}, 0, false
+	}
+
+	at := r * total
+	for i, id := range d.order {
+		e := d.entries[id]
+		at -= e.weight
+		if at < 0 || i == len(d.order)-1 {
+			delete(d.entries, id)
+			d.order = append(d.order[:i:i], d.order[i+1:]...)
+			return e, total, true
+		}
+	}
+
+	return giveawayEntry{}, 0, false
+}
+
+// giveawayTracker holds the giveaway in each channel.
+type giveawayTracker struct {
+	mu    sync.Mutex
+	draws map[string]*giveawayDraw // by channel
+}
+
+func (t *giveawayTracker) open(channel, prize string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.draws[channel] = &giveawayDraw{prize: prize, open: true, entries: map[string]giveawayEntry{}}
+}
+
+// close stops entries, reporting whether there was a giveaway to close.
+func (t *giveawayTracker) close(channel string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.draws[channel]
+	if d == nil || !d.open {
+		return false
+	}
+	d.open = false
+
+	return true
+}
+
+// cancel ends the giveaway without a winner, reporting whether there was one.
+func (t *giveawayTracker) cancel(channel string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.draws[channel]
+	delete(t.draws, channel)
+
+	return ok
+}
+
+// enter adds the user, reporting whether the giveaway's open. Entering again
+// updates their weight, in case they subbed since.
+func (t *giveawayTracker) enter(channel string, user twitch.User, weight float64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.draws[channel]
+	if d == nil || !d.open {
+		return false
+	}
+
+	if _, ok := d.entries[user.ID]; !ok {
+		d.order = append(d.order, user.ID)
+	}
+	d.entries[user.ID] = giveawayEntry{user: user.DisplayName, weight: weight}
+
+	return true
+}
+
+// draw closes the giveaway and picks a winner, returning the prize, the
+// winner, and the total entries they were drawn from.
+func (t *giveawayTracker) draw(channel string) (string, giveawayEntry, float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.draws[channel]
+	if d == nil {
+		return "", giveawayEntry{}, 0, false
+	}
+	d.open = false
+
+	winner, total, ok := d.pick(rand.Float64())
+
+	return d.prize, winner, total, ok
+}
+
+// odds returns the user's entries and the total, and whether they entered.
+func (t *giveawayTracker) odds(channel, userID string) (float64, float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.draws[channel]
+	if d == nil {
+		return 0, 0, false
+	}
+
+	e, ok := d.entries[userID]
+
+	return e.weight, d.total(), ok
+}
+
+// status describes the channel's giveaway, if there is one.
+func (t *giveawayTracker) status(channel string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.draws[channel]
+	if d == nil {
+		return "", false
+	}
+
+	state := "closed"
+	if d.open {
+		state = "open, !enter to join"
+	}
+
+	return fmt.Sprintf("The giveaway for %s is %s. %d entered with %s entries", d.prize, state, len(d.entries), formatWeight(d.total())), true
+}
+
+// runGiveaway runs !enter, !odds, and !giveaway. Mods run the giveaway with
+// !giveaway open prize, !giveaway close, !giveaway draw, which can be run
+// again to draw someone else, and !giveaway cancel.
+func runGiveaway(c *chatContext) bool {
+	name, args, ok := parseCommand(c.message.Message)
+	if !ok {
+		return false
+	}
+
+	weights := c.config.Giveaway.Weights
+	channel := c.message.Channel
+	reply := func(text string) { c.client.Reply(channel, c.message.ID, text) }
+
+	switch name {
+	case "enter":
+		// Quietly, so a busy giveaway doesn't flood chat.
+		if !c.bot.giveaways.enter(channel, c.message.User, weights.weight(c.message.User)) {
+			reply("There's no giveaway open")
+		}
+		return true
+	case "odds":
+		weight, total, ok := c.bot.giveaways.odds(channel, c.message.User.ID)
+		if !ok {
+			reply("You haven't entered a giveaway, entries are " + weights.String())
+			return true
+		}
+		reply(fmt.Sprintf("You have %s of %s entries, a %.1f%% chance", formatWeight(weight), formatWeight(total), 100*weight/total))
+		return true
+	case "giveaway":
+	default:
+		return false
+	}
+
+	if len(args) == 0 {
+		status, ok := c.bot.giveaways.status(channel)
+		if !ok {
+			status = "There's no giveaway"
+		}
+		reply(status + ". Entries are " + weights.String())
+		return true
+	}
+
+	if !c.privileged {
+		log.Debugf("%s tried to run mod command giveaway %s", c.message.User.Name, args[0])
+		return true
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "open":
+		prize := strings.Join(args[1:], " ")
+		if prize == "" {
+			prize = "a prize"
+		}
+		c.bot.giveaways.open(channel, prize)
+		c.client.Say(channel, fmt.Sprintf("A giveaway for %s is open, type !enter to join! Entries are %s", prize, weights))
+	case "close":
+		if !c.bot.giveaways.close(channel) {
+			reply("There's no giveaway open")
+			return true
+		}
+		status, _ := c.bot.giveaways.status(channel)
+		c.client.Say(channel, status)
+	case "draw":
+		prize, winner, total, ok := c.bot.giveaways.draw(channel)
+		if !ok {
+			reply("There's no one to draw")
+			return true
+		}
+		c.client.Say(channel, fmt.Sprintf("@%s won %s! They had %s of %s entries, a %.1f%% chance",
+			winner.user, prize, formatWeight(winner.weight), formatWeight(total), 100*winner.weight/total))
+	case "cancel":
+		if !c.bot.giveaways.cancel(channel) {
+			reply("There's no giveaway")
+			return true
+		}
+		reply("Giveaway cancelled")
+	default:
+		reply("Usage: !giveaway [open prize|close|draw|cancel]")
+	}
+
+	return true
+}
@@ -3,12 +3,31 @@ module github.com/losinggeneration/batybot
 go 1.20
 
 require (
+	github.com/bwmarrin/discordgo v0.29.0
+	github.com/eclipse/paho.mqtt.golang v1.5.0
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/gempir/go-twitch-irc/v4 v4.0.0
-	github.com/nicklaw5/helix/v2 v2.22.0
+	github.com/getsentry/sentry-go v0.29.1
+	github.com/gorilla/websocket v1.5.3
+	github.com/joho/godotenv v1.5.1
+	github.com/nicklaw5/helix/v2 v2.30.0
 	github.com/sirupsen/logrus v1.9.0
+	github.com/tetratelabs/wazero v1.7.3
+	github.com/yuin/gopher-lua v1.1.1
+	github.com/zalando/go-keyring v0.2.6
+	golang.org/x/crypto v0.25.0
+	google.golang.org/grpc v1.64.1
+	google.golang.org/protobuf v1.34.2
 )
 
 require (
+	al.essio.dev/pkg/shellescape v1.5.1 // indirect
+	github.com/danieljoos/wincred v1.2.2 // indirect
+	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/golang-jwt/jwt/v4 v4.0.0 // indirect
-	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+	golang.org/x/net v0.27.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
 )
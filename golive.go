@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// goLive is where, besides Discord, the stream going live is posted. In the
+// messages {channel}, {title}, {category}, and {url} are replaced from the
+// stream.
+type goLive struct {
+	Cooldown string       `json:"cooldown,omitempty"` // restarts within this long aren't posted again, 1h if unset
+	Mastodon mastodonPost `json:"mastodon"`
+	Bluesky  blueskyPost  `json:"bluesky"`
+}
+
+// goLivePosts is when the go live posts were last made, so a restart of the
+// stream doesn't post them again.
+type goLivePosts struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func streamURL(stream helix.Stream) string {
+	return "https://twitch.tv/" + stream.UserLogin
+}
+
+func streamReplacer(stream helix.Stream) *strings.Replacer {
+	return strings.NewReplacer(
+		"{channel}", stream.UserName,
+		"{title}", stream.Title,
+		"{category}", stream.GameName,
+		"{url}", streamURL(stream),
+	)
+}
+
+// onStreamOnline returns an EventSub handler that posts that the stream is
+// live everywhere it's configured to be, unless it was already posted
+// recently and this is just a restart.
+func onStreamOnline(b *bot) func(json.RawMessage) {
+	return func(raw json.RawMessage) {
+		postGoLive(b.api, b.goLive, b.config.get(), raw)
+	}
+}
+
+func postGoLive(api *twitchAPI, posts *goLivePosts, c config, raw json.RawMessage) {
+	discord, mastodon, bluesky := len(c.Discord.GoLive.Webhooks) > 0, c.GoLive.Mastodon.enabled(), c.GoLive.Bluesky.enabled()
+	if !discord && !mastodon && !bluesky {
+		return
+	}
+
+	var online helix.EventSubStreamOnlineEvent
+	if err := json.Unmarshal(raw, &online); err != nil {
+		log.Errorf("invalid stream online event: %v", err)
+		return
+	}
+
+	cooldown := time.Hour
+	if c.GoLive.Cooldown != "" {
+		var err error
+		if cooldown, err = time.ParseDuration(c.GoLive.Cooldown); err != nil {
+			log.Errorf("invalid go live cooldown: %v", err)
+			cooldown = time.Hour
+		}
+	}
+
+	posts.mu.Lock()
+	if time.Since(posts.last) < cooldown {
+		posts.mu.Unlock()
+		log.Infof("not posting go live for %s, it was posted %v ago", online.BroadcasterUserLogin, time.Since(posts.last).Round(time.Second))
+		return
+	}
+	posts.last = time.Now()
+	posts.mu.Unlock()
+
+	// Helix can take a little while to show a stream that just started.
+	var stream helix.Stream
+	var err error
+	for i := 0; i < 6; i++ {
+		if stream, err = api.stream(online.BroadcasterUserID); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Second)
+	}
+	if err != nil {
+		log.Errorf("unable to get stream for go live post: %v", err)
+		stream = helix.Stream{UserLogin: online.BroadcasterUserLogin, UserName: online.BroadcasterUserName}
+	}
+
+	if discord {
+		message := c.Discord.GoLive.message(stream)
+		for _, webhook := range c.Discord.GoLive.Webhooks {
+			if err := postDiscord(webhook, message); err != nil {
+				log.Errorf("unable to post go live: %v", err)
+			}
+		}
+	}
+
+	if mastodon {
+		if err := c.GoLive.Mastodon.post(stream); err != nil {
+			log.Errorf("unable to post go live: %v", err)
+		}
+	}
+
+	if bluesky {
+		if err := c.GoLive.Bluesky.post(stream); err != nil {
+			log.Errorf("unable to post go live: %v", err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative batybotpb/batybot.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/losinggeneration/batybot/batybotpb"
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// grpcServer serves the same things as the control API and event stream over
+// gRPC, see batybotpb/batybot.proto. Every call needs the API_TOKEN as a
+// bearer token in the authorization metadata.
+type grpcServer struct {
+	batybotpb.UnimplementedBatybotServer
+
+	addr     string
+	token    string
+	client   *chat.Client
+	status   *botStatus
+	features *featureSet
+	bus      *eventBus
+	server   *grpc.Server
+}
+
+func newGRPCServer(addr, token string, client *chat.Client, b *bot) *grpcServer {
+	s := &grpcServer{addr: addr, token: token, client: client, status: b.status, features: b.features, bus: b.bus}
+	s.server = grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := s.authorize(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := s.authorize(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	batybotpb.RegisterBatybotServer(s.server, s)
+
+	return s
+}
+
+func (s *grpcServer) Start() error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("unable to start grpc server: %w", err)
+	}
+
+	if err := s.server.Serve(l); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return fmt.Errorf("unable to start grpc server: %w", err)
+	}
+
+	return nil
+}
+
+// Shutdown waits for calls to finish, cutting off any still running, like
+// event streams, when ctx is done.
+func (s *grpcServer) Shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.server.Stop()
+	}
+
+	return nil
+}
+
+func (s *grpcServer) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+			return nil
+		}
+	}
+
+	return grpcstatus.Error(codes.Unauthenticated, "invalid token")
+}
+
+func (s *grpcServer) SendMessage(ctx context.Context, req *batybotpb.SendMessageRequest) (*batybotpb.SendMessageResponse, error) {
+	if req.Channel == "" || req.Message == "" {
+		return nil, grpcstatus.Error(codes.InvalidArgument, "expected a channel and message")
+	}
+
+	s.client.Say(strings.ToLower(req.Channel), req.Message)
+
+	return &batybotpb.SendMessageResponse{}, nil
+}
+
+func (s *grpcServer) GetStatus(ctx context.Context, req *batybotpb.GetStatusRequest) (*batybotpb.Status, error) {
+	r := s.status.report(s.client, s.features)
+
+	st := &batybotpb.Status{
+		Started:    timestamppb.New(r.Started),
+		Uptime:     r.Uptime,
+		Connected:  r.Connected,
+		Channels:   r.Channels,
+		QueueDepth: int32(r.QueueDepth),
+		Features:   r.Features,
+	}
+	if !r.TokenExpires.IsZero() {
+		st.TokenExpires = timestamppb.New(r.TokenExpires)
+	}
+
+	return st, nil
+}
+
+func (s *grpcServer) SubscribeEvents(req *batybotpb.SubscribeEventsRequest, stream batybotpb.Batybot_SubscribeEventsServer) error {
+	types := map[string]bool{}
+	for _, typ := range req.Types {
+		types[typ] = true
+	}
+
+	events, unsubscribe := s.bus.subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			if len(types) > 0 && !types[e.Type] {
+				continue
+			}
+
+			err := stream.Send(&batybotpb.Event{
+				Type:    e.Type,
+				Channel: e.Channel,
+				User:    e.User,
+				UserId:  e.UserID,
+				Message: e.Message,
+				Amount:  int32(e.Amount),
+				Tier:    e.Tier,
+				Time:    timestamppb.New(e.Time),
+			})
+			if err != nil {
+				log.Debugf("unable to send event over grpc: %v", err)
+				return err
+			}
+		}
+	}
+}
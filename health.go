@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// newHealthServer serves probes for container orchestrators. /healthz succeeds as
+// long as the bot is running, and /readyz only when it's connected to chat,
+// its token is valid, and EventSub, if enabled, is subscribed, along with each
+// subscription's status and when the last notification came in. /metrics has
+// chat's mood and how many messages are waiting to be sent for Prometheus.
+// client is nil in app only mode, and events when EventSub isn't enabled.
+func newHealthServer(addr string, status *botStatus, client *chat.Client, events *eventSub, mood *moodTracker) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		resp := map[string]interface{}{"status": "ok"}
+		if events != nil {
+			resp["eventsub"] = events.report()
+		}
+
+		if problems := status.problems(); len(problems) > 0 {
+			resp["status"], resp["problems"] = "unavailable", problems
+			writeJSON(w, http.StatusServiceUnavailable, resp)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	})
+
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		metrics(w, client, mood)
+	})
+
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+// metrics writes the bot's metrics in Prometheus's text format.
+func metrics(w http.ResponseWriter, client *chat.Client, mood *moodTracker) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	moods := mood.all(time.Now())
+
+	fmt.Fprintln(w, "# HELP batybot_chat_mood How positive chat's been recently, from -1 to 1.")
+	fmt.Fprintln(w, "# TYPE batybot_chat_mood gauge")
+	for _, m := range moods {
+		fmt.Fprintf(w, "batybot_chat_mood{channel=%s} %s\n", strconv.Quote(m.Channel), strconv.FormatFloat(m.Mood, 'f', -1, 64))
+	}
+
+	fmt.Fprintln(w, "# HELP batybot_chat_mood_messages Recent messages chat's mood is from.")
+	fmt.Fprintln(w, "# TYPE batybot_chat_mood_messages gauge")
+	for _, m := range moods {
+		fmt.Fprintf(w, "batybot_chat_mood_messages{channel=%s} %d\n", strconv.Quote(m.Channel), m.Messages)
+	}
+
+	if client == nil {
+		return
+	}
+
+	depths := client.QueueDepths()
+	fmt.Fprintln(w, "# HELP batybot_chat_queue_depth Messages waiting to be sent to chat, by priority.")
+	fmt.Fprintln(w, "# TYPE batybot_chat_queue_depth gauge")
+	for p := chat.PriorityLow; p <= chat.PriorityHigh; p++ {
+		fmt.Fprintf(w, "batybot_chat_queue_depth{priority=%s} %d\n", strconv.Quote(p.String()), depths[p])
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+)
+
+// chatHistory is a ring buffer of the most recent chat messages so mods can
+// act on messages after they've been sent.
+type chatHistory struct {
+	mu       sync.Mutex
+	messages []twitch.PrivateMessage
+	next     int
+}
+
+func newChatHistory(size int) *chatHistory {
+	return &chatHistory{messages: make([]twitch.PrivateMessage, 0, size)}
+}
+
+func (h *chatHistory) add(message twitch.PrivateMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.messages) < cap(h.messages) {
+		h.messages = append(h.messages, message)
+		return
+	}
+
+	h.messages[h.next] = message
+	h.next = (h.next + 1) % len(h.messages)
+}
+
+// purge removes the user's messages, returning how many there were.
+func (h *chatHistory) purge(userID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	kept := make([]twitch.PrivateMessage, 0, cap(h.messages))
+	for i := range h.messages {
+		message := h.messages[(h.next+i)%len(h.messages)]
+		if message.User.ID != userID {
+			kept = append(kept, message)
+		}
+	}
+	purged := len(h.messages) - len(kept)
+
+	h.messages = kept
+	h.next = 0
+
+	return purged
+}
+
+// since returns the messages in the channel sent after t that match, oldest
+// first.
+func (h *chatHistory) since(channel string, t time.Time, match func(twitch.PrivateMessage) bool) []twitch.PrivateMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var found []twitch.PrivateMessage
+	for i := range h.messages {
+		message := h.messages[(h.next+i)%len(h.messages)]
+		if message.Channel != channel || message.Time.Before(t) {
+			continue
+		}
+
+		if match(message) {
+			found = append(found, message)
+		}
+	}
+
+	return found
+}
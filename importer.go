@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// importedCommand is a command read from another bot's export.
+type importedCommand struct {
+	name     string
+	response string
+	modOnly  bool // batybot's custom commands can be run by anyone
+}
+
+// importedTimer is a timer read from another bot's export.
+type importedTimer struct {
+	name    string
+	message string
+	every   int // minutes
+}
+
+// botImporter reads another bot's command and timer exports, which are what
+// its API returns for them.
+type botImporter struct {
+	commands func([]byte) ([]importedCommand, error)
+	timers   func([]byte) ([]importedTimer, error)
+}
+
+var importers = map[string]botImporter{
+	"nightbot":       {commands: nightbotCommands, timers: nightbotTimers},
+	"streamelements": {commands: streamElementsCommands, timers: streamElementsTimers},
+}
+
+// nightbotVars are the Nightbot variables with a batybot equivalent. $(touser)
+// is whoever's named after the command, which is close enough to {query}.
+var nightbotVars = strings.NewReplacer(
+	"$(user)", "{user}",
+	"$(query)", "{query}",
+	"$(touser)", "{query}",
+)
+
+// nightbotCommands reads the response of Nightbot's GET /1/commands.
+func nightbotCommands(b []byte) ([]importedCommand, error) {
+	var export struct {
+		Commands []struct {
+			Name      string `json:"name"`
+			Message   string `json:"message"`
+			UserLevel string `json:"userLevel"`
+		} `json:"commands"`
+	}
+	if err := json.Unmarshal(b, &export); err != nil {
+		return nil, fmt.Errorf("nightbotCommands: %w", err)
+	}
+
+	commands := make([]importedCommand, 0, len(export.Commands))
+	for _, c := range export.Commands {
+		commands = append(commands, importedCommand{
+			name:     c.Name,
+			response: nightbotVars.Replace(c.Message),
+			modOnly:  c.UserLevel != "" && c.UserLevel != "everyone",
+		})
+	}
+
+	return commands, nil
+}
+
+// nightbotInterval matches the every N minutes cron intervals Nightbot's
+// timers use.
+var nightbotInterval = regexp.MustCompile(`^\*/(\d+) \* \* \* \*$`)
+
+// nightbotTimers reads the response of Nightbot's GET /1/timers.
+func nightbotTimers(b []byte) ([]importedTimer, error) {
+	var export struct {
+		Timers []struct {
+			Name     string `json:"name"`
+			Message  string `json:"message"`
+			Interval string `json:"interval"`
+			Enabled  bool   `json:"enabled"`
+		} `json:"timers"`
+	}
+	if err := json.Unmarshal(b, &export); err != nil {
+		return nil, fmt.Errorf("nightbotTimers: %w", err)
+	}
+
+	var timers []importedTimer
+	for _, t := range export.Timers {
+		if !t.Enabled {
+			continue
+		}
+
+		every := 60
+		if m := nightbotInterval.FindStringSubmatch(t.Interval); m != nil {
+			every, _ = strconv.Atoi(m[1])
+		} else {
+			log.Warnf("timer %s runs on %q, which is imported as hourly", t.Name, t.Interval)
+		}
+
+		timers = append(timers, importedTimer{name: t.Name, message: nightbotVars.Replace(t.Message), every: every})
+	}
+
+	return timers, nil
+}
+
+// streamElementsVars are the StreamElements variables with a batybot
+// equivalent.
+var streamElementsVars = strings.NewReplacer(
+	"${user}", "{user}",
+	"${sender}", "{user}",
+	"${1:}", "{query}",
+	"${touser}", "{query}",
+)
+
+// streamElementsURLFetch matches ${urlfetch url}, which is $(urlfetch url) in
+// batybot.
+var streamElementsURLFetch = regexp.MustCompile(`\$\{urlfetch ([^}]+)\}`)
+
+func streamElementsReplace(s string) string {
+	return streamElementsVars.Replace(streamElementsURLFetch.ReplaceAllString(s, "$$(urlfetch $1)"))
+}
+
+// streamElementsCommands reads the response of StreamElements' GET
+// /kappa/v2/bot/commands/{channel}.
+func streamElementsCommands(b []byte) ([]importedCommand, error) {
+	var export []struct {
+		Command     string `json:"command"`
+		Reply       string `json:"reply"`
+		Enabled     bool   `json:"enabled"`
+		AccessLevel int    `json:"accessLevel"`
+	}
+	if err := json.Unmarshal(b, &export); err != nil {
+		return nil, fmt.Errorf("streamElementsCommands: %w", err)
+	}
+
+	commands := make([]importedCommand, 0, len(export))
+	for _, c := range export {
+		if !c.Enabled {
+			continue
+		}
+
+		commands = append(commands, importedCommand{
+			name:     c.Command,
+			response: streamElementsReplace(c.Reply),
+			modOnly:  c.AccessLevel > 100, // 100 is everyone
+		})
+	}
+
+	return commands, nil
+}
+
+// streamElementsTimers reads the response of StreamElements' GET
+// /kappa/v2/bot/timers/{channel}. A timer with more than one message is
+// imported as one timer for each.
+func streamElementsTimers(b []byte) ([]importedTimer, error) {
+	var export []struct {
+		Name     string   `json:"name"`
+		Messages []string `json:"messages"`
+		Enabled  bool     `json:"enabled"`
+		Online   struct {
+			Enabled  bool `json:"enabled"`
+			Interval int  `json:"interval"`
+		} `json:"online"`
+		Offline struct {
+			Enabled  bool `json:"enabled"`
+			Interval int  `json:"interval"`
+		} `json:"offline"`
+	}
+	if err := json.Unmarshal(b, &export); err != nil {
+		return nil, fmt.Errorf("streamElementsTimers: %w", err)
+	}
+
+	var timers []importedTimer
+	for _, t := range export {
+		if !t.Enabled {
+			continue
+		}
+
+		every := t.Offline.Interval
+		if !t.Offline.Enabled || every <= 0 {
+			every = t.Online.Interval
+		}
+		if every <= 0 {
+			every = 60
+		}
+
+		for _, message := range t.Messages {
+			timers = append(timers, importedTimer{name: t.Name, message: streamElementsReplace(message), every: every})
+		}
+	}
+
+	return timers, nil
+}
+
+// importedVar matches the variables in another bot's responses.
+var importedVar = regexp.MustCompile(`\$\([^)]*\)|\$\{[^}]*\}`)
+
+// unsupportedVar returns the first variable left in a converted response that
+// batybot won't replace, if there is one.
+func unsupportedVar(response string) string {
+	for _, v := range importedVar.FindAllString(response, -1) {
+		if !strings.HasPrefix(v, "$(urlfetch ") {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// importCommands adds the commands to the custom commands, skipping ones that
+// already exist unless overwrite, and returns how many were added.
+func importCommands(custom *customCommands, commands []importedCommand, overwrite bool) int {
+	existing := map[string]bool{}
+	for _, name := range custom.names() {
+		existing[name] = true
+	}
+
+	imported := 0
+	for _, c := range commands {
+		name := strings.ToLower(strings.TrimPrefix(c.name, "!"))
+		if existing[name] && !overwrite {
+			log.Warnf("skipping %s, which already exists", name)
+			continue
+		}
+
+		if err := custom.set(name, c.response); err != nil {
+			log.Warnf("skipping %s: %v", name, err)
+			continue
+		}
+		imported++
+
+		if c.modOnly {
+			log.Warnf("%s was limited to some users, but custom commands can be run by anyone", name)
+		}
+		if v := unsupportedVar(c.response); v != "" {
+			log.Warnf("%s uses %s, which batybot doesn't replace", name, v)
+		}
+	}
+
+	return imported
+}
+
+// importTimers prints the timers as the config's offline.timers, which are
+// the only timers batybot has, so they can be added to the config file by
+// hand.
+func importTimers(timers []importedTimer) error {
+	type timer struct {
+		Message string `json:"message"`
+		Every   string `json:"every"`
+	}
+
+	var conf struct {
+		Offline struct {
+			Timers []timer `json:"timers"`
+		} `json:"offline"`
+	}
+	for _, t := range timers {
+		conf.Offline.Timers = append(conf.Offline.Timers, timer{Message: t.message, Every: fmt.Sprintf("%dm", t.every)})
+		if v := unsupportedVar(t.message); v != "" {
+			log.Warnf("timer %s uses %s, which batybot doesn't replace", t.name, v)
+		}
+	}
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	if err := e.Encode(conf); err != nil {
+		return fmt.Errorf("importTimers: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImportCommands(t *testing.T) {
+	tests := []struct {
+		name    string
+		read    func([]byte) ([]importedCommand, error)
+		export  string
+		want    []importedCommand
+		wantErr bool
+	}{
+		{
+			name: "nightbot",
+			read: nightbotCommands,
+			export: `{"commands": [
+				{"name": "!hi", "message": "Hi $(user), $(touser) $(query)", "userLevel": "everyone"},
+				{"name": "!modonly", "message": "$(count)", "userLevel": "moderator"}
+			]}`,
+			want: []importedCommand{
+				{name: "!hi", response: "Hi {user}, {query} {query}"},
+				{name: "!modonly", response: "$(count)", modOnly: true},
+			},
+		},
+		{
+			name: "streamelements",
+			read: streamElementsCommands,
+			export: `[
+				{"command": "hi", "reply": "Hi ${user} ${1:} ${urlfetch https://example.com/}", "enabled": true, "accessLevel": 100},
+				{"command": "off", "reply": "off", "enabled": false, "accessLevel": 100},
+				{"command": "mods", "reply": "${sender}", "enabled": true, "accessLevel": 500}
+			]`,
+			want: []importedCommand{
+				{name: "hi", response: "Hi {user} {query} $(urlfetch https://example.com/)"},
+				{name: "mods", response: "{user}", modOnly: true},
+			},
+		},
+		{name: "nightbot invalid", read: nightbotCommands, export: `[]`, wantErr: true},
+		{name: "streamelements invalid", read: streamElementsCommands, export: `{}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.read([]byte(tt.export))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestImportTimers(t *testing.T) {
+	tests := []struct {
+		name   string
+		read   func([]byte) ([]importedTimer, error)
+		export string
+		want   []importedTimer
+	}{
+		{
+			name: "nightbot",
+			read: nightbotTimers,
+			export: `{"timers": [
+				{"name": "discord", "message": "Join, $(user)", "interval": "*/15 * * * *", "enabled": true},
+				{"name": "odd", "message": "odd", "interval": "0 12 * * *", "enabled": true},
+				{"name": "off", "message": "off", "interval": "*/5 * * * *", "enabled": false}
+			]}`,
+			want: []importedTimer{
+				{name: "discord", message: "Join, {user}", every: 15},
+				{name: "odd", message: "odd", every: 60},
+			},
+		},
+		{
+			name: "streamelements",
+			read: streamElementsTimers,
+			export: `[
+				{"name": "both", "messages": ["one", "two ${user}"], "enabled": true,
+					"online": {"enabled": true, "interval": 10}, "offline": {"enabled": true, "interval": 30}},
+				{"name": "online", "messages": ["three"], "enabled": true,
+					"online": {"enabled": true, "interval": 10}, "offline": {"enabled": false, "interval": 30}},
+				{"name": "none", "messages": ["four"], "enabled": true},
+				{"name": "off", "messages": ["five"], "enabled": false}
+			]`,
+			want: []importedTimer{
+				{name: "both", message: "one", every: 30},
+				{name: "both", message: "two {user}", every: 30},
+				{name: "online", message: "three", every: 10},
+				{name: "none", message: "four", every: 60},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.read([]byte(tt.export))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestImportCommandsOverwrite(t *testing.T) {
+	custom := &customCommands{commands: map[string]string{"hi": "old"}}
+	imported := []importedCommand{{name: "!Hi", response: "new"}, {name: "bye", response: "Bye"}, {name: "points", response: "built in"}}
+
+	if n := importCommands(custom, imported, false); n != 1 {
+		t.Errorf("imported %d without overwrite, want 1", n)
+	}
+	if want := map[string]string{"hi": "old", "bye": "Bye"}; !reflect.DeepEqual(custom.all(), want) {
+		t.Errorf("got %v without overwrite, want %v", custom.all(), want)
+	}
+
+	if n := importCommands(custom, imported, true); n != 2 {
+		t.Errorf("imported %d with overwrite, want 2", n)
+	}
+	if want := map[string]string{"hi": "new", "bye": "Bye"}; !reflect.DeepEqual(custom.all(), want) {
+		t.Errorf("got %v with overwrite, want %v", custom.all(), want)
+	}
+}
+
+func TestUnsupportedVar(t *testing.T) {
+	for response, want := range map[string]string{
+		"Hi {user}":                           "",
+		"$(urlfetch https://example.com/)":    "",
+		"$(count) and $(urlfetch https://x/)": "$(count)",
+		"${random.pick 'a' 'b'}":              "${random.pick 'a' 'b'}",
+	} {
+		if got := unsupportedVar(response); got != want {
+			t.Errorf("unsupportedVar(%q) = %q, want %q", response, got, want)
+		}
+	}
+}
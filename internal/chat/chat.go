@@ -0,0 +1,297 @@
+// Package chat is the bot's Twitch chat client. Messages are queued and sent
+// as fast as Twitch's rate limits allow, highest priority first, and long
+// ones are split to fit.
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gempir/go-twitch-irc/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// API is the part of Helix the client sends announcements, and messages with
+// CHAT_API, through.
+type API interface {
+	// SendMessage sends the message to the channel, as a reply if parentID
+	// is set.
+	SendMessage(channel, parentID, text string) error
+	// Announce sends an announcement, where color is blue, green, orange,
+	// purple, primary, or empty for primary.
+	Announce(channel, text, color string) error
+}
+
+// Client is the IRC client with sending replaced so the bot's messages go
+// through a rate limited queue, and through the Helix chat API instead of IRC
+// when CHAT_API is set.
+type Client struct {
+	*twitch.Client
+
+	api     API
+	refresh func() (string, error) // refreshes the token after chat rejects it
+	log     logrus.FieldLogger
+	queue   *messageQueue
+	useAPI  bool
+
+	// reconnecting is set while the client disconnects to log in again with
+	// a new token.
+	reconnecting atomic.Bool
+
+	mu   sync.Mutex
+	last map[string]sentMessage
+}
+
+// Sender is the part of Client commands and handlers use to talk in chat, so
+// they can be given something else in tests.
+type Sender interface {
+	Say(channel, text string)
+	SayPriority(channel, text string, p Priority)
+	Reply(channel, parentID, text string)
+	Announce(channel, text, color string)
+	ClearQueue(channel string)
+}
+
+// sentMessage is the last message sent to a channel.
+type sentMessage struct {
+	chatMessage
+	at time.Time
+}
+
+type chatMessage struct {
+	channel  string
+	parent   string // ID of the message being replied to, if any
+	text     string
+	priority Priority
+	retries  int
+}
+
+// maxRetries is how many times a message Twitch dropped for going over the
+// rate limit is tried again.
+const maxRetries = 3
+
+// NewClient wraps client so messages sent with it are queued. refresh is
+// called for a new token when chat rejects the one client has.
+func NewClient(client *twitch.Client, api API, refresh func() (string, error), log logrus.FieldLogger) *Client {
+	c := &Client{
+		Client:  client,
+		api:     api,
+		refresh: refresh,
+		log:     log,
+		queue:   newMessageQueue(log),
+		last:    map[string]sentMessage{},
+	}
+	c.useAPI, _ = strconv.ParseBool(os.Getenv("CHAT_API"))
+
+	go c.send()
+
+	return c
+}
+
+// Run connects to chat and stays connected until the bot is stopped,
+// including logging in again with a new token after a reconnect or after
+// Twitch rejects the token.
+func (c *Client) Run() error {
+	refreshed := false
+
+	for {
+		err := c.Connect()
+		switch {
+		case errors.Is(err, twitch.ErrClientDisconnected) && c.reconnecting.CompareAndSwap(true, false):
+			c.log.Info("reconnecting to chat with the new token")
+			refreshed = false
+		case errors.Is(err, twitch.ErrLoginAuthenticationFailed) && !refreshed:
+			c.log.Warn("chat rejected the token, refreshing it")
+			token, err := c.refresh()
+			if err != nil {
+				return fmt.Errorf("Run: %w", err)
+			}
+
+			c.SetIRCToken(token)
+			refreshed = true
+		default:
+			return err
+		}
+	}
+}
+
+// Reconnect disconnects from chat so Run connects again with the token from
+// SetIRCToken, which otherwise isn't used until Twitch drops the connection.
+func (c *Client) Reconnect() {
+	c.reconnecting.Store(true)
+
+	if err := c.Disconnect(); err != nil {
+		c.reconnecting.Store(false)
+		c.log.Errorf("unable to reconnect to chat: %v", err)
+	}
+}
+
+const (
+	// MaxMessageLength is the most characters Twitch allows in a chat message.
+	MaxMessageLength = 500
+
+	// duplicateSuffix is an invisible character added to a message that's the
+	// same as the last one, since Twitch drops a message that's identical to
+	// the previous one sent within 30 seconds.
+	duplicateSuffix = " \U000E0000"
+)
+
+// Say sends the message with normal priority.
+func (c *Client) Say(channel, text string) {
+	c.SayPriority(channel, text, PriorityNormal)
+}
+
+// SayPriority sends the message ahead of or after other waiting messages
+// depending on its priority.
+func (c *Client) SayPriority(channel, text string, p Priority) {
+	c.enqueue(chatMessage{channel: channel, text: text, priority: p})
+}
+
+// Reply sends the message threaded as a reply to the message with parentID.
+// Messages the bot made up itself have no ID, so those are just said.
+func (c *Client) Reply(channel, parentID, text string) {
+	c.enqueue(chatMessage{channel: channel, parent: parentID, text: text, priority: PriorityNormal})
+}
+
+// ClearQueue drops the messages still waiting to be sent to the channel.
+func (c *Client) ClearQueue(channel string) {
+	c.queue.clear(channel)
+}
+
+func (c *Client) enqueue(m chatMessage) {
+	for _, part := range splitMessage(m.text, MaxMessageLength-utf8.RuneCountInString(duplicateSuffix)) {
+		m.text = part
+		c.queue.push(m)
+	}
+}
+
+// Announce sends the message as an announcement highlighted with color, which
+// is one of blue, green, orange, purple, or primary for the channel's accent
+// color. If it can't be announced it's sent as a normal message instead.
+func (c *Client) Announce(channel, text, color string) {
+	go func() {
+		for _, part := range splitMessage(text, MaxMessageLength) {
+			if err := c.api.Announce(channel, part, color); err != nil {
+				c.log.WithField("channel", channel).Errorf("unable to announce in %s: %v", channel, err)
+				c.SayPriority(channel, part, PriorityHigh)
+			}
+		}
+	}()
+}
+
+// HandleUserState keeps track of which channels the bot is a mod in, for
+// OnUserStateMessage.
+func (c *Client) HandleUserState(message twitch.UserStateMessage) {
+	user := message.User
+	c.queue.setMod(message.Channel, user.Badges["broadcaster"] > 0 || user.Badges["moderator"] > 0)
+}
+
+// QueueDepth returns how many messages are waiting to be sent.
+func (c *Client) QueueDepth() int {
+	return c.queue.depth()
+}
+
+// QueueDepths returns how many messages of each priority are waiting to be
+// sent.
+func (c *Client) QueueDepths() map[Priority]int {
+	return c.queue.depths()
+}
+
+// vary changes the message's text so it isn't identical to the last message
+// sent to the channel within Twitch's duplicate message window.
+func (c *Client) vary(m chatMessage) chatMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last := c.last[m.channel]
+	text := strings.TrimSuffix(m.text, duplicateSuffix)
+	if time.Since(last.at) < 30*time.Second && strings.TrimSuffix(last.text, duplicateSuffix) == text &&
+		!strings.HasSuffix(last.text, duplicateSuffix) {
+		text += duplicateSuffix
+	}
+
+	m.text = text
+	c.last[m.channel] = sentMessage{chatMessage: m, at: time.Now()}
+
+	return m
+}
+
+// HandleNotice sends the last message to a channel again, after backing off,
+// when Twitch says it was dropped for going over the rate limit. It's for
+// OnNoticeMessage.
+func (c *Client) HandleNotice(message twitch.NoticeMessage) {
+	if message.MsgID != "msg_ratelimit" {
+		return
+	}
+
+	c.mu.Lock()
+	m, ok := c.last[message.Channel]
+	c.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if m.retries >= maxRetries {
+		c.log.Warnf("giving up on message to %s after %d retries: %s", m.channel, m.retries, m.text)
+		return
+	}
+
+	backoff := time.Second << m.retries
+	m.retries++
+	c.log.Warnf("message to %s was rate limited, retrying in %v", m.channel, backoff)
+
+	time.AfterFunc(backoff, func() { c.queue.push(m.chatMessage) })
+}
+
+// send sends queued messages one at a time, so they stay in order, as fast as
+// Twitch allows.
+func (c *Client) send() {
+	for {
+		m := c.vary(c.queue.pop())
+
+		c.log.Debugf("sending to %s, %d still waiting", m.channel, c.queue.depth())
+
+		switch {
+		case c.useAPI:
+			if err := c.api.SendMessage(m.channel, m.parent, m.text); err != nil {
+				c.log.WithField("channel", m.channel).Errorf("unable to send message to %s: %v", m.channel, err)
+			}
+		case m.parent != "":
+			c.Client.Reply(m.channel, m.parent, m.text)
+		default:
+			c.Client.Say(m.channel, m.text)
+		}
+	}
+}
+
+// splitMessage breaks text into parts of at most limit characters, splitting
+// between words where it can.
+func splitMessage(text string, limit int) []string {
+	var parts []string
+	for utf8.RuneCountInString(text) > limit {
+		// Byte offset of the first character past the limit.
+		end := 0
+		for i := 0; i < limit; i++ {
+			_, size := utf8.DecodeRuneInString(text[end:])
+			end += size
+		}
+
+		cut := strings.LastIndex(text[:end+1], " ")
+		if cut <= 0 {
+			cut = end
+		}
+
+		parts = append(parts, strings.TrimSpace(text[:cut]))
+		text = strings.TrimSpace(text[cut:])
+	}
+
+	return append(parts, text)
+}
@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		text  string
+		limit int
+		want  []string
+	}{
+		{text: "short", limit: 10, want: []string{"short"}},
+		{text: "exactly10!", limit: 10, want: []string{"exactly10!"}},
+		{text: "one two three four", limit: 10, want: []string{"one two", "three four"}},
+		{text: "one two three", limit: 7, want: []string{"one two", "three"}},
+		{text: "abcdefghijklmno", limit: 5, want: []string{"abcde", "fghij", "klmno"}},
+		{text: "héllo wörld ünïcode", limit: 6, want: []string{"héllo", "wörld", "ünïcod", "e"}},
+		{text: "🦇🦇🦇 🦇🦇", limit: 3, want: []string{"🦇🦇🦇", "🦇🦇"}},
+	}
+
+	for _, tt := range tests {
+		got := splitMessage(tt.text, tt.limit)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitMessage(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+		}
+		for _, part := range got {
+			if n := utf8.RuneCountInString(part); n > tt.limit {
+				t.Errorf("splitMessage(%q, %d) has a part of %d characters", tt.text, tt.limit, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package chat
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Priority decides which queued messages are sent first when the bot is
+// sending faster than Twitch allows.
+type Priority int
+
+const (
+	PriorityLow    Priority = iota // fun responses like emote triggers
+	PriorityNormal                 // command responses
+	PriorityHigh                   // moderation and alerts
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	}
+
+	return "high"
+}
+
+// Twitch allows 20 messages every 30 seconds, or 100 in channels the bot is a
+// mod in.
+const (
+	rateWindow   = 30 * time.Second
+	rateLimit    = 20
+	rateLimitMod = 100
+)
+
+// messageQueue holds outgoing messages until they can be sent without going
+// over Twitch's rate limits, highest priority first.
+type messageQueue struct {
+	mu      sync.Mutex
+	waiting [PriorityHigh + 1][]chatMessage
+	ready   chan struct{}
+	sent    []time.Time
+	mods    map[string]bool
+	log     logrus.FieldLogger
+}
+
+func newMessageQueue(log logrus.FieldLogger) *messageQueue {
+	return &messageQueue{
+		ready: make(chan struct{}, 1),
+		mods:  map[string]bool{},
+		log:   log,
+	}
+}
+
+func (q *messageQueue) push(m chatMessage) {
+	q.mu.Lock()
+	q.waiting[m.priority] = append(q.waiting[m.priority], m)
+	depth := q.depthLocked()
+	q.mu.Unlock()
+
+	if depth > 0 && depth%10 == 0 {
+		q.log.Warnf("%d messages waiting to be sent", depth)
+	}
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop waits until a message can be sent and returns it.
+func (q *messageQueue) pop() chatMessage {
+	for {
+		q.mu.Lock()
+		m, ok := q.peekLocked()
+		if !ok {
+			q.mu.Unlock()
+			<-q.ready
+			continue
+		}
+
+		if wait := q.waitLocked(m.channel); wait > 0 {
+			q.mu.Unlock()
+			q.log.Debugf("rate limited, waiting %v to send", wait)
+			time.Sleep(wait)
+			continue
+		}
+
+		q.waiting[m.priority] = q.waiting[m.priority][1:]
+		q.sent = append(q.sent, time.Now())
+		q.mu.Unlock()
+
+		return m
+	}
+}
+
+func (q *messageQueue) peekLocked() (chatMessage, bool) {
+	for p := PriorityHigh; p >= PriorityLow; p-- {
+		if len(q.waiting[p]) > 0 {
+			return q.waiting[p][0], true
+		}
+	}
+
+	return chatMessage{}, false
+}
+
+// waitLocked returns how long until another message can be sent to the
+// channel.
+func (q *messageQueue) waitLocked(channel string) time.Duration {
+	for len(q.sent) > 0 && time.Since(q.sent[0]) > rateWindow {
+		q.sent = q.sent[1:]
+	}
+
+	limit := rateLimit
+	if q.mods[channel] {
+		limit = rateLimitMod
+	}
+
+	if len(q.sent) < limit {
+		return 0
+	}
+
+	return rateWindow - time.Since(q.sent[len(q.sent)-limit])
+}
+
+// setMod records whether the bot is a mod in the channel, which raises how
+// fast it can send there.
+func (q *messageQueue) setMod(channel string, mod bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.mods[channel] = mod
+}
+
+// clear drops every message waiting to be sent to the channel.
+func (q *messageQueue) clear(channel string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for p := range q.waiting {
+		var keep []chatMessage
+		for _, m := range q.waiting[p] {
+			if m.channel != channel {
+				keep = append(keep, m)
+			}
+		}
+		q.waiting[p] = keep
+	}
+}
+
+// depth returns how many messages are waiting to be sent.
+func (q *messageQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.depthLocked()
+}
+
+// depths returns how many messages of each priority are waiting to be sent.
+func (q *messageQueue) depths() map[Priority]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	depths := map[Priority]int{}
+	for p, waiting := range q.waiting {
+		depths[Priority(p)] = len(waiting)
+	}
+
+	return depths
+}
+
+func (q *messageQueue) depthLocked() int {
+	depth := 0
+	for _, waiting := range q.waiting {
+		depth += len(waiting)
+	}
+
+	return depth
+}
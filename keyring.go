@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// keyringStore keeps the tokens in the operating system's keyring: the
+// Keychain on macOS, the Credential Manager on Windows, and the Secret
+// Service (GNOME Keyring or KWallet) on Linux.
+type keyringStore struct {
+	user string
+}
+
+const keyringService = "batybot"
+
+func (k keyringStore) load() (storedTokens, error) {
+	secret, err := keyring.Get(keyringService, k.user)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load: unable to read keyring: %w", err)
+	}
+
+	ts, err := decodeTokens([]byte(secret))
+	if err != nil {
+		return nil, fmt.Errorf("load: invalid tokens in keyring: %w", err)
+	}
+
+	return ts, nil
+}
+
+func (k keyringStore) save(ts storedTokens) error {
+	b, err := json.Marshal(ts)
+	if err != nil {
+		return fmt.Errorf("save: unable to encode tokens: %w", err)
+	}
+
+	if err := keyring.Set(keyringService, k.user, string(b)); err != nil {
+		return fmt.Errorf("save: unable to write keyring: %w", err)
+	}
+
+	return nil
+}
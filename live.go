@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// liveState tracks whether the channel is live, so features can be limited to
+// while it is or isn't. EventSub keeps it up to date, otherwise Helix is
+// asked every minute.
+type liveState struct {
+	mu   sync.RWMutex
+	live bool
+}
+
+func (l *liveState) isLive() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.live
+}
+
+func (l *liveState) set(live bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.live == live {
+		return
+	}
+
+	l.live = live
+	if live {
+		log.Debug("channel is live")
+	} else {
+		log.Debug("channel is offline")
+	}
+}
+
+func (l *liveState) onStreamOnline(json.RawMessage) {
+	l.set(true)
+}
+
+func (l *liveState) onStreamOffline(json.RawMessage) {
+	l.set(false)
+}
+
+// check asks Helix whether the channel is live.
+func (l *liveState) check(api *twitchAPI, channel string) {
+	id, err := api.userID(channel)
+	if err != nil {
+		log.Errorf("unable to check if %s is live: %v", channel, err)
+		return
+	}
+
+	_, err = api.stream(id)
+	l.set(err == nil)
+}
+
+// track checks whether the channel is live now, then with polling keeps
+// checking every minute, for when EventSub isn't there to say when it
+// changes.
+func (l *liveState) track(api *twitchAPI, channel string, polling bool) error {
+	for {
+		l.check(api, channel)
+		if !polling {
+			return nil
+		}
+
+		time.Sleep(time.Minute)
+	}
+}
+
+// active reports whether the feature is switched on and, if the config limits
+// it to while the channel is live, whether it is.
+func (c config) active(feature string, features *featureSet, live *liveState) bool {
+	if !features.enabled(feature) {
+		return false
+	}
+
+	for _, f := range c.LiveOnly {
+		if f == feature {
+			return live.isLive()
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// shiftLogLevel makes logging more verbose by steps, or less verbose if
+// steps is negative, and returns the new level.
+func shiftLogLevel(steps int) logrus.Level {
+	level := int(log.GetLevel()) + steps
+	if level < int(logrus.PanicLevel) {
+		level = int(logrus.PanicLevel)
+	} else if level > int(logrus.TraceLevel) {
+		level = int(logrus.TraceLevel)
+	}
+
+	log.SetLevel(logrus.Level(level))
+	return logrus.Level(level)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// shipEvery is how often events are sent on to Loki or Elasticsearch.
+	shipEvery = 5 * time.Second
+	// shipBatch is how many events are sent at once, sooner than shipEvery
+	// if chat's busy.
+	shipBatch = 500
+	// maxShipBacklog is how many events are held while the sink is down
+	// before the oldest are dropped.
+	maxShipBacklog = 10000
+)
+
+// logShipper sends every chat message and event from the event bus on to a
+// log store, in batches, so chat can be searched alongside everything else.
+type logShipper struct {
+	name   string
+	ship   func([]event) error
+	events <-chan event
+	stop   func()
+}
+
+// newLogShippers returns a shipper for each of LOKI_URL and ELASTICSEARCH_URL
+// that's set.
+func newLogShippers(bus *eventBus) []*logShipper {
+	var shippers []*logShipper
+
+	if u := os.Getenv("LOKI_URL"); u != "" {
+		shippers = append(shippers, newLogShipper("loki", bus, func(events []event) error {
+			return shipLoki(u, events)
+		}))
+	}
+
+	if u := os.Getenv("ELASTICSEARCH_URL"); u != "" {
+		index := os.Getenv("ELASTICSEARCH_INDEX")
+		if index == "" {
+			index = "batybot"
+		}
+		shippers = append(shippers, newLogShipper("elasticsearch", bus, func(events []event) error {
+			return shipElasticsearch(u, index, events)
+		}))
+	}
+
+	return shippers
+}
+
+func newLogShipper(name string, bus *eventBus, ship func([]event) error) *logShipper {
+	events, unsubscribe := bus.subscribe()
+	return &logShipper{name: name, ship: ship, events: events, stop: unsubscribe}
+}
+
+func (s *logShipper) Start() error {
+	ticker := time.NewTicker(shipEvery)
+	defer ticker.Stop()
+
+	var pending []event
+	flush := func() {
+		for len(pending) > 0 {
+			n := len(pending)
+			if n > shipBatch {
+				n = shipBatch
+			}
+
+			if err := s.ship(pending[:n]); err != nil {
+				log.Errorf("unable to ship events to %s: %v", s.name, err)
+				break
+			}
+			pending = pending[n:]
+		}
+
+		if len(pending) > maxShipBacklog {
+			log.Warnf("dropped %d events %s couldn't take", len(pending)-maxShipBacklog, s.name)
+			pending = pending[len(pending)-maxShipBacklog:]
+		}
+	}
+
+	for {
+		select {
+		case e, ok := <-s.events:
+			if !ok {
+				flush()
+				return nil
+			}
+
+			pending = append(pending, e)
+			if len(pending) == shipBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Shutdown stops taking events, ending Start once what it has is sent.
+func (s *logShipper) Shutdown(ctx context.Context) error {
+	s.stop()
+	return nil
+}
+
+// shipRequest returns a request for u, taking any user and password in it
+// out as basic auth so they don't end up in errors.
+func shipRequest(u, path, contentType string, body []byte) (*http.Request, error) {
+	p, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("shipRequest: invalid url")
+	}
+	user := p.User
+	p.User = nil
+	p.Path = strings.TrimSuffix(p.Path, "/") + path
+
+	req, err := http.NewRequest(http.MethodPost, p.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("shipRequest: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+
+	return req, nil
+}
+
+// shipLoki pushes the events to Loki, in streams labelled with the channel
+// and the event's type.
+func shipLoki(u string, events []event) error {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+
+	streams := map[[2]string]*stream{}
+	var order [][2]string
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("shipLoki: unable to encode event: %w", err)
+		}
+
+		key := [2]string{e.Channel, e.Type}
+		s, ok := streams[key]
+		if !ok {
+			s = &stream{Stream: map[string]string{"app": "batybot", "channel": e.Channel, "type": e.Type}}
+			streams[key] = s
+			order = append(order, key)
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), string(line)})
+	}
+
+	var body struct {
+		Streams []*stream `json:"streams"`
+	}
+	for _, key := range order {
+		body.Streams = append(body.Streams, streams[key])
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("shipLoki: unable to encode events: %w", err)
+	}
+
+	req, err := shipRequest(u, "/loki/api/v1/push", "application/json", b)
+	if err != nil {
+		return fmt.Errorf("shipLoki: %w", err)
+	}
+	if tenant := os.Getenv("LOKI_TENANT"); tenant != "" {
+		req.Header.Set("X-Scope-OrgID", tenant)
+	}
+
+	if err := doJSON(req, nil); err != nil {
+		return fmt.Errorf("shipLoki: %w", err)
+	}
+
+	return nil
+}
+
+// shipElasticsearch indexes the events in Elasticsearch with the bulk API,
+// with the event's time as @timestamp.
+func shipElasticsearch(u, index string, events []event) error {
+	action, err := json.Marshal(map[string]any{"create": map[string]string{"_index": index}})
+	if err != nil {
+		return fmt.Errorf("shipElasticsearch: %w", err)
+	}
+
+	var b bytes.Buffer
+	for _, e := range events {
+		doc, err := json.Marshal(struct {
+			Timestamp time.Time `json:"@timestamp"`
+			event
+		}{e.Time, e})
+		if err != nil {
+			return fmt.Errorf("shipElasticsearch: unable to encode event: %w", err)
+		}
+
+		b.Write(action)
+		b.WriteByte('\n')
+		b.Write(doc)
+		b.WriteByte('\n')
+	}
+
+	req, err := shipRequest(u, "/_bulk", "application/x-ndjson", b.Bytes())
+	if err != nil {
+		return fmt.Errorf("shipElasticsearch: %w", err)
+	}
+	if key := os.Getenv("ELASTICSEARCH_API_KEY"); key != "" {
+		req.Header.Set("Authorization", "ApiKey "+key)
+	}
+
+	var resp struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Error *struct {
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := doJSON(req, &resp); err != nil {
+		return fmt.Errorf("shipElasticsearch: %w", err)
+	}
+
+	if resp.Errors {
+		// The batch isn't sent again, since most of it was likely indexed.
+		for _, item := range resp.Items {
+			for _, result := range item {
+				if result.Error != nil {
+					log.Warnf("elasticsearch didn't index an event: %s", result.Error.Reason)
+					return nil
+				}
+			}
+		}
+	}
+
+	return nil
+}
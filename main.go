@@ -1,19 +1,33 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gempir/go-twitch-irc/v4"
+	"github.com/nicklaw5/helix/v2"
 	"github.com/sirupsen/logrus"
+
+	"github.com/losinggeneration/batybot/internal/chat"
 )
 
 var log *logrus.Logger
 
 func init() {
 	log = logrus.New()
+	setLogLevel()
+}
+
+// setLogLevel sets the log level from LOG_LEVEL.
+func setLogLevel() {
 	if level := strings.TrimSpace(os.Getenv("LOG_LEVEL")); level != "" {
 		log.Infof("Trying to set log level to %q", level)
 		l, err := logrus.ParseLevel(level)
@@ -27,12 +41,110 @@ func init() {
 }
 
 func main() {
+	defer reportPanic()
+
+	if dotEnvErr != nil {
+		log.Fatal(dotEnvErr)
+	}
+
+	// Without a command, or with flags first, the bot is run.
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	switch name {
+	case "run":
+		runBot(args)
+	case "auth":
+		authorize(args)
+	case "doctor":
+		checkSetup(args)
+	case "validate-config":
+		validateConfig(args)
+	case "config-schema":
+		printSchema(args)
+	case "export":
+		exportCommand(args)
+	case "export-emotes":
+		exportEmotes(args)
+	case "export-modlog":
+		exportModlog(args)
+	case "import":
+		importCommand(args)
+	case "purge-user":
+		purgeUserCommand(args)
+	case "report":
+		printReport(args)
+	case "replay":
+		replay(args)
+	case "version":
+		printVersion(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+}
+
+// runBot connects to chat and runs everything that's set up until the bot's
+// stopped.
+func runBot(args []string) {
+	settings(flag.NewFlagSet("run", flag.ExitOnError), args)
+
+	if dsn := os.Getenv("SENTRY_DSN"); dsn != "" {
+		if err := setupSentry(dsn); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	b, err := loadFiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handleSignals(b.reload)
+
+	b.services.start(&service{name: "file watcher", run: func() error { return watchFiles(b) }, policy: restartOnFailure})
+
 	token := os.Getenv("TWITCH_TOKEN")
 	refresh := os.Getenv("TWITCH_REFRESH")
 	expires := os.Getenv("TWITCH_EXPIRES")
 
+	if err := b.tokens.setup(b.notifications); err != nil {
+		log.Fatal(err)
+	}
+
+	if os.Getenv("TWITCH_APP_ONLY") == "true" {
+		runAppOnly(b)
+		return
+	}
+
+	if token == "" || refresh == "" || expires == "" {
+		a, stored, err := b.tokens.loadToken(b.account)
+		if err != nil {
+			log.Fatal(err)
+		} else if stored != nil {
+			b.account = a
+			fresh, err := b.tokens.fresh(b.account, *stored)
+			if err != nil {
+				log.Fatal(err)
+			}
+			token, refresh, expires = fresh.Token, fresh.Refresh, fresh.Expires
+
+			// Authorize again rather than have features fail later.
+			if missing := missingScopes(token, botScopes); len(missing) > 0 {
+				log.Warnf("the stored token is missing %s, added since it was authorized, authorize the bot again", strings.Join(missing, ", "))
+				token, refresh, expires = "", "", ""
+			}
+		}
+	} else if missing := missingScopes(token, botScopes); len(missing) > 0 {
+		log.Warnf("TWITCH_TOKEN is missing %s, some features won't work until it's authorized again", strings.Join(missing, ", "))
+	}
+
 	if token == "" || refresh == "" || expires == "" {
-		creds, err := getToken()
+		creds, err := getToken(botScopes, nil)
 		if err != nil {
 			log.Debugln("unable to get access token")
 			panic(err)
@@ -41,6 +153,7 @@ func main() {
 		log.Debugf("%#v", creds)
 
 		token, refresh, expires = creds.get()
+		b.account = b.tokens.saveToken(b.account, storedToken{Token: token, Refresh: refresh, Expires: expires})
 	}
 
 	user := os.Getenv("TWITCH_USER")
@@ -48,34 +161,49 @@ func main() {
 		log.Fatalf("expected a user, set TWITCH_USER environment variable")
 	}
 
-	client := twitch.NewClient("batybot", token)
+	b.api, err = newTwitchAPI(token, b.refreshes, b.status, b.modlog)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	irc := twitch.NewClient("batybot", token)
+	if addr := os.Getenv("TWITCH_IRC_ADDRESS"); addr != "" {
+		// A local server, like fakeirc, without TLS.
+		irc.IrcAddress = addr
+		irc.TLS = false
+	}
+
+	client := chat.NewClient(irc, b.api, b.refreshes.now, log)
+	b.client = client
 
 	client.OnNoticeMessage(func(message twitch.NoticeMessage) {
 		log.Debugf("notice message: %#v", message)
+		client.HandleNotice(message)
 	})
 
-	go doRefresh(client, refresh, expires)
+	b.services.start(&service{
+		name:   "token refresh",
+		run:    func() error { return doRefresh(b, refresh, expires) },
+		policy: restartNeverCritical,
+	})
 
-	lastMention := time.Now()
+	startPluginEvents(b.bus)
+	b.scripts.setClient(client, b.api)
+	go b.scripts.handleEvents(b.bus)
+	b.wasm.setClient(client)
+	go b.wasm.handleEvents(b.bus)
 
-	client.OnPrivateMessage(func(message twitch.PrivateMessage) {
-		log.Debugln(message.Channel, message.User.Name, message.Message)
+	messages := newChatHandler(b, client, true)
+	messages.lastMention = time.Now()
+	client.OnPrivateMessage(messages.onMessage)
 
-		msg := strings.ToLower(message.Message)
-		switch {
-		case strings.Contains(msg, "batjam"):
-			client.Say(message.Channel, "BatJAM BatJAM BatJAM")
-		case strings.Contains(msg, "batpop"):
-			client.Say(message.Channel, "BatPop BatPop BatPop")
-		case strings.HasSuffix(msg, "batg"):
-			client.Say(message.Channel, "very interesting BatG")
-		}
+	client.OnGlobalUserStateMessage(b.api.onGlobalUserState)
+	client.OnUserStateMessage(client.HandleUserState)
 
-		if strings.Contains(strings.ToLower(message.Message), "batybot") && time.Since(lastMention) > 5*time.Minute {
-			lastMention = time.Now()
-			client.Say(message.Channel, "What? No, I'm awake BatPls")
-		}
-	})
+	client.OnUserNoticeMessage(b.bus.onUserNotice)
+
+	client.OnClearChatMessage(b.modlog.onClearChat)
+	client.OnClearMessage(b.modlog.onClear)
 
 	client.OnNamesMessage(func(message twitch.NamesMessage) {
 		log.Debugf("names message: %#v", message)
@@ -85,8 +213,19 @@ func main() {
 		log.Debugf("room state message: %#v", message)
 	})
 
+	client.OnSelfJoinMessage(b.status.onSelfJoin)
+	client.OnSelfPartMessage(b.status.onSelfPart)
+
+	client.OnPongMessage(func(twitch.PongMessage) {
+		b.status.heard()
+	})
+
+	var ready sync.Once
 	client.OnConnect(func() {
 		log.Info("connected")
+		b.status.setConnected(true)
+		b.status.heard()
+		ready.Do(func() { notifyReady(b.status) })
 	})
 
 	channel := os.Getenv("TWITCH_CHANNEL")
@@ -95,42 +234,299 @@ func main() {
 		panic("TWITCH_CHANNEL unset")
 	}
 
+	if addr := os.Getenv("OVERLAY_LISTEN"); addr != "" {
+		b.overlay = newOverlay(addr, b)
+		b.services.serve("overlay", b.overlay.Start, b.overlay.Shutdown)
+	}
+
+	recorder := newAnalyticsRecorder(b.config, b.bus, b.analytics)
+	b.services.serve("analytics", recorder.Start, recorder.Shutdown)
+
+	publisher := b.startMQTT(channel)
+
+	socket := os.Getenv("EVENTSUB_TRANSPORT") == "websocket"
+	if secret := os.Getenv("EVENTSUB_SECRET"); secret != "" || socket {
+		moderator := user
+		if mod := os.Getenv("TWITCH_MODERATOR"); mod != "" && !socket {
+			moderator = mod
+		} else if mod != "" {
+			// WebSocket subscriptions are made with the bot's token.
+			log.Warnf("TWITCH_MODERATOR is ignored with EVENTSUB_TRANSPORT=websocket, follows need %s to be a mod", user)
+		}
+
+		b.startEventSub(secret, channel, moderator, publisher)
+	}
+	b.services.start(&service{
+		name:   "live state",
+		run:    func() error { return b.live.track(b.api, channel, b.events == nil) },
+		policy: restartNever,
+	})
+	b.services.start(&service{
+		name:   "offline timers",
+		run:    func() error { return runOfflineTimers(b, client, channel) },
+		policy: restartNever,
+	})
+
+	b.services.start(&service{
+		name:   "word games",
+		run:    func() error { return runWordGameSchedule(b, client, channel) },
+		policy: restartNever,
+	})
+
+	client.OnWhisperMessage(onWhisper(b, channel))
+
+	if token := os.Getenv("API_TOKEN"); token != "" {
+		addr := os.Getenv("API_LISTEN")
+		if addr == "" {
+			addr = "127.0.0.1:8081"
+		}
+
+		control := newControlServer(addr, token, b)
+		b.services.serve("control server", control.Start, control.Shutdown)
+
+		if addr := os.Getenv("GRPC_LISTEN"); addr != "" {
+			server := newGRPCServer(addr, token, client, b)
+			b.services.serve("grpc server", server.Start, server.Shutdown)
+		}
+	}
+
+	b.startServers()
+
+	if addr := os.Getenv("DASHBOARD_LISTEN"); addr != "" {
+		password := os.Getenv("DASHBOARD_PASSWORD")
+		if password == "" {
+			log.Fatal("expected a password for the dashboard, set DASHBOARD_PASSWORD environment variable")
+		}
+
+		dashboard := newDashboard(addr, password, b)
+		b.services.serve("dashboard", dashboard.Start, dashboard.Shutdown)
+	}
+
+	if token := os.Getenv("DISCORD_TOKEN"); token != "" {
+		bridge, err := newChatBridge(token, b, channel)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		b.services.serve("discord bridge", bridge.Start, bridge.Shutdown)
+	}
+
 	client.Join(channel)
+	b.services.start(&service{
+		name: "chat",
+		run: func() error {
+			if err := client.Run(); err != nil && !errors.Is(err, twitch.ErrClientDisconnected) {
+				b.notifications.send("Disconnected from Twitch chat", err.Error())
+				return fmt.Errorf("unable to connect to chat: %w", err)
+			}
+			return nil
+		},
+		stop:   func(context.Context) error { return client.Disconnect() },
+		policy: restartNeverCritical,
+	})
+	handleShutdown(b.services)
 
-	if err := client.Connect(); err != nil {
-		log.Errorf("unable to connect %#v", token)
+	err = b.services.wait()
+	saveState(b)
+	if err != nil {
 		panic(err)
 	}
 }
 
-// This isn't working to keep the token valid
-func doRefresh(client *twitch.Client, refresh, expires string) {
+// startMQTT starts publishing the bot's and channel's state if MQTT_URL is
+// set, otherwise it returns nil.
+func (b *bot) startMQTT(channel string) *mqttPublisher {
+	broker := os.Getenv("MQTT_URL")
+	if broker == "" {
+		return nil
+	}
+
+	publisher := newMQTTPublisher(broker, channel, b.api, b.bus)
+	b.services.serve("mqtt", publisher.Start, publisher.Shutdown)
+
+	return publisher
+}
+
+// startEventSub subscribes to the channel's events. Without a chat client,
+// in app only mode, redemptions aren't handled, and without a moderator
+// neither are follows.
+func (b *bot) startEventSub(secret, channel, moderator string, publisher *mqttPublisher) {
+	events, err := newEventSub(secret, b.api, b.status, b.refreshes, b.notifications)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b.events = events
+	events.setToken(b.api.GetUserAccessToken())
+	b.status.setEventSubscribed(false)
+
+	events.on(helix.EventSubTypeStreamOnline, func(json.RawMessage) {
+		log.Infof("%s is live", channel)
+	})
+	events.on(helix.EventSubTypeStreamOnline, onStreamOnline(b))
+	events.on(helix.EventSubTypeStreamOnline, b.live.onStreamOnline)
+	events.on(helix.EventSubTypeStreamOffline, b.live.onStreamOffline)
+	if publisher != nil {
+		events.on(helix.EventSubTypeStreamOnline, publisher.onStreamOnline)
+		events.on(helix.EventSubTypeStreamOffline, publisher.onStreamOffline)
+	}
+	events.on(helix.EventSubTypeStreamOffline, func(json.RawMessage) {
+		log.Infof("%s is offline", channel)
+	})
+
+	if moderator != "" {
+		events.on(helix.EventSubTypeChannelFollow, b.bus.onFollow)
+	}
+
+	if len(b.config.get().Redemptions) > 0 && b.client != nil {
+		events.on(helix.EventSubTypeChannelPointsCustomRewardRedemptionAdd, onRedemption(b))
+	}
+
+	b.services.serve("eventsub server", events.Start, events.Shutdown)
+	if events.socket {
+		b.services.start(&service{
+			name:   "eventsub websocket",
+			run:    events.listenSocket,
+			stop:   events.closeSocket,
+			policy: restartOnFailure,
+		})
+	}
+	b.services.start(&service{
+		name:   "eventsub monitor",
+		run:    events.monitor,
+		stop:   events.stopMonitor,
+		policy: restartOnFailure,
+	})
+
+	if os.Getenv("TWITCH_MODERATOR") != "" {
+		checkModerator(b.tokens)
+	}
+
+	go func() {
+		if err := events.subscribe(channel, moderator); err != nil {
+			log.Errorf("unable to subscribe to events: %v", err)
+			b.notifications.send("EventSub subscription failed", err.Error())
+		}
+	}()
+}
+
+// startServers starts the servers that don't need chat: health checks, the
+// profiler, and the event stream.
+func (b *bot) startServers() {
+	if addr := os.Getenv("HEALTH_LISTEN"); addr != "" {
+		health := newHealthServer(addr, b.status, b.client, b.events, b.mood)
+		b.services.serve("health server", func() error {
+			return fmt.Errorf("unable to start health server: %w", health.ListenAndServe())
+		}, health.Shutdown)
+	}
+
+	if addr := os.Getenv("PPROF_LISTEN"); addr != "" {
+		log.Infof("serving profiles on http://%s/debug/pprof/", addr)
+		profiler := newProfiler(addr)
+		b.services.serve("profiler", func() error {
+			return fmt.Errorf("unable to start profiler: %w", profiler.ListenAndServe())
+		}, profiler.Shutdown)
+	}
+
+	if addr := os.Getenv("EVENTS_LISTEN"); addr != "" {
+		token := os.Getenv("API_TOKEN")
+		if token == "" {
+			log.Fatal("expected a token for the event stream, set API_TOKEN environment variable")
+		}
+
+		stream := newEventStream(addr, token, b.bus)
+		b.services.serve("event stream", stream.Start, stream.Shutdown)
+	}
+
+	if addr := os.Getenv("BINGO_LISTEN"); addr != "" {
+		bingo := newBingoServer(addr, b.bingo)
+		b.services.serve("bingo server", bingo.Start, bingo.Shutdown)
+	}
+
+	for _, shipper := range newLogShippers(b.bus) {
+		b.services.serve(shipper.name+" shipping", shipper.Start, shipper.Shutdown)
+	}
+}
+
+// renewToken refreshes the bot's token, retrying until it expires. If the
+// refresh token's been revoked, the bot is authorized again the same way it
+// was at startup, and keeps running on the old token until then.
+func renewToken(b *bot, refresh string, expiresAt time.Time) (*Token, error) {
+	for {
+		creds, err := b.tokens.refreshToken(refresh)
+		if err == nil {
+			return creds, nil
+		}
+
+		if errors.Is(err, errRefreshRevoked) {
+			log.Errorf("%v, the bot needs to be authorized again", err)
+			b.notifications.send("Authorize the bot again", "The refresh token was revoked, see the log for how to authorize it")
+
+			creds, err := getToken(botScopes, b.events)
+			if err != nil {
+				return nil, fmt.Errorf("renewToken: %w", err)
+			}
+
+			log.Info("authorized again")
+			return creds, nil
+		}
+
+		if time.Now().After(expiresAt) {
+			return nil, fmt.Errorf("renewToken: %w", err)
+		}
+
+		log.Errorf("unable to refresh token, trying again in 30s: %v", err)
+		time.Sleep(30 * time.Second)
+	}
+}
+
+// doRefresh refreshes the bot's token before it expires, or when it's asked
+// to through b.refreshes. Chat keeps using the old token until it reconnects,
+// so with TWITCH_RECONNECT_ON_REFRESH set it reconnects straight away.
+func doRefresh(b *bot, refresh, expires string) error {
+	reconnect, _ := strconv.ParseBool(os.Getenv("TWITCH_RECONNECT_ON_REFRESH"))
+
 	for {
 		expiresAt, err := time.Parse(time.RFC3339Nano, expires)
 		if err != nil {
-			panic(fmt.Errorf("unable to parse expires time: %w", err))
+			return fmt.Errorf("doRefresh: unable to parse expires time: %w", err)
 		} else if time.Now().After(expiresAt) {
-			panic(fmt.Errorf("refresh token %s is already expired", expiresAt))
+			return fmt.Errorf("doRefresh: refresh token %s is already expired", expiresAt)
 		}
+		b.status.setTokenExpires(expiresAt)
 
-		const early = 400
-		until := time.Until(expiresAt) / early
+		until := time.Until(expiresAt) - refreshLead()
+		if until < 0 {
+			until = 0
+		}
 		log.Debugf("Waiting %v before refreshing token that expires %s", until, expires)
-		time.Sleep(until)
 
-		creds, err := refreshToken(refresh)
+		var waiting []chan<- string
+		timer := time.NewTimer(until)
+		select {
+		case <-timer.C:
+		case done := <-b.refreshes:
+			timer.Stop()
+			waiting = append(waiting, done)
+		}
+
+		creds, err := renewToken(b, refresh, expiresAt)
 		if err != nil {
-			panic(err)
+			b.notifications.send("Token refresh failed", err.Error())
+			return fmt.Errorf("doRefresh: %w", err)
 		}
 
 		var token string
 		token, refresh, expires = creds.get()
-		client.SetIRCToken(token)
+		b.account = b.tokens.saveToken(b.account, storedToken{Token: token, Refresh: refresh, Expires: expires})
+		b.client.SetIRCToken(token)
+		b.api.setToken(token)
+		if b.events != nil {
+			b.events.setToken(token)
+		}
+		answerRefreshes(b.refreshes, waiting, token)
 
-		err = client.Connect()
-		if err != nil {
-			log.Errorf("unable to connect %#v", token)
-			panic(err)
+		if reconnect {
+			b.client.Reconnect()
 		}
 	}
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// roleModerator is a mod account, separate from the bot, that EventSub
+// subscriptions needing a moderator are made for. Twitch only checks that it
+// authorized the bot's client ID with the right scopes, so its token is kept
+// to show it has, and refreshed so it stays valid.
+const roleModerator = "moderator"
+
+// moderatorScopes are what TWITCH_MODERATOR is asked to authorize, for the
+// follow, shield mode, and AutoMod subscriptions.
+var moderatorScopes = []string{
+	"moderator:read:followers", "moderator:read:shield_mode", "moderator:manage:automod",
+}
+
+// authorizeModerator has TWITCH_MODERATOR authorize the bot in the browser
+// and stores the token for their account.
+func authorizeModerator() error {
+	if os.Getenv("TWITCH_MODERATOR") == "" {
+		return errors.New("authorizeModerator: set TWITCH_MODERATOR to the account to authorize")
+	}
+
+	tokens := &tokenKeeper{}
+	if err := tokens.setup(newNotifier()); err != nil {
+		return fmt.Errorf("authorizeModerator: %w", err)
+	} else if tokens.store == nil {
+		return errors.New("authorizeModerator: set TOKEN_STORE to keep the token in")
+	}
+
+	log.Infof("log in as %s to authorize it", os.Getenv("TWITCH_MODERATOR"))
+
+	creds, err := getToken(moderatorScopes, nil)
+	if err != nil {
+		return fmt.Errorf("authorizeModerator: %w", err)
+	}
+
+	token, refresh, expires := creds.get()
+	a := tokens.saveToken(account{Role: roleModerator}, storedToken{Token: token, Refresh: refresh, Expires: expires})
+	log.Infof("stored the moderator token for user %s", a.UserID)
+
+	return nil
+}
+
+// checkModerator refreshes the stored moderator token, and warns if there
+// isn't one since subscriptions for the moderator will fail until they've
+// authorized the bot.
+func checkModerator(tokens *tokenKeeper) {
+	a, stored, err := tokens.loadToken(account{Role: roleModerator})
+	if err != nil {
+		log.Errorf("unable to load moderator token: %v", err)
+		return
+	} else if stored == nil {
+		log.Warnf("no moderator token stored, run batybot auth -moderator if %s hasn't authorized the bot", os.Getenv("TWITCH_MODERATOR"))
+		return
+	}
+
+	fresh, err := tokens.fresh(a, *stored)
+	if err != nil {
+		log.Errorf("unable to refresh moderator token: %v", err)
+		return
+	}
+
+	if missing := missingScopes(fresh.Token, moderatorScopes); len(missing) > 0 {
+		log.Warnf("the moderator token is missing %s, run batybot auth -moderator to authorize it again", strings.Join(missing, ", "))
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// modAction is a single moderation action either taken by the bot or seen in
+// chat.
+type modAction struct {
+	Time      time.Time `json:"time"`
+	Channel   string    `json:"channel"`
+	Action    string    `json:"action"` // one of ban, timeout, delete, clear, or appeal
+	Target    string    `json:"target,omitempty"`
+	TargetID  string    `json:"target_id,omitempty"`
+	Moderator string    `json:"moderator,omitempty"`
+	Duration  int       `json:"duration,omitempty"` // timeout length in seconds
+	Message   string    `json:"message,omitempty"`
+	MessageID string    `json:"message_id,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// modLog keeps every moderation action in memory and, when a file is given,
+// appends each one to it as a JSON line so the log survives restarts.
+type modLog struct {
+	mu      sync.Mutex
+	file    string
+	actions []modAction
+}
+
+// load reads any existing entries from file and sets it as the file new
+// entries are appended to.
+func (m *modLog) load(file string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.file = file
+
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("load: unable to open %q: %w", file, err)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var a modAction
+		if err := json.Unmarshal(s.Bytes(), &a); err != nil {
+			return fmt.Errorf("load: invalid entry in %q: %w", file, err)
+		}
+
+		m.actions = append(m.actions, a)
+	}
+
+	return s.Err()
+}
+
+func (m *modLog) record(a modAction) {
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Actions the bot takes are also echoed back over IRC, so only the first
+	// record, which knows who did it and why, is kept.
+	if a.Moderator == "" && m.taken(a) {
+		return
+	}
+
+	log.Infof("moderation: %s %s in %s", a.Action, a.Target, a.Channel)
+
+	m.actions = append(m.actions, a)
+
+	if m.file == "" {
+		return
+	}
+
+	f, err := os.OpenFile(m.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Errorf("unable to open moderation log: %v", err)
+		return
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(a); err != nil {
+		log.Errorf("unable to write moderation log: %v", err)
+	}
+}
+
+// taken reports whether the bot recently recorded the same action itself.
+func (m *modLog) taken(a modAction) bool {
+	for i := len(m.actions) - 1; i >= 0; i-- {
+		b := m.actions[i]
+		if a.Time.Sub(b.Time) > 10*time.Second {
+			return false
+		}
+
+		if b.Moderator != "" && b.Channel == a.Channel && b.Action == a.Action &&
+			strings.EqualFold(b.Target, a.Target) && b.MessageID == a.MessageID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// query returns the actions matching the channel and target, newest first. An
+// empty channel or target matches everything.
+func (m *modLog) query(channel, target string, since time.Time) []modAction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found []modAction
+	for i := len(m.actions) - 1; i >= 0; i-- {
+		a := m.actions[i]
+		if a.Time.Before(since) {
+			break
+		}
+
+		if channel != "" && a.Channel != channel {
+			continue
+		}
+
+		if target != "" && !strings.EqualFold(a.Target, target) {
+			continue
+		}
+
+		found = append(found, a)
+	}
+
+	return found
+}
+
+// purge removes the actions taken against the user, in memory and in the
+// file, returning how many there were.
+func (m *modLog) purge(userID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.actions[:0]
+	for _, a := range m.actions {
+		if a.TargetID != userID {
+			kept = append(kept, a)
+		}
+	}
+	purged := len(m.actions) - len(kept)
+	m.actions = kept
+
+	if m.file == "" {
+		return purged, nil
+	}
+
+	if err := purgeJSONLines(m.file, func(a modAction) bool { return a.TargetID == userID }); err != nil {
+		return purged, fmt.Errorf("purge: %w", err)
+	}
+
+	return purged, nil
+}
+
+func (m *modLog) all() []modAction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]modAction{}, m.actions...)
+}
+
+// export writes the full log to w either as "json" or "csv".
+func (m *modLog) export(w io.Writer, format string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch format {
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(m.actions)
+	case "csv":
+		c := csv.NewWriter(w)
+		c.Write([]string{"time", "channel", "action", "target", "target_id", "moderator", "duration", "message", "message_id", "reason"})
+		for _, a := range m.actions {
+			c.Write([]string{
+				a.Time.Format(time.RFC3339), a.Channel, a.Action, a.Target, a.TargetID,
+				a.Moderator, strconv.Itoa(a.Duration), a.Message, a.MessageID, a.Reason,
+			})
+		}
+		c.Flush()
+		return c.Error()
+	}
+
+	return fmt.Errorf("export: unknown format %q", format)
+}
+
+func (m *modLog) onClearChat(message twitch.ClearChatMessage) {
+	a := modAction{
+		Time:     message.Time,
+		Channel:  message.Channel,
+		Action:   "clear",
+		Target:   message.TargetUsername,
+		TargetID: message.TargetUserID,
+		Duration: message.BanDuration,
+	}
+
+	switch {
+	case message.TargetUsername == "":
+	case message.BanDuration > 0:
+		a.Action = "timeout"
+	default:
+		a.Action = "ban"
+	}
+
+	m.record(a)
+}
+
+func (m *modLog) onClear(message twitch.ClearMessage) {
+	m.record(modAction{
+		Channel:   message.Channel,
+		Action:    "delete",
+		Target:    message.Login,
+		Message:   message.Message,
+		MessageID: message.TargetMsgID,
+	})
+}
+
+func modlogCommand(b *bot, client chat.Sender, message twitch.PrivateMessage, args []string) {
+	var target string
+	if len(args) > 0 {
+		target = strings.TrimPrefix(args[0], "@")
+	}
+
+	actions := b.modlog.query(message.Channel, target, time.Now().Add(-7*24*time.Hour))
+	if len(actions) == 0 {
+		client.Reply(message.Channel, message.ID, "No moderation actions in the last week")
+		return
+	}
+
+	var recent []string
+	for i, a := range actions {
+		if i == 5 {
+			break
+		}
+
+		s := a.Action
+		if a.Target != "" {
+			s += " " + a.Target
+		}
+		recent = append(recent, fmt.Sprintf("%s %s ago", s, shortDuration(time.Since(a.Time))))
+	}
+
+	client.Reply(message.Channel, message.ID, fmt.Sprintf("%d moderation actions in the last week: %s", len(actions), strings.Join(recent, ", ")))
+}
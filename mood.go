@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// moodWindow is how far back chat's mood is measured.
+const moodWindow = 5 * time.Minute
+
+// moodWords are how positive or negative words and emotes are, from -1 to 1.
+// It's deliberately small: chat's mood only needs to be roughly right.
+var moodWords = map[string]float64{
+	// Emotes
+	"pog": 1, "pogchamp": 1, "poggers": 1, "pogu": 1, "lul": 0.6, "lol": 0.6, "kekw": 0.6,
+	"omegalul": 0.6, "batjam": 0.8, "batpls": 0.6, "catjam": 0.8, "<3": 1, "hypers": 1,
+	"ez": 0.3, "gg": 0.8, "ggs": 0.8, "peepohappy": 1, "widepeepohappy": 1, "bloodtrail": 0.6,
+	"notlikethis": -0.8, "biblethump": -0.8, "residentsleeper": -0.8, "sadge": -0.8,
+	"pepehands": -0.8, "feelsbadman": -0.8, "monkas": -0.4, "wutface": -0.6, "dansgame": -0.8,
+	"babyrage": -0.6, "swiftrage": -0.8, "f": -0.4,
+	// Words
+	"love": 1, "loved": 1, "awesome": 1, "amazing": 1, "great": 0.8, "good": 0.6, "nice": 0.6,
+	"cool": 0.5, "fun": 0.6, "funny": 0.6, "best": 0.8, "cute": 0.8, "wow": 0.5, "yay": 0.8,
+	"haha": 0.6, "hype": 0.8, "thanks": 0.6, "thank": 0.6, "clutch": 0.8, "win": 0.6,
+	"hate": -1, "awful": -1, "terrible": -1, "worst": -1, "bad": -0.6, "boring": -0.8,
+	"sad": -0.8, "ugh": -0.6, "rip": -0.5, "lag": -0.5, "laggy": -0.6, "cringe": -0.6,
+	"angry": -0.8, "annoying": -0.8, "lost": -0.4, "lose": -0.4, "dead": -0.4, "trash": -0.8,
+}
+
+// moodNegations flip the word that follows them, as in "not good".
+var moodNegations = map[string]bool{
+	"not": true, "no": true, "never": true, "isn't": true, "isnt": true, "don't": true,
+	"dont": true, "wasn't": true, "wasnt": true, "aint": true, "ain't": true,
+}
+
+// sentiment scores text from -1 to 1, or reports false if it has nothing that
+// says how the chatter feels.
+func sentiment(text string) (float64, bool) {
+	var total float64
+	var scored int
+	negate := false
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if word != "<3" {
+			word = strings.TrimFunc(word, func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+			})
+		}
+
+		if moodNegations[word] {
+			negate = true
+			continue
+		}
+
+		score, ok := moodWords[word]
+		if !ok {
+			continue
+		}
+		if negate {
+			score = -score
+		}
+		negate = false
+
+		total += score
+		scored++
+	}
+
+	if scored == 0 {
+		return 0, false
+	}
+
+	return total / float64(scored), true
+}
+
+// moodTracker keeps the scores of each channel's recent messages.
+type moodTracker struct {
+	mu     sync.Mutex
+	scores map[string][]moodScore // by channel, oldest first
+}
+
+type moodScore struct {
+	at    time.Time
+	score float64
+}
+
+// chatMood is a channel's mood: the average score, from -1 to 1, of the
+// messages in the last moodWindow that had one.
+type chatMood struct {
+	Channel  string  `json:"channel"`
+	Mood     float64 `json:"mood"`
+	Messages int     `json:"messages"`
+}
+
+func (m *moodTracker) add(message twitch.PrivateMessage, at time.Time) {
+	score, ok := sentiment(message.Message)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.scores[message.Channel] = append(m.pruneLocked(message.Channel, at), moodScore{at: at, score: score})
+}
+
+// pruneLocked drops the channel's scores that are too old to count at now.
+func (m *moodTracker) pruneLocked(channel string, now time.Time) []moodScore {
+	scores := m.scores[channel]
+	i := 0
+	for i < len(scores) && now.Sub(scores[i].at) > moodWindow {
+		i++
+	}
+	scores = scores[i:]
+
+	if len(scores) == 0 {
+		delete(m.scores, channel)
+	} else {
+		m.scores[channel] = scores
+	}
+
+	return scores
+}
+
+func (m *moodTracker) get(channel string, now time.Time) chatMood {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := chatMood{Channel: channel}
+	scores := m.pruneLocked(channel, now)
+	for _, s := range scores {
+		c.Mood += s.score
+	}
+	if len(scores) > 0 {
+		c.Messages = len(scores)
+		c.Mood /= float64(len(scores))
+	}
+
+	return c
+}
+
+// all returns the mood of every channel with recent scores, sorted by channel.
+func (m *moodTracker) all(now time.Time) []chatMood {
+	m.mu.Lock()
+	channels := make([]string, 0, len(m.scores))
+	for channel := range m.scores {
+		channels = append(channels, channel)
+	}
+	m.mu.Unlock()
+	sort.Strings(channels)
+
+	moods := make([]chatMood, 0, len(channels))
+	for _, channel := range channels {
+		if c := m.get(channel, now); c.Messages > 0 {
+			moods = append(moods, c)
+		}
+	}
+
+	return moods
+}
+
+func (c chatMood) String() string {
+	if c.Messages == 0 {
+		return "Chat's been too quiet to tell how it's feeling"
+	}
+
+	var feeling string
+	switch {
+	case c.Mood >= 0.5:
+		feeling = "hyped BatJAM"
+	case c.Mood >= 0.15:
+		feeling = "happy BatPls"
+	case c.Mood > -0.15:
+		feeling = "chill"
+	case c.Mood > -0.5:
+		feeling = "a bit down"
+	default:
+		feeling = "sad BibleThump"
+	}
+
+	return fmt.Sprintf("Chat's feeling %s (%+.2f from %d messages in the last %s)", feeling, c.Mood, c.Messages, shortDuration(moodWindow))
+}
+
+func moodCommand(b *bot, client chat.Sender, message twitch.PrivateMessage, args []string) {
+	// Going by when it was sent keeps replays right.
+	now := message.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	client.Reply(message.Channel, message.ID, b.mood.get(message.Channel, now).String())
+}
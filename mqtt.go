@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttPublisher publishes the bot's and stream's state to an MQTT broker,
+// retained so new subscribers see the current state, and announces it to
+// Home Assistant with MQTT discovery.
+type mqttPublisher struct {
+	client  mqtt.Client
+	api     *twitchAPI
+	prefix  string
+	channel string
+
+	// Subscribed once, rather than in Start, so restarts don't publish every
+	// event again.
+	events  <-chan event
+	stop    func()
+	polling sync.Once
+	done    chan struct{}
+}
+
+func newMQTTPublisher(broker, channel string, api *twitchAPI, bus *eventBus) *mqttPublisher {
+	events, unsubscribe := bus.subscribe()
+	p := &mqttPublisher{api: api, prefix: "batybot", channel: channel, events: events, stop: unsubscribe, done: make(chan struct{})}
+	if prefix := os.Getenv("MQTT_PREFIX"); prefix != "" {
+		p.prefix = prefix
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(p.prefix).
+		SetAutoReconnect(true).
+		SetWill(p.topic("status"), "offline", 1, true).
+		SetOnConnectHandler(func(mqtt.Client) {
+			log.Info("connected to mqtt broker")
+			p.discovery()
+			p.publish("status", "online")
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Warnf("lost connection to mqtt broker: %v", err)
+		})
+
+	p.client = mqtt.NewClient(opts)
+
+	return p
+}
+
+func (p *mqttPublisher) topic(name string) string {
+	return p.prefix + "/" + name
+}
+
+// Start connects to the broker and keeps the state up to date until Shutdown.
+func (p *mqttPublisher) Start() error {
+	if t := p.client.Connect(); t.Wait() && t.Error() != nil {
+		return fmt.Errorf("unable to connect to mqtt broker: %w", t.Error())
+	}
+
+	p.polling.Do(func() { go p.poll() })
+
+	for e := range p.events {
+		if e.Type == eventTypeMessage {
+			continue
+		}
+
+		b, err := json.Marshal(e)
+		if err != nil {
+			log.Errorf("unable to encode %s event for mqtt: %v", e.Type, err)
+			continue
+		}
+		p.publish("last_event", string(b))
+	}
+
+	return nil
+}
+
+// Shutdown marks the bot offline and disconnects from the broker, ending
+// Start. The will only covers the bot going away without disconnecting.
+func (p *mqttPublisher) Shutdown(ctx context.Context) error {
+	p.stop()
+	close(p.done)
+
+	if t := p.client.Publish(p.topic("status"), 1, true, "offline"); !t.WaitTimeout(time.Second) {
+		log.Warn("timed out publishing offline status to mqtt")
+	}
+	p.client.Disconnect(250)
+
+	return nil
+}
+
+// poll checks whether the stream is live, and its viewer count, every
+// minute until Shutdown. onStreamOnline and onStreamOffline update it sooner
+// with EventSub.
+func (p *mqttPublisher) poll() {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+
+	for {
+		if id, err := p.api.userID(p.channel); err != nil {
+			log.Errorf("unable to get channel for mqtt: %v", err)
+		} else {
+			stream, err := p.api.stream(id)
+			p.setLive(err == nil)
+			if err == nil {
+				p.publish("viewers", strconv.Itoa(stream.ViewerCount))
+			}
+		}
+
+		select {
+		case <-p.done:
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+func (p *mqttPublisher) setLive(live bool) {
+	if !live {
+		p.publish("live", "OFF")
+		p.publish("viewers", "0")
+		return
+	}
+
+	p.publish("live", "ON")
+}
+
+func (p *mqttPublisher) onStreamOnline(json.RawMessage) {
+	p.setLive(true)
+}
+
+func (p *mqttPublisher) onStreamOffline(json.RawMessage) {
+	p.setLive(false)
+}
+
+// publish sends a retained message to the topic under the prefix without
+// waiting for it to be delivered.
+func (p *mqttPublisher) publish(name, payload string) {
+	t := p.client.Publish(p.topic(name), 1, true, payload)
+	go func() {
+		if t.Wait() && t.Error() != nil {
+			log.Errorf("unable to publish %s to mqtt: %v", name, t.Error())
+		}
+	}()
+}
+
+// discovery tells Home Assistant about the sensors the bot publishes.
+func (p *mqttPublisher) discovery() {
+	device := map[string]interface{}{
+		"identifiers": []string{p.prefix},
+		"name":        p.prefix,
+		"model":       "batybot",
+	}
+
+	sensors := []struct {
+		component, id string
+		config        map[string]interface{}
+	}{
+		{"binary_sensor", "live", map[string]interface{}{
+			"name":         p.channel + " live",
+			"device_class": "running",
+		}},
+		{"sensor", "viewers", map[string]interface{}{
+			"name":                p.channel + " viewers",
+			"state_class":         "measurement",
+			"unit_of_measurement": "viewers",
+		}},
+		{"sensor", "last_event", map[string]interface{}{
+			"name":                  p.channel + " last event",
+			"value_template":        "{{ value_json.type }}",
+			"json_attributes_topic": p.topic("last_event"),
+		}},
+	}
+
+	for _, s := range sensors {
+		s.config["unique_id"] = p.prefix + "_" + s.id
+		s.config["state_topic"] = p.topic(s.id)
+		s.config["availability_topic"] = p.topic("status")
+		s.config["device"] = device
+
+		b, err := json.Marshal(s.config)
+		if err != nil {
+			log.Errorf("unable to encode %s discovery: %v", s.id, err)
+			continue
+		}
+
+		t := p.client.Publish(fmt.Sprintf("homeassistant/%s/%s/%s/config", s.component, p.prefix, s.id), 1, true, b)
+		go func(id string) {
+			if t.Wait() && t.Error() != nil {
+				log.Errorf("unable to publish %s discovery: %v", id, t.Error())
+			}
+		}(s.id)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifier pushes operational problems, like the token failing to refresh,
+// to ntfy or Gotify so they're noticed without watching the logs.
+type notifier struct {
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+func newNotifier() *notifier {
+	return &notifier{sent: map[string]time.Time{}}
+}
+
+// send pushes the notification to every service that's configured. The same
+// title isn't sent more than once every 10 minutes so a flapping connection
+// doesn't flood the operator's phone. It waits until it's sent, so it can be
+// used right before the bot exits.
+func (n *notifier) send(title, message string) {
+	ntfy, gotify := os.Getenv("NTFY_URL"), os.Getenv("GOTIFY_URL")
+	if ntfy == "" && gotify == "" {
+		return
+	}
+
+	n.mu.Lock()
+	if time.Since(n.sent[title]) < 10*time.Minute {
+		n.mu.Unlock()
+		return
+	}
+	n.sent[title] = time.Now()
+	n.mu.Unlock()
+
+	if ntfy != "" {
+		if err := sendNtfy(ntfy, title, message); err != nil {
+			log.Errorf("unable to send notification: %v", err)
+		}
+	}
+
+	if gotify != "" {
+		if err := sendGotify(gotify, title, message); err != nil {
+			log.Errorf("unable to send notification: %v", err)
+		}
+	}
+}
+
+// sendNtfy publishes to the ntfy topic at topicURL, e.g. https://ntfy.sh/batybot.
+func sendNtfy(topicURL, title, message string) error {
+	req, err := http.NewRequest(http.MethodPost, topicURL, strings.NewReader(message))
+	if err != nil {
+		return fmt.Errorf("sendNtfy: invalid url: %w", err)
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Priority", "high")
+	req.Header.Set("Tags", "warning")
+	if token := os.Getenv("NTFY_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	if err := doJSON(req, nil); err != nil {
+		return fmt.Errorf("sendNtfy: %w", err)
+	}
+
+	return nil
+}
+
+// sendGotify sends a message to the Gotify server at serverURL as the
+// application GOTIFY_TOKEN belongs to.
+func sendGotify(serverURL, title, message string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    title,
+		"message":  message,
+		"priority": 8,
+	})
+	if err != nil {
+		return fmt.Errorf("sendGotify: unable to encode message: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(serverURL, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("sendGotify: invalid url: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", os.Getenv("GOTIFY_TOKEN"))
+
+	if err := doJSON(req, nil); err != nil {
+		return fmt.Errorf("sendGotify: %w", err)
+	}
+
+	return nil
+}
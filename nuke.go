@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// nukeCommand deletes every recent message containing a phrase. It's run as
+//
+//	!nuke [window=5m] [timeout=10m] phrase
+//
+// where window is how far back to look and timeout, if given, also times out
+// everyone who sent a matching message.
+func nukeCommand(b *bot, client chat.Sender, message twitch.PrivateMessage, args []string) {
+	window := 5 * time.Minute
+	var timeout time.Duration
+
+	for len(args) > 0 {
+		key, value, ok := strings.Cut(args[0], "=")
+		if !ok || (key != "window" && key != "timeout") {
+			break
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			client.Reply(message.Channel, message.ID, fmt.Sprintf("Invalid %s %q", key, value))
+			return
+		}
+
+		if key == "window" {
+			window = d
+		} else {
+			timeout = d
+		}
+
+		args = args[1:]
+	}
+
+	phrase := strings.ToLower(strings.Join(args, " "))
+	if phrase == "" {
+		client.Reply(message.Channel, message.ID, "Usage: !nuke [window=5m] [timeout=10m] phrase")
+		return
+	}
+
+	matches := b.history.since(message.Channel, time.Now().Add(-window), func(m twitch.PrivateMessage) bool {
+		return m.ID != message.ID && !isMod(m.User) && strings.Contains(strings.ToLower(m.Message), phrase)
+	})
+
+	// This makes an API call per message, so don't hold up reading chat.
+	go func() {
+		reason := fmt.Sprintf("!nuke %q by %s", phrase, message.User.Name)
+		timedOut := map[string]bool{}
+		deleted := 0
+
+		for _, m := range matches {
+			if err := b.api.deleteMessage(m, reason); err != nil {
+				log.Errorf("unable to nuke message: %v", err)
+				continue
+			}
+			deleted++
+
+			if timeout > 0 && !timedOut[m.User.ID] {
+				if err := b.api.timeout(m.Channel, m.RoomID, m.User, timeout, reason); err != nil {
+					log.Errorf("unable to time out %s: %v", m.User.Name, err)
+					continue
+				}
+				timedOut[m.User.ID] = true
+			}
+		}
+
+		client.Reply(message.Channel, message.ID, fmt.Sprintf("Nuked %d messages and timed out %d chatters", deleted, len(timedOut)))
+	}()
+}
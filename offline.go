@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// offline is what the bot does differently while the channel is offline.
+type offline struct {
+	// Commands are custom commands only answered while the channel is
+	// offline, like one pointing at the schedule.
+	Commands []string       `json:"commands"`
+	Timers   []offlineTimer `json:"timers"`
+}
+
+// offlineTimer is a message posted every so often while the channel is
+// offline.
+type offlineTimer struct {
+	Message string `json:"message"`
+	Every   string `json:"every"` // duration, e.g. 30m
+}
+
+// offlineOnly reports whether the custom command is only answered while the
+// channel is offline.
+func (o offline) offlineOnly(name string) bool {
+	for _, command := range o.Commands {
+		if strings.ToLower(strings.TrimPrefix(command, "!")) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runOfflineTimers posts the config's offline timers to the channel while it's
+// offline. Each waits its every after the bot starts, and after that only
+// posts again if someone's chatted since it last did, so an empty chat isn't
+// filled with them. Nothing's posted while chat's in panic mode.
+func runOfflineTimers(b *bot, client chat.Sender, channel string) error {
+	posted := map[string]time.Time{}
+	for range time.Tick(time.Minute) {
+		if b.live.isLive() || b.panics.active(channel) {
+			continue
+		}
+
+		for _, t := range b.config.get().Offline.Timers {
+			every, err := time.ParseDuration(t.Every)
+			if err != nil || every <= 0 {
+				continue
+			}
+
+			last, ok := posted[t.Message]
+			if !ok {
+				posted[t.Message] = time.Now()
+				continue
+			} else if time.Since(last) < every {
+				continue
+			}
+
+			chatted := b.history.since(channel, last, func(twitch.PrivateMessage) bool { return true })
+			if len(chatted) == 0 {
+				continue
+			}
+
+			client.SayPriority(channel, t.Message, chat.PriorityLow)
+			posted[t.Message] = time.Now()
+		}
+	}
+
+	return nil
+}
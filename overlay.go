@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+//go:embed web/overlay.html
+var overlayPage []byte
+
+// alert is how a type of event is shown on the overlay. In Text and Speak
+// {user}, {amount}, {tier}, and {message} are replaced from the event.
+type alert struct {
+	Text     string `json:"text"`
+	Image    string `json:"image,omitempty"`    // URL of an image shown above the text
+	Duration string `json:"duration,omitempty"` // how long it's shown, 5s if unset
+	Speak    string `json:"speak,omitempty"`    // read out with the config's tts
+}
+
+// overlayAlert is an alert ready to be shown by the overlay page.
+type overlayAlert struct {
+	Text     string  `json:"text"`
+	Image    string  `json:"image,omitempty"`
+	Audio    string  `json:"audio,omitempty"` // URL of audio to play with it
+	Volume   float64 `json:"volume,omitempty"`
+	Duration int64   `json:"duration"` // milliseconds
+}
+
+func (a alert) render(e event) overlayAlert {
+	d := 5 * time.Second
+	if a.Duration != "" {
+		var err error
+		if d, err = time.ParseDuration(a.Duration); err != nil {
+			log.Errorf("invalid duration for %s alert: %v", e.Type, err)
+			d = 5 * time.Second
+		}
+	}
+
+	r := strings.NewReplacer(
+		"{user}", e.User,
+		"{amount}", strconv.Itoa(e.Amount),
+		"{tier}", e.Tier,
+		"{message}", e.Message,
+	)
+
+	return overlayAlert{Text: r.Replace(a.Text), Image: a.Image, Duration: d.Milliseconds()}
+}
+
+func (a alert) speech(e event) string {
+	return strings.NewReplacer(
+		"{user}", e.User,
+		"{amount}", strconv.Itoa(e.Amount),
+		"{tier}", e.Tier,
+		"{message}", e.Message,
+	).Replace(a.Speak)
+}
+
+// overlay serves a page at /overlay, to be used as an OBS browser source, that
+// shows alerts for the events configured in the config file's alerts.
+type overlay struct {
+	http.Server
+
+	config   *configManager
+	live     *liveState
+	features *featureSet
+	bus      *eventBus
+	mood     *moodTracker
+	upgrader websocket.Upgrader
+	audio    *audioStore
+	sounds   *soundPlayer
+
+	mu       sync.Mutex
+	clients  map[chan interface{}]bool
+	watching sync.Once // the watchers outlive restarts of the server
+}
+
+// overlayMood is chat's mood, sent to the overlay every moodInterval for
+// pages showing it.
+type overlayMood struct {
+	Type string `json:"type"` // always mood, where alerts have none
+	chatMood
+}
+
+// moodInterval is how often chat's mood is sent to the overlay.
+const moodInterval = 10 * time.Second
+
+func newOverlay(addr string, b *bot) *overlay {
+	o := &overlay{
+		config:   b.config,
+		live:     b.live,
+		features: b.features,
+		bus:      b.bus,
+		mood:     b.mood,
+		upgrader: websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+		audio:    newAudioStore(),
+		clients:  map[chan interface{}]bool{},
+	}
+	o.sounds = &soundPlayer{config: b.config, overlay: o, played: map[string]time.Time{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/overlay", o.page)
+	mux.HandleFunc("/overlay/ws", o.socket)
+	mux.Handle("/overlay/audio/", o.audio)
+	if dir := os.Getenv("SOUNDS_DIR"); dir != "" {
+		mux.Handle("/overlay/sounds/", http.StripPrefix("/overlay/sounds/", http.FileServer(http.Dir(dir))))
+	}
+
+	o.Addr = addr
+	o.Handler = mux
+
+	return o
+}
+
+func (o *overlay) Start() error {
+	o.watching.Do(func() {
+		go o.watch()
+		go o.watchMood()
+	})
+
+	return fmt.Errorf("unable to start overlay: %w", o.ListenAndServe())
+}
+
+// watch shows an alert and plays the sound for every event that has them
+// configured.
+func (o *overlay) watch() {
+	events, _ := o.bus.subscribe()
+	for e := range events {
+		if a, ok := o.config.get().Alerts[e.Type]; ok {
+			o.alert(a, e)
+		}
+
+		o.sounds.play(e.Type)
+	}
+}
+
+// watchMood sends chat's mood in each channel to the overlay pages.
+func (o *overlay) watchMood() {
+	for range time.Tick(moodInterval) {
+		for _, m := range o.mood.all(time.Now()) {
+			o.send(overlayMood{Type: "mood", chatMood: m})
+		}
+	}
+}
+
+// alert renders the alert for the event, speaking it if it has speech, and
+// shows it.
+func (o *overlay) alert(a alert, e event) {
+	rendered := a.render(e)
+
+	if a.Speak != "" && o.config.get().active(featureSounds, o.features, o.live) {
+		audio, contentType, err := o.config.get().TTS.speak(context.Background(), a.speech(e))
+		if err != nil {
+			log.WithFields(logrus.Fields{"channel": e.Channel, "event_type": e.Type}).Errorf("unable to speak %s alert: %v", e.Type, err)
+		} else {
+			rendered.Audio = "/overlay/audio/" + o.audio.add(audio, contentType)
+		}
+	}
+
+	o.show(rendered)
+}
+
+// show sends the alert to every open overlay page. Its audio is dropped while
+// sounds are muted.
+func (o *overlay) show(a overlayAlert) {
+	if !o.config.get().active(featureSounds, o.features, o.live) {
+		a.Audio = ""
+	}
+
+	if a.Text == "" && a.Image == "" && a.Audio == "" {
+		return
+	}
+
+	o.send(a)
+}
+
+// send sends v to every open overlay page.
+func (o *overlay) send(v interface{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for ch := range o.clients {
+		select {
+		case ch <- v:
+		default:
+			log.Warnf("dropped %T for a slow overlay", v)
+		}
+	}
+}
+
+func (o *overlay) page(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(overlayPage)
+}
+
+func (o *overlay) socket(w http.ResponseWriter, r *http.Request) {
+	conn, err := o.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debugf("unable to upgrade overlay connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	messages := make(chan interface{}, 10)
+	o.mu.Lock()
+	o.clients[messages] = true
+	o.mu.Unlock()
+
+	defer func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		delete(o.clients, messages)
+	}()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case m := <-messages:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(m); err != nil {
+				log.Debugf("unable to write to overlay: %v", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gempir/go-twitch-irc/v4"
+	"github.com/nicklaw5/helix/v2"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// panicMode locks chat down during hate raids and spam waves, and remembers
+// each channel's previous chat settings so they can be put back afterwards.
+type panicMode struct {
+	modlog *modLog
+
+	mu       sync.Mutex
+	previous map[string]helix.ChatSettings
+}
+
+const (
+	panicFollowMinutes = 10
+	panicSlowSeconds   = 30
+)
+
+// active reports whether the channel is in panic mode, in which case the bot
+// shouldn't add to the noise in chat.
+func (p *panicMode) active(channel string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Chat's channels are lowercase, but TWITCH_CHANNEL might not be.
+	_, ok := p.previous[strings.ToLower(channel)]
+	return ok
+}
+
+func (p *panicMode) start(api *twitchAPI, channel, broadcasterID, by string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.previous[channel]; ok {
+		return nil
+	}
+
+	previous, err := api.chatSettings(broadcasterID)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+
+	on := true
+	follow, slow := panicFollowMinutes, panicSlowSeconds
+	err = api.updateChatSettings(broadcasterID, helix.UpdateChatSettingsParams{
+		SubscriberMode:       &on,
+		FollowerMode:         &on,
+		FollowerModeDuration: &follow,
+		SlowMode:             &on,
+		SlowModeWaitTime:     &slow,
+	})
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+
+	p.previous[channel] = previous
+	p.modlog.record(modAction{Channel: channel, Action: "panic", Moderator: by})
+
+	return nil
+}
+
+func (p *panicMode) stop(api *twitchAPI, channel, broadcasterID, by string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous, ok := p.previous[channel]
+	if !ok {
+		return nil
+	}
+
+	err := api.updateChatSettings(broadcasterID, helix.UpdateChatSettingsParams{
+		SubscriberMode:       &previous.SubscriberMode,
+		FollowerMode:         &previous.FollowerMode,
+		FollowerModeDuration: nonZero(previous.FollowerModeDuration),
+		SlowMode:             &previous.SlowMode,
+		SlowModeWaitTime:     nonZero(previous.SlowModeWaitTime),
+	})
+	if err != nil {
+		return fmt.Errorf("stop: %w", err)
+	}
+
+	delete(p.previous, channel)
+	p.modlog.record(modAction{Channel: channel, Action: "unpanic", Moderator: by})
+
+	return nil
+}
+
+// nonZero returns a pointer to i, or nil if it's 0 since Twitch rejects a
+// duration for a mode that's being turned off.
+func nonZero(i int) *int {
+	if i == 0 {
+		return nil
+	}
+
+	return &i
+}
+
+func panicCommand(b *bot, client chat.Sender, message twitch.PrivateMessage, args []string) {
+	go func() {
+		if err := b.panics.start(b.api, message.Channel, message.RoomID, message.User.Name); err != nil {
+			log.Errorf("unable to start panic mode: %v", err)
+			client.Reply(message.Channel, message.ID, "Unable to lock down chat")
+			return
+		}
+
+		client.ClearQueue(message.Channel)
+		client.SayPriority(message.Channel, "Chat is locked down, use !unpanic to restore it", chat.PriorityHigh)
+	}()
+}
+
+func unpanicCommand(b *bot, client chat.Sender, message twitch.PrivateMessage, args []string) {
+	go func() {
+		if err := b.panics.stop(b.api, message.Channel, message.RoomID, message.User.Name); err != nil {
+			log.Errorf("unable to stop panic mode: %v", err)
+			client.Reply(message.Channel, message.ID, "Unable to restore chat settings")
+			return
+		}
+
+		client.SayPriority(message.Channel, "Chat settings restored", chat.PriorityHigh)
+	}()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// stateDir is where the bot keeps files it writes itself, like the token:
+// STATE_DIR if it's set, otherwise $XDG_STATE_HOME/batybot, which defaults
+// to ~/.local/state/batybot. macOS and Windows don't separate state from
+// config, so their config directory is used there.
+func stateDir() (string, error) {
+	if dir := os.Getenv("STATE_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "batybot"), nil
+	}
+
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("stateDir: %w", err)
+		}
+		return filepath.Join(dir, "batybot"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("stateDir: %w", err)
+	}
+
+	return filepath.Join(home, ".local", "state", "batybot"), nil
+}
+
+// defaultConfigFile returns $XDG_CONFIG_HOME/batybot/config.json, or the
+// platform's equivalent, if it exists so CONFIG_FILE doesn't have to be set.
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	file := filepath.Join(dir, "batybot", "config.json")
+	if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+		return ""
+	} else if err != nil {
+		log.Warnf("unable to check for %s: %v", file, err)
+		return ""
+	}
+
+	return file
+}
+
+// writeStateFile writes b to file, creating the state directory it's in if
+// need be, by way of a temporary file so it's never left half written.
+func writeStateFile(file string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return fmt.Errorf("writeStateFile: unable to create state directory: %w", err)
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("writeStateFile: unable to write %q: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		return fmt.Errorf("writeStateFile: unable to replace %q: %w", file, err)
+	}
+
+	return nil
+}
+
+// saveState writes what's only saved every so often, so none of it's lost
+// when the bot stops.
+func saveState(b *bot) {
+	if err := b.brain.save(); err != nil {
+		log.Errorf("unable to save chatter: %v", err)
+	}
+	if err := b.emotes.save(); err != nil {
+		log.Errorf("unable to save emote usage: %v", err)
+	}
+	if err := b.analytics.save(time.Now()); err != nil {
+		log.Errorf("unable to save analytics: %v", err)
+	}
+}
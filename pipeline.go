@@ -0,0 +1,515 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// chatContext is a chat message on its way through the pipeline.
+type chatContext struct {
+	message twitch.PrivateMessage
+	client  chat.Sender
+	config  config
+	bot     *bot
+
+	// privileged is whether the message can run mod only commands.
+	privileged bool
+}
+
+// sent is when the message was sent. Going by that rather than now keeps
+// cooldowns right when a log's replayed faster than it happened.
+func (c *chatContext) sent() time.Time {
+	if c.message.Time.IsZero() {
+		return time.Now()
+	}
+
+	return c.message.Time
+}
+
+// middleware is a step chat messages go through. It passes the message on to
+// the next step by calling next, or stops it there by returning without.
+type middleware func(c *chatContext, next func())
+
+// defaultPipeline is the built in steps, in the order messages go through
+// them unless the config's pipeline says otherwise.
+var defaultPipeline = []string{
+	"history",   // remember the message for !nuke and send it to the event bus
+	"chatlog",   // keep it in CHATLOG_DIR for !search
+	"ignore",    // drop messages from the config's ignore list
+	"toxicity",  // check messages with the config's toxicity filter
+	"words",     // act on filtered words, stopping there if there were any
+	"panic",     // stop there while chat's locked down, unless it's a mod command
+	"mood",      // score how chat's feeling for !mood
+	"stats",     // count messages and chatters for !chatstats
+	"emotes",    // count the emotes used for !topemotes
+	"pyramids",  // congratulate or sabotage emote pyramids
+	"combos",    // announce emote combos
+	"raffle",    // enter chatters who type the raffle's keyword, and run !raffle
+	"offline",   // drop offline only commands while live
+	"cooldown",  // drop commands from chatters that ran one too recently
+	"commands",  // run !commands, stopping there if it was one
+	"exec",      // run the config's program !commands, stopping there if it was one
+	"giveaway",  // run !giveaway, !enter, and !odds, stopping there if it was one
+	"bingo",     // run !bingo and !mark, stopping there if it was one
+	"wordgames", // answer word games, and run !scramble, !hangman, and !guess
+	"fun",       // run the fun commands like !8ball and !roll, stopping there if it was one
+	"weather",   // run !weather, stopping there if it was one
+	"currency",  // run !convert, stopping there if it was one
+	"chatter",   // learn from chat for !chatter, and run it
+	"translate", // translate messages in other languages, and run !translate
+	"scripts",   // run the scripts' !commands, stopping there if it was one
+	"wasm",      // pass it to the wasm plugins, stopping there if one says to
+	"triggers",  // emote responses such as BatJAM
+	"mention",   // respond to being mentioned
+}
+
+// pipelineSteps are the steps plugins add. Unless the config sets the
+// pipeline, messages go through them after the built in ones, in the order
+// they were registered.
+type pipelineSteps struct {
+	mu    sync.RWMutex
+	steps map[string]middleware
+	order []string // names in the order they were registered
+}
+
+// register adds a step messages can go through.
+func (p *pipelineSteps) register(name string, m middleware) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if isMiddleware(name) || p.steps[name] != nil {
+		panic(fmt.Sprintf("register: %q is already registered", name))
+	}
+
+	p.steps[name] = m
+	p.order = append(p.order, name)
+}
+
+func (p *pipelineSteps) get(name string) (middleware, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	m, ok := p.steps[name]
+	return m, ok
+}
+
+// has reports whether name is a built in step or one that's registered.
+func (p *pipelineSteps) has(name string) bool {
+	_, ok := p.get(name)
+	return isMiddleware(name) || ok
+}
+
+// isMiddleware reports whether name is a built in step.
+func isMiddleware(name string) bool {
+	for _, n := range defaultPipeline {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pipeline is the order messages go through the steps with the config.
+func (p *pipelineSteps) pipeline(c config) []string {
+	if len(c.Pipeline) > 0 {
+		return c.Pipeline
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return append(append([]string{}, defaultPipeline...), p.order...)
+}
+
+// chatHandler is what the bot does with each chat message, by passing it
+// through the steps of the pipeline.
+type chatHandler struct {
+	bot    *bot
+	client chat.Sender
+	config *configManager
+
+	// modCommands is whether mods can run mod only commands. Replays don't
+	// allow them, since they'd act on the live channel.
+	modCommands bool
+
+	steps map[string]middleware
+
+	mu          sync.Mutex
+	lastMention time.Time
+	lastCommand map[string]time.Time // by user ID
+
+	replies sync.WaitGroup // AI replies being generated
+}
+
+func newChatHandler(b *bot, client chat.Sender, modCommands bool) *chatHandler {
+	h := &chatHandler{
+		bot:         b,
+		client:      client,
+		config:      b.config,
+		modCommands: modCommands,
+		lastCommand: map[string]time.Time{},
+	}
+
+	h.steps = map[string]middleware{
+		"history":   h.record,
+		"chatlog":   h.logChat,
+		"ignore":    h.ignore,
+		"toxicity":  h.toxicity,
+		"words":     h.filterWords,
+		"mood":      h.mood,
+		"stats":     h.chatStats,
+		"emotes":    h.countEmotes,
+		"pyramids":  h.pyramids,
+		"combos":    h.combos,
+		"raffle":    h.runRaffle,
+		"offline":   h.offline,
+		"cooldown":  h.cooldown,
+		"commands":  h.runCommands,
+		"exec":      h.runExec,
+		"giveaway":  h.runGiveaway,
+		"bingo":     h.runBingo,
+		"wordgames": h.runWordGames,
+		"fun":       h.runFun,
+		"weather":   h.runWeather,
+		"currency":  h.runCurrency,
+		"chatter":   h.runChatter,
+		"translate": h.runTranslate,
+		"scripts":   h.runScripts,
+		"wasm":      h.runWasm,
+		"panic":     h.panicMode,
+		"triggers":  h.triggers,
+		"mention":   h.mention,
+	}
+
+	return h
+}
+
+func (h *chatHandler) onMessage(message twitch.PrivateMessage) {
+	log.Debugln(message.Channel, message.User.Name, message.Message)
+	h.bot.status.heard()
+
+	c := &chatContext{
+		message:    message,
+		client:     h.client,
+		config:     h.config.get(),
+		bot:        h.bot,
+		privileged: h.modCommands && isMod(message.User),
+	}
+	h.run(c, h.bot.steps.pipeline(c.config))
+}
+
+// run passes the message to the first of the steps, which passes it on to
+// the rest.
+func (h *chatHandler) run(c *chatContext, steps []string) {
+	if len(steps) == 0 {
+		return
+	}
+
+	m, ok := h.steps[steps[0]]
+	if !ok {
+		m, ok = h.bot.steps.get(steps[0])
+	}
+	if !ok {
+		log.Warnf("skipping unknown pipeline step %q", steps[0])
+		h.run(c, steps[1:])
+		return
+	}
+
+	m(c, func() { h.run(c, steps[1:]) })
+}
+
+func (h *chatHandler) record(c *chatContext, next func()) {
+	h.bot.history.add(c.message)
+	h.bot.bus.onPrivateMessage(c.message)
+
+	next()
+}
+
+// logChat keeps the message in the chat log, unless it's being replayed.
+func (h *chatHandler) logChat(c *chatContext, next func()) {
+	if h.modCommands {
+		h.bot.chatlog.add(c.message)
+	}
+
+	next()
+}
+
+func (h *chatHandler) ignore(c *chatContext, next func()) {
+	for _, user := range c.config.Ignore {
+		if strings.EqualFold(user, c.message.User.Name) {
+			return
+		}
+	}
+
+	next()
+}
+
+// toxicity queues the message to be checked by the toxicity filter, which
+// acts on it later. Mods aren't checked.
+func (h *chatHandler) toxicity(c *chatContext, next func()) {
+	if c.config.Toxicity.enabled() && !isMod(c.message.User) {
+		h.bot.toxicity.check(toxicityCheck{message: c.message, config: c.config.Toxicity, api: h.bot.api, dryRun: !h.modCommands})
+	}
+
+	next()
+}
+
+func (h *chatHandler) filterWords(c *chatContext, next func()) {
+	if filterWords(c, !h.modCommands) {
+		return
+	}
+
+	next()
+}
+
+func (h *chatHandler) mood(c *chatContext, next func()) {
+	if _, _, ok := parseCommand(c.message.Message); !ok {
+		h.bot.mood.add(c.message, c.sent())
+	}
+
+	next()
+}
+
+func (h *chatHandler) chatStats(c *chatContext, next func()) {
+	h.bot.stats.add(c.message, c.sent())
+
+	next()
+}
+
+func (h *chatHandler) countEmotes(c *chatContext, next func()) {
+	h.bot.emotes.add(c.message, c.sent())
+
+	next()
+}
+
+func (h *chatHandler) pyramids(c *chatContext, next func()) {
+	watchPyramids(c)
+
+	next()
+}
+
+func (h *chatHandler) combos(c *chatContext, next func()) {
+	watchCombos(c)
+
+	next()
+}
+
+func (h *chatHandler) offline(c *chatContext, next func()) {
+	if name, _, ok := parseCommand(c.message.Message); ok && c.config.Offline.offlineOnly(name) && h.bot.live.isLive() {
+		log.Debugf("not answering %s while live", name)
+		return
+	}
+
+	next()
+}
+
+// cooldown drops commands from chatters who ran one less than the config's
+// command_cooldown ago. Mods don't have to wait.
+func (h *chatHandler) cooldown(c *chatContext, next func()) {
+	cooldown, _ := time.ParseDuration(c.config.CommandCooldown)
+	if _, _, ok := parseCommand(c.message.Message); !ok || cooldown <= 0 || isMod(c.message.User) {
+		next()
+		return
+	}
+
+	sent := c.sent()
+	h.mu.Lock()
+	last := h.lastCommand[c.message.User.ID]
+	waiting := sent.Sub(last) < cooldown
+	if !waiting {
+		h.lastCommand[c.message.User.ID] = sent
+	}
+	h.mu.Unlock()
+
+	if waiting {
+		log.Debugf("%s ran a command too soon after the last", c.message.User.Name)
+		return
+	}
+
+	next()
+}
+
+func (h *chatHandler) runCommands(c *chatContext, next func()) {
+	if runCommand(h.bot, c.client, c.message, c.privileged) {
+		return
+	}
+
+	next()
+}
+
+func (h *chatHandler) runExec(c *chatContext, next func()) {
+	if runExec(c) {
+		return
+	}
+
+	next()
+}
+
+func (h *chatHandler) runChatter(c *chatContext, next func()) {
+	if runChatter(c) {
+		return
+	}
+
+	next()
+}
+
+func (h *chatHandler) runRaffle(c *chatContext, next func()) {
+	if runRaffle(c) {
+		return
+	}
+
+	next()
+}
+
+func (h *chatHandler) runGiveaway(c *chatContext, next func()) {
+	if runGiveaway(c) {
+		return
+	}
+
+	next()
+}
+
+// runBingo runs !bingo and !mark, replying with cards instead of whispering
+// them in replays.
+func (h *chatHandler) runBingo(c *chatContext, next func()) {
+	if runBingo(c, !h.modCommands) {
+		return
+	}
+
+	next()
+}
+
+// runWordGames doesn't give out points in replays.
+func (h *chatHandler) runWordGames(c *chatContext, next func()) {
+	if runWordGames(c, !h.modCommands) {
+		return
+	}
+
+	next()
+}
+
+func (h *chatHandler) runFun(c *chatContext, next func()) {
+	if runFun(c) {
+		return
+	}
+
+	next()
+}
+
+func (h *chatHandler) runWeather(c *chatContext, next func()) {
+	if runWeather(c) {
+		return
+	}
+
+	next()
+}
+
+func (h *chatHandler) runCurrency(c *chatContext, next func()) {
+	if runCurrency(c) {
+		return
+	}
+
+	next()
+}
+
+func (h *chatHandler) runTranslate(c *chatContext, next func()) {
+	if runTranslate(c) {
+		return
+	}
+
+	next()
+}
+
+func (h *chatHandler) runScripts(c *chatContext, next func()) {
+	if h.bot.scripts.runCommand(c) {
+		return
+	}
+
+	next()
+}
+
+func (h *chatHandler) runWasm(c *chatContext, next func()) {
+	if h.bot.wasm.handleMessage(c) {
+		return
+	}
+
+	next()
+}
+
+// panicMode stops messages while chat's locked down, so nothing after it
+// responds. Mods' built in commands, like !unpanic, still get through.
+func (h *chatHandler) panicMode(c *chatContext, next func()) {
+	if h.bot.panics.active(c.message.Channel) {
+		name, _, ok := parseCommand(c.message.Message)
+		if cmd, known := commands[name]; !ok || !known || !cmd.modOnly || !c.privileged {
+			return
+		}
+	}
+
+	next()
+}
+
+func (h *chatHandler) triggers(c *chatContext, next func()) {
+	msg := strings.ToLower(c.message.Message)
+	switch {
+	case !c.config.active(featureTriggers, c.bot.features, c.bot.live):
+	case strings.Contains(msg, "batjam"):
+		c.client.SayPriority(c.message.Channel, "BatJAM BatJAM BatJAM", chat.PriorityLow)
+	case strings.Contains(msg, "batpop"):
+		c.client.SayPriority(c.message.Channel, "BatPop BatPop BatPop", chat.PriorityLow)
+	case strings.HasSuffix(msg, "batg"):
+		c.client.SayPriority(c.message.Channel, "very interesting BatG", chat.PriorityLow)
+	}
+
+	next()
+}
+
+// mention responds to being mentioned, at most every 5 minutes, or with the
+// config's AI replies if they're set up, as often as their cooldown allows.
+func (h *chatHandler) mention(c *chatContext, next func()) {
+	if c.config.active(featureMention, c.bot.features, c.bot.live) && strings.Contains(strings.ToLower(c.message.Message), "batybot") {
+		ai := c.config.AI
+		cooldown := 5 * time.Minute
+		if ai.enabled() {
+			cooldown = ai.cooldown()
+		}
+
+		sent := c.sent()
+		h.mu.Lock()
+		answer := sent.Sub(h.lastMention) > cooldown
+		if answer {
+			h.lastMention = sent
+		}
+		h.mu.Unlock()
+
+		switch {
+		case !answer:
+		case ai.enabled():
+			// Models can take a while, so don't hold up chat.
+			messages := ai.messages(c)
+			h.replies.Add(1)
+			go func() {
+				defer h.replies.Done()
+
+				text, err := ai.reply(messages)
+				if err != nil {
+					log.Errorf("unable to get an AI reply: %v", err)
+					return
+				} else if text != "" {
+					c.client.Reply(c.message.Channel, c.message.ID, text)
+				}
+			}()
+		default:
+			c.client.Reply(c.message.Channel, c.message.ID, "What? No, I'm awake BatPls")
+		}
+	}
+
+	next()
+}
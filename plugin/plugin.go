@@ -0,0 +1,110 @@
+// Package plugin lets a fork of the bot add commands, chat steps, and event
+// handlers without changing the bot's own files. A plugin implements one or
+// more of CommandProvider, Middleware, and EventHandler and registers itself,
+// usually from an init function:
+//
+//	func init() {
+//		plugin.Register(dice{})
+//	}
+//
+// The bot picks up every plugin registered by the time it starts, so all a
+// fork needs is a file next to the bot's main.go importing the plugin:
+//
+//	package main
+//
+//	import _ "example.com/batybot-dice"
+package plugin
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+)
+
+// Chat sends messages to chat.
+type Chat interface {
+	Say(channel, text string)
+	Reply(channel, parentID, text string)
+	// Announce sends an announcement, where color is blue, green, orange,
+	// purple, or primary.
+	Announce(channel, text, color string)
+}
+
+// Command is a !command.
+type Command struct {
+	Name    string // without the !
+	ModOnly bool
+	Run     func(chat Chat, message twitch.PrivateMessage, args []string)
+}
+
+// CommandProvider adds !commands.
+type CommandProvider interface {
+	Commands() []Command
+}
+
+// Middleware is a step chat messages go through. HandleMessage passes the
+// message on to the next step by calling next, or stops it there by returning
+// without. Name is how the config's pipeline refers to it.
+type Middleware interface {
+	Name() string
+	HandleMessage(chat Chat, message twitch.PrivateMessage, next func())
+}
+
+// Types of Event.
+const (
+	EventMessage = "message"
+	EventSub     = "sub"
+	EventGift    = "gift"
+	EventRaid    = "raid"
+	EventCheer   = "cheer"
+	EventFollow  = "follow"
+)
+
+// Event is something that happened in a channel, the same as what the bot's
+// event stream sends.
+type Event struct {
+	Type    string    `json:"type"`
+	Channel string    `json:"channel"`
+	User    string    `json:"user,omitempty"`
+	UserID  string    `json:"user_id,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Amount  int       `json:"amount,omitempty"` // bits, raiders, months subbed, or subs gifted
+	Tier    string    `json:"tier,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// EventHandler is given every event, one at a time. Events that arrive while
+// it's busy are dropped if it falls too far behind.
+type EventHandler interface {
+	HandleEvent(e Event)
+}
+
+var (
+	mu      sync.Mutex
+	plugins []interface{}
+)
+
+// Register adds a plugin. It panics if p doesn't implement any of
+// CommandProvider, Middleware, or EventHandler.
+func Register(p interface{}) {
+	switch p.(type) {
+	case CommandProvider, Middleware, EventHandler:
+	default:
+		panic(fmt.Sprintf("plugin.Register: %T isn't a CommandProvider, Middleware, or EventHandler", p))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	plugins = append(plugins, p)
+}
+
+// Registered returns the plugins in the order they were registered.
+func Registered() []interface{} {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return append([]interface{}{}, plugins...)
+}
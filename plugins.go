@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+	"github.com/losinggeneration/batybot/plugin"
+)
+
+// loadPlugins adds the commands and chat steps of the registered plugins to
+// the bot. Their event handlers are started by startPluginEvents.
+func loadPlugins(b *bot) error {
+	for _, p := range plugin.Registered() {
+		if provider, ok := p.(plugin.CommandProvider); ok {
+			for _, c := range provider.Commands() {
+				if err := addCommand(b.commands, c); err != nil {
+					return fmt.Errorf("loadPlugins: %T: %w", p, err)
+				}
+			}
+		}
+
+		if m, ok := p.(plugin.Middleware); ok {
+			if b.steps.has(m.Name()) {
+				return fmt.Errorf("loadPlugins: %T: pipeline step %q already exists", p, m.Name())
+			}
+
+			b.steps.register(m.Name(), func(c *chatContext, next func()) {
+				m.HandleMessage(c.client, c.message, next)
+			})
+		}
+	}
+
+	return nil
+}
+
+func addCommand(commands map[string]command, c plugin.Command) error {
+	name := strings.ToLower(strings.TrimPrefix(c.Name, "!"))
+	if name == "" || c.Run == nil {
+		return fmt.Errorf("addCommand: command needs a name and Run")
+	} else if _, ok := commands[name]; ok {
+		return fmt.Errorf("addCommand: !%s already exists", name)
+	}
+
+	commands[name] = command{
+		modOnly: c.ModOnly,
+		run: func(_ *bot, client chat.Sender, message twitch.PrivateMessage, args []string) {
+			c.Run(client, message, args)
+		},
+	}
+
+	return nil
+}
+
+// isPluginStep reports whether a registered plugin adds the pipeline step,
+// for checking the config before the plugins are loaded.
+func isPluginStep(name string) bool {
+	for _, p := range plugin.Registered() {
+		if m, ok := p.(plugin.Middleware); ok && m.Name() == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isCommand reports whether name is a built in command or one a registered
+// plugin adds.
+func isCommand(name string) bool {
+	if _, ok := commands[name]; ok {
+		return true
+	}
+
+	for _, p := range plugin.Registered() {
+		provider, ok := p.(plugin.CommandProvider)
+		if !ok {
+			continue
+		}
+		for _, c := range provider.Commands() {
+			if strings.EqualFold(strings.TrimPrefix(c.Name, "!"), name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// startPluginEvents passes every event on the bus from now on to the plugins
+// that handle them.
+func startPluginEvents(bus *eventBus) {
+	for _, p := range plugin.Registered() {
+		h, ok := p.(plugin.EventHandler)
+		if !ok {
+			continue
+		}
+
+		events, _ := bus.subscribe()
+		go func() {
+			for e := range events {
+				h.HandleEvent(plugin.Event(e))
+			}
+		}()
+	}
+}
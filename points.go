@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// pointsEntry is a chatter's points in a channel.
+type pointsEntry struct {
+	User   string `json:"user"`
+	Points int    `json:"points"`
+}
+
+// pointsStore keeps the points chatters have won in each channel, saved to
+// points.json in the state directory.
+type pointsStore struct {
+	mu     sync.Mutex
+	loaded bool
+	file   string                             // empty to keep them in memory
+	points map[string]map[string]*pointsEntry // by channel, then user ID
+}
+
+// loadLocked reads the points saved before, the first time they're needed.
+func (p *pointsStore) loadLocked() {
+	if p.loaded {
+		return
+	}
+	p.loaded = true
+	p.points = map[string]map[string]*pointsEntry{}
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Warnf("points will only be kept in memory: %v", err)
+		return
+	}
+	p.file = filepath.Join(dir, "points.json")
+
+	data, err := os.ReadFile(p.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Errorf("unable to read points: %v", err)
+		return
+	}
+
+	if err := json.Unmarshal(data, &p.points); err != nil {
+		log.Errorf("invalid points in %q: %v", p.file, err)
+		p.points = map[string]map[string]*pointsEntry{}
+	}
+}
+
+func (p *pointsStore) saveLocked() error {
+	if p.file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(p.points)
+	if err != nil {
+		return fmt.Errorf("save: unable to encode points: %w", err)
+	}
+
+	if err := writeStateFile(p.file, data); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+
+	return nil
+}
+
+// add gives the user n points in the channel, returning how many they have.
+// They're saved right away, since they're won rarely enough.
+func (p *pointsStore) add(channel string, user twitch.User, n int) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loadLocked()
+
+	users := p.points[channel]
+	if users == nil {
+		users = map[string]*pointsEntry{}
+		p.points[channel] = users
+	}
+
+	e := users[user.ID]
+	if e == nil {
+		e = &pointsEntry{}
+		users[user.ID] = e
+	}
+	e.User = user.DisplayName
+	e.Points += n
+
+	if err := p.saveLocked(); err != nil {
+		return e.Points, fmt.Errorf("add: %w", err)
+	}
+
+	return e.Points, nil
+}
+
+// get returns the user's points in the channel.
+func (p *pointsStore) get(channel, userID string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loadLocked()
+
+	if e := p.points[channel][userID]; e != nil {
+		return e.Points
+	}
+
+	return 0
+}
+
+// top returns the n chatters with the most points in the channel.
+func (p *pointsStore) top(channel string, n int) []pointsEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loadLocked()
+
+	top := make([]pointsEntry, 0, len(p.points[channel]))
+	for _, e := range p.points[channel] {
+		top = append(top, *e)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Points != top[j].Points {
+			return top[i].Points > top[j].Points
+		}
+		return top[i].User < top[j].User
+	})
+
+	if len(top) > n {
+		top = top[:n]
+	}
+
+	return top
+}
+
+// purge removes the user's points in every channel, returning how many
+// channels they had them in.
+func (p *pointsStore) purge(userID string) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loadLocked()
+
+	purged := 0
+	for _, users := range p.points {
+		if _, ok := users[userID]; ok {
+			delete(users, userID)
+			purged++
+		}
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+
+	if err := p.saveLocked(); err != nil {
+		return purged, fmt.Errorf("purge: %w", err)
+	}
+
+	return purged, nil
+}
+
+// all returns a copy of everyone's points, by channel, then user ID.
+func (p *pointsStore) all() map[string]map[string]pointsEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loadLocked()
+
+	all := make(map[string]map[string]pointsEntry, len(p.points))
+	for channel, users := range p.points {
+		all[channel] = make(map[string]pointsEntry, len(users))
+		for id, e := range users {
+			all[channel][id] = *e
+		}
+	}
+
+	return all
+}
+
+// export writes everyone's points to w as JSON, or as CSV with a row for each
+// chatter in each channel.
+func (p *pointsStore) export(w io.Writer, format string) error {
+	all := p.all()
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(all)
+	case "csv":
+		c := csv.NewWriter(w)
+		c.Write([]string{"channel", "user_id", "user", "points"})
+		for _, channel := range sortedKeys(all) {
+			for _, id := range sortedKeys(all[channel]) {
+				e := all[channel][id]
+				c.Write([]string{channel, id, e.User, strconv.Itoa(e.Points)})
+			}
+		}
+		c.Flush()
+		return c.Error()
+	}
+
+	return fmt.Errorf("export: unknown format %q", format)
+}
+
+// pointsCommand replies with the chatter's points, or with !points top, the
+// channel's top five.
+func pointsCommand(b *bot, client chat.Sender, message twitch.PrivateMessage, args []string) {
+	if len(args) == 0 || !strings.EqualFold(args[0], "top") {
+		client.Reply(message.Channel, message.ID, fmt.Sprintf("You have %d points", b.points.get(message.Channel, message.User.ID)))
+		return
+	}
+
+	top := b.points.top(message.Channel, 5)
+	if len(top) == 0 {
+		client.Reply(message.Channel, message.ID, "No one has any points yet")
+		return
+	}
+
+	parts := make([]string, 0, len(top))
+	for i, e := range top {
+		parts = append(parts, strconv.Itoa(i+1)+". "+e.User+" "+strconv.Itoa(e.Points))
+	}
+	client.Reply(message.Channel, message.ID, strings.Join(parts, ", "))
+}
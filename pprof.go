@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// newProfiler serves net/http/pprof's profiles at /debug/pprof/ for diagnosing
+// a long running bot. It has no authentication, so it should only listen
+// locally.
+func newProfiler(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return &http.Server{Addr: addr, Handler: mux}
+}
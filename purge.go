@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// purgeJSONLines rewrites file without the lines that match, leaving it alone
+// if none do.
+func purgeJSONLines[T any](file string, match func(T) bool) error {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("purgeJSONLines: unable to open %q: %w", file, err)
+	}
+	defer f.Close()
+
+	var kept bytes.Buffer
+	purged := false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var v T
+		if err := json.Unmarshal(s.Bytes(), &v); err != nil {
+			return fmt.Errorf("purgeJSONLines: invalid entry in %q: %w", file, err)
+		}
+
+		if match(v) {
+			purged = true
+			continue
+		}
+		kept.Write(s.Bytes())
+		kept.WriteByte('\n')
+	}
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("purgeJSONLines: unable to read %q: %w", file, err)
+	}
+
+	if !purged {
+		return nil
+	}
+
+	if err := writeStateFile(file, kept.Bytes()); err != nil {
+		return fmt.Errorf("purgeJSONLines: %w", err)
+	}
+
+	return nil
+}
+
+// purgeResult is how much was deleted from each place a user's data is kept.
+type purgeResult map[string]int
+
+func (r purgeResult) String() string {
+	parts := make([]string, 0, len(r))
+	for _, name := range sortedKeys(r) {
+		parts = append(parts, fmt.Sprintf("%s: %d", name, r[name]))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// purgeUser deletes everything the bot keeps about the Twitch user with the
+// ID, for deletion requests: their messages in the chat log, recent history,
+// !chatstats, and !chatter, moderation actions against them, their part in
+// the analytics, raffles they won, and their points. It keeps going if one of
+// them fails, returning the first error.
+func purgeUser(b *bot, userID string) (purgeResult, error) {
+	userID = strings.TrimSpace(userID)
+	if userID == "" {
+		return nil, fmt.Errorf("purgeUser: a user ID is required")
+	}
+
+	result := purgeResult{
+		"history":    b.history.purge(userID),
+		"chat stats": b.stats.purge(userID),
+	}
+
+	var first error
+	stores := []struct {
+		name  string
+		purge func(string) (int, error)
+	}{
+		{"chat log", b.chatlog.purge},
+		{"moderation log", b.modlog.purge},
+		{"chatter", b.brain.purge},
+		{"analytics", b.analytics.purge},
+		{"raffle winners", b.winners.purge},
+		{"points", b.points.purge},
+	}
+	for _, s := range stores {
+		n, err := s.purge(userID)
+		result[s.name] = n
+		if err != nil && first == nil {
+			first = fmt.Errorf("purgeUser: %s: %w", s.name, err)
+		}
+	}
+
+	log.Infof("purged user %s: %s", userID, result)
+
+	return result, first
+}
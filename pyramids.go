@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultPyramidHeight = 3
+
+// pyramid is the config for what the bot does about emote pyramids in a
+// channel, built by one chatter as "Kappa", "Kappa Kappa", "Kappa Kappa
+// Kappa", "Kappa Kappa", "Kappa". Action is congratulate, which answers a
+// finished pyramid, sabotage, which breaks it up once it's min_height high, or
+// none. Message can use {user}, {emote}, and {height}.
+type pyramid struct {
+	Action    string `json:"action"`
+	MinHeight int    `json:"min_height"` // default 3
+	Message   string `json:"message"`
+}
+
+func (p pyramid) minHeight() int {
+	if p.MinHeight <= 0 {
+		return defaultPyramidHeight
+	}
+
+	return p.MinHeight
+}
+
+func (p pyramid) message(user, emote string, height int) string {
+	message := p.Message
+	if message == "" {
+		message = "{user} built a {height} high {emote} pyramid! PogChamp"
+		if p.Action == "sabotage" {
+			message = "No pyramids today, {user} BatPls"
+		}
+	}
+
+	return replaceVars(message, map[string]string{
+		"{user}":   user,
+		"{emote}":  emote,
+		"{height}": strconv.Itoa(height),
+	})
+}
+
+// pyramidFor returns the config's pyramid for the channel, or the one for *
+// if the channel doesn't have its own.
+func pyramidFor(pyramids map[string]pyramid, channel string) (pyramid, bool) {
+	if p, ok := pyramids[strings.ToLower(channel)]; ok {
+		return p, true
+	}
+
+	p, ok := pyramids["*"]
+	return p, ok
+}
+
+// pyramidProgress is the pyramid being built in a channel.
+type pyramidProgress struct {
+	user       string
+	emote      string
+	height     int // of the last step
+	peak       int // once it's on the way down
+	descending bool
+}
+
+// pyramidTracker follows the pyramids being built in each channel. Anyone
+// else saying something in between steps breaks the pyramid.
+type pyramidTracker struct {
+	mu       sync.Mutex
+	building map[string]*pyramidProgress // by channel
+}
+
+// pyramidStep returns the word that makes up the whole message and how many
+// times it's there, or false if the message isn't a step of a pyramid.
+func pyramidStep(text string) (string, int, bool) {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return "", 0, false
+	}
+
+	for _, w := range words[1:] {
+		if w != words[0] {
+			return "", 0, false
+		}
+	}
+
+	return words[0], len(words), true
+}
+
+// add follows the message's part in the channel's pyramid. It returns the
+// pyramid's height when it's finished or, with sabotage, when it's reached
+// minHeight on the way up. Otherwise it returns 0.
+func (t *pyramidTracker) add(channel, user, text string, minHeight int, sabotage bool) (string, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	emote, n, ok := pyramidStep(text)
+	p := t.building[channel]
+	if !ok || p == nil || p.user != user || p.emote != emote {
+		delete(t.building, channel)
+		if ok && n == 1 {
+			t.building[channel] = &pyramidProgress{user: user, emote: emote, height: 1}
+		}
+		return "", 0
+	}
+
+	switch {
+	case !p.descending && n == p.height+1:
+		p.height = n
+		if sabotage && n >= minHeight {
+			delete(t.building, channel)
+			return emote, n
+		}
+	case n == p.height-1 && p.height >= 2:
+		if !p.descending {
+			p.descending = true
+			p.peak = p.height
+		}
+		p.height = n
+		if n == 1 {
+			delete(t.building, channel)
+			if p.peak >= minHeight {
+				return emote, p.peak
+			}
+		}
+	default:
+		delete(t.building, channel)
+		if n == 1 {
+			t.building[channel] = &pyramidProgress{user: user, emote: emote, height: 1}
+		}
+	}
+
+	return "", 0
+}
+
+// watchPyramids congratulates chatters on the pyramids they finish, or breaks
+// them up, as the config says for the channel.
+func watchPyramids(c *chatContext) {
+	p, ok := pyramidFor(c.config.Pyramids, c.message.Channel)
+	if !ok || p.Action == "" || p.Action == "none" {
+		return
+	}
+
+	emote, height := c.bot.pyramids.add(c.message.Channel, c.message.User.ID, c.message.Message, p.minHeight(), p.Action == "sabotage")
+	if height == 0 {
+		return
+	}
+
+	c.client.Say(c.message.Channel, p.message(c.message.User.DisplayName, emote, height))
+}
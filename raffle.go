@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+)
+
+const (
+	// defaultRaffleWinnerCooldown is how long before a raffle winner can win
+	// again if the config doesn't say.
+	defaultRaffleWinnerCooldown = 7 * 24 * time.Hour
+	// maxRaffleWinners is how many past winners are kept.
+	maxRaffleWinners = 1000
+)
+
+// raffle is the config for !raffle.
+type raffle struct {
+	WinnerCooldown string `json:"winner_cooldown"` // before a winner can win again, default 168h
+}
+
+func (r raffle) winnerCooldown() time.Duration {
+	if d, err := time.ParseDuration(r.WinnerCooldown); err == nil && r.WinnerCooldown != "" {
+		return d
+	}
+
+	return defaultRaffleWinnerCooldown
+}
+
+// raffleWinner is someone who won a raffle.
+type raffleWinner struct {
+	Time    time.Time `json:"time"`
+	Channel string    `json:"channel"`
+	UserID  string    `json:"user_id"`
+	User    string    `json:"user"`
+	Prize   string    `json:"prize"`
+}
+
+// raffleWinners keeps who won raffles, saved to raffle_winners.json in the
+// state directory, so they can't win again too soon.
+type raffleWinners struct {
+	mu      sync.Mutex
+	loaded  bool
+	file    string         // empty to keep them in memory
+	winners []raffleWinner // oldest first
+}
+
+// loadLocked reads the winners saved before, the first time they're needed.
+func (w *raffleWinners) loadLocked() {
+	if w.loaded {
+		return
+	}
+	w.loaded = true
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Warnf("raffle winners will only be kept in memory: %v", err)
+		return
+	}
+	w.file = filepath.Join(dir, "raffle_winners.json")
+
+	data, err := os.ReadFile(w.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Errorf("unable to read raffle winners: %v", err)
+		return
+	}
+
+	if err := json.Unmarshal(data, &w.winners); err != nil {
+		log.Errorf("invalid raffle winners in %q: %v", w.file, err)
+		w.winners = nil
+	}
+}
+
+func (w *raffleWinners) saveLocked() error {
+	if w.file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(w.winners)
+	if err != nil {
+		return fmt.Errorf("save: unable to encode raffle winners: %w", err)
+	}
+
+	if err := writeStateFile(w.file, data); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+
+	return nil
+}
+
+// add records the winner, saving it right away since raffles are rare.
+func (w *raffleWinners) add(winner raffleWinner) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.loadLocked()
+
+	w.winners = append(w.winners, winner)
+	if len(w.winners) > maxRaffleWinners {
+		w.winners = w.winners[len(w.winners)-maxRaffleWinners:]
+	}
+
+	return w.saveLocked()
+}
+
+// won reports whether the user won a raffle in the channel since then.
+func (w *raffleWinners) won(channel, userID string, since time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.loadLocked()
+
+	for i := len(w.winners) - 1; i >= 0 && w.winners[i].Time.After(since); i-- {
+		if w.winners[i].Channel == channel && w.winners[i].UserID == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recent returns the channel's last n winners, newest first.
+func (w *raffleWinners) recent(channel string, n int) []raffleWinner {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.loadLocked()
+
+	var recent []raffleWinner
+	for i := len(w.winners) - 1; i >= 0 && len(recent) < n; i-- {
+		if w.winners[i].Channel == channel {
+			recent = append(recent, w.winners[i])
+		}
+	}
+
+	return recent
+}
+
+// purge removes the user's wins, returning how many there were.
+func (w *raffleWinners) purge(userID string) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.loadLocked()
+
+	kept := make([]raffleWinner, 0, len(w.winners))
+	for _, winner := range w.winners {
+		if winner.UserID != userID {
+			kept = append(kept, winner)
+		}
+	}
+	purged := len(w.winners) - len(kept)
+	if purged == 0 {
+		return 0, nil
+	}
+	w.winners = kept
+
+	if err := w.saveLocked(); err != nil {
+		return purged, fmt.Errorf("purge: %w", err)
+	}
+
+	return purged, nil
+}
+
+// raffleDraw is a channel's raffle, which everyone has the same odds in.
+type raffleDraw struct {
+	giveawayDraw
+	keyword string
+}
+
+// raffleTracker holds the raffle in each channel.
+type raffleTracker struct {
+	mu    sync.Mutex
+	draws map[string]*raffleDraw // by channel
+}
+
+func (t *raffleTracker) open(channel, keyword, prize string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.draws[channel] = &raffleDraw{
+		giveawayDraw: giveawayDraw{prize: prize, open: true, entries: map[string]giveawayEntry{}},
+		keyword:      keyword,
+	}
+}
+
+// close stops entries, reporting whether there was a raffle to close.
+func (t *raffleTracker) close(channel string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.draws[channel]
+	if d == nil || !d.open {
+		return false
+	}
+	d.open = false
+
+	return true
+}
+
+// cancel ends the raffle without a winner, reporting whether there was one.
+func (t *raffleTracker) cancel(channel string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.draws[channel]
+	delete(t.draws, channel)
+
+	return ok
+}
+
+// keyword returns the keyword that enters the channel's raffle, if one's
+// open.
+func (t *raffleTracker) keyword(channel string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.draws[channel]
+	if d == nil || !d.open {
+		return "", false
+	}
+
+	return d.keyword, true
+}
+
+func (t *raffleTracker) enter(channel string, user twitch.User) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.draws[channel]
+	if d == nil || !d.open {
+		return
+	}
+
+	if _, ok := d.entries[user.ID]; !ok {
+		d.order = append(d.order, user.ID)
+		d.entries[user.ID] = giveawayEntry{user: user.DisplayName, weight: 1}
+	}
+}
+
+// draw closes the raffle and picks a winner, returning the prize, the
+// winner's user ID and entry, and how many they were drawn from.
+func (t *raffleTracker) draw(channel string) (string, string, giveawayEntry, int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.draws[channel]
+	if d == nil || len(d.order) == 0 {
+		return "", "", giveawayEntry{}, 0, false
+	}
+	d.open = false
+
+	entered := len(d.order)
+	id := d.order[rand.Intn(entered)]
+	winner := d.entries[id]
+	delete(d.entries, id)
+	for i := range d.order {
+		if d.order[i] == id {
+			d.order = append(d.order[:i:i], d.order[i+1:]...)
+			break
+		}
+	}
+
+	return d.prize, id, winner, entered, true
+}
+
+// status describes the channel's raffle, if there is one.
+func (t *raffleTracker) status(channel string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d := t.draws[channel]
+	if d == nil {
+		return "", false
+	}
+
+	if d.open {
+		return fmt.Sprintf("The raffle for %s is open, type %s to enter. %d entered", d.prize, d.keyword, len(d.entries)), true
+	}
+
+	return fmt.Sprintf("The raffle for %s is closed. %d entered", d.prize, len(d.entries)), true
+}
+
+// runRaffle enters chatters who type the keyword of the channel's raffle, and
+// runs !raffle. Mods run the raffle with !raffle open keyword prize, !raffle
+// close, !raffle draw, which can be run again to draw someone else, and
+// !raffle cancel. Anyone can see the last winners with !raffle winners.
+// Chatters who won within the winner cooldown aren't entered.
+func runRaffle(c *chatContext) bool {
+	channel := c.message.Channel
+	text := strings.TrimSpace(c.message.Message)
+	cooldown := c.config.Raffle.winnerCooldown()
+
+	if keyword, ok := c.bot.raffles.keyword(channel); ok && strings.EqualFold(text, keyword) {
+		if c.bot.winners.won(channel, c.message.User.ID, c.sent().Add(-cooldown)) {
+			log.Debugf("%s won a raffle too recently to enter", c.message.User.Name)
+		} else {
+			c.bot.raffles.enter(channel, c.message.User)
+		}
+		return false
+	}
+
+	name, args, ok := parseCommand(text)
+	if !ok || name != "raffle" {
+		return false
+	}
+
+	reply := func(text string) { c.client.Reply(channel, c.message.ID, text) }
+
+	if len(args) > 0 && strings.EqualFold(args[0], "winners") {
+		recent := c.bot.winners.recent(channel, 5)
+		if len(recent) == 0 {
+			reply("No one's won a raffle yet")
+			return true
+		}
+
+		names := make([]string, 0, len(recent))
+		for _, w := range recent {
+			names = append(names, fmt.Sprintf("%s (%s ago)", w.User, shortDuration(c.sent().Sub(w.Time))))
+		}
+		reply("Last winners: " + strings.Join(names, ", "))
+		return true
+	}
+
+	if len(args) == 0 {
+		status, ok := c.bot.raffles.status(channel)
+		if !ok {
+			status = "There's no raffle"
+		}
+		reply(fmt.Sprintf("%s. Winners can't win again for %s", status, shortDuration(cooldown)))
+		return true
+	}
+
+	if !c.privileged {
+		log.Debugf("%s tried to run mod command raffle %s", c.message.User.Name, args[0])
+		return true
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "open":
+		if len(args) < 2 {
+			reply("Usage: !raffle open keyword [prize]")
+			return true
+		}
+		keyword := args[1]
+		prize := strings.Join(args[2:], " ")
+		if prize == "" {
+			prize = "a prize"
+		}
+		c.bot.raffles.open(channel, keyword, prize)
+		c.client.Say(channel, fmt.Sprintf("A raffle for %s is open, type %s to enter!", prize, keyword))
+	case "close":
+		if !c.bot.raffles.close(channel) {
+			reply("There's no raffle open")
+			return true
+		}
+		status, _ := c.bot.raffles.status(channel)
+		c.client.Say(channel, status)
+	case "draw":
+		prize, id, winner, entered, ok := c.bot.raffles.draw(channel)
+		if !ok {
+			reply("There's no one to draw")
+			return true
+		}
+
+		err := c.bot.winners.add(raffleWinner{Time: c.sent(), Channel: channel, UserID: id, User: winner.user, Prize: prize})
+		if err != nil {
+			log.Errorf("unable to save raffle winner: %v", err)
+		}
+		c.client.Say(channel, fmt.Sprintf("@%s won %s out of %d entered!", winner.user, prize, entered))
+	case "cancel":
+		if !c.bot.raffles.cancel(channel) {
+			reply("There's no raffle")
+			return true
+		}
+		reply("Raffle cancelled")
+	default:
+		reply("Usage: !raffle [open keyword prize|close|draw|cancel|winners]")
+	}
+
+	return true
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+	"github.com/nicklaw5/helix/v2"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// redemption maps a channel point reward, by title or ID, to what the bot does
+// when it's redeemed. In Say, Command, Overlay, and Speak {user} is replaced
+// with who redeemed it and {input} with the text they entered.
+type redemption struct {
+	Reward   string `json:"reward"`
+	Say      string `json:"say,omitempty"`
+	Announce string `json:"announce,omitempty"` // color to send Say as an announcement in
+	Command  string `json:"command,omitempty"`  // run as if a mod sent it
+	Overlay  string `json:"overlay,omitempty"`  // shown as an alert on the overlay
+	Speak    string `json:"speak,omitempty"`    // read out on the overlay with the config's tts
+	Sound    string `json:"sound,omitempty"`    // name of one of the config's sounds to play
+	Toggle   string `json:"toggle,omitempty"`   // feature to switch on or off
+	For      string `json:"for,omitempty"`      // how long until Toggle is switched back, e.g. 10m
+}
+
+func (r redemption) matches(reward helix.EventSubReward) bool {
+	return r.Reward == reward.ID || strings.EqualFold(r.Reward, reward.Title)
+}
+
+// onRedemption returns an EventSub handler that runs every action configured
+// for the redeemed reward.
+func onRedemption(b *bot) func(json.RawMessage) {
+	var client chat.Sender = b.client
+
+	return func(raw json.RawMessage) {
+		var redeemed helix.EventSubChannelPointsCustomRewardRedemptionEvent
+		if err := json.Unmarshal(raw, &redeemed); err != nil {
+			log.WithField("event_type", helix.EventSubTypeChannelPointsCustomRewardRedemptionAdd).Errorf("invalid redemption event: %v", err)
+			return
+		}
+
+		log.Infof("%s redeemed %q", redeemed.UserLogin, redeemed.Reward.Title)
+
+		replacer := strings.NewReplacer("{user}", redeemed.UserName, "{input}", redeemed.UserInput)
+		for _, r := range b.config.get().Redemptions {
+			if !r.matches(redeemed.Reward) {
+				continue
+			}
+
+			switch {
+			case r.Say == "":
+			case r.Announce != "":
+				client.Announce(redeemed.BroadcasterUserLogin, replacer.Replace(r.Say), r.Announce)
+			default:
+				client.Say(redeemed.BroadcasterUserLogin, replacer.Replace(r.Say))
+			}
+
+			if r.Command != "" {
+				runCommand(b, client, twitch.PrivateMessage{
+					User: twitch.User{
+						ID:          redeemed.UserID,
+						Name:        redeemed.UserLogin,
+						DisplayName: redeemed.UserName,
+					},
+					Message: replacer.Replace(r.Command),
+					Channel: redeemed.BroadcasterUserLogin,
+					RoomID:  redeemed.BroadcasterUserID,
+					Time:    time.Now(),
+				}, true)
+			}
+
+			if (r.Overlay != "" || r.Speak != "") && b.overlay != nil {
+				b.overlay.alert(alert{Text: replacer.Replace(r.Overlay), Speak: replacer.Replace(r.Speak)}, event{})
+			}
+
+			if r.Sound != "" && b.overlay != nil {
+				b.overlay.sounds.play(r.Sound)
+			}
+
+			if r.Toggle != "" {
+				r.toggle(b.features)
+			}
+		}
+	}
+}
+
+func (r redemption) toggle(features *featureSet) {
+	on := features.toggle(r.Toggle)
+	log.Infof("%s turned %s by redemption", r.Toggle, onOff(on))
+
+	if r.For == "" {
+		return
+	}
+
+	d, err := time.ParseDuration(r.For)
+	if err != nil {
+		log.Errorf("invalid duration for %q redemption: %v", r.Reward, err)
+		return
+	}
+
+	time.AfterFunc(d, func() {
+		features.set(r.Toggle, !on)
+		log.Infof("%s turned back %s", r.Toggle, onOff(!on))
+	})
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+
+	return "off"
+}
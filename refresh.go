@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// refreshLead returns how long before the token expires to refresh it,
+// TOKEN_REFRESH_LEAD, plus a random part of TOKEN_REFRESH_JITTER so bots
+// sharing a client ID don't all refresh at once.
+func refreshLead() time.Duration {
+	lead := envDuration("TOKEN_REFRESH_LEAD", 10*time.Minute)
+	if jitter := envDuration("TOKEN_REFRESH_JITTER", time.Minute); jitter > 0 {
+		lead += time.Duration(rand.Int63n(int64(jitter)))
+	}
+
+	return lead
+}
+
+// envDuration returns the duration in the environment variable, or def if
+// it's unset or invalid.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Warnf("invalid %s %q, using %v", name, v, def)
+		return def
+	}
+
+	return d
+}
+
+// refreshRequests asks doRefresh to refresh the bot's token straight away,
+// rather than waiting until it's due, and to send the new token back.
+type refreshRequests chan chan<- string
+
+// now refreshes the bot's token after Twitch rejects it and returns the new
+// one.
+func (r refreshRequests) now() (string, error) {
+	done := make(chan string, 1)
+
+	select {
+	case r <- done:
+	case <-time.After(time.Minute):
+		return "", errors.New("now: timed out asking for a refresh")
+	}
+
+	select {
+	case token := <-done:
+		return token, nil
+	case <-time.After(time.Minute):
+		return "", errors.New("now: timed out waiting for the refresh")
+	}
+}
+
+// answerRefreshes sends the new token to everything waiting on it, including
+// anything that asked while it was being refreshed.
+func answerRefreshes(requests refreshRequests, waiting []chan<- string, token string) {
+	for {
+		select {
+		case done := <-requests:
+			waiting = append(waiting, done)
+			continue
+		default:
+		}
+
+		break
+	}
+
+	for _, done := range waiting {
+		done <- token
+	}
+}
+
+// refreshingClient is the HTTP client for the bot's Helix requests. When
+// Twitch answers 401 it refreshes the token straight away and tries the
+// request once more with the new one.
+type refreshingClient struct {
+	http.Client
+	refreshes refreshRequests
+}
+
+func (c *refreshingClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.Client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		return resp, err
+	}
+
+	log.Warnf("%s %s was unauthorized, refreshing the token", req.Method, req.URL.Path)
+
+	token, err := c.refreshes.now()
+	if err != nil {
+		log.Errorf("unable to refresh token: %v", err)
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	retry.Header.Set("Authorization", "Bearer "+strings.TrimPrefix(token, "oauth:"))
+
+	return c.Client.Do(retry)
+}
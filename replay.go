@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// replaySender prints what the bot would have said instead of saying it.
+type replaySender struct {
+	out  io.Writer
+	sent int
+	at   time.Time // when the message being handled was sent
+}
+
+func (r *replaySender) print(channel, format string, args ...any) {
+	r.sent++
+	fmt.Fprintf(r.out, "%s #%s %s\n", r.at.Format("15:04:05"), strings.TrimPrefix(channel, "#"), fmt.Sprintf(format, args...))
+}
+
+func (r *replaySender) Say(channel, text string) {
+	r.print(channel, "say: %s", text)
+}
+
+func (r *replaySender) SayPriority(channel, text string, _ chat.Priority) {
+	r.print(channel, "say: %s", text)
+}
+
+func (r *replaySender) Reply(channel, parentID, text string) {
+	r.print(channel, "reply to %s: %s", parentID, text)
+}
+
+func (r *replaySender) Announce(channel, text, color string) {
+	r.print(channel, "announce (%s): %s", color, text)
+}
+
+func (r *replaySender) ClearQueue(string) {}
+
+// readChatLog reads the messages in a chat log, either raw IRC lines with
+// tags, one per line as Twitch sends them, or a VOD's chat exported as JSON
+// by TwitchDownloader.
+func readChatLog(r io.Reader) ([]twitch.PrivateMessage, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("readChatLog: %w", err)
+		}
+
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			br.ReadByte()
+			continue
+		case '{':
+			return readChatExport(br)
+		}
+
+		return readIRCLog(br)
+	}
+}
+
+// readIRCLog reads the PRIVMSG lines of an IRC log, skipping the rest.
+func readIRCLog(r io.Reader) ([]twitch.PrivateMessage, error) {
+	var messages []twitch.PrivateMessage
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		if message, ok := twitch.ParseMessage(line).(*twitch.PrivateMessage); ok {
+			messages = append(messages, *message)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("readIRCLog: %w", err)
+	}
+
+	return messages, nil
+}
+
+// chatExport is the part of a TwitchDownloader chat export the bot needs.
+type chatExport struct {
+	Streamer struct {
+		Name string `json:"name"`
+		ID   any    `json:"id"`
+	} `json:"streamer"`
+	Comments []struct {
+		ID        string    `json:"_id"`
+		CreatedAt time.Time `json:"created_at"`
+		Commenter struct {
+			ID          string `json:"_id"`
+			Name        string `json:"name"`
+			DisplayName string `json:"display_name"`
+		} `json:"commenter"`
+		Message struct {
+			Body       string `json:"body"`
+			UserColor  string `json:"user_color"`
+			UserBadges []struct {
+				ID      string `json:"_id"`
+				Version string `json:"version"`
+			} `json:"user_badges"`
+		} `json:"message"`
+	} `json:"comments"`
+}
+
+func readChatExport(r io.Reader) ([]twitch.PrivateMessage, error) {
+	var export chatExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("readChatExport: invalid chat export: %w", err)
+	}
+
+	channel := strings.ToLower(export.Streamer.Name)
+	// Older exports have the ID as a number.
+	roomID := fmt.Sprint(export.Streamer.ID)
+
+	messages := make([]twitch.PrivateMessage, 0, len(export.Comments))
+	for _, c := range export.Comments {
+		badges := map[string]int{}
+		for _, b := range c.Message.UserBadges {
+			var version int
+			fmt.Sscan(b.Version, &version)
+			badges[b.ID] = version
+		}
+
+		messages = append(messages, twitch.PrivateMessage{
+			User: twitch.User{
+				ID:          c.Commenter.ID,
+				Name:        c.Commenter.Name,
+				DisplayName: c.Commenter.DisplayName,
+				Color:       c.Message.UserColor,
+				Badges:      badges,
+			},
+			Type:    twitch.PRIVMSG,
+			Message: c.Message.Body,
+			Channel: channel,
+			RoomID:  roomID,
+			ID:      c.ID,
+			Time:    c.CreatedAt,
+		})
+	}
+
+	return messages, nil
+}
+
+// replayChat runs the messages through the bot's chat handling, waiting
+// between them as long as there was between them in chat divided by speed,
+// or not at all if speed is 0. It returns how many messages the bot would
+// have sent.
+func replayChat(b *bot, messages []twitch.PrivateMessage, speed float64, out io.Writer) int {
+	sender := &replaySender{out: out}
+	handler := newChatHandler(b, sender, false)
+	b.scripts.setClient(sender, nil)
+	b.wasm.setClient(sender)
+
+	var last time.Time
+	for _, message := range messages {
+		if speed > 0 && !last.IsZero() && message.Time.After(last) {
+			time.Sleep(time.Duration(float64(message.Time.Sub(last)) / speed))
+		}
+		last = message.Time
+
+		sender.at = message.Time
+		handler.onMessage(message)
+	}
+	b.execs.wait()
+	b.fetcher.wg.Wait()
+	handler.replies.Wait()
+	b.toxicity.pending.Wait()
+	b.translator.pending.Wait()
+
+	return sender.sent
+}
+
+// replay runs a chat log through the bot, printing what it would have said,
+// to try out trigger and command changes on real chat.
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := fs.Float64("speed", 1, "how many times faster than it happened to replay the log, 0 for no waiting")
+	channel := fs.String("channel", "", "replay the log as if it were in this channel")
+	live := fs.Bool("live", true, "replay the log as if the channel were live")
+	settings(fs, args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: batybot replay [-speed n] [-channel name] [-live=false] file")
+		os.Exit(2)
+	} else if *speed < 0 {
+		log.Fatal("-speed can't be negative")
+	}
+
+	b, err := loadFiles()
+	if err != nil {
+		log.Fatal(err)
+	}
+	b.live.set(*live)
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	messages, err := readChatLog(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *channel != "" {
+		for i := range messages {
+			messages[i].Channel = strings.ToLower(*channel)
+		}
+	}
+
+	sent := replayChat(b, messages, *speed, os.Stdout)
+	fmt.Printf("replayed %d messages, the bot would have sent %d\n", len(messages), sent)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// schemaFor returns a JSON Schema for values of t, following the json tags of
+// structs the same way encoding/json does.
+func schemaFor(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			} else if name == "" {
+				name = f.Name
+			}
+
+			properties[name] = schemaFor(f.Type)
+		}
+
+		return map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+	}
+
+	return map[string]any{}
+}
+
+// configSchema returns the JSON Schema of the config file.
+func configSchema() map[string]any {
+	s := schemaFor(reflect.TypeOf(config{}))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "batybot config"
+	// So the file can point editors at the schema.
+	s["properties"].(map[string]any)["$schema"] = map[string]any{"type": "string"}
+
+	return s
+}
+
+func printSchema(args []string) {
+	fs := flag.NewFlagSet("config-schema", flag.ExitOnError)
+	fs.Parse(args)
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	if err := e.Encode(configSchema()); err != nil {
+		log.Fatal(err)
+	}
+}
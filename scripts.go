@@ -0,0 +1,619 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	runtimemetrics "runtime/metrics"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+const (
+	// scriptTimeout is how long a script gets to handle a command or event
+	// before it's stopped.
+	scriptTimeout = 5 * time.Second
+	// scriptMemoryLimit is how much the heap can grow while a script handles
+	// something before it's stopped.
+	scriptMemoryLimit = 64 << 20
+	// maxScriptString is the longest string string.rep can make.
+	maxScriptString = 1 << 20
+)
+
+// scriptEngine runs the Lua scripts in SCRIPTS_DIR. Each script has its own
+// Lua state without access to files, the OS, or loading code, and reaches the
+// bot only through the bot table, see newScript.
+type scriptEngine struct {
+	mu      sync.RWMutex
+	dir     string
+	scripts []*script
+	client  chat.Sender
+	api     *twitchAPI // nil when replaying, so scripts can't moderate
+
+	store *scriptStore
+}
+
+// script is one loaded Lua file and what it registered.
+type script struct {
+	name string
+
+	// A Lua state can only run one thing at a time.
+	mu       sync.Mutex
+	L        *lua.LState
+	closed   bool
+	loaded   bool // commands and handlers can't be added after
+	commands map[string]*lua.LFunction
+	handlers map[string][]*lua.LFunction
+}
+
+// setClient sets where scripts' messages are sent.
+func (e *scriptEngine) setClient(client chat.Sender, api *twitchAPI) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.client, e.api = client, api
+}
+
+// load runs every .lua file in dir, replacing the scripts that were loaded
+// before. If any fails to load, the current scripts are kept.
+func (e *scriptEngine) load(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+	sort.Strings(files)
+
+	e.mu.Lock()
+	if e.store == nil {
+		e.store = newScriptStore()
+	}
+	e.mu.Unlock()
+
+	loaded := make([]*script, 0, len(files))
+	closeAll := func() {
+		for _, s := range loaded {
+			s.L.Close()
+		}
+	}
+
+	names := map[string]string{} // script by command
+	for _, file := range files {
+		s, err := e.newScript(file)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("load: %w", err)
+		}
+		loaded = append(loaded, s)
+
+		for name := range s.commands {
+			if other, ok := names[name]; ok {
+				closeAll()
+				return fmt.Errorf("load: !%s is in both %s and %s", name, other, s.name)
+			} else if isCommand(name) {
+				closeAll()
+				return fmt.Errorf("load: %s: !%s is a built in command", s.name, name)
+			}
+			names[name] = s.name
+		}
+	}
+
+	e.mu.Lock()
+	old := e.scripts
+	e.dir = dir
+	e.scripts = loaded
+	e.mu.Unlock()
+
+	for _, s := range old {
+		s.close()
+	}
+
+	log.Infof("loaded %d scripts from %s", len(loaded), dir)
+
+	return nil
+}
+
+// reload loads the scripts from the same directory again.
+func (e *scriptEngine) reload() error {
+	e.mu.RLock()
+	dir := e.dir
+	e.mu.RUnlock()
+
+	return e.load(dir)
+}
+
+// newScript runs the file in a new sandboxed Lua state.
+func (e *scriptEngine) newScript(file string) (*script, error) {
+	s := &script{
+		name: filepath.Base(file),
+		L: lua.NewState(lua.Options{
+			SkipOpenLibs:    true,
+			CallStackSize:   256,
+			RegistryMaxSize: 256 * 1024,
+		}),
+		commands: map[string]*lua.LFunction{},
+		handlers: map[string][]*lua.LFunction{},
+	}
+
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		s.L.Push(s.L.NewFunction(lib.open))
+		s.L.Push(lua.LString(lib.name))
+		s.L.Call(1, 0)
+	}
+	// string.rep could otherwise use up the memory in one go, before the
+	// limit's noticed.
+	s.L.GetGlobal(lua.StringLibName).(*lua.LTable).RawSetString("rep", s.L.NewFunction(luaStringRep))
+	// Nothing from outside the script can be run.
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		s.L.SetGlobal(name, lua.LNil)
+	}
+
+	s.L.SetGlobal("bot", s.L.SetFuncs(s.L.NewTable(), map[string]lua.LGFunction{
+		"command":  s.luaCommand,
+		"on":       s.luaOn,
+		"say":      e.luaSay,
+		"reply":    e.luaReply,
+		"timeout":  e.luaTimeout,
+		"get_user": e.luaGetUser,
+		"get":      e.luaGet,
+		"set":      e.luaSet,
+		"log":      s.luaLog,
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	go limitMemory(ctx, cancel, s.name)
+	s.L.SetContext(ctx)
+	defer s.L.RemoveContext()
+
+	if err := s.L.DoFile(file); err != nil {
+		s.L.Close()
+		return nil, fmt.Errorf("newScript: %s: %w", s.name, err)
+	}
+	s.loaded = true
+
+	return s, nil
+}
+
+func (s *script) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.L.Close()
+}
+
+// call runs fn with the arguments made by args, stopping it if it takes longer
+// than scriptTimeout.
+func (s *script) call(fn *lua.LFunction, args func(L *lua.LState) []lua.LValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		// It was reloaded since it was picked to run.
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	go limitMemory(ctx, cancel, s.name)
+	s.L.SetContext(ctx)
+	defer s.L.RemoveContext()
+
+	if err := s.L.CallByParam(lua.P{Fn: fn, Protect: true}, args(s.L)...); err != nil {
+		log.Errorf("script %s failed: %v", s.name, err)
+	}
+}
+
+// limitMemory stops the script, by cancelling its context, if the heap grows
+// by more than scriptMemoryLimit before ctx is done. Lua states can't limit
+// what they allocate themselves, so it's approximate, since anything else
+// allocating at the same time counts too.
+func limitMemory(ctx context.Context, cancel context.CancelFunc, name string) {
+	heap := []runtimemetrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	runtimemetrics.Read(heap)
+	limit := heap[0].Value.Uint64() + scriptMemoryLimit
+
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if runtimemetrics.Read(heap); heap[0].Value.Uint64() > limit {
+				log.Errorf("script %s is using too much memory, stopping it", name)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// luaStringRep is string.rep, refusing to make strings longer than
+// maxScriptString.
+func luaStringRep(L *lua.LState) int {
+	str := L.CheckString(1)
+	n := L.CheckInt(2)
+	if n > 0 && len(str) > maxScriptString/n {
+		L.RaiseError("string.rep: the result can be at most %d bytes", maxScriptString)
+		return 0
+	}
+	if n < 0 {
+		n = 0
+	}
+
+	L.Push(lua.LString(strings.Repeat(str, n)))
+	return 1
+}
+
+// runCommand runs the scripts' command in the message, reporting whether
+// there was one.
+func (e *scriptEngine) runCommand(c *chatContext) bool {
+	name, args, ok := parseCommand(c.message.Message)
+	if !ok {
+		return false
+	}
+
+	for _, s := range e.loaded() {
+		fn, ok := s.commands[name]
+		if !ok {
+			continue
+		}
+
+		s.call(fn, func(L *lua.LState) []lua.LValue {
+			luaArgs := L.NewTable()
+			for _, arg := range args {
+				luaArgs.Append(lua.LString(arg))
+			}
+
+			return []lua.LValue{messageTable(L, c), luaArgs}
+		})
+
+		return true
+	}
+
+	return false
+}
+
+// onEvent passes the event to the scripts' handlers for its type.
+func (e *scriptEngine) onEvent(ev event) {
+	for _, s := range e.loaded() {
+		for _, fn := range s.handlers[ev.Type] {
+			s.call(fn, func(L *lua.LState) []lua.LValue {
+				t := L.NewTable()
+				t.RawSetString("type", lua.LString(ev.Type))
+				t.RawSetString("channel", lua.LString(ev.Channel))
+				t.RawSetString("user", lua.LString(ev.User))
+				t.RawSetString("user_id", lua.LString(ev.UserID))
+				t.RawSetString("message", lua.LString(ev.Message))
+				t.RawSetString("amount", lua.LNumber(ev.Amount))
+				t.RawSetString("tier", lua.LString(ev.Tier))
+				t.RawSetString("time", lua.LNumber(ev.Time.Unix()))
+
+				return []lua.LValue{t}
+			})
+		}
+	}
+}
+
+func (e *scriptEngine) loaded() []*script {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.scripts
+}
+
+// handleEvents passes every event on the bus from now on to the scripts.
+func (e *scriptEngine) handleEvents(bus *eventBus) {
+	events, _ := bus.subscribe()
+	for ev := range events {
+		e.onEvent(ev)
+	}
+}
+
+func messageTable(L *lua.LState, c *chatContext) *lua.LTable {
+	t := L.NewTable()
+	t.RawSetString("channel", lua.LString(c.message.Channel))
+	t.RawSetString("id", lua.LString(c.message.ID))
+	t.RawSetString("user", lua.LString(c.message.User.Name))
+	t.RawSetString("user_id", lua.LString(c.message.User.ID))
+	t.RawSetString("display_name", lua.LString(c.message.User.DisplayName))
+	t.RawSetString("text", lua.LString(c.message.Message))
+	t.RawSetString("mod", lua.LBool(c.privileged))
+	t.RawSetString("time", lua.LNumber(c.sent().Unix()))
+
+	return t
+}
+
+// bot.command(name, function(msg, args)) handles !name.
+func (s *script) luaCommand(L *lua.LState) int {
+	if s.loaded {
+		L.RaiseError("bot.command can only be called while the script's loading")
+	}
+
+	name := strings.ToLower(strings.TrimPrefix(L.CheckString(1), "!"))
+	s.commands[name] = L.CheckFunction(2)
+
+	return 0
+}
+
+// bot.on(type, function(event)) handles events like sub, raid, and message.
+func (s *script) luaOn(L *lua.LState) int {
+	if s.loaded {
+		L.RaiseError("bot.on can only be called while the script's loading")
+	}
+
+	typ := L.CheckString(1)
+	s.handlers[typ] = append(s.handlers[typ], L.CheckFunction(2))
+
+	return 0
+}
+
+// bot.log(text) logs text.
+func (s *script) luaLog(L *lua.LState) int {
+	log.Infof("script %s: %s", s.name, L.CheckString(1))
+
+	return 0
+}
+
+func (e *scriptEngine) sender() chat.Sender {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.client
+}
+
+func (e *scriptEngine) apiClient() *twitchAPI {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.api
+}
+
+// bot.say(channel, text) sends text to the channel.
+func (e *scriptEngine) luaSay(L *lua.LState) int {
+	channel, text := L.CheckString(1), L.CheckString(2)
+	if client := e.sender(); client != nil {
+		client.Say(channel, text)
+	}
+
+	return 0
+}
+
+// bot.reply(msg, text) replies to a message a command was given.
+func (e *scriptEngine) luaReply(L *lua.LState) int {
+	msg, text := L.CheckTable(1), L.CheckString(2)
+	if client := e.sender(); client != nil {
+		client.Reply(lua.LVAsString(msg.RawGetString("channel")), lua.LVAsString(msg.RawGetString("id")), text)
+	}
+
+	return 0
+}
+
+// bot.timeout(channel, user, seconds, reason) times the user out, returning
+// true, or nil and an error.
+func (e *scriptEngine) luaTimeout(L *lua.LState) int {
+	channel, login := strings.ToLower(L.CheckString(1)), strings.ToLower(L.CheckString(2))
+	d := time.Duration(L.CheckInt(3)) * time.Second
+	reason := L.OptString(4, "")
+
+	err := func() error {
+		api := e.apiClient()
+		if api == nil {
+			return errors.New("not connected to Twitch")
+		}
+
+		broadcasterID, err := api.userID(channel)
+		if err != nil {
+			return err
+		}
+		userID, err := api.userID(login)
+		if err != nil {
+			return err
+		}
+
+		return api.timeout(channel, broadcasterID, twitch.User{ID: userID, Name: login}, d, reason)
+	}()
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// bot.get_user(login) returns a table of the user's id, login, display_name,
+// and created_at, or nil and an error.
+func (e *scriptEngine) luaGetUser(L *lua.LState) int {
+	login := strings.ToLower(L.CheckString(1))
+
+	api := e.apiClient()
+	if api == nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString("not connected to Twitch"))
+		return 2
+	}
+
+	user, err := api.user(login)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	t := L.NewTable()
+	t.RawSetString("id", lua.LString(user.ID))
+	t.RawSetString("login", lua.LString(user.Login))
+	t.RawSetString("display_name", lua.LString(user.DisplayName))
+	t.RawSetString("created_at", lua.LNumber(user.CreatedAt.Unix()))
+	L.Push(t)
+
+	return 1
+}
+
+// bot.get(key) returns the value stored under key, or nil.
+func (e *scriptEngine) luaGet(L *lua.LState) int {
+	switch v := e.store.get(L.CheckString(1)).(type) {
+	case string:
+		L.Push(lua.LString(v))
+	case float64:
+		L.Push(lua.LNumber(v))
+	case bool:
+		L.Push(lua.LBool(v))
+	default:
+		L.Push(lua.LNil)
+	}
+
+	return 1
+}
+
+// bot.set(key, value) stores a string, number, or boolean under key, or
+// removes it when value is nil.
+func (e *scriptEngine) luaSet(L *lua.LState) int {
+	key := L.CheckString(1)
+	if len(key) > maxScriptValue {
+		L.ArgError(1, fmt.Sprintf("must be at most %d bytes", maxScriptValue))
+	}
+
+	var value interface{}
+	switch v := L.Get(2).(type) {
+	case lua.LString:
+		if len(v) > maxScriptValue {
+			L.ArgError(2, fmt.Sprintf("must be at most %d bytes", maxScriptValue))
+		}
+		value = string(v)
+	case lua.LNumber:
+		// JSON can't hold them, so the store couldn't be saved again.
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			L.ArgError(2, "must be a finite number")
+		}
+		value = float64(v)
+	case lua.LBool:
+		value = bool(v)
+	case *lua.LNilType:
+	default:
+		L.ArgError(2, "must be a string, number, boolean, or nil")
+	}
+
+	if err := e.store.set(key, value); errors.Is(err, errScriptStoreFull) {
+		L.RaiseError("bot.set: %v", err)
+	} else if err != nil {
+		log.Errorf("unable to save script store: %v", err)
+	}
+
+	return 0
+}
+
+const (
+	// maxScriptKeys is how many keys scripts can keep with bot.set, so they
+	// can't grow scripts.json without limit.
+	maxScriptKeys = 1000
+	// maxScriptValue is the longest key or string value in bytes.
+	maxScriptValue = 4096
+)
+
+var errScriptStoreFull = fmt.Errorf("the store already has %d keys, remove some with bot.set(key, nil)", maxScriptKeys)
+
+// scriptStore is what scripts keep with bot.set, saved to scripts.json in the
+// state directory so it lasts between restarts.
+type scriptStore struct {
+	mu     sync.Mutex
+	file   string // empty to keep it in memory
+	values map[string]interface{}
+}
+
+func newScriptStore() *scriptStore {
+	s := &scriptStore{values: map[string]interface{}{}}
+
+	dir, err := stateDir()
+	if err != nil {
+		log.Warnf("script store will only be kept in memory: %v", err)
+		return s
+	}
+	s.file = filepath.Join(dir, "scripts.json")
+
+	b, err := os.ReadFile(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s
+	} else if err != nil {
+		log.Errorf("unable to read script store: %v", err)
+		return s
+	}
+
+	if err := json.Unmarshal(b, &s.values); err != nil {
+		log.Errorf("invalid script store in %q: %v", s.file, err)
+	}
+
+	return s
+}
+
+func (s *scriptStore) get(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.values[key]
+}
+
+func (s *scriptStore) all() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := make(map[string]interface{}, len(s.values))
+	for k, v := range s.values {
+		all[k] = v
+	}
+
+	return all
+}
+
+func (s *scriptStore) set(key string, value interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.values[key]; value != nil && !ok && len(s.values) >= maxScriptKeys {
+		return fmt.Errorf("set: %w", errScriptStoreFull)
+	}
+
+	if value == nil {
+		delete(s.values, key)
+	} else {
+		s.values[key] = value
+	}
+
+	if s.file == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.values, "", "  ")
+	if err != nil {
+		return fmt.Errorf("set: unable to encode store: %w", err)
+	}
+
+	if err := writeStateFile(s.file, b); err != nil {
+		return fmt.Errorf("set: %w", err)
+	}
+
+	return nil
+}
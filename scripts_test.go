@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runScript loads a script with the source into an engine with an in memory
+// store.
+func runScript(t *testing.T, store *scriptStore, source string) error {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "test.lua"), []byte(source), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &scriptEngine{store: store}
+	err := e.load(dir)
+	if err == nil {
+		for _, s := range e.scripts {
+			s.close()
+		}
+	}
+
+	return err
+}
+
+func TestLuaSet(t *testing.T) {
+	long := strings.Repeat("a", maxScriptValue+1)
+
+	tests := []struct {
+		name   string
+		source string
+		want   interface{}
+		err    string
+	}{
+		{name: "string", source: `bot.set("k", "v")`, want: "v"},
+		{name: "number", source: `bot.set("k", 1.5)`, want: 1.5},
+		{name: "boolean", source: `bot.set("k", true)`, want: true},
+		{name: "nil removes", source: `bot.set("k", "v") bot.set("k", nil)`, want: nil},
+		{name: "NaN", source: `bot.set("k", 0/0)`, err: "finite"},
+		{name: "infinity", source: `bot.set("k", 1/0)`, err: "finite"},
+		{name: "negative infinity", source: `bot.set("k", -1/0)`, err: "finite"},
+		{name: "table", source: `bot.set("k", {})`, err: "must be a string"},
+		{name: "long value", source: fmt.Sprintf(`bot.set("k", %q)`, long), err: "at most"},
+		{name: "long key", source: fmt.Sprintf(`bot.set(%q, 1)`, long), err: "at most"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &scriptStore{values: map[string]interface{}{}}
+
+			err := runScript(t, store, tt.source)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("got error %v, want one containing %q", err, tt.err)
+			}
+
+			if got := store.get("k"); got != tt.want {
+				t.Errorf("stored %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptStoreFull(t *testing.T) {
+	store := &scriptStore{values: map[string]interface{}{}}
+	for i := 0; i < maxScriptKeys; i++ {
+		store.values[fmt.Sprint(i)] = float64(i)
+	}
+
+	if err := runScript(t, store, `bot.set("0", "replaced")`); err != nil {
+		t.Errorf("replacing a key in a full store: %v", err)
+	}
+	if err := runScript(t, store, `bot.set("new", 1)`); err == nil || !strings.Contains(err.Error(), "already has") {
+		t.Errorf("got error %v adding a key to a full store", err)
+	}
+	if err := runScript(t, store, `bot.set("1", nil) bot.set("new", 1)`); err != nil {
+		t.Errorf("adding a key after removing one: %v", err)
+	}
+	if got := len(store.all()); got != maxScriptKeys {
+		t.Errorf("store has %d keys, want %d", got, maxScriptKeys)
+	}
+}
+
+func TestScriptStoreSave(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "scripts.json")
+	store := &scriptStore{file: file, values: map[string]interface{}{}}
+
+	if err := runScript(t, store, `bot.set("hugs", 3) bot.set("name", "bat")`); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n  \"hugs\": 3,\n  \"name\": \"bat\"\n}"; string(b) != want {
+		t.Errorf("saved %s, want %s", b, want)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// secretVars are the settings that can instead be read from the file named
+// by the same variable with _FILE on the end, like Docker and Kubernetes
+// secrets, so they don't have to be in the environment.
+var secretVars = []string{
+	"TWITCH_TOKEN",
+	"TWITCH_REFRESH",
+	"TWITCH_CLIENT_SECRET",
+	"EVENTSUB_SECRET",
+	"API_TOKEN",
+	"DASHBOARD_PASSWORD",
+	"DISCORD_TOKEN",
+	"MASTODON_TOKEN",
+	"BLUESKY_APP_PASSWORD",
+	"NTFY_TOKEN",
+	"GOTIFY_TOKEN",
+	"MQTT_URL",
+	"SENTRY_DSN",
+	"PERSPECTIVE_API_KEY",
+	"TRANSLATE_API_KEY",
+	"OPENWEATHERMAP_API_KEY",
+	"SMTP_PASSWORD",
+	"LOKI_URL",
+	"ELASTICSEARCH_URL",
+	"ELASTICSEARCH_API_KEY",
+	"VAULT_TOKEN",
+	"TOKEN_KEY",
+}
+
+// loadSecretFiles sets every secret that has a _FILE variable from its file.
+func loadSecretFiles() error {
+	for _, name := range secretVars {
+		file := os.Getenv(name + "_FILE")
+		if file == "" {
+			continue
+		}
+
+		if os.Getenv(name) != "" {
+			return fmt.Errorf("loadSecretFiles: both %s and %s_FILE are set", name, name)
+		}
+
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("loadSecretFiles: unable to read %s_FILE: %w", name, err)
+		}
+
+		if err := os.Setenv(name, strings.TrimRight(string(b), "\r\n")); err != nil {
+			return fmt.Errorf("loadSecretFiles: unable to set %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/sirupsen/logrus"
+)
+
+// sentryHook reports everything logged at error level or above to Sentry, or
+// anything compatible with its API, with the entry's fields, like channel and
+// event_type, as tags.
+type sentryHook struct{}
+
+func (sentryHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (sentryHook) Fire(entry *logrus.Entry) error {
+	event := sentry.NewEvent()
+	event.Message = entry.Message
+	event.Timestamp = entry.Time
+	event.Logger = "logrus"
+
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		event.Level = sentry.LevelFatal
+	default:
+		event.Level = sentry.LevelError
+	}
+
+	for key, value := range entry.Data {
+		event.Tags[key] = fmt.Sprint(value)
+	}
+
+	sentry.CaptureEvent(event)
+
+	// The bot is about to exit, so this is the last chance to send it.
+	if entry.Level <= logrus.FatalLevel {
+		sentry.Flush(2 * time.Second)
+	}
+
+	return nil
+}
+
+// setupSentry starts reporting errors to the Sentry DSN.
+func setupSentry(dsn string) error {
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: os.Getenv("SENTRY_ENVIRONMENT"),
+	})
+	if err != nil {
+		return fmt.Errorf("setupSentry: %w", err)
+	}
+
+	log.AddHook(sentryHook{})
+
+	return nil
+}
+
+// reportPanic sends a panic to Sentry before letting it crash the bot. It has
+// to be deferred at the top of each goroutine that can panic.
+func reportPanic() {
+	if r := recover(); r != nil {
+		sentry.CurrentHub().Recover(r)
+		sentry.Flush(2 * time.Second)
+		panic(r)
+	}
+}
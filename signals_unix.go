@@ -0,0 +1,29 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals makes logging more verbose on SIGUSR1 and less verbose on
+// SIGUSR2, and reloads the config and custom commands on SIGHUP.
+func handleSignals(reload func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
+
+	go func() {
+		for sig := range signals {
+			switch sig {
+			case syscall.SIGUSR1:
+				log.Warnf("log level changed to %s by %s", shiftLogLevel(1), sig)
+			case syscall.SIGUSR2:
+				log.Warnf("log level changed to %s by %s", shiftLogLevel(-1), sig)
+			case syscall.SIGHUP:
+				reload()
+			}
+		}
+	}()
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+// handleSignals does nothing since Windows doesn't have SIGUSR1, SIGUSR2, or
+// SIGHUP. The log level can still be changed with the control API, and the
+// config reloaded by whispering the bot.
+func handleSignals(reload func()) {}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+const defaultGoLiveMessage = "{channel} is live! {title} {url}"
+
+// mastodonPost posts a status to a Mastodon server as the account
+// MASTODON_TOKEN belongs to.
+type mastodonPost struct {
+	Server     string `json:"server"`               // e.g. https://mastodon.social
+	Message    string `json:"message,omitempty"`    // defaultGoLiveMessage if unset
+	Visibility string `json:"visibility,omitempty"` // public, unlisted, private, or direct
+}
+
+func (m mastodonPost) enabled() bool {
+	return m.Server != "" && os.Getenv("MASTODON_TOKEN") != ""
+}
+
+func (m mastodonPost) post(stream helix.Stream) error {
+	message := m.Message
+	if message == "" {
+		message = defaultGoLiveMessage
+	}
+
+	form := url.Values{"status": {streamReplacer(stream).Replace(message)}}
+	if m.Visibility != "" {
+		form.Set("visibility", m.Visibility)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(m.Server, "/")+"/api/v1/statuses", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("post: invalid mastodon server: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("MASTODON_TOKEN"))
+
+	if err := doJSON(req, nil); err != nil {
+		return fmt.Errorf("post: unable to post to mastodon: %w", err)
+	}
+
+	return nil
+}
+
+// blueskyPost posts to Bluesky as Handle, logging in with the app password in
+// BLUESKY_APP_PASSWORD.
+type blueskyPost struct {
+	Handle  string `json:"handle"`
+	Message string `json:"message,omitempty"` // defaultGoLiveMessage if unset
+	PDS     string `json:"pds,omitempty"`     // https://bsky.social if unset
+}
+
+func (b blueskyPost) enabled() bool {
+	return b.Handle != "" && os.Getenv("BLUESKY_APP_PASSWORD") != ""
+}
+
+func (b blueskyPost) post(stream helix.Stream) error {
+	pds := strings.TrimSuffix(b.PDS, "/")
+	if pds == "" {
+		pds = "https://bsky.social"
+	}
+
+	var session struct {
+		AccessJwt string `json:"accessJwt"`
+		DID       string `json:"did"`
+	}
+	err := postJSON(pds+"/xrpc/com.atproto.server.createSession", "", map[string]string{
+		"identifier": b.Handle,
+		"password":   os.Getenv("BLUESKY_APP_PASSWORD"),
+	}, &session)
+	if err != nil {
+		return fmt.Errorf("post: unable to log in to bluesky: %w", err)
+	}
+
+	message := b.Message
+	if message == "" {
+		message = defaultGoLiveMessage
+	}
+	text := streamReplacer(stream).Replace(message)
+
+	record := map[string]interface{}{
+		"$type":     "app.bsky.feed.post",
+		"text":      text,
+		"createdAt": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	// Bluesky doesn't find links itself, they have to be marked by their
+	// byte offsets.
+	link := streamURL(stream)
+	if start := strings.Index(text, link); start >= 0 {
+		record["facets"] = []interface{}{map[string]interface{}{
+			"index": map[string]int{"byteStart": start, "byteEnd": start + len(link)},
+			"features": []interface{}{map[string]string{
+				"$type": "app.bsky.richtext.facet#link",
+				"uri":   link,
+			}},
+		}}
+	}
+
+	err = postJSON(pds+"/xrpc/com.atproto.repo.createRecord", session.AccessJwt, map[string]interface{}{
+		"repo":       session.DID,
+		"collection": "app.bsky.feed.post",
+		"record":     record,
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("post: unable to post to bluesky: %w", err)
+	}
+
+	return nil
+}
+
+// postJSON posts body as JSON, with the bearer token if it's set, and decodes
+// the response into v if it isn't nil.
+func postJSON(u, token string, body, v interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("postJSON: unable to encode body: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("postJSON: invalid url: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return doJSON(req, v)
+}
+
+// doJSON sends the request and decodes the response into v if it isn't nil.
+func doJSON(req *http.Request, v interface{}) error {
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("doJSON: request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("doJSON: unexpected status %s: %s", resp.Status, b)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("doJSON: invalid response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// sound is a file from SOUNDS_DIR played on the overlay for an event type or
+// a redemption.
+type sound struct {
+	File     string  `json:"file"`
+	Volume   float64 `json:"volume,omitempty"`   // from 0 to 1, 1 if unset
+	Cooldown string  `json:"cooldown,omitempty"` // minimum time between plays, e.g. 30s
+}
+
+// soundPlayer plays the config's sounds on the overlay, keeping track of when
+// each was last played for their cooldowns.
+type soundPlayer struct {
+	config  *configManager
+	overlay *overlay
+
+	mu     sync.Mutex
+	played map[string]time.Time
+}
+
+// play shows the sound named in the config's sounds on the overlay, unless
+// sounds are muted or it's cooling down.
+func (s *soundPlayer) play(name string) {
+	c := s.config.get()
+	snd, ok := c.Sounds[name]
+	if !ok || !c.active(featureSounds, s.overlay.features, s.overlay.live) {
+		return
+	}
+
+	var cooldown time.Duration
+	if snd.Cooldown != "" {
+		var err error
+		if cooldown, err = time.ParseDuration(snd.Cooldown); err != nil {
+			log.Errorf("invalid cooldown for %s sound: %v", name, err)
+		}
+	}
+
+	s.mu.Lock()
+	if time.Since(s.played[name]) < cooldown {
+		s.mu.Unlock()
+		log.Debugf("%s sound is cooling down", name)
+		return
+	}
+	s.played[name] = time.Now()
+	s.mu.Unlock()
+
+	volume := snd.Volume
+	if volume <= 0 || volume > 1 {
+		volume = 1
+	}
+
+	s.overlay.show(overlayAlert{
+		Audio:  "/overlay/sounds/" + url.PathEscape(snd.File),
+		Volume: volume,
+	})
+}
+
+// muteAlertsCommand switches alert sounds and speech on the overlay on or off.
+// It's run as
+//
+//	!mutealerts [for]
+//
+// where for, if given, is how long until they're switched back.
+func muteAlertsCommand(b *bot, client chat.Sender, message twitch.PrivateMessage, args []string) {
+	var d time.Duration
+	if len(args) > 0 {
+		var err error
+		if d, err = time.ParseDuration(args[0]); err != nil {
+			client.Reply(message.Channel, message.ID, fmt.Sprintf("Invalid duration %q", args[0]))
+			return
+		}
+	}
+
+	on := b.features.toggle(featureSounds)
+	log.Infof("%s turned alert sounds %s", message.User.Name, onOff(on))
+
+	reply := "Alert sounds muted"
+	if on {
+		reply = "Alert sounds unmuted"
+	}
+
+	if d > 0 {
+		reply += " for " + shortDuration(d)
+		time.AfterFunc(d, func() {
+			b.features.set(featureSounds, !on)
+			log.Infof("alert sounds turned back %s", onOff(!on))
+		})
+	}
+
+	client.Reply(message.Channel, message.ID, reply)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+// botStatus tracks what the bot is up to for anything reporting on it.
+type botStatus struct {
+	mu           sync.RWMutex
+	started      time.Time
+	connected    bool
+	lastHeard    time.Time
+	tokenExpires time.Time
+	channels     map[string]bool
+
+	// appOnly is set when running without chat, see runAppOnly.
+	appOnly bool
+
+	// eventSub is nil when EventSub isn't enabled, otherwise whether the
+	// subscriptions were made.
+	eventSub *bool
+}
+
+// statusReport is a snapshot of botStatus.
+type statusReport struct {
+	Started      time.Time       `json:"started"`
+	Uptime       string          `json:"uptime"`
+	Connected    bool            `json:"connected"`
+	TokenExpires time.Time       `json:"token_expires"`
+	Channels     []string        `json:"channels"`
+	QueueDepth   int             `json:"queue_depth"`
+	Features     map[string]bool `json:"features"`
+	AppOnly      bool            `json:"app_only,omitempty"`
+}
+
+func (s *botStatus) setAppOnly() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appOnly = true
+}
+
+// expectsChat reports whether anything should be heard from chat, which
+// isn't connected to in app only mode.
+func (s *botStatus) expectsChat() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return !s.appOnly
+}
+
+func (s *botStatus) setConnected(connected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.connected = connected
+}
+
+// heard records that something was received from chat, so the connection is
+// still alive.
+func (s *botStatus) heard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastHeard = time.Now()
+}
+
+func (s *botStatus) sinceHeard() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return time.Since(s.lastHeard)
+}
+
+func (s *botStatus) setTokenExpires(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokenExpires = t
+}
+
+func (s *botStatus) setEventSubscribed(subscribed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.eventSub = &subscribed
+}
+
+func (s *botStatus) onSelfJoin(message twitch.UserJoinMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.channels[message.Channel] = true
+}
+
+func (s *botStatus) onSelfPart(message twitch.UserPartMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.channels, message.Channel)
+}
+
+func (s *botStatus) joined() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	channels := make([]string, 0, len(s.channels))
+	for channel := range s.channels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+
+	return channels
+}
+
+// problems returns what's stopping the bot from working, if anything.
+func (s *botStatus) problems() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var problems []string
+	if !s.connected && !s.appOnly {
+		problems = append(problems, "not connected to chat")
+	}
+	if !s.tokenExpires.IsZero() && time.Now().After(s.tokenExpires) {
+		problems = append(problems, "token expired")
+	}
+	if s.eventSub != nil && !*s.eventSub {
+		problems = append(problems, "eventsub not subscribed")
+	}
+
+	return problems
+}
+
+func (s *botStatus) report(client *chat.Client, features *featureSet) statusReport {
+	channels := s.joined()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return statusReport{
+		Started:      s.started,
+		Uptime:       time.Since(s.started).Round(time.Second).String(),
+		Connected:    s.connected,
+		TokenExpires: s.tokenExpires,
+		Channels:     channels,
+		QueueDepth:   client.QueueDepth(),
+		Features: map[string]bool{
+			featureTriggers: features.enabled(featureTriggers),
+			featureMention:  features.enabled(featureMention),
+			featureSounds:   features.enabled(featureSounds),
+		},
+		AppOnly: s.appOnly,
+	}
+}
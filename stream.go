@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// eventStream serves every event on the bus as JSON over a WebSocket at
+// /events, for overlays and companion apps. Connections need the API_TOKEN,
+// as a bearer token or, since browsers can't set headers on WebSockets, in
+// ?token=.
+type eventStream struct {
+	http.Server
+
+	token    string
+	bus      *eventBus
+	upgrader websocket.Upgrader
+}
+
+func newEventStream(addr, token string, bus *eventBus) *eventStream {
+	s := &eventStream{
+		token: token,
+		bus:   bus,
+		// Overlays are often loaded from files or other local origins, so
+		// any is allowed, as the token keeps other pages out.
+		upgrader: websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/events", s)
+
+	s.Addr = addr
+	s.Handler = mux
+
+	return s
+}
+
+func (s *eventStream) Start() error {
+	return fmt.Errorf("unable to start event stream: %w", s.ListenAndServe())
+}
+
+func (s *eventStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	// Without a token it's the dashboard's, which checks its own sessions.
+	if s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debugf("unable to upgrade event stream connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	events, unsubscribe := s.bus.subscribe()
+	defer unsubscribe()
+
+	// Nothing is expected from the client, but reading is how a close is
+	// noticed.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case e := <-events:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(e); err != nil {
+				log.Debugf("unable to write to event stream: %v", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// restartPolicy is what the supervisor does when a service stops on its own.
+type restartPolicy int
+
+const (
+	// restartNever leaves the service stopped and the rest running.
+	restartNever restartPolicy = iota
+	// restartOnFailure starts the service again after it returns an error,
+	// waiting longer each time it fails in a row.
+	restartOnFailure
+	// restartNeverCritical stops the whole bot when the service stops, like
+	// when chat disconnects for good.
+	restartNeverCritical
+)
+
+// service is a long running part of the bot, like one of its servers. run
+// blocks until it stops, and stop, if set, asks it to.
+type service struct {
+	name   string
+	run    func() error
+	stop   func(ctx context.Context) error
+	policy restartPolicy
+}
+
+// supervisor owns the bot's services. They're started in the order they're
+// added and stopped in the reverse, so chat disconnects before the servers
+// it depends on go away.
+type supervisor struct {
+	mu       sync.Mutex
+	services []*service
+	stopping bool
+
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+// shutdownTimeout is how long the services get to stop.
+const shutdownTimeout = 10 * time.Second
+
+// maxBackoff is the longest a failing service waits before it's restarted.
+const maxBackoff = time.Minute
+
+func newSupervisor() *supervisor {
+	return &supervisor{done: make(chan struct{})}
+}
+
+// start runs the service and keeps it running according to its policy.
+func (s *supervisor) start(svc *service) {
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		return
+	}
+	s.services = append(s.services, svc)
+	s.mu.Unlock()
+
+	go s.supervise(svc)
+}
+
+// serve is start for a server with the usual Start and Shutdown methods.
+func (s *supervisor) serve(name string, start func() error, shutdown func(context.Context) error) {
+	s.start(&service{name: name, run: start, stop: shutdown, policy: restartOnFailure})
+}
+
+func (s *supervisor) supervise(svc *service) {
+	backoff := time.Second
+
+	for {
+		err := svc.run()
+		if s.isStopping() || errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+
+		switch {
+		case svc.policy == restartNeverCritical:
+			if err == nil {
+				err = fmt.Errorf("%s stopped", svc.name)
+			}
+			s.fail(err)
+			return
+		case err == nil:
+			log.Infof("%s stopped", svc.name)
+			return
+		case svc.policy == restartNever:
+			log.Error(err)
+			return
+		}
+
+		log.Errorf("%v, restarting %s in %v", err, svc.name, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (s *supervisor) isStopping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stopping
+}
+
+// fail stops everything because of err, which wait then returns.
+func (s *supervisor) fail(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+
+	s.stop()
+}
+
+// stop stops every service, the last started first, giving them
+// shutdownTimeout between them to finish.
+func (s *supervisor) stop() {
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.stopping = true
+		services := s.services
+		s.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		for i := len(services) - 1; i >= 0; i-- {
+			svc := services[i]
+			if svc.stop == nil {
+				continue
+			}
+
+			log.Debugf("stopping %s", svc.name)
+			if err := svc.stop(ctx); err != nil {
+				log.Errorf("unable to stop %s: %v", svc.name, err)
+			}
+		}
+
+		close(s.done)
+	})
+}
+
+// wait blocks until the services have been stopped, returning the error of
+// the critical service that stopped them, if that's why.
+func (s *supervisor) wait() error {
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// sdNotify sends a state, like READY=1, to systemd when it's running the bot
+// as a Type=notify service, and does nothing otherwise.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// Sockets starting with @ are in the abstract namespace.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("sdNotify: unable to connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("sdNotify: unable to notify systemd: %w", err)
+	}
+
+	return nil
+}
+
+// notifyReady tells systemd the bot has started and starts pinging its
+// watchdog, if it's enabled.
+func notifyReady(status *botStatus) {
+	if err := sdNotify("READY=1\nSTATUS=connected to chat"); err != nil {
+		log.Error(err)
+	}
+
+	go watchdog(status)
+}
+
+// watchdog pings systemd's watchdog for as long as the bot is still hearing
+// from chat. If the IRC connection hangs the pings stop and systemd restarts
+// the bot.
+func watchdog(status *botStatus) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	timeout := time.Duration(usec) * time.Microsecond
+	for range time.Tick(timeout / 2) {
+		// Twitch is pinged after 15 seconds without a message, so something
+		// should have been heard from it well within a minute.
+		if since := status.sinceHeard(); since > time.Minute && status.expectsChat() {
+			log.Warnf("nothing heard from chat in %v, not pinging the watchdog", since.Round(time.Second))
+			continue
+		}
+
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// handleShutdown tells systemd the bot is stopping and stops its services
+// when it's asked to exit.
+func handleShutdown(services *supervisor) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		log.Infof("stopping on %s", sig)
+
+		if err := sdNotify("STOPPING=1"); err != nil {
+			log.Error(err)
+		}
+
+		services.stop()
+	}()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// fileStore keeps the tokens in a JSON file. With a key, from TOKEN_KEY or the
+// file in TOKEN_KEY_FILE, the tokens are encrypted with NaCl's secretbox so a
+// leaked backup doesn't give away a working refresh token.
+type fileStore struct {
+	file string
+	key  string
+}
+
+// tokenFile is what's written to the file. Only one of Tokens or Encrypted
+// is set.
+type tokenFile struct {
+	// Version is the format the file was written in, files from before it
+	// was added are 0.
+	Version int `json:"version"`
+
+	// Token is the bot's token in files before version 2.
+	Token *storedToken `json:"token,omitempty"`
+
+	Tokens storedTokens `json:"tokens,omitempty"`
+
+	// Encrypted is the scrypt salt, the nonce, and then the sealed Tokens,
+	// or Token before version 2.
+	Encrypted []byte `json:"encrypted,omitempty"`
+}
+
+const (
+	saltSize  = 16
+	nonceSize = 24
+)
+
+// tokenFileVersion is the format new token files are written in.
+const tokenFileVersion = 2
+
+// tokenFileMigrations upgrade a token file from the version it's indexed by
+// to the next one. A change to the format bumps tokenFileVersion and adds a
+// migration here so existing files keep working.
+var tokenFileMigrations = []func(tf *tokenFile) error{
+	// 0 to 1 only added the version.
+	func(tf *tokenFile) error { return nil },
+
+	// 1 to 2 stores tokens by account, the single token was the bot's.
+	func(tf *tokenFile) error {
+		if tf.Token != nil {
+			tf.Tokens = storedTokens{{Role: roleBot}: *tf.Token}
+			tf.Token = nil
+		}
+		return nil
+	},
+}
+
+// migrate upgrades the decrypted file to tokenFileVersion, returning whether
+// anything changed.
+func (tf *tokenFile) migrate() (bool, error) {
+	migrated := tf.Version < tokenFileVersion
+	for tf.Version < tokenFileVersion {
+		if err := tokenFileMigrations[tf.Version](tf); err != nil {
+			return false, fmt.Errorf("migrate: from version %d: %w", tf.Version, err)
+		}
+		tf.Version++
+	}
+
+	return migrated, nil
+}
+
+func (f fileStore) load() (storedTokens, error) {
+	b, err := os.ReadFile(f.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load: unable to read %q: %w", f.file, err)
+	}
+
+	var tf tokenFile
+	if err := json.Unmarshal(b, &tf); err != nil {
+		return nil, fmt.Errorf("load: invalid token file %q: %w", f.file, err)
+	}
+
+	if tf.Version > tokenFileVersion {
+		return nil, fmt.Errorf("load: %q is version %d, newer than this build supports (%d)", f.file, tf.Version, tokenFileVersion)
+	}
+
+	if err := f.open(&tf); err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+
+	migrated, err := tf.migrate()
+	if err != nil {
+		return nil, fmt.Errorf("load: %q: %w", f.file, err)
+	}
+
+	if migrated && tf.Tokens != nil {
+		log.Infof("upgrading %s to version %d", f.file, tokenFileVersion)
+		if err := f.save(tf.Tokens); err != nil {
+			return nil, fmt.Errorf("load: %w", err)
+		}
+	}
+
+	return tf.Tokens, nil
+}
+
+// open decrypts the file's tokens, if they're encrypted. A plain file is
+// encrypted the next time it's saved if a key has been set since.
+func (f fileStore) open(tf *tokenFile) error {
+	if tf.Encrypted == nil {
+		return nil
+	}
+
+	if f.key == "" {
+		return fmt.Errorf("open: %q is encrypted, set TOKEN_KEY or TOKEN_KEY_FILE", f.file)
+	}
+
+	if len(tf.Encrypted) < saltSize+nonceSize {
+		return fmt.Errorf("open: invalid token file %q: too short", f.file)
+	}
+
+	salt, rest := tf.Encrypted[:saltSize], tf.Encrypted[saltSize:]
+	var nonce [nonceSize]byte
+	copy(nonce[:], rest[:nonceSize])
+
+	key, err := f.deriveKey(salt)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+
+	plain, ok := secretbox.Open(nil, rest[nonceSize:], &nonce, key)
+	if !ok {
+		return fmt.Errorf("open: unable to decrypt %q, is the key right?", f.file)
+	}
+
+	var payload interface{} = &tf.Tokens
+	if tf.Version < 2 {
+		tf.Token = &storedToken{}
+		payload = tf.Token
+	}
+
+	if err := json.Unmarshal(plain, payload); err != nil {
+		return fmt.Errorf("open: invalid tokens in %q: %w", f.file, err)
+	}
+	tf.Encrypted = nil
+
+	return nil
+}
+
+func (f fileStore) save(ts storedTokens) error {
+	tf := tokenFile{Version: tokenFileVersion, Tokens: ts}
+
+	if f.key != "" {
+		plain, err := json.Marshal(ts)
+		if err != nil {
+			return fmt.Errorf("save: unable to encode tokens: %w", err)
+		}
+
+		salt := make([]byte, saltSize)
+		var nonce [nonceSize]byte
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("save: unable to make salt: %w", err)
+		}
+		if _, err := rand.Read(nonce[:]); err != nil {
+			return fmt.Errorf("save: unable to make nonce: %w", err)
+		}
+
+		key, err := f.deriveKey(salt)
+		if err != nil {
+			return fmt.Errorf("save: %w", err)
+		}
+
+		tf = tokenFile{Version: tokenFileVersion, Encrypted: secretbox.Seal(append(salt, nonce[:]...), plain, &nonce, key)}
+	}
+
+	b, err := json.MarshalIndent(tf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("save: unable to encode token file: %w", err)
+	}
+
+	if err := writeStateFile(f.file, b); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+
+	return nil
+}
+
+// deriveKey stretches the passphrase into a secretbox key.
+func (f fileStore) deriveKey(salt []byte) (*[32]byte, error) {
+	b, err := scrypt.Key([]byte(f.key), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("deriveKey: %w", err)
+	}
+
+	var key [32]byte
+	copy(key[:], b)
+
+	return &key, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+var testToken = storedToken{Token: "oauth:token", Refresh: "refresh-secret", Expires: "2030-01-01T00:00:00Z"}
+
+// sealTokens encrypts plain the way fileStore.save does, for writing files in
+// older formats.
+func sealTokens(t *testing.T, f fileStore, plain []byte) []byte {
+	t.Helper()
+
+	salt := make([]byte, saltSize)
+	var nonce [nonceSize]byte
+	key, err := f.deriveKey(salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return secretbox.Seal(append(salt, nonce[:]...), plain, &nonce, key)
+}
+
+func TestTokenFileLoad(t *testing.T) {
+	bot := storedTokens{{Role: roleBot}: testToken}
+	moderator := storedTokens{{Role: roleBot}: testToken, {Role: "moderator", UserID: "42"}: {Token: "oauth:mod"}}
+
+	plainV1, err := json.Marshal(testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainV2, err := json.Marshal(moderator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		file func(f fileStore) tokenFile
+		want storedTokens
+		err  string
+	}{
+		{
+			name: "version 0",
+			file: func(fileStore) tokenFile { return tokenFile{Token: &testToken} },
+			want: bot,
+		},
+		{
+			name: "version 1",
+			file: func(fileStore) tokenFile { return tokenFile{Version: 1, Token: &testToken} },
+			want: bot,
+		},
+		{
+			name: "version 0 encrypted",
+			key:  "passphrase",
+			file: func(f fileStore) tokenFile { return tokenFile{Encrypted: sealTokens(t, f, plainV1)} },
+			want: bot,
+		},
+		{
+			name: "version 1 encrypted",
+			key:  "passphrase",
+			file: func(f fileStore) tokenFile { return tokenFile{Version: 1, Encrypted: sealTokens(t, f, plainV1)} },
+			want: bot,
+		},
+		{
+			name: "version 2",
+			file: func(fileStore) tokenFile { return tokenFile{Version: 2, Tokens: moderator} },
+			want: moderator,
+		},
+		{
+			name: "version 2 encrypted",
+			key:  "passphrase",
+			file: func(f fileStore) tokenFile { return tokenFile{Version: 2, Encrypted: sealTokens(t, f, plainV2)} },
+			want: moderator,
+		},
+		{
+			name: "newer version",
+			file: func(fileStore) tokenFile { return tokenFile{Version: tokenFileVersion + 1, Tokens: moderator} },
+			err:  "newer than this build supports",
+		},
+		{
+			name: "encrypted without a key",
+			file: func(fileStore) tokenFile {
+				return tokenFile{Version: 2, Encrypted: sealTokens(t, fileStore{key: "passphrase"}, plainV2)}
+			},
+			err: "set TOKEN_KEY",
+		},
+		{
+			name: "wrong key",
+			key:  "wrong",
+			file: func(fileStore) tokenFile {
+				return tokenFile{Version: 2, Encrypted: sealTokens(t, fileStore{key: "passphrase"}, plainV2)}
+			},
+			err: "is the key right",
+		},
+		{
+			name: "too short",
+			key:  "passphrase",
+			file: func(fileStore) tokenFile { return tokenFile{Version: 2, Encrypted: []byte("short")} },
+			err:  "too short",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := fileStore{file: filepath.Join(t.TempDir(), "tokens.json"), key: tt.key}
+
+			b, err := json.Marshal(tt.file(f))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(f.file, b, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := f.load()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loaded %v, want %v", got, tt.want)
+			}
+
+			// Older files are written again in the current format, and
+			// still encrypted if they were.
+			b, err = os.ReadFile(f.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var saved tokenFile
+			if err := json.Unmarshal(b, &saved); err != nil {
+				t.Fatal(err)
+			}
+			if saved.Version != tokenFileVersion || saved.Token != nil {
+				t.Errorf("saved version %d with token %v, want version %d without", saved.Version, saved.Token, tokenFileVersion)
+			}
+			if encrypted := saved.Encrypted != nil; encrypted != (tt.key != "") {
+				t.Errorf("saved encrypted %t, want %t", encrypted, tt.key != "")
+			}
+
+			again, err := f.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(again, tt.want) {
+				t.Errorf("loaded %v after saving, want %v", again, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenFileSave(t *testing.T) {
+	tokens := storedTokens{{Role: roleBot, UserID: "1"}: testToken}
+
+	for _, key := range []string{"", "passphrase"} {
+		f := fileStore{file: filepath.Join(t.TempDir(), "tokens.json"), key: key}
+		if err := f.save(tokens); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := os.ReadFile(f.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leaked := strings.Contains(string(b), testToken.Refresh); leaked != (key == "") {
+			t.Errorf("key %q: refresh token in the file is %t", key, leaked)
+		}
+
+		got, err := f.load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tokens) {
+			t.Errorf("key %q: loaded %v, want %v", key, got, tokens)
+		}
+	}
+}
+
+func TestTokenFileMissing(t *testing.T) {
+	f := fileStore{file: filepath.Join(t.TempDir(), "tokens.json")}
+
+	got, err := f.load()
+	if err != nil || got != nil {
+		t.Errorf("got %v, %v, want nothing", got, err)
+	}
+}
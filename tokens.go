@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storedToken is a token as it's kept between runs, in the same form as
+// TWITCH_TOKEN, TWITCH_REFRESH, and TWITCH_EXPIRES.
+type storedToken struct {
+	Token   string `json:"token"`
+	Refresh string `json:"refresh"`
+	Expires string `json:"expires"`
+}
+
+// account is who a token belongs to and what the bot uses it for, so more
+// than one identity can be stored.
+type account struct {
+	UserID string
+	Role   string
+}
+
+const roleBot = "bot"
+
+func (a account) MarshalText() ([]byte, error) {
+	return []byte(a.Role + ":" + a.UserID), nil
+}
+
+func (a *account) UnmarshalText(b []byte) error {
+	role, id, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return fmt.Errorf("UnmarshalText: invalid account %q", b)
+	}
+	a.Role, a.UserID = role, id
+
+	return nil
+}
+
+// storedTokens are every stored token by account.
+type storedTokens map[account]storedToken
+
+// decodeTokens reads stored tokens, including a single token from before
+// more than one could be stored, which is taken to be the bot's.
+func decodeTokens(b []byte) (storedTokens, error) {
+	var ts storedTokens
+	if err := json.Unmarshal(b, &ts); err == nil {
+		return ts, nil
+	}
+
+	var t storedToken
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("decodeTokens: %w", err)
+	}
+
+	return storedTokens{{Role: roleBot}: t}, nil
+}
+
+// tokenStore keeps tokens between runs so accounts don't have to be
+// authorized again every time the bot starts, and so refreshed tokens survive
+// a restart.
+type tokenStore interface {
+	// load returns the stored tokens, or nil if there aren't any yet.
+	load() (storedTokens, error)
+	save(ts storedTokens) error
+}
+
+// tokenKeeper is where a bot keeps its tokens and refreshes them.
+type tokenKeeper struct {
+	// store is where tokens are stored, or nil if they're only kept in
+	// memory.
+	store tokenStore
+
+	// mu is held while the stored tokens are updated, since a save rewrites
+	// all of them.
+	mu sync.Mutex
+
+	// refresher refreshes every token, with the bot's Twitch application
+	// unless it's replaced, like in tests.
+	refresher tokenRefresher
+}
+
+// setup picks the token store from TOKEN_STORE, or Vault if VAULT_ADDR is
+// set. Vault's problems are sent to notifications.
+func (k *tokenKeeper) setup(notifications *notifier) error {
+	store := os.Getenv("TOKEN_STORE")
+	if store == "" && os.Getenv("VAULT_ADDR") != "" {
+		store = "vault"
+	}
+
+	switch store {
+	case "":
+	case "vault":
+		v, err := newVaultStore(os.Getenv("VAULT_ADDR"), notifications)
+		if err != nil {
+			return fmt.Errorf("setup: %w", err)
+		}
+		k.store = v
+	case "file":
+		file := os.Getenv("TOKEN_FILE")
+		if file == "" {
+			dir, err := stateDir()
+			if err != nil {
+				return fmt.Errorf("setup: %w", err)
+			}
+			file = filepath.Join(dir, "tokens.json")
+		}
+		k.store = fileStore{file: file, key: os.Getenv("TOKEN_KEY")}
+	case "keyring":
+		user := os.Getenv("TWITCH_USER")
+		if user == "" {
+			return errors.New("setup: the keyring needs TWITCH_USER set")
+		}
+		k.store = keyringStore{user: user}
+	default:
+		return fmt.Errorf("setup: unknown token store %q", store)
+	}
+
+	return nil
+}
+
+// loadToken returns the stored token for the account, and the account with
+// its user ID filled in. Without a user ID the first token with the role is
+// used. It returns nil if there isn't one stored.
+func (k *tokenKeeper) loadToken(a account) (account, *storedToken, error) {
+	if k.store == nil {
+		return a, nil, nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	ts, err := k.store.load()
+	if err != nil {
+		return a, nil, fmt.Errorf("loadToken: %w", err)
+	}
+
+	if t, ok := ts[a]; ok {
+		return a, &t, nil
+	}
+
+	if a.UserID == "" {
+		for stored, t := range ts {
+			if stored.Role == a.Role {
+				return stored, &t, nil
+			}
+		}
+	}
+
+	return a, nil, nil
+}
+
+// saveToken stores the account's token, if there's somewhere to store it.
+// The user ID is looked up from the token if the account doesn't have one,
+// and the account is returned with it.
+func (k *tokenKeeper) saveToken(a account, t storedToken) account {
+	if k.store == nil {
+		return a
+	}
+
+	if a.UserID == "" {
+		id, err := tokenUserID(t.Token)
+		if err != nil {
+			log.Warnf("unable to check whose token it is: %v", err)
+		}
+		a.UserID = id
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	ts, err := k.store.load()
+	if err != nil {
+		log.Errorf("unable to store token: %v", err)
+		return a
+	} else if ts == nil {
+		ts = storedTokens{}
+	}
+
+	// Replace a token stored before its user ID was known.
+	delete(ts, account{Role: a.Role})
+	ts[a] = t
+
+	if err := k.store.save(ts); err != nil {
+		log.Errorf("unable to store token: %v", err)
+	}
+
+	return a
+}
+
+// fresh returns the token, refreshed and saved for the account first if it's
+// expired since it was stored or is about to.
+func (k *tokenKeeper) fresh(a account, t storedToken) (storedToken, error) {
+	expiresAt, err := time.Parse(time.RFC3339Nano, t.Expires)
+	if err == nil && time.Until(expiresAt) > refreshLead() {
+		return t, nil
+	}
+
+	creds, err := k.refreshToken(t.Refresh)
+	if err != nil {
+		return t, fmt.Errorf("fresh: %w", err)
+	}
+
+	t.Token, t.Refresh, t.Expires = creds.get()
+	k.saveToken(a, t)
+
+	return t, nil
+}
+
+func (k *tokenKeeper) refreshToken(refresh string) (*Token, error) {
+	r := k.refresher
+	if r == nil {
+		r = twitchApp()
+	}
+
+	creds, err := r.Refresh(refresh)
+	if err != nil {
+		return nil, fmt.Errorf("refreshToken: %w", err)
+	}
+
+	return &Token{*creds}, nil
+}
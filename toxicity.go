@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+const (
+	// toxicityReason starts the moderation log reason of what the filter
+	// does, so appeals can find it.
+	toxicityReason = "toxicity filter"
+	// toxicityQueue is how many messages can be waiting to be scored before
+	// more are let through unscored.
+	toxicityQueue = 100
+	// appealWindow is how long after the filter acts it can be appealed.
+	appealWindow = 24 * time.Hour
+)
+
+// toxicity is the config for the filter that scores messages with a
+// classifier, from 0 to 1, and deletes them or times out whoever sent them
+// when the score is over a threshold. A threshold of 0 turns that action off.
+// The backend is perspective, Google's Perspective API with the key in
+// PERSPECTIVE_API_KEY, or url, which posts {"text": "..."} to URL, such as a
+// local model, and expects {"score": 0.9} back.
+type toxicity struct {
+	Backend    string  `json:"backend"`
+	URL        string  `json:"url"`
+	Delete     float64 `json:"delete"`      // score to delete a message at
+	Timeout    float64 `json:"timeout"`     // score to time out whoever sent it at
+	TimeoutFor string  `json:"timeout_for"` // default 10m
+}
+
+func (t toxicity) enabled() bool {
+	return t.Backend != "" && (t.Delete > 0 || t.Timeout > 0)
+}
+
+// score asks the classifier how toxic the text is.
+func (t toxicity) score(text string) (float64, error) {
+	switch t.Backend {
+	case "perspective":
+		var resp struct {
+			AttributeScores struct {
+				Toxicity struct {
+					SummaryScore struct {
+						Value float64 `json:"value"`
+					} `json:"summaryScore"`
+				} `json:"TOXICITY"`
+			} `json:"attributeScores"`
+		}
+		key := os.Getenv("PERSPECTIVE_API_KEY")
+		if key == "" {
+			return 0, fmt.Errorf("score: PERSPECTIVE_API_KEY isn't set")
+		}
+
+		u := "https://commentanalyzer.googleapis.com/v1alpha1/comments:analyze?key=" + url.QueryEscape(key)
+		err := postJSON(u, "", map[string]any{
+			"comment":             map[string]string{"text": text},
+			"requestedAttributes": map[string]any{"TOXICITY": struct{}{}},
+			"doNotStore":          true,
+		}, &resp)
+		if err != nil {
+			return 0, fmt.Errorf("score: %w", err)
+		}
+		return resp.AttributeScores.Toxicity.SummaryScore.Value, nil
+	case "url":
+		var resp struct {
+			Score float64 `json:"score"`
+		}
+		if err := postJSON(t.URL, "", map[string]string{"text": text}, &resp); err != nil {
+			return 0, fmt.Errorf("score: %w", err)
+		}
+		return resp.Score, nil
+	}
+
+	return 0, fmt.Errorf("score: unknown backend %q", t.Backend)
+}
+
+// toxicityFilter scores messages one at a time in the background, so a slow
+// classifier doesn't hold up chat and a rate limited one isn't flooded.
+type toxicityFilter struct {
+	start    sync.Once
+	messages chan toxicityCheck
+	pending  sync.WaitGroup
+}
+
+type toxicityCheck struct {
+	message twitch.PrivateMessage
+	config  toxicity
+	api     *twitchAPI
+	dryRun  bool // only log what would be done, for replays
+}
+
+// check queues the message to be scored and acted on.
+func (f *toxicityFilter) check(c toxicityCheck) {
+	f.start.Do(func() { go f.run() })
+
+	f.pending.Add(1)
+	select {
+	case f.messages <- c:
+	default:
+		f.pending.Done()
+		log.Warnf("toxicity filter is behind, not checking a message from %s", c.message.User.Name)
+	}
+}
+
+func (f *toxicityFilter) run() {
+	for c := range f.messages {
+		f.act(c)
+		f.pending.Done()
+	}
+}
+
+func (f *toxicityFilter) act(c toxicityCheck) {
+	score, err := c.config.score(c.message.Message)
+	if err != nil {
+		log.Errorf("unable to check message for toxicity: %v", err)
+		return
+	}
+
+	timeout := c.config.Timeout > 0 && score >= c.config.Timeout
+	remove := c.config.Delete > 0 && score >= c.config.Delete
+	if !timeout && !remove {
+		return
+	}
+
+	reason := fmt.Sprintf("%s, scored %.2f", toxicityReason, score)
+	if c.dryRun {
+		log.Infof("%s would act on %q from %s", reason, c.message.Message, c.message.User.Name)
+		return
+	}
+
+	if remove {
+		if err := c.api.deleteMessage(c.message, reason); err != nil {
+			log.Errorf("unable to delete toxic message: %v", err)
+		}
+	}
+
+	if timeout {
+		d := 10 * time.Minute
+		if v, err := time.ParseDuration(c.config.TimeoutFor); err == nil && c.config.TimeoutFor != "" {
+			d = v
+		}
+
+		if err := c.api.timeout(c.message.Channel, c.message.RoomID, c.message.User, d, reason); err != nil {
+			log.Errorf("unable to time out %s: %v", c.message.User.Name, err)
+		}
+	}
+}
+
+// appealCommand records an appeal of what the toxicity filter did to the
+// chatter in the moderation log, for mods to look over with !modlog. Each
+// action can be appealed once, within a day.
+func appealCommand(b *bot, client chat.Sender, message twitch.PrivateMessage, args []string) {
+	text := strings.Join(args, " ")
+	if text == "" {
+		client.Reply(message.Channel, message.ID, "Usage: !appeal why it was a mistake")
+		return
+	}
+
+	var appealed *modAction
+	for _, a := range b.modlog.query(message.Channel, message.User.Name, time.Now().Add(-appealWindow)) {
+		if a.Action == "appeal" {
+			// Newest first, so anything after this was already appealed.
+			break
+		} else if strings.HasPrefix(a.Reason, toxicityReason) {
+			a := a
+			appealed = &a
+			break
+		}
+	}
+
+	if appealed == nil {
+		client.Reply(message.Channel, message.ID, "There's nothing from the filter to appeal")
+		return
+	}
+
+	b.modlog.record(modAction{
+		Channel:   message.Channel,
+		Action:    "appeal",
+		Target:    message.User.Name,
+		TargetID:  message.User.ID,
+		Message:   text,
+		MessageID: appealed.MessageID,
+		Reason:    fmt.Sprintf("appealing %s from %s", appealed.Action, appealed.Time.Format(time.RFC3339)),
+	})
+	client.Reply(message.Channel, message.ID, "Your appeal's been logged for the mods")
+}
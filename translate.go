@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// defaultTranslateMinLength is how many letters a message needs to be
+	// translated automatically if the config doesn't say. Shorter ones are
+	// usually emotes, names, or "gg", which don't need it.
+	defaultTranslateMinLength = 12
+	// translateQueue is how many messages can be waiting to be translated
+	// automatically before more are skipped.
+	translateQueue = 50
+)
+
+// translation is the config for translating chat. Backend is libretranslate,
+// with url the server's address, or deepl, and either uses the key in
+// TRANSLATE_API_KEY if it needs one. With auto, messages in other languages
+// than language are translated to it as replies, and mods can translate any
+// message with !translate.
+type translation struct {
+	Backend   string `json:"backend"`
+	URL       string `json:"url"`        // default https://libretranslate.com for libretranslate
+	Language  string `json:"language"`   // chat's language, default en
+	Auto      bool   `json:"auto"`       // translate messages in other languages
+	MinLength int    `json:"min_length"` // letters a message needs to be translated automatically, default 12
+}
+
+func (t translation) language() string {
+	if t.Language == "" {
+		return "en"
+	}
+
+	return strings.ToLower(t.Language)
+}
+
+// translate translates text to the config's language, returning the
+// translation and the language it was detected as being in.
+func (t translation) translate(text string) (string, string, error) {
+	key := os.Getenv("TRANSLATE_API_KEY")
+
+	switch t.Backend {
+	case "libretranslate":
+		u := "https://libretranslate.com"
+		if t.URL != "" {
+			u = strings.TrimSuffix(t.URL, "/")
+		}
+
+		var resp struct {
+			TranslatedText   string `json:"translatedText"`
+			DetectedLanguage struct {
+				Language string `json:"language"`
+			} `json:"detectedLanguage"`
+		}
+		body := map[string]string{"q": text, "source": "auto", "target": t.language(), "format": "text"}
+		if key != "" {
+			body["api_key"] = key
+		}
+		if err := postJSON(u+"/translate", "", body, &resp); err != nil {
+			return "", "", fmt.Errorf("translate: %w", err)
+		}
+
+		return resp.TranslatedText, strings.ToLower(resp.DetectedLanguage.Language), nil
+	case "deepl":
+		if key == "" {
+			return "", "", fmt.Errorf("translate: TRANSLATE_API_KEY isn't set")
+		}
+
+		// Free keys have their own server.
+		u := "https://api.deepl.com/v2/translate"
+		if strings.HasSuffix(key, ":fx") {
+			u = "https://api-free.deepl.com/v2/translate"
+		}
+
+		b, err := json.Marshal(map[string]any{"text": []string{text}, "target_lang": strings.ToUpper(t.language())})
+		if err != nil {
+			return "", "", fmt.Errorf("translate: %w", err)
+		}
+
+		req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(b))
+		if err != nil {
+			return "", "", fmt.Errorf("translate: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "DeepL-Auth-Key "+key)
+
+		var resp struct {
+			Translations []struct {
+				DetectedSourceLanguage string `json:"detected_source_language"`
+				Text                   string `json:"text"`
+			} `json:"translations"`
+		}
+		if err := doJSON(req, &resp); err != nil {
+			return "", "", fmt.Errorf("translate: %w", err)
+		} else if len(resp.Translations) == 0 {
+			return "", "", fmt.Errorf("translate: no translations in the response")
+		}
+
+		return resp.Translations[0].Text, strings.ToLower(resp.Translations[0].DetectedSourceLanguage), nil
+	}
+
+	return "", "", fmt.Errorf("translate: unknown backend %q", t.Backend)
+}
+
+// sameLanguage reports whether detected, which DeepL gives as EN for EN-GB
+// and the like, is the config's language.
+func (t translation) sameLanguage(detected string) bool {
+	want, _, _ := strings.Cut(t.language(), "-")
+	got, _, _ := strings.Cut(detected, "-")
+
+	return want == got
+}
+
+// translator translates messages automatically one at a time in the
+// background, so chat isn't held up and the backend isn't flooded.
+type translator struct {
+	start    sync.Once
+	messages chan *chatContext
+	pending  sync.WaitGroup
+}
+
+func (t *translator) queue(c *chatContext) {
+	t.start.Do(func() { go t.run() })
+
+	t.pending.Add(1)
+	select {
+	case t.messages <- c:
+	default:
+		t.pending.Done()
+		log.Debugf("translations are behind, not translating a message from %s", c.message.User.Name)
+	}
+}
+
+func (t *translator) run() {
+	for c := range t.messages {
+		t.auto(c)
+		t.pending.Done()
+	}
+}
+
+// auto replies with the message's translation if it's in another language.
+func (t *translator) auto(c *chatContext) {
+	conf := c.config.Translate
+
+	translated, detected, err := conf.translate(c.message.Message)
+	if err != nil {
+		log.Errorf("unable to translate message: %v", err)
+		return
+	} else if detected == "" || conf.sameLanguage(detected) || strings.EqualFold(strings.TrimSpace(translated), strings.TrimSpace(c.message.Message)) {
+		return
+	}
+
+	c.client.Reply(c.message.Channel, c.message.ID, fmt.Sprintf("[%s] %s", detected, translated))
+}
+
+// letters counts the letters in text.
+func letters(text string) int {
+	n := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// runTranslate answers !translate from mods, translating the text after it
+// or, in a reply, the message replied to. Other messages are queued to be
+// translated if the config's auto is on.
+func runTranslate(c *chatContext) bool {
+	conf := c.config.Translate
+	if conf.Backend == "" {
+		return false
+	}
+
+	// Replies start with who's being replied to.
+	text := c.message.Message
+	if c.message.Reply != nil && strings.HasPrefix(text, "@") {
+		_, text, _ = strings.Cut(text, " ")
+		text = strings.TrimSpace(text)
+	}
+
+	name, args, ok := parseCommand(text)
+	if !ok {
+		minLength := conf.MinLength
+		if minLength <= 0 {
+			minLength = defaultTranslateMinLength
+		}
+
+		if conf.Auto && letters(c.message.Message) >= minLength {
+			c.bot.translator.queue(c)
+		}
+		return false
+	} else if name != "translate" {
+		return false
+	} else if !c.privileged {
+		log.Debugf("%s tried to run mod command translate", c.message.User.Name)
+		return true
+	}
+
+	text = strings.Join(args, " ")
+	parentID := c.message.ID
+	if r := c.message.Reply; r != nil && text == "" {
+		text, parentID = r.ParentMsgBody, r.ParentMsgID
+	}
+	if text == "" {
+		c.client.Reply(c.message.Channel, c.message.ID, "Usage: !translate text, or reply to a message with !translate")
+		return true
+	}
+
+	go func() {
+		translated, detected, err := conf.translate(text)
+		if err != nil {
+			log.Errorf("unable to translate message: %v", err)
+			c.client.Reply(c.message.Channel, c.message.ID, "Unable to translate that")
+			return
+		}
+
+		c.client.Reply(c.message.Channel, parentID, fmt.Sprintf("[%s] %s", detected, translated))
+	}()
+
+	return true
+}
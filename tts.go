@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSpeechLength is the most characters that will be read out, so chat can't
+// tie up the overlay with a wall of text.
+const maxSpeechLength = 300
+
+// tts turns text into speech with either a local program or an HTTP API. If
+// both are set Command is used.
+type tts struct {
+	// Command is run with {text} in its arguments replaced and has to write
+	// the audio to stdout, e.g. ["espeak-ng", "--stdout", "{text}"].
+	Command []string `json:"command,omitempty"`
+
+	// URL is fetched with {text} replaced by the escaped text and has to
+	// respond with the audio, e.g.
+	// "https://api.streamelements.com/kappa/v2/speech?voice=Brian&text={text}".
+	URL string `json:"url,omitempty"`
+}
+
+// speak returns the audio for text and its content type.
+func (t tts) speak(ctx context.Context, text string) ([]byte, string, error) {
+	if r := []rune(text); len(r) > maxSpeechLength {
+		text = string(r[:maxSpeechLength])
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	switch {
+	case len(t.Command) > 0:
+		args := make([]string, len(t.Command)-1)
+		for i, arg := range t.Command[1:] {
+			args[i] = strings.ReplaceAll(arg, "{text}", text)
+		}
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, t.Command[0], args...)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, "", fmt.Errorf("speak: %s failed: %w: %s", t.Command[0], err, stderr.String())
+		}
+
+		return stdout.Bytes(), http.DetectContentType(stdout.Bytes()), nil
+	case t.URL != "":
+		u := strings.ReplaceAll(t.URL, "{text}", url.QueryEscape(text))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, "", fmt.Errorf("speak: invalid url: %w", err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, "", fmt.Errorf("speak: request failed: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, "", fmt.Errorf("speak: unexpected status %s", resp.Status)
+		}
+
+		audio, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+		if err != nil {
+			return nil, "", fmt.Errorf("speak: unable to read audio: %w", err)
+		}
+
+		return audio, resp.Header.Get("Content-Type"), nil
+	}
+
+	return nil, "", errors.New("speak: no tts command or url configured")
+}
+
+// audioStore keeps generated audio around long enough for the overlay to
+// fetch and play it.
+type audioStore struct {
+	mu    sync.Mutex
+	clips map[string]audioClip
+}
+
+type audioClip struct {
+	data        []byte
+	contentType string
+	created     time.Time
+}
+
+func newAudioStore() *audioStore {
+	return &audioStore{clips: map[string]audioClip{}}
+}
+
+// add stores the audio and returns the ID it can be fetched by.
+func (s *audioStore) add(data []byte, contentType string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, clip := range s.clips {
+		if time.Since(clip.created) > 10*time.Minute {
+			delete(s.clips, id)
+		}
+	}
+
+	s.clips[id] = audioClip{data: data, contentType: contentType, created: time.Now()}
+
+	return id
+}
+
+func (s *audioStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	clip, ok := s.clips[strings.TrimPrefix(r.URL.Path, "/overlay/audio/")]
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", clip.contentType)
+	w.Write(clip.data)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+const (
+	// urlFetchTimeout is how long a $(urlfetch) gets to respond.
+	urlFetchTimeout = 5 * time.Second
+	// urlFetchCache is how long a URL's response is reused for.
+	urlFetchCache = time.Minute
+	// maxURLFetchSize is how much of a response is read.
+	maxURLFetchSize = 16 * 1024
+	// maxURLFetchCached is how many responses are cached at once.
+	maxURLFetchCached = 256
+)
+
+// urlFetchPattern matches $(urlfetch url) in a custom command's response.
+var urlFetchPattern = regexp.MustCompile(`\$\(urlfetch\s+([^)\s]+)\s*\)`)
+
+// urlFetcher gets the responses of the URLs in $(urlfetch), caching them for a
+// little while so a command spammed in chat doesn't hammer the API.
+type urlFetcher struct {
+	client http.Client
+	wg     sync.WaitGroup // responses being fetched
+
+	mu    sync.Mutex
+	cache map[string]fetched
+}
+
+type fetched struct {
+	body    string
+	expires time.Time
+}
+
+func newURLFetcher() *urlFetcher {
+	return &urlFetcher{
+		client: http.Client{
+			Timeout: urlFetchTimeout,
+			// Without a proxy, so where the URL resolves to is what's checked.
+			Transport: &http.Transport{
+				DialContext:         (&net.Dialer{Timeout: urlFetchTimeout, Control: publicOnly}).DialContext,
+				TLSHandshakeTimeout: urlFetchTimeout,
+			},
+		},
+		cache: map[string]fetched{},
+	}
+}
+
+// nonPublicNets are the ranges that aren't on the internet that net.IP's
+// methods don't cover.
+var nonPublicNets = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"), // carrier grade NAT
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"), // benchmarking
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return n
+}
+
+// publicOnly is a dialer's Control, refusing to connect to loopback, private,
+// and link local addresses. It's checked after the host's resolved, so a
+// public name pointing somewhere private doesn't get through. Otherwise a
+// $(urlfetch) could read cloud metadata, the control API, or anything else on
+// the bot's network into chat.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("publicOnly: %w", err)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("publicOnly: %s isn't a public address", host)
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return fmt.Errorf("publicOnly: %s isn't a public address", host)
+		}
+	}
+
+	return nil
+}
+
+// hasURLFetch reports whether the response has a $(urlfetch) in it.
+func hasURLFetch(response string) bool {
+	return urlFetchPattern.MatchString(response)
+}
+
+// expand replaces each $(urlfetch url) in the response with what the URL
+// returns. vars are replaced in the URLs, query escaped, first.
+func (f *urlFetcher) expand(response string, vars map[string]string) string {
+	return urlFetchPattern.ReplaceAllStringFunc(response, func(match string) string {
+		u := urlFetchPattern.FindStringSubmatch(match)[1]
+		for k, v := range vars {
+			u = strings.ReplaceAll(u, k, url.QueryEscape(v))
+		}
+
+		body, err := f.fetch(u)
+		if err != nil {
+			log.Errorf("urlfetch failed: %v", err)
+			return "(error fetching a response)"
+		}
+
+		return body
+	})
+}
+
+// fetch gets the URL's body as one line of text, from the cache if it was
+// fetched recently.
+func (f *urlFetcher) fetch(u string) (string, error) {
+	if p, err := url.Parse(u); err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		return "", fmt.Errorf("fetch: invalid url %q", u)
+	}
+
+	now := time.Now()
+	f.mu.Lock()
+	cached, ok := f.cache[u]
+	f.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.body, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return "", fmt.Errorf("fetch: invalid url: %w", err)
+	}
+	req.Header.Set("Accept", "text/plain, */*")
+	req.Header.Set("User-Agent", "batybot")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("fetch: request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("fetch: unexpected status %s from %s", resp.Status, u)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxURLFetchSize))
+	if err != nil {
+		return "", fmt.Errorf("fetch: unable to read response: %w", err)
+	}
+
+	body := strings.Join(strings.Fields(strings.ToValidUTF8(string(b), "")), " ")
+	if utf8.RuneCountInString(body) > chat.MaxMessageLength {
+		body = string([]rune(body)[:chat.MaxMessageLength-1]) + "…"
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.cache) >= maxURLFetchCached {
+		for k, c := range f.cache {
+			if now.After(c.expires) {
+				delete(f.cache, k)
+			}
+		}
+		// Everything's still fresh, so make room by dropping any.
+		for k := range f.cache {
+			if len(f.cache) < maxURLFetchCached {
+				break
+			}
+			delete(f.cache, k)
+		}
+	}
+	f.cache[u] = fetched{body: body, expires: now.Add(urlFetchCache)}
+
+	return body, nil
+}
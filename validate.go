@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fieldError is a problem with one setting in the config, where path is how
+// it's reached in the file, e.g. redemptions[2].for.
+type fieldError struct {
+	path string
+	msg  string
+}
+
+func (e fieldError) Error() string {
+	return e.path + ": " + e.msg
+}
+
+// configErrors collects every problem in a config so they can all be fixed at
+// once rather than one per reload.
+type configErrors []error
+
+func (errs *configErrors) add(path, format string, args ...any) {
+	*errs = append(*errs, fieldError{path: path, msg: fmt.Sprintf(format, args...)})
+}
+
+func (errs *configErrors) duration(path, d string) {
+	if d == "" {
+		return
+	}
+
+	if v, err := time.ParseDuration(d); err != nil {
+		errs.add(path, "invalid duration %q", d)
+	} else if v < 0 {
+		errs.add(path, "can't be negative")
+	}
+}
+
+func (errs *configErrors) url(path, u string) {
+	if u == "" {
+		return
+	}
+
+	if p, err := url.Parse(u); err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		errs.add(path, "invalid URL %q", u)
+	}
+}
+
+// validate checks the settings that are only used later on, like durations and
+// references to sounds, so mistakes are found when the config is loaded.
+func (c config) validate() error {
+	var errs configErrors
+
+	if c.LogLevel != "" {
+		if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+			errs.add("log_level", "unknown level %q", c.LogLevel)
+		}
+	}
+
+	for i, feature := range c.LiveOnly {
+		if !isFeature(feature) {
+			errs.add(fmt.Sprintf("live_only[%d]", i), "unknown feature %q", feature)
+		}
+	}
+
+	errs.duration("command_cooldown", c.CommandCooldown)
+
+	seen := map[string]bool{}
+	for i, name := range c.Pipeline {
+		path := fmt.Sprintf("pipeline[%d]", i)
+		if !isMiddleware(name) && !isPluginStep(name) {
+			errs.add(path, "unknown step %q", name)
+		} else if seen[name] {
+			errs.add(path, "%q is already in the pipeline", name)
+		}
+		seen[name] = true
+	}
+
+	for i, name := range c.Offline.Commands {
+		if isCommand(strings.ToLower(strings.TrimPrefix(name, "!"))) {
+			errs.add(fmt.Sprintf("offline.commands[%d]", i), "%q is a built in command", name)
+		}
+	}
+	for i, t := range c.Offline.Timers {
+		path := fmt.Sprintf("offline.timers[%d]", i)
+
+		if t.Message == "" {
+			errs.add(path+".message", "is required")
+		}
+		if t.Every == "" {
+			errs.add(path+".every", "is required")
+		}
+		errs.duration(path+".every", t.Every)
+	}
+
+	switch c.AI.Backend {
+	case "":
+	case "ollama":
+		if c.AI.Model == "" {
+			errs.add("ai.model", "is required for ollama")
+		}
+	case "llamacpp":
+	default:
+		errs.add("ai.backend", "unknown backend %q, should be ollama or llamacpp", c.AI.Backend)
+	}
+	errs.url("ai.url", c.AI.URL)
+	errs.duration("ai.cooldown", c.AI.Cooldown)
+	errs.duration("chatter.cooldown", c.Chatter.Cooldown)
+
+	for _, channel := range sortedKeys(c.Pyramids) {
+		p := c.Pyramids[channel]
+		path := "pyramids." + channel
+
+		switch p.Action {
+		case "", "none", "congratulate", "sabotage":
+		default:
+			errs.add(path+".action", "unknown action %q, should be congratulate, sabotage, or none", p.Action)
+		}
+		if p.MinHeight < 0 || p.MinHeight == 1 {
+			errs.add(path+".min_height", "should be at least 2")
+		}
+	}
+
+	for i, t := range c.Combos.Thresholds {
+		if t < 2 {
+			errs.add(fmt.Sprintf("combos.thresholds[%d]", i), "should be at least 2")
+		}
+	}
+	errs.duration("combos.cooldown", c.Combos.Cooldown)
+
+	w := c.Giveaway.Weights
+	for _, weight := range []struct {
+		name  string
+		value float64
+	}{
+		{"everyone", w.Everyone},
+		{"tier1", w.Tier1},
+		{"tier2", w.Tier2},
+		{"tier3", w.Tier3},
+		{"per_gift", w.PerGift},
+		{"max_gift_bonus", w.MaxGiftBonus},
+	} {
+		if weight.value < 0 {
+			errs.add("giveaway.weights."+weight.name, "shouldn't be negative")
+		}
+	}
+
+	errs.duration("raffle.winner_cooldown", c.Raffle.WinnerCooldown)
+	errs.duration("word_games.timeout", c.WordGames.Timeout)
+	errs.duration("word_games.every", c.WordGames.Every)
+
+	if c.Bingo.Size < 0 {
+		errs.add("bingo.size", "should be at least 1")
+	}
+	if len(c.Bingo.Squares) > 0 && len(c.Bingo.Squares) < c.Bingo.needed() {
+		errs.add("bingo.squares", "a %dx%d card needs %d squares, there are %d", c.Bingo.size(), c.Bingo.size(), c.Bingo.needed(), len(c.Bingo.Squares))
+	}
+
+	if c.Report.At != "" {
+		if _, err := time.Parse("15:04", c.Report.At); err != nil {
+			errs.add("report.at", "invalid time %q, should be like 09:00", c.Report.At)
+		}
+	}
+	for i, webhook := range c.Report.Webhooks {
+		errs.url(fmt.Sprintf("report.webhooks[%d]", i), webhook)
+	}
+	for i, addr := range c.Report.Email {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			errs.add(fmt.Sprintf("report.email[%d]", i), "invalid address %q", addr)
+		}
+	}
+
+	switch c.Toxicity.Backend {
+	case "":
+	case "perspective":
+	case "url":
+		if c.Toxicity.URL == "" {
+			errs.add("toxicity.url", "is required for the url backend")
+		}
+		errs.url("toxicity.url", c.Toxicity.URL)
+	default:
+		errs.add("toxicity.backend", "unknown backend %q, should be perspective or url", c.Toxicity.Backend)
+	}
+	if c.Toxicity.Delete < 0 || c.Toxicity.Delete > 1 {
+		errs.add("toxicity.delete", "should be from 0 to 1")
+	}
+	if c.Toxicity.Timeout < 0 || c.Toxicity.Timeout > 1 {
+		errs.add("toxicity.timeout", "should be from 0 to 1")
+	}
+	errs.duration("toxicity.timeout_for", c.Toxicity.TimeoutFor)
+
+	switch c.Translate.Backend {
+	case "", "libretranslate", "deepl":
+	default:
+		errs.add("translate.backend", "unknown backend %q, should be libretranslate or deepl", c.Translate.Backend)
+	}
+	errs.url("translate.url", c.Translate.URL)
+	if c.Translate.MinLength < 0 {
+		errs.add("translate.min_length", "can't be negative")
+	}
+
+	switch c.Weather.Provider {
+	case "", "wttr", "openweathermap":
+	default:
+		errs.add("weather.provider", "unknown provider %q, should be wttr or openweathermap", c.Weather.Provider)
+	}
+	switch c.Weather.Units {
+	case "", "both", "metric", "imperial":
+	default:
+		errs.add("weather.units", "unknown units %q, should be metric, imperial, or both", c.Weather.Units)
+	}
+	errs.duration("weather.cache", c.Weather.Cache)
+	errs.url("currency.url", c.Currency.URL)
+	errs.duration("currency.cache", c.Currency.Cache)
+
+	for i, lang := range c.WordFilter.Languages {
+		if err := readWordList(lang, map[string]string{}); err != nil {
+			errs.add(fmt.Sprintf("word_filter.languages[%d]", i), "no built in list for %q", lang)
+		}
+	}
+	for _, word := range sortedKeys(c.WordFilter.Words) {
+		if s := c.WordFilter.Words[word]; !isSeverity(s) {
+			errs.add("word_filter.words."+word, "unknown severity %q, should be one of %s", s, strings.Join(severities, ", "))
+		}
+	}
+	for _, severity := range sortedKeys(c.WordFilter.Actions) {
+		path := "word_filter.actions." + severity
+		if !isSeverity(severity) {
+			errs.add(path, "unknown severity %q, should be one of %s", severity, strings.Join(severities, ", "))
+		}
+
+		action, d, _ := strings.Cut(c.WordFilter.Actions[severity], " ")
+		switch action {
+		case "none", "warn", "delete", "ban":
+			if d != "" {
+				errs.add(path, "%s doesn't take a duration", action)
+			}
+		case "timeout":
+			if v, err := time.ParseDuration(strings.TrimSpace(d)); err != nil || v < time.Second {
+				errs.add(path, "timeout needs a duration of at least 1s, like timeout 10m")
+			}
+		default:
+			errs.add(path, "unknown action %q, should be none, warn, delete, timeout, or ban", action)
+		}
+	}
+
+	for _, name := range sortedKeys(c.ExecCommands) {
+		e := c.ExecCommands[name]
+		path := fmt.Sprintf("exec_commands.%s", name)
+
+		if _, ok := commands[name]; ok {
+			errs.add(path, "%q is a built in command", name)
+		} else if name == "" || name != strings.ToLower(name) || strings.ContainsAny(name, " !") {
+			errs.add(path, "%q should be a lowercase name without the !", name)
+		}
+		if e.Program == "" {
+			errs.add(path+".program", "is required")
+		}
+		errs.duration(path+".timeout", e.Timeout)
+	}
+	if c.ExecLimit < 0 {
+		errs.add("exec_limit", "can't be negative")
+	}
+
+	for i, r := range c.Redemptions {
+		path := fmt.Sprintf("redemptions[%d]", i)
+
+		if r.Reward == "" {
+			errs.add(path+".reward", "is required")
+		}
+
+		switch r.Announce {
+		case "", "blue", "green", "orange", "purple", "primary":
+		default:
+			errs.add(path+".announce", "must be blue, green, orange, purple, or primary")
+		}
+		if r.Announce != "" && r.Say == "" {
+			errs.add(path+".announce", "needs say to be set")
+		}
+
+		if r.Sound != "" {
+			if _, ok := c.Sounds[r.Sound]; !ok {
+				errs.add(path+".sound", "no sound named %q", r.Sound)
+			}
+		}
+
+		if r.Toggle != "" && !isFeature(r.Toggle) {
+			errs.add(path+".toggle", "unknown feature %q", r.Toggle)
+		}
+		if r.For != "" && r.Toggle == "" {
+			errs.add(path+".for", "needs toggle to be set")
+		}
+		errs.duration(path+".for", r.For)
+	}
+
+	for _, typ := range sortedKeys(c.Alerts) {
+		a := c.Alerts[typ]
+		path := fmt.Sprintf("alerts[%q]", typ)
+
+		errs.url(path+".image", a.Image)
+		errs.duration(path+".duration", a.Duration)
+	}
+
+	errs.url("tts.url", c.TTS.URL)
+
+	for _, name := range sortedKeys(c.Sounds) {
+		s := c.Sounds[name]
+		path := fmt.Sprintf("sounds[%q]", name)
+
+		if s.File == "" {
+			errs.add(path+".file", "is required")
+		}
+		if s.Volume < 0 || s.Volume > 1 {
+			errs.add(path+".volume", "must be from 0 to 1")
+		}
+		errs.duration(path+".cooldown", s.Cooldown)
+	}
+
+	for i, hook := range c.Discord.GoLive.Webhooks {
+		errs.url(fmt.Sprintf("discord.golive.webhooks[%d]", i), hook)
+	}
+
+	errs.duration("golive.cooldown", c.GoLive.Cooldown)
+	errs.url("golive.mastodon.server", c.GoLive.Mastodon.Server)
+	switch c.GoLive.Mastodon.Visibility {
+	case "", "public", "unlisted", "private", "direct":
+	default:
+		errs.add("golive.mastodon.visibility", "must be public, unlisted, private, or direct")
+	}
+	errs.url("golive.bluesky.pds", c.GoLive.Bluesky.PDS)
+
+	return errors.Join(errs...)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// vaultStore keeps the token in a HashiCorp Vault KV version 2 secrets
+// engine, and can provide the client ID and secret from it as well.
+//
+//	VAULT_ADDR  - e.g. https://vault.example.com:8200
+//	VAULT_TOKEN - token with read and write access to the path
+//	VAULT_MOUNT - KV mount, secret by default
+//	VAULT_PATH  - path in the mount, batybot by default
+//
+// The client ID and secret are read from client_id and client_secret at the
+// path, and the tokens are stored at path/tokens.
+type vaultStore struct {
+	addr          string
+	token         string
+	mount         string
+	path          string
+	notifications *notifier
+}
+
+func newVaultStore(addr string, notifications *notifier) (*vaultStore, error) {
+	v := &vaultStore{
+		addr:          strings.TrimSuffix(addr, "/"),
+		token:         os.Getenv("VAULT_TOKEN"),
+		mount:         "secret",
+		path:          "batybot",
+		notifications: notifications,
+	}
+	if mount := os.Getenv("VAULT_MOUNT"); mount != "" {
+		v.mount = strings.Trim(mount, "/")
+	}
+	if path := os.Getenv("VAULT_PATH"); path != "" {
+		v.path = strings.Trim(path, "/")
+	}
+
+	if v.token == "" {
+		return nil, errors.New("newVaultStore: VAULT_TOKEN isn't set")
+	}
+
+	if err := v.credentials(); err != nil {
+		return nil, fmt.Errorf("newVaultStore: %w", err)
+	}
+
+	go v.renew()
+
+	return v, nil
+}
+
+// credentials sets TWITCH_CLIENT_ID and TWITCH_CLIENT_SECRET from Vault,
+// unless they're already set.
+func (v *vaultStore) credentials() error {
+	var creds struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}
+	if _, err := v.read(v.path, &creds); err != nil {
+		return fmt.Errorf("credentials: %w", err)
+	}
+
+	if creds.ClientID != "" && os.Getenv("TWITCH_CLIENT_ID") == "" {
+		os.Setenv("TWITCH_CLIENT_ID", creds.ClientID)
+	}
+	if creds.ClientSecret != "" && os.Getenv("TWITCH_CLIENT_SECRET") == "" {
+		os.Setenv("TWITCH_CLIENT_SECRET", creds.ClientSecret)
+	}
+
+	return nil
+}
+
+func (v *vaultStore) load() (storedTokens, error) {
+	var raw json.RawMessage
+	found, err := v.read(v.path+"/tokens", &raw)
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	} else if !found {
+		return nil, nil
+	}
+
+	ts, err := decodeTokens(raw)
+	if err != nil {
+		return nil, fmt.Errorf("load: invalid tokens in vault: %w", err)
+	}
+
+	return ts, nil
+}
+
+func (v *vaultStore) save(ts storedTokens) error {
+	if err := v.request(http.MethodPost, "/v1/"+v.mount+"/data/"+v.path+"/tokens", map[string]interface{}{"data": ts}, nil); err != nil {
+		return fmt.Errorf("save: %w", err)
+	}
+
+	return nil
+}
+
+// read decodes the secret at path into data, and reports whether it exists.
+func (v *vaultStore) read(path string, data interface{}) (bool, error) {
+	var secret struct {
+		Data struct {
+			Data json.RawMessage `json:"data"`
+		} `json:"data"`
+	}
+
+	err := v.request(http.MethodGet, "/v1/"+v.mount+"/data/"+path, nil, &secret)
+	if errors.Is(err, errVaultNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("read: %w", err)
+	}
+
+	if err := json.Unmarshal(secret.Data.Data, data); err != nil {
+		return false, fmt.Errorf("read: invalid secret at %q: %w", path, err)
+	}
+
+	return true, nil
+}
+
+var errVaultNotFound = errors.New("not found")
+
+func (v *vaultStore) request(method, path string, body, response interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("request: unable to encode body: %w", err)
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, v.addr+path, r)
+	if err != nil {
+		return fmt.Errorf("request: invalid url: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request: request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errVaultNotFound
+	} else if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("request: unexpected status %s: %s", resp.Status, b)
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("request: invalid response: %w", err)
+	}
+
+	return nil
+}
+
+// renew keeps the Vault token from expiring, if it's renewable, by renewing
+// it when half its TTL is left.
+func (v *vaultStore) renew() {
+	for {
+		var self struct {
+			Data struct {
+				TTL       int  `json:"ttl"`
+				Renewable bool `json:"renewable"`
+			} `json:"data"`
+		}
+		if err := v.request(http.MethodGet, "/v1/auth/token/lookup-self", nil, &self); err != nil {
+			log.Errorf("unable to look up vault token: %v", err)
+			time.Sleep(time.Minute)
+			continue
+		}
+
+		// Root and other tokens with no TTL never expire.
+		if !self.Data.Renewable || self.Data.TTL == 0 {
+			return
+		}
+
+		time.Sleep(time.Duration(self.Data.TTL) * time.Second / 2)
+
+		if err := v.request(http.MethodPost, "/v1/auth/token/renew-self", map[string]interface{}{}, nil); err != nil {
+			log.Errorf("unable to renew vault token: %v", err)
+			v.notifications.send("Vault token renewal failed", err.Error())
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	wasmapi "github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+const (
+	// wasmTimeout is how long a plugin gets to handle a message or event.
+	// One that takes longer is stopped and stays stopped until the plugins
+	// are reloaded.
+	wasmTimeout = time.Second
+	// wasmMemoryPages is how much memory a plugin can have, in 64KiB pages.
+	wasmMemoryPages = 256
+)
+
+// wasmHost runs the WebAssembly plugins in WASM_DIR. A plugin exports:
+//
+//	alloc(size i32) i32            - memory for the bot to write size bytes to
+//	on_message(ptr, len i32) i32   - optional, a chat message as JSON, returning
+//	                                 1 to stop it going any further
+//	on_event(ptr, len i32)         - optional, an event from the event bus as JSON
+//
+// and can import from the batybot module:
+//
+//	say(channel_ptr, channel_len, text_ptr, text_len i32)
+//	reply(channel_ptr, channel_len, id_ptr, id_len, text_ptr, text_len i32)
+//	log(ptr, len i32)
+//
+// as well as WASI, without any files, environment, or network, for languages
+// that need it. Strings are UTF-8 in the plugin's memory.
+type wasmHost struct {
+	mu      sync.RWMutex
+	dir     string
+	runtime wazero.Runtime
+	plugins []*wasmPlugin
+	client  chat.Sender
+}
+
+// wasmPlugin is one loaded module. A module can only run one thing at a time.
+type wasmPlugin struct {
+	name string
+
+	mu        sync.Mutex
+	module    wasmapi.Module
+	alloc     wasmapi.Function
+	onMessage wasmapi.Function
+	onEvent   wasmapi.Function
+}
+
+// pluginMessage is a chat message as wasm plugins and exec commands get it.
+type pluginMessage struct {
+	Channel     string `json:"channel"`
+	ID          string `json:"id"`
+	User        string `json:"user"`
+	UserID      string `json:"user_id"`
+	DisplayName string `json:"display_name"`
+	Text        string `json:"text"`
+	Mod         bool   `json:"mod"`
+	Time        int64  `json:"time"`
+}
+
+func newPluginMessage(c *chatContext) pluginMessage {
+	return pluginMessage{
+		Channel:     c.message.Channel,
+		ID:          c.message.ID,
+		User:        c.message.User.Name,
+		UserID:      c.message.User.ID,
+		DisplayName: c.message.User.DisplayName,
+		Text:        c.message.Message,
+		Mod:         c.privileged,
+		Time:        c.sent().Unix(),
+	}
+}
+
+// setClient sets where plugins' messages are sent.
+func (h *wasmHost) setClient(client chat.Sender) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.client = client
+}
+
+func (h *wasmHost) sender() chat.Sender {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.client
+}
+
+// load instantiates every .wasm file in dir, replacing the plugins that were
+// loaded before. If any fails to load, the current plugins are kept.
+func (h *wasmHost) load(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.wasm"))
+	if err != nil {
+		return fmt.Errorf("load: %w", err)
+	}
+	sort.Strings(files)
+
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(wasmMemoryPages).
+		WithCloseOnContextDone(true))
+
+	if err := h.instantiateHost(ctx, r); err != nil {
+		r.Close(ctx)
+		return fmt.Errorf("load: %w", err)
+	}
+
+	plugins := make([]*wasmPlugin, 0, len(files))
+	for _, file := range files {
+		p, err := h.instantiate(ctx, r, file)
+		if err != nil {
+			r.Close(ctx)
+			return fmt.Errorf("load: %w", err)
+		}
+		plugins = append(plugins, p)
+	}
+
+	h.mu.Lock()
+	old, oldPlugins := h.runtime, h.plugins
+	h.dir = dir
+	h.runtime = r
+	h.plugins = plugins
+	h.mu.Unlock()
+
+	if old != nil {
+		// Wait for anything the old plugins are doing to finish.
+		for _, p := range oldPlugins {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+		}
+		old.Close(ctx)
+	}
+
+	log.Infof("loaded %d wasm plugins from %s", len(plugins), dir)
+
+	return nil
+}
+
+// reload loads the plugins from the same directory again.
+func (h *wasmHost) reload() error {
+	h.mu.RLock()
+	dir := h.dir
+	h.mu.RUnlock()
+
+	return h.load(dir)
+}
+
+// instantiateHost adds WASI and the batybot module plugins import.
+func (h *wasmHost) instantiateHost(ctx context.Context, r wazero.Runtime) error {
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		return fmt.Errorf("instantiateHost: unable to add wasi: %w", err)
+	}
+
+	_, err := r.NewHostModuleBuilder("batybot").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m wasmapi.Module, channelPtr, channelLen, textPtr, textLen uint32) {
+		channel, text := readString(m, channelPtr, channelLen), readString(m, textPtr, textLen)
+		if client := h.sender(); client != nil && channel != "" && text != "" {
+			client.Say(channel, text)
+		}
+	}).Export("say").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m wasmapi.Module, channelPtr, channelLen, idPtr, idLen, textPtr, textLen uint32) {
+		channel, id, text := readString(m, channelPtr, channelLen), readString(m, idPtr, idLen), readString(m, textPtr, textLen)
+		if client := h.sender(); client != nil && channel != "" && text != "" {
+			client.Reply(channel, id, text)
+		}
+	}).Export("reply").
+		NewFunctionBuilder().WithFunc(func(ctx context.Context, m wasmapi.Module, ptr, length uint32) {
+		log.Infof("wasm plugin %s: %s", m.Name(), readString(m, ptr, length))
+	}).Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		return fmt.Errorf("instantiateHost: unable to add batybot module: %w", err)
+	}
+
+	return nil
+}
+
+// instantiate compiles and starts the plugin in file.
+func (h *wasmHost) instantiate(ctx context.Context, r wazero.Runtime, file string) (*wasmPlugin, error) {
+	name := filepath.Base(file)
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("instantiate: unable to read %q: %w", file, err)
+	}
+
+	compiled, err := r.CompileModule(ctx, b)
+	if err != nil {
+		return nil, fmt.Errorf("instantiate: unable to compile %s: %w", name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wasmTimeout)
+	defer cancel()
+
+	// Reactors, which only export functions, are set up by _initialize.
+	m, err := r.InstantiateModule(ctx, compiled, wazero.NewModuleConfig().
+		WithName(name).
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, fmt.Errorf("instantiate: unable to start %s: %w", name, err)
+	}
+
+	p := &wasmPlugin{
+		name:      name,
+		module:    m,
+		alloc:     m.ExportedFunction("alloc"),
+		onMessage: m.ExportedFunction("on_message"),
+		onEvent:   m.ExportedFunction("on_event"),
+	}
+	if p.alloc == nil {
+		return nil, fmt.Errorf("instantiate: %s doesn't export alloc", name)
+	} else if p.onMessage == nil && p.onEvent == nil {
+		return nil, fmt.Errorf("instantiate: %s exports neither on_message nor on_event", name)
+	}
+
+	return p, nil
+}
+
+func readString(m wasmapi.Module, ptr, length uint32) string {
+	b, ok := m.Memory().Read(ptr, length)
+	if !ok {
+		return ""
+	}
+
+	return string(b)
+}
+
+// call writes v as JSON to the plugin's memory and calls fn with where it is,
+// returning what fn returns.
+func (p *wasmPlugin) call(fn wasmapi.Function, v interface{}) (uint64, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0, fmt.Errorf("call: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.module.IsClosed() {
+		return 0, fmt.Errorf("call: %s was stopped", p.name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wasmTimeout)
+	defer cancel()
+
+	results, err := p.alloc.Call(ctx, uint64(len(b)))
+	if err != nil {
+		return 0, fmt.Errorf("call: %s: alloc failed: %w", p.name, err)
+	}
+
+	ptr := uint32(results[0])
+	if !p.module.Memory().Write(ptr, b) {
+		return 0, fmt.Errorf("call: %s: alloc returned memory out of range", p.name)
+	}
+
+	results, err = fn.Call(ctx, uint64(ptr), uint64(len(b)))
+	if err != nil {
+		return 0, fmt.Errorf("call: %s: %w", p.name, err)
+	} else if len(results) == 0 {
+		return 0, nil
+	}
+
+	return results[0], nil
+}
+
+func (h *wasmHost) loaded() []*wasmPlugin {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.plugins
+}
+
+// handleMessage passes the message to the plugins, reporting whether one
+// stopped it.
+func (h *wasmHost) handleMessage(c *chatContext) bool {
+	message := newPluginMessage(c)
+
+	for _, p := range h.loaded() {
+		if p.onMessage == nil {
+			continue
+		}
+
+		stop, err := p.call(p.onMessage, message)
+		if err != nil {
+			log.Errorf("wasm plugin failed: %v", err)
+		} else if stop != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleEvents passes every event on the bus from now on to the plugins.
+func (h *wasmHost) handleEvents(bus *eventBus) {
+	events, _ := bus.subscribe()
+	for e := range events {
+		for _, p := range h.loaded() {
+			if p.onEvent == nil {
+				continue
+			}
+
+			if _, err := p.call(p.onEvent, e); err != nil {
+				log.Errorf("wasm plugin failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFiles reloads the config, custom commands, scripts, and wasm plugins
+// whenever their files change. The directories are watched rather than the files since editors
+// often save by replacing the file. Reloads wait until the file has been
+// quiet for a moment so it isn't read half saved, and a file that doesn't
+// parse leaves the current settings in place.
+func watchFiles(b *bot) error {
+	loaders := map[string]func() error{}
+	if file := os.Getenv("CONFIG_FILE"); file != "" {
+		loaders[filepath.Clean(file)] = b.reloadConfig
+	}
+	if file := os.Getenv("COMMANDS_FILE"); file != "" {
+		loaders[filepath.Clean(file)] = func() error { return b.custom.load(file) }
+	}
+	// Scripts and plugins are reloaded together whenever any of them changes,
+	// or one is added or removed, so they're keyed by a pattern.
+	if dir := os.Getenv("SCRIPTS_DIR"); dir != "" {
+		loaders[filepath.Join(filepath.Clean(dir), "*.lua")] = b.scripts.reload
+	}
+	if dir := os.Getenv("WASM_DIR"); dir != "" {
+		loaders[filepath.Join(filepath.Clean(dir), "*.wasm")] = b.wasm.reload
+	}
+
+	if len(loaders) == 0 {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("watchFiles: unable to watch files: %w", err)
+	}
+	defer watcher.Close()
+
+	for file := range loaders {
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			return fmt.Errorf("watchFiles: unable to watch %q: %w", file, err)
+		}
+	}
+
+	var mu sync.Mutex
+	pending := map[string]*time.Timer{}
+
+	for {
+		select {
+		case e, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			file := filepath.Clean(e.Name)
+			ops := fsnotify.Write | fsnotify.Create
+			if pattern := filepath.Join(filepath.Dir(file), "*"+filepath.Ext(file)); loaders[pattern] != nil {
+				file = pattern
+				ops |= fsnotify.Remove | fsnotify.Rename
+			}
+
+			load, ok := loaders[file]
+			if !ok || !e.Has(ops) {
+				continue
+			}
+
+			mu.Lock()
+			if t, ok := pending[file]; ok {
+				t.Stop()
+			}
+			pending[file] = time.AfterFunc(500*time.Millisecond, func() {
+				mu.Lock()
+				delete(pending, file)
+				mu.Unlock()
+
+				if err := load(); err != nil {
+					log.Errorf("unable to reload %s, keeping the current settings: %v", file, err)
+					return
+				}
+				log.Infof("reloaded %s", file)
+			})
+			mu.Unlock()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Errorf("error watching files: %v", err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultWeatherCache is how long a location's weather is reused for if the
+// config doesn't say.
+const defaultWeatherCache = 10 * time.Minute
+
+// weather is the config for !weather. Provider is wttr, for wttr.in, or
+// openweathermap, which needs OPENWEATHERMAP_API_KEY. Units is metric,
+// imperial, or both.
+type weather struct {
+	Enabled  bool   `json:"enabled"`
+	Provider string `json:"provider"` // default wttr
+	Units    string `json:"units"`    // default both
+	Cache    string `json:"cache"`    // how long to reuse a location's weather, default 10m
+}
+
+func (w weather) cache() time.Duration {
+	if d, err := time.ParseDuration(w.Cache); err == nil {
+		return d
+	}
+
+	return defaultWeatherCache
+}
+
+// weatherReport is the weather somewhere right now, in metric units.
+type weatherReport struct {
+	place       string
+	description string
+	temp        float64 // °C
+	feelsLike   float64 // °C
+	humidity    int     // %
+	wind        float64 // km/h
+}
+
+// format writes the report out for chat in the units.
+func (r weatherReport) format(units string) string {
+	temp := func(c float64) string {
+		f := c*9/5 + 32
+		switch units {
+		case "metric":
+			return fmt.Sprintf("%.0f°C", c)
+		case "imperial":
+			return fmt.Sprintf("%.0f°F", f)
+		}
+		return fmt.Sprintf("%.0f°C (%.0f°F)", c, f)
+	}
+
+	wind := fmt.Sprintf("%.0f km/h (%.0f mph)", r.wind, r.wind/1.609)
+	switch units {
+	case "metric":
+		wind = fmt.Sprintf("%.0f km/h", r.wind)
+	case "imperial":
+		wind = fmt.Sprintf("%.0f mph", r.wind/1.609)
+	}
+
+	return fmt.Sprintf("%s: %s, %s, feels like %s, humidity %d%%, wind %s",
+		r.place, r.description, temp(r.temp), temp(r.feelsLike), r.humidity, wind)
+}
+
+// fetch gets the weather at the location from the provider.
+func (w weather) fetch(location string) (weatherReport, error) {
+	switch w.Provider {
+	case "", "wttr":
+		return wttrWeather(location)
+	case "openweathermap":
+		return openWeatherMapWeather(location)
+	}
+
+	return weatherReport{}, fmt.Errorf("fetch: unknown provider %q", w.Provider)
+}
+
+// wttrWeather gets the weather from wttr.in, which doesn't need a key.
+func wttrWeather(location string) (weatherReport, error) {
+	req, err := http.NewRequest(http.MethodGet, "https://wttr.in/"+url.PathEscape(location)+"?format=j1", nil)
+	if err != nil {
+		return weatherReport{}, fmt.Errorf("wttrWeather: %w", err)
+	}
+
+	type value []struct {
+		Value string `json:"value"`
+	}
+	var resp struct {
+		CurrentCondition []struct {
+			TempC         float64 `json:"temp_C,string"`
+			FeelsLikeC    float64 `json:"FeelsLikeC,string"`
+			Humidity      int     `json:"humidity,string"`
+			WindspeedKmph float64 `json:"windspeedKmph,string"`
+			WeatherDesc   value   `json:"weatherDesc"`
+		} `json:"current_condition"`
+		NearestArea []struct {
+			AreaName value `json:"areaName"`
+			Country  value `json:"country"`
+		} `json:"nearest_area"`
+	}
+	if err := doJSON(req, &resp); err != nil {
+		return weatherReport{}, fmt.Errorf("wttrWeather: %w", err)
+	} else if len(resp.CurrentCondition) == 0 {
+		return weatherReport{}, fmt.Errorf("wttrWeather: no weather for %q", location)
+	}
+
+	c := resp.CurrentCondition[0]
+	r := weatherReport{place: location, temp: c.TempC, feelsLike: c.FeelsLikeC, humidity: c.Humidity, wind: c.WindspeedKmph}
+	if len(c.WeatherDesc) > 0 {
+		r.description = strings.TrimSpace(c.WeatherDesc[0].Value)
+	}
+	if len(resp.NearestArea) > 0 && len(resp.NearestArea[0].AreaName) > 0 {
+		r.place = resp.NearestArea[0].AreaName[0].Value
+		if len(resp.NearestArea[0].Country) > 0 {
+			r.place += ", " + resp.NearestArea[0].Country[0].Value
+		}
+	}
+
+	return r, nil
+}
+
+// openWeatherMapWeather gets the weather from OpenWeatherMap with the key in
+// OPENWEATHERMAP_API_KEY.
+func openWeatherMapWeather(location string) (weatherReport, error) {
+	key := os.Getenv("OPENWEATHERMAP_API_KEY")
+	if key == "" {
+		return weatherReport{}, fmt.Errorf("openWeatherMapWeather: OPENWEATHERMAP_API_KEY isn't set")
+	}
+
+	q := url.Values{"q": {location}, "appid": {key}, "units": {"metric"}}
+	req, err := http.NewRequest(http.MethodGet, "https://api.openweathermap.org/data/2.5/weather?"+q.Encode(), nil)
+	if err != nil {
+		return weatherReport{}, fmt.Errorf("openWeatherMapWeather: %w", err)
+	}
+
+	var resp struct {
+		Name string `json:"name"`
+		Sys  struct {
+			Country string `json:"country"`
+		} `json:"sys"`
+		Main struct {
+			Temp      float64 `json:"temp"`
+			FeelsLike float64 `json:"feels_like"`
+			Humidity  int     `json:"humidity"`
+		} `json:"main"`
+		Wind struct {
+			Speed float64 `json:"speed"` // m/s
+		} `json:"wind"`
+		Weather []struct {
+			Description string `json:"description"`
+		} `json:"weather"`
+	}
+	if err := doJSON(req, &resp); err != nil {
+		// The key's in the URL, which the error would otherwise show.
+		return weatherReport{}, fmt.Errorf("openWeatherMapWeather: %w", redactKey(err, key))
+	}
+
+	r := weatherReport{
+		place:     resp.Name,
+		temp:      resp.Main.Temp,
+		feelsLike: resp.Main.FeelsLike,
+		humidity:  resp.Main.Humidity,
+		wind:      math.Round(resp.Wind.Speed * 3.6),
+	}
+	if resp.Sys.Country != "" {
+		r.place += ", " + resp.Sys.Country
+	}
+	if len(resp.Weather) > 0 {
+		r.description = resp.Weather[0].Description
+	}
+
+	return r, nil
+}
+
+// redactKey takes the key out of err's message.
+func redactKey(err error, key string) error {
+	if !strings.Contains(err.Error(), key) {
+		return err
+	}
+
+	return fmt.Errorf("%s", strings.ReplaceAll(err.Error(), key, "REDACTED"))
+}
+
+// cachedWeather is a location's weather and when it was fetched.
+type cachedWeather struct {
+	report  weatherReport
+	fetched time.Time
+}
+
+// weatherCache keeps the weather fetched for each location for a while, so
+// everyone asking about the same place doesn't use up the provider's limits.
+type weatherCache struct {
+	mu      sync.Mutex
+	reports map[string]cachedWeather // by provider and lowercase location
+}
+
+func (c *weatherCache) get(conf weather, location string, now time.Time) (weatherReport, error) {
+	key := conf.Provider + "|" + strings.ToLower(location)
+
+	c.mu.Lock()
+	cached, ok := c.reports[key]
+	c.mu.Unlock()
+	if ok && now.Sub(cached.fetched) < conf.cache() {
+		return cached.report, nil
+	}
+
+	report, err := conf.fetch(location)
+	if err != nil {
+		return weatherReport{}, fmt.Errorf("get: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, r := range c.reports {
+		if now.Sub(r.fetched) >= conf.cache() {
+			delete(c.reports, k)
+		}
+	}
+	c.reports[key] = cachedWeather{report: report, fetched: now}
+
+	return report, nil
+}
+
+// runWeather answers !weather location.
+func runWeather(c *chatContext) bool {
+	conf := c.config.Weather
+	if !conf.Enabled {
+		return false
+	}
+
+	name, args, ok := parseCommand(c.message.Message)
+	if !ok || name != "weather" {
+		return false
+	}
+
+	location := strings.Join(args, " ")
+	if location == "" {
+		c.client.Reply(c.message.Channel, c.message.ID, "Usage: !weather location")
+		return true
+	}
+
+	go func() {
+		report, err := c.bot.weather.get(conf, location, time.Now())
+		if err != nil {
+			log.Errorf("unable to get the weather: %v", err)
+			c.client.Reply(c.message.Channel, c.message.ID, "Unable to get the weather for "+location)
+			return
+		}
+
+		c.client.Reply(c.message.Channel, c.message.ID, report.format(conf.Units))
+	}()
+
+	return true
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+//go:embed wordlists/*.txt
+var wordLists embed.FS
+
+// The severities a filtered word can have, from least to most.
+var severities = []string{"mild", "strong", "severe"}
+
+// defaultWordActions are what's done about each severity unless the config
+// says otherwise.
+var defaultWordActions = map[string]string{
+	"mild":   "warn",
+	"strong": "delete",
+	"severe": "timeout 10m",
+}
+
+// wordFilter is the config for filtering words and phrases. Languages picks
+// the built in lists, in wordlists, to use. Words adds to them, or changes
+// their severity, and Allow takes words back out. Actions are what's done
+// about each severity: warn, delete, timeout and a duration, ban, or none.
+type wordFilter struct {
+	Languages []string          `json:"languages"`
+	Words     map[string]string `json:"words"`   // severity by word or phrase
+	Allow     []string          `json:"allow"`   // words that aren't filtered even if a list has them
+	Actions   map[string]string `json:"actions"` // by severity
+}
+
+func (w wordFilter) enabled() bool {
+	return len(w.Languages) > 0 || len(w.Words) > 0
+}
+
+// action returns what's done about words of the severity, and for how long if
+// it's a timeout.
+func (w wordFilter) action(severity string) (string, time.Duration) {
+	action, ok := w.Actions[severity]
+	if !ok {
+		action = defaultWordActions[severity]
+	}
+
+	name, d, _ := strings.Cut(action, " ")
+	duration, _ := time.ParseDuration(strings.TrimSpace(d))
+
+	return name, duration
+}
+
+func isSeverity(s string) bool {
+	for _, v := range severities {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func severityRank(s string) int {
+	for i, v := range severities {
+		if v == s {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// leetspeak maps the characters used in place of letters back to them.
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l', '+': 't', '€': 'e',
+}
+
+// normalizeWords splits text into lowercase words, with leetspeak turned back
+// into letters and everything else that isn't a letter dropped.
+func normalizeWords(text string) []string {
+	var words []string
+	for _, field := range strings.Fields(strings.ToLower(text)) {
+		// Punctuation around a word isn't leetspeak, as in "@name" or "hi!".
+		field = strings.TrimLeft(field, `@("'[`)
+		field = strings.TrimRight(field, `!?.,)"':;]`)
+
+		var b strings.Builder
+		for _, r := range field {
+			if l, ok := leetspeak[r]; ok {
+				r = l
+			}
+
+			if unicode.IsLetter(r) {
+				b.WriteRune(r)
+			} else if b.Len() > 0 && r != '*' && r != '.' && r != '_' && r != '-' {
+				// Punctuation between words, as in "what,the", splits them,
+				// but not that put inside one to hide it.
+				words = append(words, b.String())
+				b.Reset()
+			}
+		}
+		if b.Len() > 0 {
+			words = append(words, b.String())
+		}
+	}
+
+	return words
+}
+
+// squeeze drops letters repeated in a row, so "fuuuun" and "fun" are the same.
+func squeeze(word string) string {
+	var b strings.Builder
+	var last rune
+	for _, r := range word {
+		if r != last {
+			b.WriteRune(r)
+		}
+		last = r
+	}
+
+	return b.String()
+}
+
+// stretched reports whether the word has a letter three or more times in a
+// row, which is when it's worth comparing squeezed. Otherwise words like "as"
+// and "ass" would be the same.
+func stretched(word string) bool {
+	var last rune
+	run := 0
+	for _, r := range word {
+		if r == last {
+			run++
+			if run >= 3 {
+				return true
+			}
+		} else {
+			run = 1
+		}
+		last = r
+	}
+
+	return false
+}
+
+// wordEntry is a filtered word or phrase, split into words.
+type wordEntry struct {
+	words    []string
+	severity string
+}
+
+// wordMatcher is a config's lists, ready to match messages against.
+type wordMatcher struct {
+	entries []wordEntry
+	allow   map[string]bool
+}
+
+// match returns the most severe word or phrase in text, if there is one.
+func (m *wordMatcher) match(text string) (string, string, bool) {
+	words := normalizeWords(text)
+
+	var found wordEntry
+	for i := range words {
+		if m.allow[words[i]] {
+			continue
+		}
+
+		for _, e := range m.entries {
+			if severityRank(e.severity) <= severityRank(found.severity) || i+len(e.words) > len(words) {
+				continue
+			}
+
+			matched := true
+			for j, w := range e.words {
+				got := words[i+j]
+				if got != w && !(stretched(got) && squeeze(got) == squeeze(w)) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				found = e
+			}
+		}
+	}
+
+	if found.severity == "" {
+		return "", "", false
+	}
+
+	return strings.Join(found.words, " "), found.severity, true
+}
+
+// wordMatchers caches the wordMatcher for the config, since building one
+// means reading the lists.
+type wordMatchers struct {
+	sync.Mutex
+	key     string
+	matcher *wordMatcher
+}
+
+// get returns the config's wordMatcher.
+func (matchers *wordMatchers) get(w wordFilter) (*wordMatcher, error) {
+	key := fmt.Sprint(w.Languages, w.Words, w.Allow)
+
+	matchers.Lock()
+	defer matchers.Unlock()
+
+	if matchers.matcher != nil && matchers.key == key {
+		return matchers.matcher, nil
+	}
+
+	severity := map[string]string{}
+	for _, lang := range w.Languages {
+		if err := readWordList(lang, severity); err != nil {
+			return nil, fmt.Errorf("get: %w", err)
+		}
+	}
+	for word, s := range w.Words {
+		severity[strings.Join(normalizeWords(word), " ")] = s
+	}
+
+	m := &wordMatcher{allow: map[string]bool{}}
+	for _, word := range w.Allow {
+		for _, n := range normalizeWords(word) {
+			m.allow[n] = true
+		}
+	}
+	for phrase, s := range severity {
+		if phrase == "" || !isSeverity(s) {
+			continue
+		}
+		m.entries = append(m.entries, wordEntry{words: strings.Fields(phrase), severity: s})
+	}
+
+	matchers.key = key
+	matchers.matcher = m
+
+	return m, nil
+}
+
+// readWordList adds the built in list for the language to severity.
+func readWordList(lang string, severity map[string]string) error {
+	f, err := wordLists.Open("wordlists/" + strings.ToLower(lang) + ".txt")
+	if err != nil {
+		return fmt.Errorf("readWordList: no built in list for %q", lang)
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		level, phrase, _ := strings.Cut(line, " ")
+		severity[strings.Join(normalizeWords(phrase), " ")] = level
+	}
+
+	return s.Err()
+}
+
+// filterWords checks the message against the config's word filter, acting on
+// it if it matches, and reports whether it did. Mods aren't filtered.
+func filterWords(c *chatContext, dryRun bool) bool {
+	conf := c.config.WordFilter
+	if !conf.enabled() || isMod(c.message.User) {
+		return false
+	}
+
+	m, err := c.bot.words.get(conf)
+	if err != nil {
+		log.Errorf("unable to filter words: %v", err)
+		return false
+	}
+
+	word, severity, ok := m.match(c.message.Message)
+	if !ok {
+		return false
+	}
+
+	action, d := conf.action(severity)
+	if action == "none" {
+		return false
+	}
+
+	reason := fmt.Sprintf("word filter, %s: %q", severity, word)
+	if dryRun {
+		log.Infof("%s would %s %q from %s", reason, action, c.message.Message, c.message.User.Name)
+		return true
+	}
+
+	message := c.message
+	switch action {
+	case "warn":
+		c.client.Reply(message.Channel, message.ID, "Please watch your language")
+	case "delete":
+		go func() {
+			if err := c.bot.api.deleteMessage(message, reason); err != nil {
+				log.Errorf("unable to delete filtered message: %v", err)
+			}
+		}()
+	case "timeout":
+		go func() {
+			if err := c.bot.api.timeout(message.Channel, message.RoomID, message.User, d, reason); err != nil {
+				log.Errorf("unable to time out %s: %v", message.User.Name, err)
+			}
+		}()
+	case "ban":
+		go func() {
+			if err := c.bot.api.ban(message.Channel, message.RoomID, message.User, reason); err != nil {
+				log.Errorf("unable to ban %s: %v", message.User.Name, err)
+			}
+		}()
+	}
+
+	return true
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gempir/go-twitch-irc/v4"
+
+	"github.com/losinggeneration/batybot/internal/chat"
+)
+
+const (
+	// defaultWordGamePoints is how many points winning a word game is worth
+	// if the config doesn't say.
+	defaultWordGamePoints = 10
+	// defaultWordGameTimeout is how long a word game lasts if the config
+	// doesn't say.
+	defaultWordGameTimeout = 2 * time.Minute
+	// hangmanMisses is how many wrong letters end a game of hangman.
+	hangmanMisses = 6
+)
+
+// wordGames is the config for the word scramble and hangman games. Words are
+// by channel, or * for any other. With every set a game's started on its own
+// that long after the last one, if anyone's chatted since.
+type wordGames struct {
+	Words   map[string][]string `json:"words"`
+	Points  int                 `json:"points"`  // for winning, default 10
+	Timeout string              `json:"timeout"` // how long a game lasts, default 2m
+	Every   string              `json:"every"`
+}
+
+// words returns the channel's words, or the ones for * if it doesn't have its
+// own.
+func (w wordGames) words(channel string) []string {
+	if words, ok := w.Words[strings.ToLower(channel)]; ok {
+		return words
+	}
+
+	return w.Words["*"]
+}
+
+func (w wordGames) points() int {
+	if w.Points <= 0 {
+		return defaultWordGamePoints
+	}
+
+	return w.Points
+}
+
+func (w wordGames) timeout() time.Duration {
+	if d, err := time.ParseDuration(w.Timeout); err == nil && d > 0 {
+		return d
+	}
+
+	return defaultWordGameTimeout
+}
+
+// wordGame is a game of word scramble or hangman going in a channel.
+type wordGame struct {
+	kind    string // scramble or hangman
+	word    string // lowercase
+	guessed map[rune]bool
+	misses  []rune
+	timer   *time.Timer
+}
+
+// scramble returns the word with the letters of each of its words shuffled,
+// so it's different from the word if it can be.
+func scramble(word string) string {
+	for try := 0; try < 10; try++ {
+		fields := strings.Fields(word)
+		for i, f := range fields {
+			letters := []rune(f)
+			rand.Shuffle(len(letters), func(i, j int) { letters[i], letters[j] = letters[j], letters[i] })
+			fields[i] = string(letters)
+		}
+
+		if s := strings.Join(fields, " "); s != word {
+			return s
+		}
+	}
+
+	return word
+}
+
+// progress shows the hangman word with the letters that haven't been guessed
+// blanked out, and the misses so far.
+func (g *wordGame) progress() string {
+	var shown []string
+	for _, r := range g.word {
+		switch {
+		case r == ' ':
+			shown = append(shown, " ")
+		case g.guessed[r] || !isLetter(r):
+			shown = append(shown, string(r))
+		default:
+			shown = append(shown, "_")
+		}
+	}
+
+	text := strings.Join(shown, " ")
+	if len(g.misses) > 0 {
+		misses := make([]string, 0, len(g.misses))
+		for _, r := range g.misses {
+			misses = append(misses, string(r))
+		}
+		text += fmt.Sprintf(" | misses: %s (%d left)", strings.Join(misses, " "), hangmanMisses-len(g.misses))
+	}
+
+	return text
+}
+
+// solved reports whether every letter of the hangman word has been guessed.
+func (g *wordGame) solved() bool {
+	for _, r := range g.word {
+		if isLetter(r) && !g.guessed[r] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isLetter(r rune) bool {
+	return strings.ContainsRune("abcdefghijklmnopqrstuvwxyz", r) || r > 127
+}
+
+// wordGameTracker holds the word game going in each channel.
+type wordGameTracker struct {
+	mu    sync.Mutex
+	games map[string]*wordGame // by channel
+	ended map[string]time.Time // when the last game in each channel ended
+}
+
+// start begins a game of kind in the channel with a random word from the
+// config, announcing it, unless one's already going. When its time's up the
+// word's announced.
+func (t *wordGameTracker) start(client chat.Sender, channel, kind string, conf wordGames) error {
+	words := conf.words(channel)
+	if len(words) == 0 {
+		return fmt.Errorf("start: there are no words for %s", channel)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.games[channel] != nil {
+		return fmt.Errorf("start: there's already a game going")
+	}
+
+	g := &wordGame{kind: kind, word: strings.ToLower(strings.TrimSpace(words[rand.Intn(len(words))])), guessed: map[rune]bool{}}
+	g.timer = time.AfterFunc(conf.timeout(), func() {
+		if t.end(channel, g) {
+			client.Say(channel, fmt.Sprintf("Time's up! The word was %s", g.word))
+		}
+	})
+	t.games[channel] = g
+
+	timeout := shortDuration(conf.timeout())
+	if kind == "hangman" {
+		client.Say(channel, fmt.Sprintf("Hangman! Guess letters or the word with !guess, %s to get it: %s", timeout, g.progress()))
+	} else {
+		client.Say(channel, fmt.Sprintf("Unscramble this, %s to get it: %s", timeout, scramble(g.word)))
+	}
+
+	return nil
+}
+
+// end stops the game if it's still the one going in the channel, reporting
+// whether it was.
+func (t *wordGameTracker) end(channel string, g *wordGame) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.endLocked(channel, g)
+}
+
+func (t *wordGameTracker) endLocked(channel string, g *wordGame) bool {
+	if t.games[channel] != g {
+		return false
+	}
+
+	g.timer.Stop()
+	delete(t.games, channel)
+	t.ended[channel] = time.Now()
+
+	return true
+}
+
+// going reports whether there's a game in the channel, and when the last one
+// ended.
+func (t *wordGameTracker) going(channel string) (bool, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.games[channel] != nil, t.ended[channel]
+}
+
+// guess checks the message against the channel's game: any message or
+// !guess for scramble, and only !guess for hangman. It returns what to say
+// about it, or the word if it won.
+func (t *wordGameTracker) guess(channel, text string, command bool) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	g := t.games[channel]
+	if g == nil || (g.kind == "hangman" && !command) {
+		return "", false
+	}
+
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == g.word {
+		t.endLocked(channel, g)
+		return g.word, true
+	}
+
+	letters := []rune(text)
+	if g.kind != "hangman" || len(letters) != 1 || !isLetter(letters[0]) || g.guessed[letters[0]] {
+		return "", false
+	}
+
+	r := letters[0]
+	g.guessed[r] = true
+	if strings.ContainsRune(g.word, r) {
+		if g.solved() {
+			t.endLocked(channel, g)
+			return g.word, true
+		}
+		return g.progress(), false
+	}
+
+	g.misses = append(g.misses, r)
+	if len(g.misses) >= hangmanMisses {
+		t.endLocked(channel, g)
+		return fmt.Sprintf("Out of guesses! The word was %s", g.word), false
+	}
+
+	return g.progress(), false
+}
+
+// runWordGames answers word scramble games and runs !guess. Mods start games
+// with !scramble and !hangman. Winners get the config's points, unless dryRun.
+func runWordGames(c *chatContext, dryRun bool) bool {
+	conf := c.config.WordGames
+	channel := c.message.Channel
+
+	name, args, isCommand := parseCommand(c.message.Message)
+	guess := c.message.Message
+	switch {
+	case !isCommand:
+	case name == "guess":
+		guess = strings.Join(args, " ")
+	case name == "scramble" || name == "hangman":
+		if !c.privileged {
+			log.Debugf("%s tried to run mod command %s", c.message.User.Name, name)
+			return true
+		}
+
+		if err := c.bot.wordGames.start(c.client, channel, name, conf); err != nil {
+			c.client.Reply(channel, c.message.ID, strings.TrimPrefix(err.Error(), "start: "))
+		}
+		return true
+	default:
+		return false
+	}
+
+	text, won := c.bot.wordGames.guess(channel, guess, isCommand)
+	if won {
+		text = fmt.Sprintf("@%s got it, the word was %s!", c.message.User.DisplayName, text)
+		if !dryRun {
+			total, err := c.bot.points.add(channel, c.message.User, conf.points())
+			if err != nil {
+				log.Errorf("unable to save points: %v", err)
+			}
+			text += fmt.Sprintf(" +%d points, %d in all", conf.points(), total)
+		}
+	}
+	if text != "" {
+		c.client.Say(channel, text)
+	}
+
+	return isCommand
+}
+
+// runWordGameSchedule starts a game of word scramble or hangman in the channel
+// every so often, as the config says. It waits for chat to have been active
+// since the last one, so games aren't started in an empty chat, and for chat
+// to not be in panic mode.
+func runWordGameSchedule(b *bot, client chat.Sender, channel string) error {
+	started := time.Now()
+	for range time.Tick(time.Minute) {
+		c := b.config.get().WordGames
+		every, err := time.ParseDuration(c.Every)
+		if err != nil || every <= 0 || len(c.words(channel)) == 0 || b.panics.active(channel) {
+			continue
+		}
+
+		going, last := b.wordGames.going(channel)
+		if last.IsZero() {
+			last = started
+		}
+		if going || time.Since(last) < every {
+			continue
+		}
+
+		chatted := b.history.since(channel, last, func(twitch.PrivateMessage) bool { return true })
+		if len(chatted) == 0 {
+			continue
+		}
+
+		kind := "scramble"
+		if rand.Intn(2) == 0 {
+			kind = "hangman"
+		}
+		if err := b.wordGames.start(client, channel, kind, c); err != nil {
+			log.Errorf("unable to start a word game: %v", err)
+		}
+	}
+
+	return nil
+}